@@ -0,0 +1,336 @@
+package audit
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+const maxArchiveEntries = 100000
+
+// ArchiveManifest describes an archive file. Prev* is the chain state before
+// FirstSeq and Count/HeadSeq/HeadHash the state after LastSeq, so an archive
+// can be verified on its own and the next archive or the live log continues
+// from it. The manifest is signed like a checkpoint.
+type ArchiveManifest struct {
+	Version   int       `json:"version"`
+	File      string    `json:"file"`
+	SHA256    string    `json:"sha256"`
+	FirstSeq  int64     `json:"firstSeq"`
+	LastSeq   int64     `json:"lastSeq"`
+	Entries   int64     `json:"entries"`
+	PrevSeq   int64     `json:"prevSeq"`
+	PrevCount int64     `json:"prevCount"`
+	PrevHash  string    `json:"prevHash"`
+	Count     int64     `json:"count"`
+	HeadSeq   int64     `json:"headSeq"`
+	HeadHash  string    `json:"headHash"`
+	KeyID     string    `json:"keyId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// OpenArchiveManifest verifies a detached base64 signature over manifest and
+// decodes it.
+func OpenArchiveManifest(manifest []byte, signature string, pub ed25519.PublicKey) (ArchiveManifest, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return ArchiveManifest{}, errors.New("signature is not base64")
+	}
+	if !ed25519.Verify(pub, manifest, sig) {
+		return ArchiveManifest{}, errors.New("signature does not verify")
+	}
+	var m ArchiveManifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return ArchiveManifest{}, err
+	}
+	if m.Version != checkpointVersion {
+		return ArchiveManifest{}, fmt.Errorf("unsupported version %d", m.Version)
+	}
+	return m, nil
+}
+
+// Archiver moves audit entries older than the retention period to gzipped
+// NDJSON files with a signed manifest, then deletes them. Only entries
+// covered by a signed checkpoint are archived, and each range is verified
+// first, so a broken chain is never archived away.
+type Archiver struct {
+	store     archiveStore
+	key       ed25519.PrivateKey
+	dir       string
+	retention time.Duration
+	interval  time.Duration
+	logger    *slog.Logger
+}
+
+// archiveStore is the part of the store the archiver uses.
+type archiveStore interface {
+	LatestAuditCheckpoint(ctx context.Context) (store.AuditCheckpoint, error)
+	ListAuditCheckpoints(ctx context.Context) ([]store.AuditCheckpoint, error)
+	LatestAuditArchive(ctx context.Context) (store.AuditArchive, error)
+	ListAuditAfter(ctx context.Context, seq int64, limit int) ([]store.AuditEntry, error)
+	ArchiveAudit(ctx context.Context, a store.AuditArchive) error
+}
+
+func NewArchiver(st *store.Store, key ed25519.PrivateKey, dir string, retention time.Duration, interval time.Duration, logger *slog.Logger) *Archiver {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Archiver{store: st, key: key, dir: dir, retention: retention, interval: interval, logger: logger}
+}
+
+func (a *Archiver) Start(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := a.Sweep(ctx); err != nil {
+					a.logger.Error("audit archive failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Sweep archives every eligible entry and returns the archives written.
+func (a *Archiver) Sweep(ctx context.Context) ([]store.AuditArchive, error) {
+	var written []store.AuditArchive
+	for {
+		archive, err := a.archive(ctx)
+		if err != nil || archive == nil {
+			return written, err
+		}
+		a.logger.Info("audit entries archived", "file", archive.FileName, "firstSeq", archive.FirstSeq, "lastSeq", archive.LastSeq)
+		written = append(written, *archive)
+	}
+}
+
+func (a *Archiver) archive(ctx context.Context) (*store.AuditArchive, error) {
+	pub := a.key.Public().(ed25519.PublicKey)
+	anchor, err := a.store.LatestAuditCheckpoint(ctx)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	v := &Verifier{}
+	prev, err := a.store.LatestAuditArchive(ctx)
+	switch {
+	case err == nil:
+		v = resumeVerifier(prev.LastSeq, prev.HeadSeq, prev.SignedCount, prev.HeadHash)
+	case !errors.Is(err, store.ErrNotFound):
+		return nil, err
+	}
+	start := v.Result()
+	cps, err := a.store.ListAuditCheckpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sc := range cps {
+		if sc.KeyID != KeyID(pub) {
+			continue
+		}
+		cp, err := OpenCheckpoint([]byte(sc.Statement), sc.Signature, pub)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %d: %w", sc.Seq, err)
+		}
+		v.AddCheckpoints(cp)
+	}
+
+	tmp, err := os.CreateTemp(a.dir, ".audit-archive-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	sum := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(tmp, sum))
+
+	cutoff := time.Now().Add(-a.retention)
+	var first, last, count int64
+	after := start.LastSeq
+scan:
+	for count < maxArchiveEntries {
+		entries, err := a.store.ListAuditAfter(ctx, after, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Seq > anchor.Seq || !entry.CreatedAt.Before(cutoff) || count == maxArchiveEntries {
+				break scan
+			}
+			if brk := v.Add(entry); brk != nil {
+				return nil, fmt.Errorf("audit chain broken at seq %d: %s", brk.Seq, brk.Reason)
+			}
+			line, err := json.Marshal(entry)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := zw.Write(append(line, '\n')); err != nil {
+				return nil, err
+			}
+			if first == 0 {
+				first = entry.Seq
+			}
+			last = entry.Seq
+			count++
+			after = entry.Seq
+		}
+		if len(entries) < 1000 {
+			break
+		}
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, err
+	}
+	result := v.Result()
+	base := fmt.Sprintf("audit-%020d-%020d", first, last)
+	manifest, err := json.Marshal(ArchiveManifest{
+		Version:   checkpointVersion,
+		File:      base + ".ndjson.gz",
+		SHA256:    hex.EncodeToString(sum.Sum(nil)),
+		FirstSeq:  first,
+		LastSeq:   last,
+		Entries:   count,
+		PrevSeq:   start.HeadSeq,
+		PrevCount: start.Signed,
+		PrevHash:  start.LastHash,
+		Count:     result.Signed,
+		HeadSeq:   result.HeadSeq,
+		HeadHash:  result.LastHash,
+		KeyID:     KeyID(pub),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(a.key, manifest))
+	if err := os.WriteFile(filepath.Join(a.dir, base+".manifest.json"), manifest, 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(a.dir, base+".manifest.sig"), []byte(signature+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(a.dir, base+".ndjson.gz")); err != nil {
+		return nil, err
+	}
+	archive := store.AuditArchive{
+		ID:          uuid.New(),
+		FirstSeq:    first,
+		LastSeq:     last,
+		Entries:     count,
+		SignedCount: result.Signed,
+		HeadSeq:     result.HeadSeq,
+		HeadHash:    result.LastHash,
+		FileName:    base + ".ndjson.gz",
+		SHA256:      hex.EncodeToString(sum.Sum(nil)),
+		KeyID:       KeyID(pub),
+		Manifest:    string(manifest),
+		Signature:   signature,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := a.store.ArchiveAudit(ctx, archive); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// VerifyFiles verifies the chain across exports and archives given in any
+// order. With a public key each archive's signed manifest (<name>.manifest.json
+// and .manifest.sig next to it) is checked against the file, and when the
+// earliest file is an archive verification starts from the chain state its
+// manifest records instead of from the beginning of the log.
+func VerifyFiles(paths []string, pub ed25519.PublicKey, checkpoints []Checkpoint) (VerifyResult, error) {
+	var entries []store.AuditEntry
+	var manifests []ArchiveManifest
+	for _, path := range paths {
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return VerifyResult{}, err
+		}
+		if pub != nil && strings.HasSuffix(path, ".ndjson.gz") {
+			m, err := readArchiveManifest(path, data, pub)
+			if err != nil {
+				return VerifyResult{}, fmt.Errorf("%s: %w", filepath.Base(path), err)
+			}
+			manifests = append(manifests, m)
+		}
+		read, err := ReadExport(strings.NewReader(string(data)))
+		if err != nil {
+			return VerifyResult{}, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		entries = append(entries, read...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	v := &Verifier{}
+	if len(manifests) > 0 && len(entries) > 0 {
+		sort.Slice(manifests, func(i, j int) bool { return manifests[i].FirstSeq < manifests[j].FirstSeq })
+		if m := manifests[0]; m.FirstSeq == entries[0].Seq {
+			v = resumeVerifier(m.FirstSeq-1, m.PrevSeq, m.PrevCount, m.PrevHash)
+		}
+	}
+	v.AddCheckpoints(checkpoints...)
+	for _, entry := range entries {
+		if v.Add(entry) != nil {
+			break
+		}
+	}
+	return v.Finish(), nil
+}
+
+func readArchiveManifest(path string, data []byte, pub ed25519.PublicKey) (ArchiveManifest, error) {
+	base := strings.TrimSuffix(path, ".ndjson.gz")
+	manifest, err := os.ReadFile(base + ".manifest.json")
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	sig, err := os.ReadFile(base + ".manifest.sig")
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	m, err := OpenArchiveManifest(manifest, string(sig), pub)
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != m.SHA256 {
+		return ArchiveManifest{}, errors.New("file does not match its manifest")
+	}
+	return m, nil
+}
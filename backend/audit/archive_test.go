@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"flowdb/backend/store"
+)
+
+func ndjson(t *testing.T, entries []store.AuditEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	return buf.Bytes()
+}
+
+// memArchiveStore keeps audit entries, checkpoints and archives in memory.
+type memArchiveStore struct {
+	entries     []store.AuditEntry
+	checkpoints []store.AuditCheckpoint
+	archives    []store.AuditArchive
+}
+
+func (m *memArchiveStore) LatestAuditCheckpoint(context.Context) (store.AuditCheckpoint, error) {
+	if len(m.checkpoints) == 0 {
+		return store.AuditCheckpoint{}, store.ErrNotFound
+	}
+	return m.checkpoints[len(m.checkpoints)-1], nil
+}
+
+func (m *memArchiveStore) ListAuditCheckpoints(context.Context) ([]store.AuditCheckpoint, error) {
+	return m.checkpoints, nil
+}
+
+func (m *memArchiveStore) LatestAuditArchive(context.Context) (store.AuditArchive, error) {
+	if len(m.archives) == 0 {
+		return store.AuditArchive{}, store.ErrNotFound
+	}
+	return m.archives[len(m.archives)-1], nil
+}
+
+func (m *memArchiveStore) ListAuditAfter(_ context.Context, seq int64, limit int) ([]store.AuditEntry, error) {
+	var out []store.AuditEntry
+	for _, e := range m.entries {
+		if e.Seq > seq && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (m *memArchiveStore) ArchiveAudit(_ context.Context, a store.AuditArchive) error {
+	m.archives = append(m.archives, a)
+	kept := m.entries[:0]
+	for _, e := range m.entries {
+		if e.Seq > a.LastSeq {
+			kept = append(kept, e)
+		}
+	}
+	m.entries = kept
+	return nil
+}
+
+// checkpoint signs the chain head at entry the way Checkpointer does.
+func (m *memArchiveStore) checkpoint(t *testing.T, key ed25519.PrivateKey, entry store.AuditEntry) Checkpoint {
+	t.Helper()
+	pub := key.Public().(ed25519.PublicKey)
+	cp := Checkpoint{Version: checkpointVersion, Seq: entry.Seq, Count: entry.Seq, HeadHash: entry.Hash, KeyID: KeyID(pub), CreatedAt: time.Now().UTC()}
+	statement, err := json.Marshal(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.checkpoints = append(m.checkpoints, store.AuditCheckpoint{
+		Seq:         cp.Seq,
+		SignedCount: cp.Count,
+		HeadHash:    cp.HeadHash,
+		KeyID:       cp.KeyID,
+		Statement:   string(statement),
+		Signature:   base64.StdEncoding.EncodeToString(ed25519.Sign(key, statement)),
+	})
+	return cp
+}
+
+func TestVerifyFilesAcrossArchive(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	entries := buildChain(t, map[string]any{"a": 1}, map[string]any{"a": 2}, map[string]any{"a": 3}, map[string]any{"a": 4})
+	dir := t.TempDir()
+	st := &memArchiveStore{entries: append([]store.AuditEntry(nil), entries...)}
+	archiver := &Archiver{store: st, key: key, dir: dir, retention: time.Hour, logger: slog.Default()}
+
+	// Entry 1 is archived first; that archive is not passed to VerifyFiles.
+	st.checkpoint(t, key, entries[0])
+	if written, err := archiver.Sweep(context.Background()); err != nil || len(written) != 1 || written[0].LastSeq != 1 {
+		t.Fatalf("unexpected first sweep %+v, %v", written, err)
+	}
+	checkpoint := st.checkpoint(t, key, entries[2])
+	written, err := archiver.Sweep(context.Background())
+	if err != nil || len(written) != 1 || written[0].FirstSeq != 2 || written[0].LastSeq != 3 {
+		t.Fatalf("unexpected second sweep %+v, %v", written, err)
+	}
+	if len(st.entries) != 1 || st.entries[0].Seq != 4 {
+		t.Fatalf("expected only the entry after the checkpoint to remain, got %+v", st.entries)
+	}
+	archive := filepath.Join(dir, written[0].FileName)
+	export := filepath.Join(dir, "export.ndjson")
+	os.WriteFile(export, ndjson(t, st.entries), 0o644)
+
+	result, err := VerifyFiles([]string{export, archive}, pub, []Checkpoint{checkpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK || result.Entries != 3 || result.Signed != 4 || result.Checkpoints != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// Without the archive the export does not link to anything.
+	if result, _ := VerifyFiles([]string{export}, pub, nil); result.OK {
+		t.Fatalf("expected break without archive, got %+v", result)
+	}
+
+	// A modified archive no longer matches its signed manifest.
+	os.WriteFile(archive, []byte("tampered"), 0o644)
+	if _, err := VerifyFiles([]string{export, archive}, pub, nil); err == nil {
+		t.Fatal("expected manifest mismatch")
+	}
+}
+
+func TestAddCheckpointsSkipsResumePoint(t *testing.T) {
+	entries := buildChain(t, map[string]any{"a": 1}, map[string]any{"a": 2})
+	v := ResumeVerifier(Checkpoint{Seq: 1, Count: 1, HeadHash: entries[0].Hash})
+	v.AddCheckpoints(Checkpoint{Seq: 1, Count: 1, HeadHash: entries[0].Hash}, Checkpoint{Seq: 2, Count: 2, HeadHash: entries[1].Hash})
+	v.Add(entries[1])
+	if result := v.Finish(); !result.OK || result.Checkpoints != 2 || result.AnchoredSeq != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
@@ -0,0 +1,393 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"flowdb/backend/store"
+	"flowdb/backend/util"
+
+	"github.com/google/uuid"
+)
+
+// ChainBreak describes the first entry whose link does not verify.
+type ChainBreak struct {
+	Seq          int64     `json:"seq"`
+	ID           uuid.UUID `json:"id"`
+	EventType    string    `json:"eventType"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Reason       string    `json:"reason"`
+	ExpectedPrev string    `json:"expectedPrevHash,omitempty"`
+	PrevHash     string    `json:"prevHash,omitempty"`
+	ExpectedHash string    `json:"expectedHash,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+}
+
+type VerifyResult struct {
+	OK       bool        `json:"ok"`
+	Entries  int         `json:"entries"`
+	Signed   int64       `json:"signed"`
+	LastSeq  int64       `json:"lastSeq"`
+	HeadSeq  int64       `json:"headSeq"`
+	LastHash string      `json:"lastHash"`
+	Broken   *ChainBreak `json:"firstBroken,omitempty"`
+	// Checkpoints is the number of signed checkpoints the chain matched;
+	// AnchoredSeq is the seq covered by the latest of them.
+	Checkpoints int   `json:"checkpoints"`
+	AnchoredSeq int64 `json:"anchoredSeq"`
+	// ArchivedSeq is the last seq moved to an archive; verification of the
+	// entries after it starts from the chain state the archive recorded.
+	ArchivedSeq int64 `json:"archivedSeq,omitempty"`
+	// StrictFromSeq is the first hash version 2 entry. Before it, entries
+	// were written by versions that restarted the chain at an empty
+	// prev_hash; those restarts are listed in LegacyRestarts rather than
+	// reported as breaks. From it on, every signed entry must link.
+	StrictFromSeq  int64   `json:"strictFromSeq,omitempty"`
+	LegacyRestarts []int64 `json:"legacyRestarts,omitempty"`
+}
+
+// Verifier checks entries one at a time in chain order. Entries written
+// while signing was disabled carry no hash and are skipped; each signed
+// entry must link to the previous signed one, except that legacy (hash
+// version 1) entries before the first version 2 entry may restart the chain
+// at an empty prev_hash. Checkpoints given with AddCheckpoints must match the
+// chain at their seq.
+type Verifier struct {
+	result      VerifyResult
+	checkpoints []Checkpoint
+}
+
+// ResumeVerifier continues verification after a trusted checkpoint, checking
+// only the entries that follow it.
+func ResumeVerifier(cp Checkpoint) *Verifier {
+	v := resumeVerifier(cp.Seq, cp.Seq, cp.Count, cp.HeadHash)
+	v.result.Checkpoints = 1
+	v.result.AnchoredSeq = cp.Seq
+	return v
+}
+
+// resumeVerifier starts after lastSeq with the chain state at that point.
+func resumeVerifier(lastSeq, headSeq, count int64, headHash string) *Verifier {
+	return &Verifier{result: VerifyResult{
+		Signed:   count,
+		LastSeq:  lastSeq,
+		HeadSeq:  headSeq,
+		LastHash: headHash,
+	}}
+}
+
+// AddCheckpoints registers verified checkpoints. It must be called before
+// the first entry is added. Checkpoints at or before the resume point are
+// ignored.
+func (v *Verifier) AddCheckpoints(cps ...Checkpoint) {
+	for _, cp := range cps {
+		if cp.Seq > v.result.LastSeq {
+			v.checkpoints = append(v.checkpoints, cp)
+		}
+	}
+	sort.Slice(v.checkpoints, func(i, j int) bool { return v.checkpoints[i].Seq < v.checkpoints[j].Seq })
+}
+
+// Add checks the next entry and returns the break it found, if any. After a
+// break further entries are not checked.
+func (v *Verifier) Add(entry store.AuditEntry) *ChainBreak {
+	if v.result.Broken != nil {
+		return v.result.Broken
+	}
+	if brk := v.checkpointsBefore(entry.Seq); brk != nil {
+		return brk
+	}
+	if brk := v.add(entry); brk != nil {
+		return brk
+	}
+	return v.checkpointsBefore(entry.Seq + 1)
+}
+
+// Finish checks the checkpoints past the last entry: entries they cover are
+// missing, so the log was truncated.
+func (v *Verifier) Finish() VerifyResult {
+	if v.result.Broken == nil && len(v.checkpoints) > 0 {
+		cp := v.checkpoints[0]
+		v.result.Broken = &ChainBreak{
+			Seq:          cp.Seq,
+			Reason:       "entries covered by a signed checkpoint are missing",
+			ExpectedHash: cp.HeadHash,
+			Hash:         v.result.LastHash,
+		}
+	}
+	return v.Result()
+}
+
+// checkpointsBefore matches the current state against the checkpoints with
+// a seq below seq.
+func (v *Verifier) checkpointsBefore(seq int64) *ChainBreak {
+	for len(v.checkpoints) > 0 && v.checkpoints[0].Seq < seq {
+		cp := v.checkpoints[0]
+		v.checkpoints = v.checkpoints[1:]
+		if cp.HeadHash != v.result.LastHash || cp.Count != v.result.Signed || cp.Seq != v.result.HeadSeq {
+			v.result.Broken = &ChainBreak{
+				Seq:          cp.Seq,
+				Reason:       "chain does not match the signed checkpoint",
+				ExpectedHash: cp.HeadHash,
+				Hash:         v.result.LastHash,
+			}
+			return v.result.Broken
+		}
+		v.result.Checkpoints++
+		v.result.AnchoredSeq = cp.Seq
+	}
+	return nil
+}
+
+func (v *Verifier) add(entry store.AuditEntry) *ChainBreak {
+	v.result.Entries++
+	v.result.LastSeq = entry.Seq
+	if entry.Hash == "" {
+		return nil
+	}
+	v.result.Signed++
+	if entry.HashVersion >= 2 && v.result.StrictFromSeq == 0 {
+		v.result.StrictFromSeq = entry.Seq
+	}
+	if entry.HashVersion < 2 && v.result.StrictFromSeq == 0 && entry.PrevHash == "" && v.result.LastHash != "" {
+		v.result.LegacyRestarts = append(v.result.LegacyRestarts, entry.Seq)
+		v.result.LastHash = ""
+	}
+	brk := &ChainBreak{Seq: entry.Seq, ID: entry.ID, EventType: entry.EventType, CreatedAt: entry.CreatedAt}
+	payload, err := canonicalDetails(entry.Details)
+	switch {
+	case err != nil:
+		brk.Reason = "details are not valid JSON"
+	case entry.PrevHash != v.result.LastHash:
+		brk.Reason = "prev_hash does not match the previous signed entry"
+		brk.ExpectedPrev = v.result.LastHash
+		brk.PrevHash = entry.PrevHash
+	default:
+		expected, err := entryHash(entry.PrevHash, entry, payload)
+		if err == nil && expected == entry.Hash {
+			v.result.LastHash = entry.Hash
+			v.result.HeadSeq = entry.Seq
+			return nil
+		}
+		brk.Reason = "hash does not match details"
+		brk.ExpectedHash = expected
+		brk.Hash = entry.Hash
+	}
+	v.result.Broken = brk
+	return brk
+}
+
+func (v *Verifier) Result() VerifyResult {
+	result := v.result
+	result.OK = result.Broken == nil
+	return result
+}
+
+// canonicalDetails re-encodes stored details the way LogEvent hashed them.
+// JSONB does not keep key order or spacing, so the stored text is not used
+// as is.
+func canonicalDetails(raw []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return util.CanonicalJSON(map[string]any{})
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var details any
+	if err := dec.Decode(&details); err != nil {
+		return nil, err
+	}
+	return util.CanonicalJSON(details)
+}
+
+// VerifyStore walks the chain in the database, starting after the latest
+// archive if entries were archived. With a public key the
+// stored checkpoints signed by it are checked too; a checkpoint whose
+// signature does not verify is reported as a break.
+func VerifyStore(ctx context.Context, st *store.Store, pub ed25519.PublicKey) (VerifyResult, error) {
+	v := &Verifier{}
+	archive, err := st.LatestAuditArchive(ctx)
+	switch {
+	case err == nil:
+		v = resumeVerifier(archive.LastSeq, archive.HeadSeq, archive.SignedCount, archive.HeadHash)
+		v.result.ArchivedSeq = archive.LastSeq
+	case !errors.Is(err, store.ErrNotFound):
+		return VerifyResult{}, err
+	}
+	if pub != nil {
+		stored, err := st.ListAuditCheckpoints(ctx)
+		if err != nil {
+			return VerifyResult{}, err
+		}
+		for _, sc := range stored {
+			if sc.KeyID != KeyID(pub) {
+				// Signed with a retired key; verify those with the CLI.
+				continue
+			}
+			cp, err := OpenCheckpoint([]byte(sc.Statement), sc.Signature, pub)
+			if err != nil {
+				v.result.Broken = &ChainBreak{Seq: sc.Seq, Reason: "checkpoint " + err.Error()}
+				return v.Result(), nil
+			}
+			v.AddCheckpoints(cp)
+		}
+	}
+	if err := walkChain(ctx, st, v.result.LastSeq, v); err != nil {
+		return VerifyResult{}, err
+	}
+	return v.Finish(), nil
+}
+
+func walkChain(ctx context.Context, st *store.Store, after int64, v *Verifier) error {
+	for {
+		entries, err := st.ListAuditAfter(ctx, after, 1000)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if v.Add(entry) != nil {
+				return nil
+			}
+			after = entry.Seq
+		}
+		if len(entries) < 1000 {
+			return nil
+		}
+	}
+}
+
+// ReadExport reads audit entries from an export: either NDJSON as written by
+// GET /audit?ndjson=true and in archives, or CSV with a header row as written
+// by `\copy audit_log TO ... CSV HEADER`, optionally gzipped. Entries are
+// returned in chain order.
+func ReadExport(r io.Reader) ([]store.AuditEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+	trimmed := bytes.TrimSpace(data)
+	var entries []store.AuditEntry
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		entries, err = readNDJSON(trimmed)
+	} else {
+		entries, err = readCSV(trimmed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Seq != entries[j].Seq {
+			return entries[i].Seq < entries[j].Seq
+		}
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+func readNDJSON(data []byte) ([]store.AuditEntry, error) {
+	var entries []store.AuditEntry
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry store.AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readCSV(data []byte) ([]store.AuditEntry, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	col := map[string]int{}
+	for i, name := range records[0] {
+		col[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"id", "event_type", "details", "created_at", "prev_hash", "hash"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("csv export is missing column %q", name)
+		}
+	}
+	var entries []store.AuditEntry
+	for i, rec := range records[1:] {
+		line := i + 2
+		id, err := uuid.Parse(rec[col["id"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		createdAt, err := parseExportTime(rec[col["created_at"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entry := store.AuditEntry{
+			ID:        id,
+			EventType: rec[col["event_type"]],
+			Details:   []byte(rec[col["details"]]),
+			CreatedAt: createdAt,
+			PrevHash:  rec[col["prev_hash"]],
+			Hash:      rec[col["hash"]],
+		}
+		if idx, ok := col["seq"]; ok {
+			if entry.Seq, err = strconv.ParseInt(rec[idx], 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if idx, ok := col["hash_version"]; ok {
+			if entry.HashVersion, err = strconv.Atoi(rec[idx]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		for name, target := range map[string]**uuid.UUID{"actor_user_id": &entry.ActorID, "session_id": &entry.SessionID} {
+			if idx, ok := col[name]; ok && rec[idx] != "" {
+				id, err := uuid.Parse(rec[idx])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				*target = &id
+			}
+		}
+		for name, target := range map[string]*string{"request_id": &entry.RequestID, "client_ip": &entry.ClientIP, "user_agent": &entry.UserAgent} {
+			if idx, ok := col[name]; ok {
+				*target = rec[idx]
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseExportTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid created_at " + strconv.Quote(value))
+}
@@ -0,0 +1,258 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+// buildChain signs details the way LogEvent does and stores them re-encoded
+// with different spacing, as JSONB would.
+func buildChain(t *testing.T, details ...map[string]any) []store.AuditEntry {
+	t.Helper()
+	var entries []store.AuditEntry
+	prev := ""
+	for i, d := range details {
+		payload, err := canonicalPayload(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded any
+		_ = json.Unmarshal(payload, &decoded)
+		stored, _ := json.MarshalIndent(decoded, "", "  ")
+		hash := computeHash(prev, payload)
+		entries = append(entries, store.AuditEntry{
+			ID:        uuid.New(),
+			Seq:       int64(i + 1),
+			EventType: "test",
+			Details:   stored,
+			CreatedAt: time.Unix(int64(i), 0).UTC(),
+			PrevHash:  prev,
+			Hash:      hash,
+		})
+		prev = hash
+	}
+	return entries
+}
+
+func verify(entries []store.AuditEntry) VerifyResult {
+	var v Verifier
+	for _, e := range entries {
+		v.Add(e)
+	}
+	return v.Result()
+}
+
+func TestVerifierAcceptsValidChain(t *testing.T) {
+	entries := buildChain(t,
+		map[string]any{"b": 1, "a": "x<y"},
+		map[string]any{"nested": map[string]any{"z": true, "y": []string{"q"}}},
+		nil,
+	)
+	unsigned := store.AuditEntry{ID: uuid.New(), Seq: 10, Details: []byte(`{}`)}
+	result := verify(append(entries, unsigned))
+	if !result.OK || result.Signed != 3 || result.Entries != 4 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result.LastHash != entries[2].Hash {
+		t.Fatalf("last hash %q, want %q", result.LastHash, entries[2].Hash)
+	}
+}
+
+func TestVerifierReportsTampering(t *testing.T) {
+	entries := buildChain(t, map[string]any{"a": 1}, map[string]any{"a": 2}, map[string]any{"a": 3})
+	entries[1].Details = []byte(`{"a": 20}`)
+	result := verify(entries)
+	if result.OK || result.Broken.Seq != 2 || !strings.Contains(result.Broken.Reason, "hash") {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestVerifierReportsFork(t *testing.T) {
+	entries := buildChain(t, map[string]any{"a": 1}, map[string]any{"a": 2})
+	forked := store.AuditEntry{
+		ID:       uuid.New(),
+		Seq:      3,
+		Details:  []byte(`{"a":3}`),
+		PrevHash: entries[0].Hash,
+		Hash:     computeHash(entries[0].Hash, []byte(`{"a":3}`)),
+	}
+	result := verify(append(entries, forked))
+	if result.OK || result.Broken.Seq != 3 || result.Broken.ExpectedPrev != entries[1].Hash {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestReadExport(t *testing.T) {
+	entries := buildChain(t, map[string]any{"a": 1}, map[string]any{"a": 2})
+	var ndjson strings.Builder
+	for i := len(entries) - 1; i >= 0; i-- {
+		line, _ := json.Marshal(entries[i])
+		ndjson.Write(line)
+		ndjson.WriteString("\n")
+	}
+	read, err := ReadExport(strings.NewReader(ndjson.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := verify(read); !result.OK || result.Signed != 2 {
+		t.Fatalf("ndjson: unexpected result %+v", result)
+	}
+
+	csv := "id,event_type,actor_user_id,details,created_at,prev_hash,hash,error_id,seq\n"
+	for _, e := range entries {
+		csv += e.ID.String() + ",test,," + `"` + strings.ReplaceAll(string(e.Details), `"`, `""`) + `"` +
+			",2024-01-02 03:04:05.123456+00," + e.PrevHash + "," + e.Hash + ",," + strconv.FormatInt(e.Seq, 10) + "\n"
+	}
+	read, err = ReadExport(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := verify(read); !result.OK || result.Signed != 2 {
+		t.Fatalf("csv: unexpected result %+v", result)
+	}
+}
+
+func signCheckpoint(t *testing.T, key ed25519.PrivateKey, cp Checkpoint) ([]byte, string) {
+	t.Helper()
+	cp.Version = checkpointVersion
+	statement, err := json.Marshal(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return statement, base64.StdEncoding.EncodeToString(ed25519.Sign(key, statement))
+}
+
+func TestOpenCheckpoint(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	statement, sig := signCheckpoint(t, key, Checkpoint{Seq: 3, Count: 2, HeadHash: "abc", KeyID: KeyID(pub)})
+	cp, err := OpenCheckpoint(statement, sig, pub)
+	if err != nil || cp.Seq != 3 || cp.HeadHash != "abc" {
+		t.Fatalf("unexpected checkpoint %+v, %v", cp, err)
+	}
+	forged := []byte(strings.Replace(string(statement), `"seq":3`, `"seq":4`, 1))
+	if _, err := OpenCheckpoint(forged, sig, pub); err == nil {
+		t.Fatal("expected forged statement to fail")
+	}
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if _, err := OpenCheckpoint(statement, sig, otherPub); err == nil {
+		t.Fatal("expected wrong key to fail")
+	}
+}
+
+func TestVerifierCheckpoints(t *testing.T) {
+	entries := buildChain(t, map[string]any{"a": 1}, map[string]any{"a": 2}, map[string]any{"a": 3})
+	anchor := Checkpoint{Seq: 2, Count: 2, HeadHash: entries[1].Hash}
+
+	var v Verifier
+	v.AddCheckpoints(anchor)
+	for _, e := range entries {
+		v.Add(e)
+	}
+	if result := v.Finish(); !result.OK || result.Checkpoints != 1 || result.AnchoredSeq != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// A rewritten chain is internally consistent but does not match the
+	// checkpoint signed before the rewrite.
+	rewritten := buildChain(t, map[string]any{"a": 1}, map[string]any{"a": 20}, map[string]any{"a": 3})
+	v = Verifier{}
+	v.AddCheckpoints(anchor)
+	for _, e := range rewritten {
+		v.Add(e)
+	}
+	if result := v.Finish(); result.OK || result.Broken.Seq != 2 {
+		t.Fatalf("rewrite: unexpected result %+v", result)
+	}
+
+	// Dropping the tail leaves the checkpoint uncovered.
+	v = Verifier{}
+	v.AddCheckpoints(anchor)
+	v.Add(entries[0])
+	if result := v.Finish(); result.OK || !strings.Contains(result.Broken.Reason, "missing") {
+		t.Fatalf("truncation: unexpected result %+v", result)
+	}
+}
+
+func TestResumeVerifier(t *testing.T) {
+	entries := buildChain(t, map[string]any{"a": 1}, map[string]any{"a": 2}, map[string]any{"a": 3})
+	v := ResumeVerifier(Checkpoint{Seq: 2, Count: 2, HeadHash: entries[1].Hash})
+	v.Add(entries[2])
+	if result := v.Finish(); !result.OK || result.Signed != 3 || result.HeadSeq != 3 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestVerifierHashVersion2CoversContext(t *testing.T) {
+	actor := uuid.New()
+	entry := store.AuditEntry{
+		ID:          uuid.New(),
+		Seq:         1,
+		EventType:   "settings_update",
+		ActorID:     &actor,
+		RequestID:   "req-1",
+		ClientIP:    "203.0.113.7",
+		UserAgent:   "curl/8.0",
+		Details:     []byte(`{"a":1}`),
+		HashVersion: hashVersion,
+	}
+	var err error
+	if entry.Hash, err = entryHash("", entry, []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if result := verify([]store.AuditEntry{entry}); !result.OK {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	tampered := entry
+	tampered.ClientIP = "198.51.100.1"
+	if result := verify([]store.AuditEntry{tampered}); result.OK {
+		t.Fatal("expected changed client IP to break the chain")
+	}
+	tampered = entry
+	tampered.EventType = "login"
+	if result := verify([]store.AuditEntry{tampered}); result.OK {
+		t.Fatal("expected changed event type to break the chain")
+	}
+}
+
+func TestVerifierLegacyRestarts(t *testing.T) {
+	link := func(seq int64, prev string, version int) store.AuditEntry {
+		entry := store.AuditEntry{ID: uuid.New(), Seq: seq, EventType: "test", Details: []byte(`{"a":1}`), PrevHash: prev, HashVersion: version}
+		var err error
+		if entry.Hash, err = entryHash(prev, entry, []byte(`{"a":1}`)); err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+	first := link(1, "", 1)
+	second := link(2, first.Hash, 1)
+	restart := link(3, "", 1)
+	upgraded := link(4, restart.Hash, hashVersion)
+	result := verify([]store.AuditEntry{first, second, restart, upgraded})
+	if !result.OK || result.StrictFromSeq != 4 || !reflect.DeepEqual(result.LegacyRestarts, []int64{3}) {
+		t.Fatalf("expected the legacy restart to be reported separately, got %+v", result)
+	}
+
+	for _, version := range []int{1, hashVersion} {
+		late := link(5, "", version)
+		result = verify([]store.AuditEntry{first, second, restart, upgraded, late})
+		if result.OK || result.Broken.Seq != 5 {
+			t.Fatalf("expected a restart after strict verification began to break the chain, got %+v", result)
+		}
+	}
+
+	tampered := restart
+	tampered.Details = []byte(`{"a":2}`)
+	if result := verify([]store.AuditEntry{first, second, tampered}); result.OK || result.Broken.Seq != 3 {
+		t.Fatalf("expected legacy entries to still be hash checked, got %+v", result)
+	}
+}
@@ -0,0 +1,281 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"flowdb/backend/store"
+)
+
+const checkpointVersion = 1
+
+// Checkpoint states that the chain up to Seq holds Count signed entries and
+// ends in HeadHash.
+type Checkpoint struct {
+	Version   int       `json:"version"`
+	Seq       int64     `json:"seq"`
+	Count     int64     `json:"count"`
+	HeadHash  string    `json:"headHash"`
+	KeyID     string    `json:"keyId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// KeyID identifies a public key: the first 8 bytes of its SHA-256, in hex.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// OpenCheckpoint verifies a detached base64 signature over statement and
+// decodes it.
+func OpenCheckpoint(statement []byte, signature string, pub ed25519.PublicKey) (Checkpoint, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return Checkpoint{}, errors.New("signature is not base64")
+	}
+	if !ed25519.Verify(pub, statement, sig) {
+		return Checkpoint{}, errors.New("signature does not verify")
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(statement, &cp); err != nil {
+		return Checkpoint{}, err
+	}
+	if cp.Version != checkpointVersion {
+		return Checkpoint{}, fmt.Errorf("unsupported version %d", cp.Version)
+	}
+	return cp, nil
+}
+
+// LoadSigningKey reads a PEM PKCS#8 Ed25519 private key, as written by
+// `openssl genpkey -algorithm ed25519`.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New(path + ": not an Ed25519 private key")
+	}
+	return priv, nil
+}
+
+// LoadPublicKey reads a PEM PKIX Ed25519 public key, as written by
+// `openssl pkey -pubout`.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New(path + ": not an Ed25519 public key")
+	}
+	return pub, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New(path + ": no PEM data")
+	}
+	return block, nil
+}
+
+// Checkpointer periodically signs the chain head with a key kept outside the
+// database and publishes the checkpoint to a directory and/or a URL. The
+// entries since the previous checkpoint are verified before signing, so a
+// broken chain is never signed.
+type Checkpointer struct {
+	store    *store.Store
+	key      ed25519.PrivateKey
+	dir      string
+	url      string
+	interval time.Duration
+	client   *http.Client
+	logger   *slog.Logger
+}
+
+func NewCheckpointer(st *store.Store, key ed25519.PrivateKey, dir string, url string, interval time.Duration, logger *slog.Logger) *Checkpointer {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Checkpointer{
+		store:    st,
+		key:      key,
+		dir:      dir,
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+	}
+}
+
+func (c *Checkpointer) PublicKey() ed25519.PublicKey {
+	return c.key.Public().(ed25519.PublicKey)
+}
+
+func (c *Checkpointer) Start(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := c.Checkpoint(ctx); err != nil {
+					c.logger.Error("audit checkpoint failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Checkpoint signs the current chain head. It returns nil when no signed
+// entry was added since the previous checkpoint.
+func (c *Checkpointer) Checkpoint(ctx context.Context) (*store.AuditCheckpoint, error) {
+	pub := c.PublicKey()
+	v := &Verifier{}
+	latest, err := c.store.LatestAuditCheckpoint(ctx)
+	switch {
+	case err == nil:
+		prev, err := OpenCheckpoint([]byte(latest.Statement), latest.Signature, pub)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %d: %w", latest.Seq, err)
+		}
+		v = ResumeVerifier(prev)
+	case !errors.Is(err, store.ErrNotFound):
+		return nil, err
+	}
+	if err := walkChain(ctx, c.store, v.result.LastSeq, v); err != nil {
+		return nil, err
+	}
+	result := v.Result()
+	if result.Broken != nil {
+		return nil, fmt.Errorf("audit chain broken at seq %d: %s", result.Broken.Seq, result.Broken.Reason)
+	}
+	if result.Signed == 0 || result.HeadSeq == result.AnchoredSeq {
+		return nil, nil
+	}
+	statement, err := json.Marshal(Checkpoint{
+		Version:   checkpointVersion,
+		Seq:       result.HeadSeq,
+		Count:     result.Signed,
+		HeadHash:  result.LastHash,
+		KeyID:     KeyID(pub),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	cp := store.AuditCheckpoint{
+		Seq:         result.HeadSeq,
+		SignedCount: result.Signed,
+		HeadHash:    result.LastHash,
+		KeyID:       KeyID(pub),
+		Statement:   string(statement),
+		Signature:   base64.StdEncoding.EncodeToString(ed25519.Sign(c.key, statement)),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := c.store.CreateAuditCheckpoint(ctx, cp); err != nil {
+		return nil, err
+	}
+	c.publish(ctx, cp)
+	return &cp, nil
+}
+
+// publish writes checkpoint-<seq>.json and its detached checkpoint-<seq>.sig
+// to the directory and posts both to the URL. Failures are logged; the
+// checkpoint is already stored.
+func (c *Checkpointer) publish(ctx context.Context, cp store.AuditCheckpoint) {
+	if c.dir != "" {
+		base := filepath.Join(c.dir, fmt.Sprintf("checkpoint-%020d", cp.Seq))
+		if err := os.WriteFile(base+".json", []byte(cp.Statement), 0o644); err != nil {
+			c.logger.Error("audit checkpoint write failed", "seq", cp.Seq, "error", err)
+		} else if err := os.WriteFile(base+".sig", []byte(cp.Signature+"\n"), 0o644); err != nil {
+			c.logger.Error("audit checkpoint write failed", "seq", cp.Seq, "error", err)
+		}
+	}
+	if c.url != "" {
+		body, err := json.Marshal(map[string]any{
+			"statement": json.RawMessage(cp.Statement),
+			"signature": cp.Signature,
+			"keyId":     cp.KeyID,
+		})
+		if err != nil {
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+		if err != nil {
+			c.logger.Error("audit checkpoint post failed", "seq", cp.Seq, "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.client.Do(req)
+		if err != nil {
+			c.logger.Error("audit checkpoint post failed", "seq", cp.Seq, "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			c.logger.Error("audit checkpoint post failed", "seq", cp.Seq, "status", resp.StatusCode)
+		}
+	}
+}
+
+// ReadCheckpointDir loads and verifies every checkpoint-*.json with its .sig
+// in dir.
+func ReadCheckpointDir(dir string, pub ed25519.PublicKey) ([]Checkpoint, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "checkpoint-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var cps []Checkpoint
+	for _, path := range paths {
+		statement, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := os.ReadFile(strings.TrimSuffix(path, ".json") + ".sig")
+		if err != nil {
+			return nil, err
+		}
+		cp, err := OpenCheckpoint(statement, string(sig), pub)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		cps = append(cps, cp)
+	}
+	return cps, nil
+}
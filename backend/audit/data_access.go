@@ -0,0 +1,264 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/middleware"
+
+	"github.com/google/uuid"
+)
+
+// Data access operations.
+const (
+	AccessListNamespaces = "list_namespaces"
+	AccessListEntities   = "list_entities"
+	AccessEntityInfo     = "entity_info"
+	AccessBrowse         = "browse"
+	AccessExport         = "export"
+	AccessQuery          = "query"
+)
+
+// DataAccess describes data returned to a user.
+type DataAccess struct {
+	Operation     string
+	ConnectionID  uuid.UUID
+	Resource      string
+	Columns       []string
+	RowCount      int
+	MaskedColumns []string
+	Environment   string
+	Prod          bool
+}
+
+func (a DataAccess) details() map[string]any {
+	details := map[string]any{
+		"operation":     a.Operation,
+		"connectionId":  a.ConnectionID.String(),
+		"resource":      a.Resource,
+		"columns":       nonNil(a.Columns),
+		"rowCount":      a.RowCount,
+		"maskedColumns": nonNil(a.MaskedColumns),
+		"prod":          a.Prod,
+	}
+	if a.Environment != "" {
+		details["environment"] = a.Environment
+	}
+	return details
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+type eventLogger interface {
+	LogEvent(ctx context.Context, eventType string, userID *uuid.UUID, details map[string]any, errorID string) error
+}
+
+// DataAccessLog records data_access events. Access to prod-tagged
+// connections, exports and queries is always recorded one event per access.
+// Other access (browsing, entity info, listings) is sampled at the given
+// percentage, and with a window, repeated access by the same user to the same
+// resource is recorded once when it starts and then as one aggregated event
+// per window. Aggregated events are not tied to a single request; they carry
+// the first and last request IDs of the window instead.
+type DataAccessLog struct {
+	logger  eventLogger
+	percent int
+	window  time.Duration
+	log     *slog.Logger
+	now     func() time.Time
+	sample  func() int
+
+	mu     sync.Mutex
+	groups map[accessKey]*accessGroup
+}
+
+type accessKey struct {
+	userID    uuid.UUID
+	operation string
+	resource  string
+}
+
+type accessGroup struct {
+	userID       uuid.UUID
+	access       DataAccess
+	columns      map[string]bool
+	masked       map[string]bool
+	count        int
+	start        time.Time
+	first        time.Time
+	last         time.Time
+	firstRequest string
+	lastRequest  string
+	breakGlassID string
+}
+
+func NewDataAccessLog(logger *Logger, percent int, window time.Duration, log *slog.Logger) *DataAccessLog {
+	return newDataAccessLog(logger, percent, window, log)
+}
+
+func newDataAccessLog(logger eventLogger, percent int, window time.Duration, log *slog.Logger) *DataAccessLog {
+	if log == nil {
+		log = slog.Default()
+	}
+	return &DataAccessLog{
+		logger:  logger,
+		percent: percent,
+		window:  window,
+		log:     log,
+		now:     time.Now,
+		sample:  func() int { return rand.Intn(100) },
+		groups:  map[accessKey]*accessGroup{},
+	}
+}
+
+// Record logs an access by userID, subject to sampling and aggregation.
+func (d *DataAccessLog) Record(ctx context.Context, userID uuid.UUID, access DataAccess) {
+	if d.always(access) {
+		d.write(ctx, userID, access.details())
+		return
+	}
+	if d.percent < 100 && d.sample() >= d.percent {
+		return
+	}
+	details := access.details()
+	if d.percent < 100 {
+		details["samplePercent"] = d.percent
+	}
+	if d.window > 0 && d.fold(ctx, userID, access) {
+		return
+	}
+	d.write(ctx, userID, details)
+}
+
+func (d *DataAccessLog) always(access DataAccess) bool {
+	return access.Prod || access.Operation == AccessExport || access.Operation == AccessQuery
+}
+
+// fold adds the access to an open window and reports whether it did. The
+// first access opens the window and is logged by the caller.
+func (d *DataAccessLog) fold(ctx context.Context, userID uuid.UUID, access DataAccess) bool {
+	key := accessKey{userID: userID, operation: access.Operation, resource: access.Resource}
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	group, ok := d.groups[key]
+	if !ok {
+		access.RowCount = 0
+		d.groups[key] = &accessGroup{
+			userID:  userID,
+			access:  access,
+			columns: map[string]bool{},
+			masked:  map[string]bool{},
+			start:   now,
+		}
+		return false
+	}
+	requestID, _ := middleware.RequestIDFromContext(ctx)
+	if group.count == 0 {
+		group.first = now
+		group.firstRequest = requestID
+	}
+	group.lastRequest = requestID
+	if bg, ok := auth.BreakGlassFromContext(ctx); ok {
+		group.breakGlassID = bg.ID.String()
+	}
+	group.count++
+	group.access.RowCount += access.RowCount
+	for _, c := range access.Columns {
+		group.columns[c] = true
+	}
+	for _, c := range access.MaskedColumns {
+		group.masked[c] = true
+	}
+	group.last = now
+	return true
+}
+
+// Start flushes closed windows in the background and every open one when
+// ctx is done.
+func (d *DataAccessLog) Start(ctx context.Context) {
+	if d.window <= 0 {
+		return
+	}
+	interval := d.window / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				d.flush(true)
+				return
+			case <-ticker.C:
+				d.flush(false)
+			}
+		}
+	}()
+}
+
+// flush logs an aggregated event for each window that has ended, or for
+// every window when all is set. Windows with no access after the first are
+// dropped silently since that access was already logged.
+func (d *DataAccessLog) flush(all bool) {
+	now := d.now()
+	var closed []*accessGroup
+	d.mu.Lock()
+	for key, group := range d.groups {
+		if all || !now.Before(group.start.Add(d.window)) {
+			delete(d.groups, key)
+			if group.count > 0 {
+				closed = append(closed, group)
+			}
+		}
+	}
+	d.mu.Unlock()
+	for _, group := range closed {
+		access := group.access
+		access.Columns = sortedKeys(group.columns)
+		access.MaskedColumns = sortedKeys(group.masked)
+		details := access.details()
+		details["aggregated"] = true
+		details["accesses"] = group.count
+		details["from"] = group.first.UTC().Format(time.RFC3339Nano)
+		details["to"] = group.last.UTC().Format(time.RFC3339Nano)
+		if group.firstRequest != "" {
+			details["firstRequestId"] = group.firstRequest
+			details["lastRequestId"] = group.lastRequest
+		}
+		if group.breakGlassID != "" {
+			details["breakGlassId"] = group.breakGlassID
+		}
+		if d.percent < 100 {
+			details["samplePercent"] = d.percent
+		}
+		d.write(context.Background(), group.userID, details)
+	}
+}
+
+func (d *DataAccessLog) write(ctx context.Context, userID uuid.UUID, details map[string]any) {
+	if err := d.logger.LogEvent(ctx, "data_access", &userID, details, ""); err != nil {
+		d.log.Error("data access audit failed", "resource", details["resource"], "error", err)
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"flowdb/backend/middleware"
+
+	"github.com/google/uuid"
+)
+
+type recordedEvent struct {
+	userID  uuid.UUID
+	details map[string]any
+}
+
+type fakeLogger struct {
+	events []recordedEvent
+}
+
+func (f *fakeLogger) LogEvent(ctx context.Context, eventType string, userID *uuid.UUID, details map[string]any, errorID string) error {
+	f.events = append(f.events, recordedEvent{userID: *userID, details: details})
+	return nil
+}
+
+func TestDataAccessAggregatesRepeatedBrowsing(t *testing.T) {
+	logger := &fakeLogger{}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newDataAccessLog(logger, 100, time.Minute, nil)
+	d.now = func() time.Time { return now }
+	user := uuid.New()
+	browse := DataAccess{Operation: AccessBrowse, Resource: "connection/x/db/app/entity/users", Columns: []string{"id", "email"}, RowCount: 10, MaskedColumns: []string{"email"}}
+
+	for i := 0; i < 3; i++ {
+		ctx := context.WithValue(context.Background(), middleware.RequestIDKey, fmt.Sprintf("req-%d", i))
+		d.Record(ctx, user, browse)
+		now = now.Add(10 * time.Second)
+	}
+	if len(logger.events) != 1 {
+		t.Fatalf("expected the first access only, got %d events", len(logger.events))
+	}
+	d.Record(context.Background(), user, DataAccess{Operation: AccessBrowse, Resource: browse.Resource, Prod: true, RowCount: 5})
+	if len(logger.events) != 2 {
+		t.Fatalf("expected prod access to be logged immediately, got %d events", len(logger.events))
+	}
+
+	d.flush(false)
+	if len(logger.events) != 2 {
+		t.Fatalf("expected the window to stay open, got %d events", len(logger.events))
+	}
+	now = now.Add(time.Minute)
+	d.flush(false)
+	if len(logger.events) != 3 {
+		t.Fatalf("expected an aggregated event, got %d events", len(logger.events))
+	}
+	agg := logger.events[2].details
+	if agg["aggregated"] != true || agg["accesses"] != 2 || agg["rowCount"] != 20 || agg["prod"] != false {
+		t.Fatalf("unexpected aggregated event %v", agg)
+	}
+	if agg["firstRequestId"] != "req-1" || agg["lastRequestId"] != "req-2" {
+		t.Fatalf("unexpected request ids %v", agg)
+	}
+	if masked := agg["maskedColumns"].([]string); len(masked) != 1 || masked[0] != "email" {
+		t.Fatalf("unexpected masked columns %v", masked)
+	}
+
+	d.Record(context.Background(), user, browse)
+	if len(logger.events) != 4 {
+		t.Fatalf("expected a new window to log its first access, got %d events", len(logger.events))
+	}
+}
+
+func TestDataAccessSampling(t *testing.T) {
+	logger := &fakeLogger{}
+	d := newDataAccessLog(logger, 25, 0, nil)
+	draws := []int{10, 60, 24, 99}
+	d.sample = func() int {
+		v := draws[0]
+		draws = draws[1:]
+		return v
+	}
+	user := uuid.New()
+	for i := 0; i < 4; i++ {
+		d.Record(context.Background(), user, DataAccess{Operation: AccessEntityInfo, Resource: "connection/x"})
+	}
+	if len(logger.events) != 2 {
+		t.Fatalf("expected 2 sampled events, got %d", len(logger.events))
+	}
+	if logger.events[0].details["samplePercent"] != 25 {
+		t.Fatalf("expected sample rate on event, got %v", logger.events[0].details)
+	}
+	d.Record(context.Background(), user, DataAccess{Operation: AccessExport, Resource: "connection/x"})
+	if len(logger.events) != 3 {
+		t.Fatalf("expected exports to bypass sampling")
+	}
+}
@@ -4,56 +4,209 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"hash"
+	"net"
 	"time"
+	"unicode/utf8"
 
+	"flowdb/backend/auth"
+	"flowdb/backend/middleware"
 	"flowdb/backend/settings"
 	"flowdb/backend/store"
-	"flowdb/backend/util"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// Sink receives every event after it has been written to the audit log.
+type Sink interface {
+	Publish(ctx context.Context, eventType string, userID *uuid.UUID, details map[string]any)
+}
+
 type Logger struct {
 	store    *store.Store
 	settings *settings.Store
+	sinks    []Sink
 }
 
 func NewLogger(st *store.Store, settings *settings.Store) *Logger {
 	return &Logger{store: st, settings: settings}
 }
 
+// AddSink registers s to receive events. It must be called before the logger
+// is used.
+func (l *Logger) AddSink(s Sink) {
+	l.sinks = append(l.sinks, s)
+}
+
+// LogEvent appends an event. The actor defaults to the user of the request;
+// the session, request ID, client address and user agent are taken from the
+// request context. Events recorded while the request runs under a
+// break-glass elevation carry its id in details.breakGlassId.
 func (l *Logger) LogEvent(ctx context.Context, eventType string, userID *uuid.UUID, details map[string]any, errorID string) error {
-	payload, err := util.CanonicalJSON(details)
+	details = tagBreakGlass(ctx, details)
+	payload, err := canonicalPayload(details)
 	if err != nil {
 		return err
 	}
-	useHash := l.settings.Get().FlagEnabled("enable_signed_audit_log")
-	prevHash := ""
-	currentHash := ""
-	if useHash {
-		prevHash, err = l.lastHash(ctx)
-		if err != nil {
-			return err
+	entry := requestEntry(ctx, userID)
+	entry.ID = uuid.New()
+	entry.EventType = eventType
+	entry.Details = payload
+	entry.CreatedAt = time.Now().UTC()
+	entry.HashVersion = hashVersion
+	entry.ErrorID = errorID
+	if l.settings.Get().FlagEnabled("enable_signed_audit_log") {
+		err = l.appendChained(ctx, entry)
+	} else {
+		err = insertEntry(ctx, l.store.DB(), entry)
+	}
+	if err != nil {
+		return err
+	}
+	for _, sink := range l.sinks {
+		sink.Publish(ctx, eventType, entry.ActorID, details)
+	}
+	return nil
+}
+
+// tagBreakGlass returns details with the id of the break-glass elevation the
+// request runs under, if any.
+func tagBreakGlass(ctx context.Context, details map[string]any) map[string]any {
+	bg, ok := auth.BreakGlassFromContext(ctx)
+	if !ok {
+		return details
+	}
+	tagged := make(map[string]any, len(details)+1)
+	for k, v := range details {
+		tagged[k] = v
+	}
+	tagged["breakGlassId"] = bg.ID.String()
+	return tagged
+}
+
+// requestEntry fills the actor and request context of an entry.
+func requestEntry(ctx context.Context, userID *uuid.UUID) store.AuditEntry {
+	var entry store.AuditEntry
+	entry.ActorID = userID
+	if entry.ActorID == nil {
+		if user, ok := auth.UserFromContext(ctx); ok {
+			entry.ActorID = &user.ID
 		}
-		currentHash = computeHash(prevHash, payload)
 	}
-	_, err = l.store.DB().Exec(ctx, `
-		INSERT INTO audit_log (id, event_type, actor_user_id, details, created_at, prev_hash, hash, error_id)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
-	`, uuid.New(), eventType, userID, payload, time.Now().UTC(), prevHash, currentHash, errorID)
+	if session, ok := auth.SessionFromContext(ctx); ok {
+		entry.SessionID = &session.ID
+	}
+	entry.RequestID, _ = middleware.RequestIDFromContext(ctx)
+	if client, ok := middleware.ClientFromContext(ctx); ok {
+		if ip := net.ParseIP(client.IP); ip != nil {
+			entry.ClientIP = ip.String()
+		}
+		entry.UserAgent = truncate(client.UserAgent, 512)
+	}
+	return entry
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	value = value[:max]
+	for !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+	return value
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func insertEntry(ctx context.Context, db execer, e store.AuditEntry) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO audit_log (id, event_type, actor_user_id, session_id, request_id, client_ip, user_agent, details, created_at, prev_hash, hash, hash_version, error_id)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+	`, e.ID, e.EventType, e.ActorID, e.SessionID, e.RequestID, e.ClientIP, e.UserAgent, e.Details, e.CreatedAt, e.PrevHash, e.Hash, e.HashVersion, e.ErrorID)
 	return err
 }
 
-func (l *Logger) lastHash(ctx context.Context) (string, error) {
-	var hashValue string
-	err := l.store.DB().QueryRow(ctx, `
-		SELECT hash FROM audit_log ORDER BY created_at DESC LIMIT 1
-	`).Scan(&hashValue)
+// chainLockKey is the advisory lock serializing chained appends.
+const chainLockKey = 0x666c6f7764620001
+
+// appendChained links the entry to the latest signed one. The advisory lock
+// is held until commit, so concurrent appends cannot read the same head and
+// fork the chain.
+func (l *Logger) appendChained(ctx context.Context, entry store.AuditEntry) error {
+	tx, err := l.store.DB().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(chainLockKey)); err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx, `
+		SELECT hash FROM audit_log WHERE COALESCE(hash, '') <> '' ORDER BY seq DESC LIMIT 1
+	`).Scan(&entry.PrevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if entry.Hash, err = entryHash(entry.PrevHash, entry, entry.Details); err != nil {
+		return err
+	}
+	if err := insertEntry(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// canonicalPayload encodes details so that decoding the stored JSONB and
+// encoding it again gives the same bytes, which verification relies on.
+func canonicalPayload(details map[string]any) ([]byte, error) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	raw, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
+	}
+	return canonicalDetails(raw)
+}
+
+// hashVersion is the hash version of new entries.
+const hashVersion = 2
+
+// entryHash hashes an entry with the canonical details payload. Version 2
+// covers the event type and request context as well, as a JSON object ahead
+// of the details.
+func entryHash(prevHash string, entry store.AuditEntry, payload []byte) (string, error) {
+	if entry.HashVersion < 2 {
+		return computeHash(prevHash, payload), nil
+	}
+	fields := map[string]string{"eventType": entry.EventType}
+	if entry.ActorID != nil {
+		fields["actorId"] = entry.ActorID.String()
+	}
+	if entry.SessionID != nil {
+		fields["sessionId"] = entry.SessionID.String()
+	}
+	for key, value := range map[string]string{
+		"requestId": entry.RequestID,
+		"clientIp":  entry.ClientIP,
+		"userAgent": entry.UserAgent,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	meta, err := json.Marshal(fields)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
-	return hashValue, nil
+	return computeHash(prevHash, append(meta, payload...)), nil
 }
 
 func computeHash(prevHash string, payload []byte) string {
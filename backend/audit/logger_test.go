@@ -0,0 +1,26 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+func TestTagBreakGlass(t *testing.T) {
+	details := map[string]any{"action": "query:write"}
+	if got := tagBreakGlass(context.Background(), details); got["breakGlassId"] != nil {
+		t.Fatalf("unexpected tag outside break-glass: %v", got)
+	}
+	bg := store.BreakGlass{ID: uuid.New()}
+	got := tagBreakGlass(auth.WithBreakGlass(context.Background(), bg), details)
+	if got["breakGlassId"] != bg.ID.String() || got["action"] != "query:write" {
+		t.Fatalf("unexpected tagged details %v", got)
+	}
+	if _, ok := details["breakGlassId"]; ok {
+		t.Fatal("caller's details were modified")
+	}
+}
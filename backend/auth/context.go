@@ -9,8 +9,9 @@ import (
 type contextKey string
 
 const (
-	userKey    contextKey = "user"
-	sessionKey contextKey = "session"
+	userKey       contextKey = "user"
+	sessionKey    contextKey = "session"
+	breakGlassKey contextKey = "break_glass"
 )
 
 func WithUser(ctx context.Context, user store.User) context.Context {
@@ -38,3 +39,17 @@ func SessionFromContext(ctx context.Context) (store.Session, bool) {
 	session, ok := val.(store.Session)
 	return session, ok
 }
+
+// WithBreakGlass marks the request as running under an emergency elevation.
+func WithBreakGlass(ctx context.Context, b store.BreakGlass) context.Context {
+	return context.WithValue(ctx, breakGlassKey, b)
+}
+
+func BreakGlassFromContext(ctx context.Context) (store.BreakGlass, bool) {
+	val := ctx.Value(breakGlassKey)
+	if val == nil {
+		return store.BreakGlass{}, false
+	}
+	b, ok := val.(store.BreakGlass)
+	return b, ok
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeviceCookieName holds a random per-browser ID used to recognise devices
+// across sessions.
+const DeviceCookieName = "flowdb_device"
+
+const deviceCookieTTL = 400 * 24 * time.Hour
+
+// DeviceID returns the device ID from the request cookie, or a new one with
+// ok false when the browser has none yet.
+func DeviceID(r *http.Request) (id string, ok bool) {
+	if c, err := r.Cookie(DeviceCookieName); err == nil {
+		if parsed, err := uuid.Parse(c.Value); err == nil {
+			return parsed.String(), true
+		}
+	}
+	return uuid.NewString(), false
+}
+
+func SetDeviceCookie(w http.ResponseWriter, id string, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     DeviceCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(deviceCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// DeviceFingerprint identifies a device by its cookie ID and browser family,
+// so browser updates do not make it look new.
+func DeviceFingerprint(deviceID string, family string) string {
+	sum := sha256.Sum256([]byte(deviceID + "\n" + family))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeviceFamily reduces a User-Agent to "<browser>/<platform>", for example
+// "chrome/windows", ignoring versions.
+func DeviceFamily(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	browser := "other"
+	switch {
+	case strings.Contains(ua, "edg/"), strings.Contains(ua, "edga/"), strings.Contains(ua, "edgios/"):
+		browser = "edge"
+	case strings.Contains(ua, "opr/"), strings.Contains(ua, "opera"):
+		browser = "opera"
+	case strings.Contains(ua, "firefox/"), strings.Contains(ua, "fxios/"):
+		browser = "firefox"
+	case strings.Contains(ua, "chrome/"), strings.Contains(ua, "crios/"), strings.Contains(ua, "chromium/"):
+		browser = "chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "safari"
+	}
+	platform := "other"
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		platform = "ios"
+	case strings.Contains(ua, "android"):
+		platform = "android"
+	case strings.Contains(ua, "windows"):
+		platform = "windows"
+	case strings.Contains(ua, "cros"):
+		platform = "chromeos"
+	case strings.Contains(ua, "macintosh"), strings.Contains(ua, "mac os x"):
+		platform = "macos"
+	case strings.Contains(ua, "linux"):
+		platform = "linux"
+	}
+	return browser + "/" + platform
+}
@@ -0,0 +1,30 @@
+package auth
+
+import "testing"
+
+func TestDeviceFamily(t *testing.T) {
+	cases := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36":           "chrome/windows",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36":           "chrome/windows",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0": "edge/windows",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15":     "safari/macos",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/604.1": "safari/ios",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":                                                    "firefox/linux",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36":              "chrome/android",
+		"curl/8.4.0": "other/other",
+	}
+	for ua, want := range cases {
+		if got := DeviceFamily(ua); got != want {
+			t.Errorf("DeviceFamily(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
+
+func TestDeviceFingerprint(t *testing.T) {
+	if DeviceFingerprint("a", "chrome/windows") == DeviceFingerprint("b", "chrome/windows") {
+		t.Fatal("fingerprint does not depend on the device cookie")
+	}
+	if DeviceFingerprint("a", "chrome/windows") == DeviceFingerprint("a", "firefox/windows") {
+		t.Fatal("fingerprint does not depend on the browser family")
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -11,20 +12,50 @@ import (
 	"github.com/google/uuid"
 )
 
+// oidcStateTTL bounds how long an OIDC login may take between redirect and
+// callback.
+const oidcStateTTL = 10 * time.Minute
+
+// touchEvery throttles last-seen updates to one write per session and period.
+const touchEvery = time.Minute
+
 type SessionManager struct {
-	store      *store.Store
-	cookieName string
-	ttl        time.Duration
+	store        *store.Store
+	cookieName   string
+	ttl          time.Duration
+	idle         time.Duration
+	maxPerUser   int
+	cleanupEvery time.Duration
+	logger       *slog.Logger
 }
 
-func NewSessionManager(st *store.Store, cookieName string, ttl time.Duration) *SessionManager {
-	return &SessionManager{store: st, cookieName: cookieName, ttl: ttl}
+// NewSessionManager creates sessions that expire after ttl, or after idle
+// without use when idle is positive. maxPerUser, when positive, caps the
+// sessions a user may hold; the least recently used are evicted.
+func NewSessionManager(st *store.Store, cookieName string, ttl time.Duration, idle time.Duration, maxPerUser int, cleanupEvery time.Duration, logger *slog.Logger) *SessionManager {
+	if cleanupEvery <= 0 {
+		cleanupEvery = 10 * time.Minute
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SessionManager{
+		store:        st,
+		cookieName:   cookieName,
+		ttl:          ttl,
+		idle:         idle,
+		maxPerUser:   maxPerUser,
+		cleanupEvery: cleanupEvery,
+		logger:       logger,
+	}
 }
 
-func (s *SessionManager) Create(ctx context.Context, userID uuid.UUID, mfaAt *time.Time) (store.Session, error) {
+// Create starts a session for userID and returns it with the ids of the
+// sessions evicted to stay within the per-user limit.
+func (s *SessionManager) Create(ctx context.Context, userID uuid.UUID, mfaAt *time.Time, clientIP string, userAgent string) (store.Session, []uuid.UUID, error) {
 	csrf, err := util.RandomToken(32)
 	if err != nil {
-		return store.Session{}, err
+		return store.Session{}, nil, err
 	}
 	now := time.Now().UTC()
 	sess := store.Session{
@@ -33,8 +64,74 @@ func (s *SessionManager) Create(ctx context.Context, userID uuid.UUID, mfaAt *ti
 		ExpiresAt:  now.Add(s.ttl),
 		LastAuthAt: now,
 		LastMFAAt:  mfaAt,
+		ClientIP:   clientIP,
+		UserAgent:  userAgent,
 	}
-	return s.store.CreateSession(ctx, sess)
+	return s.store.CreateSession(ctx, sess, s.maxPerUser)
+}
+
+// Expired reports whether sess has passed its absolute or idle timeout.
+func (s *SessionManager) Expired(sess store.Session, now time.Time) bool {
+	if now.After(sess.ExpiresAt) {
+		return true
+	}
+	return s.idle > 0 && now.Sub(sess.LastSeenAt) > s.idle
+}
+
+// Touch records that sess was used from clientIP with userAgent. Writes are
+// throttled unless the client changed.
+func (s *SessionManager) Touch(ctx context.Context, sess store.Session, now time.Time, clientIP string, userAgent string) {
+	if now.Sub(sess.LastSeenAt) < touchEvery && sess.ClientIP == clientIP && sess.UserAgent == userAgent {
+		return
+	}
+	if err := s.store.TouchSession(ctx, sess.ID, now, clientIP, userAgent); err != nil {
+		s.logger.Error("session touch failed", "session", sess.ID, "error", err)
+	}
+}
+
+// Start removes expired sessions and stale OIDC login states periodically.
+func (s *SessionManager) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.cleanupEvery)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Cleanup(ctx); err != nil {
+					s.logger.Error("session cleanup failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (s *SessionManager) Cleanup(ctx context.Context) error {
+	now := time.Now().UTC()
+	var idleBefore *time.Time
+	if s.idle > 0 {
+		cutoff := now.Add(-s.idle)
+		idleBefore = &cutoff
+	}
+	sessions, err := s.store.DeleteExpiredSessions(ctx, now, idleBefore)
+	if err != nil {
+		return err
+	}
+	states, err := s.store.DeleteOIDCStatesBefore(ctx, now.Add(-oidcStateTTL))
+	if err != nil {
+		return err
+	}
+	if sessions > 0 || states > 0 {
+		s.logger.Info("session cleanup", "sessions", sessions, "oidcStates", states)
+	}
+	return nil
+}
+
+// OIDCStateValid reports whether an OIDC login state created at createdAt
+// may still be used.
+func OIDCStateValid(createdAt time.Time, now time.Time) bool {
+	return now.Sub(createdAt) <= oidcStateTTL
 }
 
 func (s *SessionManager) Get(r *http.Request) (uuid.UUID, bool) {
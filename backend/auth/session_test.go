@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"flowdb/backend/store"
+)
+
+func TestSessionExpired(t *testing.T) {
+	now := time.Now().UTC()
+	s := NewSessionManager(nil, "flowdb_session", 24*time.Hour, time.Hour, 0, 0, nil)
+	cases := []struct {
+		name string
+		sess store.Session
+		want bool
+	}{
+		{"active", store.Session{ExpiresAt: now.Add(time.Hour), LastSeenAt: now.Add(-time.Minute)}, false},
+		{"idle", store.Session{ExpiresAt: now.Add(time.Hour), LastSeenAt: now.Add(-2 * time.Hour)}, true},
+		{"absolute", store.Session{ExpiresAt: now.Add(-time.Second), LastSeenAt: now}, true},
+	}
+	for _, tc := range cases {
+		if got := s.Expired(tc.sess, now); got != tc.want {
+			t.Errorf("%s: Expired = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	noIdle := NewSessionManager(nil, "flowdb_session", 24*time.Hour, 0, 0, 0, nil)
+	if noIdle.Expired(cases[1].sess, now) {
+		t.Error("idle timeout applied when disabled")
+	}
+}
+
+func TestOIDCStateValid(t *testing.T) {
+	now := time.Now()
+	if !OIDCStateValid(now.Add(-time.Minute), now) {
+		t.Error("fresh state rejected")
+	}
+	if OIDCStateValid(now.Add(-11*time.Minute), now) {
+		t.Error("stale state accepted")
+	}
+}
@@ -16,6 +16,9 @@ type Config struct {
 	MongoURI             string
 	MasterKey            []byte
 	SessionTTL           time.Duration
+	SessionIdleTimeout   time.Duration
+	SessionMaxPerUser    int
+	SessionCleanupEvery  time.Duration
 	SessionCookieName    string
 	CSRFHeaderName       string
 	SettingsRefresh      time.Duration
@@ -44,6 +47,56 @@ type Config struct {
 	UpdateCheckInterval  time.Duration
 	UpdateToken          string
 	UpdateAutoRestart    bool
+	PIIScanSampleSize    int
+	PIIScanInterval      time.Duration
+	PolicyDir            string
+	PolicyDirPoll        time.Duration
+	AccessMaxDuration    time.Duration
+	AccessSweepInterval  time.Duration
+	BreakGlassTTL        time.Duration
+	ApprovalTTL          time.Duration
+	// ApprovalQuorum maps an environment to the number of distinct approvers
+	// a query needs; "*" sets the default.
+	ApprovalQuorum      map[string]int
+	WebhookPollInterval time.Duration
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	// WebhookAllowPrivate lets webhooks target loopback, link-local and
+	// private addresses.
+	WebhookAllowPrivate bool
+	// SMTPHost enables email notifications when set.
+	SMTPHost           string
+	SMTPPort           int
+	SMTPUsername       string
+	SMTPPassword       string
+	SMTPFrom           string
+	SMTPRequireTLS     bool
+	NotifyAppURL       string
+	NotifyRecipients   map[string][]string
+	AccessExpiryNotice time.Duration
+	// AuditSigningKeyFile enables signed audit checkpoints when set.
+	AuditSigningKeyFile  string
+	AuditCheckpointEvery time.Duration
+	AuditCheckpointDir   string
+	AuditCheckpointURL   string
+	// AuditRetention enables archiving entries older than it when set.
+	AuditRetention    time.Duration
+	AuditArchiveDir   string
+	AuditArchiveEvery time.Duration
+	// SIEMSyslogAddr enables forwarding audit events to syslog when set.
+	SIEMSyslogAddr      string
+	SIEMSyslogNetwork   string
+	SIEMSyslogFormat    string
+	SIEMSyslogFacility  int
+	SIEMSyslogCAFile    string
+	SIEMForwardInterval time.Duration
+	SIEMSettleDelay     time.Duration
+	// DataAccessSample is the percentage of non-prod browse events logged;
+	// DataAccessWindow folds repeated ones into one event per window.
+	DataAccessSample int
+	DataAccessWindow time.Duration
+	// HistoryStatements is full, pii, literals or off.
+	HistoryStatements string
 }
 
 func Load() (*Config, error) {
@@ -52,6 +105,9 @@ func Load() (*Config, error) {
 		DatabaseURL:          os.Getenv("DATABASE_URL"),
 		MongoURI:             envOrDefault("MONGO_URI", "mongodb://mongo:27017"),
 		SessionTTL:           envDuration("SESSION_TTL", 24*time.Hour),
+		SessionIdleTimeout:   envDuration("SESSION_IDLE_TIMEOUT", time.Hour),
+		SessionMaxPerUser:    envInt("SESSION_MAX_PER_USER", 0),
+		SessionCleanupEvery:  envDuration("SESSION_CLEANUP_INTERVAL", 10*time.Minute),
 		SessionCookieName:    envOrDefault("SESSION_COOKIE_NAME", "flowdb_session"),
 		CSRFHeaderName:       envOrDefault("CSRF_HEADER_NAME", "X-CSRF-Token"),
 		SettingsRefresh:      envDuration("SETTINGS_REFRESH", 10*time.Second),
@@ -77,6 +133,43 @@ func Load() (*Config, error) {
 		UpdateCheckInterval:  envDuration("UPDATE_CHECK_INTERVAL", 5*time.Minute),
 		UpdateToken:          os.Getenv("UPDATE_GITHUB_TOKEN"),
 		UpdateAutoRestart:    envBool("UPDATE_AUTO_RESTART", true),
+		PIIScanSampleSize:    envInt("PII_SCAN_SAMPLE_SIZE", 100),
+		PIIScanInterval:      envDuration("PII_SCAN_INTERVAL", 24*time.Hour),
+		PolicyDir:            os.Getenv("POLICY_DIR"),
+		PolicyDirPoll:        envDuration("POLICY_DIR_POLL", 10*time.Second),
+		AccessMaxDuration:    envDuration("ACCESS_REQUEST_MAX_DURATION", 8*time.Hour),
+		AccessSweepInterval:  envDuration("ACCESS_GRANT_SWEEP_INTERVAL", time.Minute),
+		BreakGlassTTL:        envDuration("BREAK_GLASS_TTL", 30*time.Minute),
+		ApprovalTTL:          envDuration("APPROVAL_TTL", time.Hour),
+		WebhookPollInterval:  envDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		WebhookTimeout:       envDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookMaxAttempts:   envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookAllowPrivate:  envBool("WEBHOOK_ALLOW_PRIVATE", false),
+		SMTPHost:             os.Getenv("SMTP_HOST"),
+		SMTPPort:             envInt("SMTP_PORT", 587),
+		SMTPUsername:         os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:         os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:             envOrDefault("SMTP_FROM", "flowdb@localhost"),
+		SMTPRequireTLS:       envBool("SMTP_REQUIRE_TLS", true),
+		NotifyAppURL:         os.Getenv("NOTIFY_APP_URL"),
+		AccessExpiryNotice:   envDuration("ACCESS_GRANT_EXPIRY_NOTICE", 15*time.Minute),
+		AuditSigningKeyFile:  os.Getenv("AUDIT_SIGNING_KEY_FILE"),
+		AuditCheckpointEvery: envDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+		AuditCheckpointDir:   os.Getenv("AUDIT_CHECKPOINT_DIR"),
+		AuditCheckpointURL:   os.Getenv("AUDIT_CHECKPOINT_WEBHOOK"),
+		AuditRetention:       envDuration("AUDIT_RETENTION", 0),
+		AuditArchiveDir:      os.Getenv("AUDIT_ARCHIVE_DIR"),
+		AuditArchiveEvery:    envDuration("AUDIT_ARCHIVE_INTERVAL", time.Hour),
+		SIEMSyslogAddr:       os.Getenv("SIEM_SYSLOG_ADDR"),
+		SIEMSyslogNetwork:    envOrDefault("SIEM_SYSLOG_NETWORK", "tcp"),
+		SIEMSyslogFormat:     envOrDefault("SIEM_SYSLOG_FORMAT", "cef"),
+		SIEMSyslogFacility:   envInt("SIEM_SYSLOG_FACILITY", 13),
+		SIEMSyslogCAFile:     os.Getenv("SIEM_SYSLOG_CA_FILE"),
+		SIEMForwardInterval:  envDuration("SIEM_FORWARD_INTERVAL", 5*time.Second),
+		SIEMSettleDelay:      envDuration("SIEM_SETTLE_DELAY", 2*time.Second),
+		DataAccessSample:     envInt("DATA_ACCESS_SAMPLE_PERCENT", 100),
+		DataAccessWindow:     envDuration("DATA_ACCESS_AGGREGATE_WINDOW", 0),
+		HistoryStatements:    envOrDefault("HISTORY_STATEMENTS", "pii"),
 	}
 
 	if cors := os.Getenv("CORS_ALLOW_ORIGINS"); cors != "" {
@@ -85,11 +178,50 @@ func Load() (*Config, error) {
 	if trust := os.Getenv("TRUSTED_PROXY_CIDR"); trust != "" {
 		cfg.TrustedProxyCIDR = splitCSV(trust)
 	}
+	if quorum := os.Getenv("APPROVAL_QUORUM"); quorum != "" {
+		var raw map[string]int
+		if err := json.Unmarshal([]byte(quorum), &raw); err != nil {
+			return nil, err
+		}
+		cfg.ApprovalQuorum = make(map[string]int, len(raw))
+		for env, n := range raw {
+			cfg.ApprovalQuorum[strings.ToLower(strings.TrimSpace(env))] = n
+		}
+	}
+	if recipients := os.Getenv("NOTIFY_RECIPIENTS"); recipients != "" {
+		if err := json.Unmarshal([]byte(recipients), &cfg.NotifyRecipients); err != nil {
+			return nil, err
+		}
+	}
 	if roleMap := os.Getenv("OIDC_ROLE_MAP"); roleMap != "" {
 		if err := json.Unmarshal([]byte(roleMap), &cfg.OIDCRoleMap); err != nil {
 			return nil, err
 		}
 	}
+	if cfg.AuditRetention > 0 && (cfg.AuditSigningKeyFile == "" || cfg.AuditArchiveDir == "") {
+		return nil, errors.New("AUDIT_RETENTION requires AUDIT_SIGNING_KEY_FILE and AUDIT_ARCHIVE_DIR")
+	}
+	if cfg.SIEMSyslogAddr != "" {
+		switch cfg.SIEMSyslogNetwork {
+		case "udp", "tcp", "tls":
+		default:
+			return nil, errors.New("SIEM_SYSLOG_NETWORK must be udp, tcp or tls")
+		}
+		if cfg.SIEMSyslogFormat != "cef" && cfg.SIEMSyslogFormat != "json" {
+			return nil, errors.New("SIEM_SYSLOG_FORMAT must be cef or json")
+		}
+	}
+	if cfg.DataAccessSample < 0 || cfg.DataAccessSample > 100 {
+		return nil, errors.New("DATA_ACCESS_SAMPLE_PERCENT must be between 0 and 100")
+	}
+	switch cfg.HistoryStatements {
+	case "full", "pii", "literals", "off":
+	default:
+		return nil, errors.New("HISTORY_STATEMENTS must be full, pii, literals or off")
+	}
+	if cfg.SessionMaxPerUser < 0 {
+		return nil, errors.New("SESSION_MAX_PER_USER must be 0 or more")
+	}
 	masterKey := os.Getenv("MASTER_KEY")
 	if masterKey == "" {
 		return nil, errors.New("MASTER_KEY required")
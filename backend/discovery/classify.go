@@ -0,0 +1,196 @@
+package discovery
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	CategoryEmail      = "email"
+	CategoryPhone      = "phone"
+	CategoryNationalID = "national_id"
+	CategoryCreditCard = "credit_card"
+	CategoryIPAddress  = "ip_address"
+	CategoryAddress    = "address"
+)
+
+var (
+	emailRegex      = regexp.MustCompile(`^[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}$`)
+	phoneRegex      = regexp.MustCompile(`^\+?[0-9][0-9 ().\-]{7,18}[0-9]$`)
+	ssnRegex        = regexp.MustCompile(`^[0-9]{3}-[0-9]{2}-[0-9]{4}$`)
+	nationalIDRegex = regexp.MustCompile(`^[0-9]{9}$|^[0-9]{12}$`)
+	addressRegex    = regexp.MustCompile(`(?i)^[0-9]{1,6}[a-z]?[ ,/]+.*\b(street|st|avenue|ave|road|rd|boulevard|blvd|lane|ln|drive|dr|way|court|ct|place|pl|đường|phố|ngõ|hẻm)\b`)
+)
+
+// nameHints maps normalized column-name tokens to a category. Multi-word hints
+// are matched against the column name with separators removed.
+var nameHints = []struct {
+	hint     string
+	category string
+}{
+	{"email", CategoryEmail},
+	{"mail", CategoryEmail},
+	{"phone", CategoryPhone},
+	{"mobile", CategoryPhone},
+	{"msisdn", CategoryPhone},
+	{"telephone", CategoryPhone},
+	{"ssn", CategoryNationalID},
+	{"nationalid", CategoryNationalID},
+	{"passport", CategoryNationalID},
+	{"cccd", CategoryNationalID},
+	{"cmnd", CategoryNationalID},
+	{"taxid", CategoryNationalID},
+	{"creditcard", CategoryCreditCard},
+	{"cardnumber", CategoryCreditCard},
+	{"ccnumber", CategoryCreditCard},
+	{"pan", CategoryCreditCard},
+	{"ipaddress", CategoryIPAddress},
+	{"ipaddr", CategoryIPAddress},
+	{"clientip", CategoryIPAddress},
+	{"remoteip", CategoryIPAddress},
+	{"address", CategoryAddress},
+	{"street", CategoryAddress},
+	{"postcode", CategoryAddress},
+	{"zipcode", CategoryAddress},
+}
+
+// ClassifyName guesses a category from a column or field name.
+func ClassifyName(name string) (string, bool) {
+	last := name
+	if idx := strings.LastIndex(last, "."); idx >= 0 {
+		last = last[idx+1:]
+	}
+	tokens := splitName(last)
+	joined := strings.Join(tokens, "")
+	for _, h := range nameHints {
+		for _, tok := range tokens {
+			if tok == h.hint {
+				return h.category, true
+			}
+		}
+		if len(h.hint) > 4 && strings.Contains(joined, h.hint) {
+			return h.category, true
+		}
+	}
+	if joined == "ip" {
+		return CategoryIPAddress, true
+	}
+	return "", false
+}
+
+// ClassifyValue inspects a sampled value and returns the category it looks
+// like, if any.
+func ClassifyValue(value any) (string, bool) {
+	s, ok := value.(string)
+	if !ok {
+		return "", false
+	}
+	s = strings.TrimSpace(s)
+	if s == "" || len(s) > 256 {
+		return "", false
+	}
+	switch {
+	case emailRegex.MatchString(s):
+		return CategoryEmail, true
+	case isCreditCard(s):
+		return CategoryCreditCard, true
+	case ssnRegex.MatchString(s):
+		return CategoryNationalID, true
+	case isIP(s):
+		return CategoryIPAddress, true
+	case nationalIDRegex.MatchString(s):
+		// Bare 9- and 12-digit numbers are CMND/CCCD numbers; phone numbers
+		// of that length are recognized with a + or separators.
+		return CategoryNationalID, true
+	case phoneRegex.MatchString(s) && countDigits(s) >= 9 && countDigits(s) <= 15:
+		return CategoryPhone, true
+	case addressRegex.MatchString(s):
+		return CategoryAddress, true
+	default:
+		return "", false
+	}
+}
+
+// Luhn reports whether the digits in number pass the Luhn checksum.
+func Luhn(number string) bool {
+	sum := 0
+	double := false
+	digits := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits > 0 && sum%10 == 0
+}
+
+func isCreditCard(s string) bool {
+	n := countDigits(s)
+	if n < 13 || n > 19 {
+		return false
+	}
+	for _, r := range s {
+		if !(unicode.IsDigit(r) || r == ' ' || r == '-') {
+			return false
+		}
+	}
+	return Luhn(s)
+}
+
+func isIP(s string) bool {
+	if !strings.ContainsAny(s, ".:") {
+		return false
+	}
+	return net.ParseIP(s) != nil
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+func splitName(name string) []string {
+	var tokens []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			tokens = append(tokens, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ' || r == '.':
+			flush()
+		case unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]):
+			flush()
+			current = append(current, r)
+		default:
+			current = append(current, r)
+		}
+	}
+	flush()
+	return tokens
+}
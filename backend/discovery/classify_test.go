@@ -0,0 +1,54 @@
+package discovery
+
+import "testing"
+
+func TestLuhn(t *testing.T) {
+	if !Luhn("4111 1111 1111 1111") {
+		t.Fatalf("expected valid card number")
+	}
+	if Luhn("4111 1111 1111 1112") {
+		t.Fatalf("expected invalid card number")
+	}
+}
+
+func TestClassifyValue(t *testing.T) {
+	cases := map[string]string{
+		"alice@example.com":   CategoryEmail,
+		"4111-1111-1111-1111": CategoryCreditCard,
+		"123-45-6789":         CategoryNationalID,
+		"123456789":           CategoryNationalID,
+		"123456789012":        CategoryNationalID,
+		"10.0.0.12":           CategoryIPAddress,
+		"+84 912 345 678":     CategoryPhone,
+		"+84912345678":        CategoryPhone,
+		"0912345678":          CategoryPhone,
+		"091 234 5678":        CategoryPhone,
+		"221B Baker Street":   CategoryAddress,
+	}
+	for value, want := range cases {
+		got, ok := ClassifyValue(value)
+		if !ok || got != want {
+			t.Fatalf("%q: expected %s, got %s", value, want, got)
+		}
+	}
+	if _, ok := ClassifyValue("4111-1111-1111-1112"); ok {
+		t.Fatalf("expected card number failing luhn to be ignored")
+	}
+}
+
+func TestAnalyzeCombinesNameAndValues(t *testing.T) {
+	findings := Analyze(map[string][]any{
+		"contactEmail": {"a@example.com", "b@example.com"},
+		"notes":        {"hello", "world"},
+		"billing_note": {"4111111111111111", "5500005555555559"},
+	})
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, got %d", len(findings))
+	}
+	if findings[0].Field != "billing_note" || findings[0].Category != CategoryCreditCard {
+		t.Fatalf("unexpected finding %+v", findings[0])
+	}
+	if findings[1].Field != "contactEmail" || findings[1].Confidence != 1 {
+		t.Fatalf("unexpected finding %+v", findings[1])
+	}
+}
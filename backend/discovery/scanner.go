@@ -0,0 +1,441 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"flowdb/backend/adapters"
+	"flowdb/backend/audit"
+	"flowdb/backend/connections"
+	"flowdb/backend/query"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const scanTimeout = 30 * time.Minute
+
+// maxScanFailures caps the failures recorded on one scan.
+const maxScanFailures = 100
+
+var ErrScanRunning = errors.New("scan already running")
+
+type Finding struct {
+	Field      string   `json:"field"`
+	Category   string   `json:"category"`
+	Confidence float64  `json:"confidence"`
+	Reasons    []string `json:"reasons"`
+}
+
+type Scanner struct {
+	store       *store.Store
+	connections *connections.Service
+	audit       *audit.Logger
+	sampleSize  int
+	interval    time.Duration
+	logger      *slog.Logger
+	mu          sync.Mutex
+	running     map[uuid.UUID]uuid.UUID
+}
+
+// NewScanner creates a scanner. With a positive interval, Start rescans
+// every connection whose last scan is older than interval.
+func NewScanner(st *store.Store, conns *connections.Service, auditLogger *audit.Logger, sampleSize int, interval time.Duration, logger *slog.Logger) *Scanner {
+	if sampleSize <= 0 {
+		sampleSize = 100
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Scanner{
+		store:       st,
+		connections: conns,
+		audit:       auditLogger,
+		sampleSize:  sampleSize,
+		interval:    interval,
+		logger:      logger,
+		running:     map[uuid.UUID]uuid.UUID{},
+	}
+}
+
+// Start fails scans left running by a previous process and, when an interval
+// is configured, scans due connections one at a time in the background.
+func (s *Scanner) Start(ctx context.Context) {
+	if n, err := s.store.FailRunningPIIScans(ctx, "interrupted by server restart"); err != nil {
+		s.logger.Error("pii scan recovery failed", "error", err)
+	} else if n > 0 {
+		s.logger.Warn("marked interrupted pii scans as failed", "scans", n)
+	}
+	if s.interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(min(s.interval, time.Hour))
+		defer ticker.Stop()
+		for {
+			if err := s.scanDue(ctx); err != nil {
+				s.logger.Error("scheduled pii scan failed", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// scanDue scans, one after another, connections not scanned within interval.
+func (s *Scanner) scanDue(ctx context.Context) error {
+	conns, err := s.store.ListConnections(ctx)
+	if err != nil {
+		return err
+	}
+	last, err := s.store.LastPIIScanTimes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, conn := range conns {
+		if at, ok := last[conn.ID]; ok && time.Since(at) < s.interval {
+			continue
+		}
+		scan, err := s.begin(ctx, conn, nil)
+		if errors.Is(err, ErrScanRunning) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.run(ctx, conn, scan)
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// StartScan records a new scan and runs it in the background. Only one scan
+// per connection runs at a time.
+func (s *Scanner) StartScan(ctx context.Context, conn store.Connection, startedBy *uuid.UUID) (store.PIIScan, error) {
+	scan, err := s.begin(ctx, conn, startedBy)
+	if err != nil {
+		return store.PIIScan{}, err
+	}
+	go s.run(context.WithoutCancel(ctx), conn, scan)
+	return scan, nil
+}
+
+func (s *Scanner) begin(ctx context.Context, conn store.Connection, startedBy *uuid.UUID) (store.PIIScan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.running[conn.ID]; ok {
+		return store.PIIScan{}, ErrScanRunning
+	}
+	scan, err := s.store.CreatePIIScan(ctx, store.PIIScan{
+		ConnectionID: conn.ID,
+		Status:       "running",
+		StartedBy:    startedBy,
+	})
+	if err != nil {
+		return store.PIIScan{}, err
+	}
+	s.running[conn.ID] = scan.ID
+	return scan, nil
+}
+
+func (s *Scanner) run(ctx context.Context, conn store.Connection, scan store.PIIScan) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, conn.ID)
+		s.mu.Unlock()
+	}()
+	scanCtx, cancel := context.WithTimeout(ctx, scanTimeout)
+	err := s.scan(scanCtx, conn, &scan)
+	cancel()
+	scan.Status = "completed"
+	if err != nil {
+		scan.Status = "failed"
+		scan.Error = err.Error()
+	}
+	// Record the outcome even when ctx was cancelled by shutdown.
+	ctx = context.WithoutCancel(ctx)
+	if err := s.store.FinishPIIScan(ctx, scan); err != nil {
+		s.logger.Error("pii scan finish failed", "scan", scan.ID, "error", err)
+	}
+	_ = s.audit.LogEvent(ctx, "pii_scan_complete", scan.StartedBy, map[string]any{
+		"connectionId":    conn.ID.String(),
+		"scanId":          scan.ID.String(),
+		"status":          scan.Status,
+		"entitiesScanned": scan.EntitiesScanned,
+		"fieldsFlagged":   scan.FieldsFlagged,
+		"failures":        len(scan.Failures),
+		"scheduled":       scan.StartedBy == nil,
+	}, "")
+}
+
+func (s *Scanner) scan(ctx context.Context, conn store.Connection, scan *store.PIIScan) error {
+	adapter, err := s.connections.GetAdapter(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer adapter.Close()
+	rules, err := s.store.ListPIIRules(ctx, conn.ID)
+	if err != nil {
+		return err
+	}
+	namespaces, err := adapter.ListNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	fail := func(what string, err error) {
+		if len(scan.Failures) < maxScanFailures {
+			scan.Failures = append(scan.Failures, what+": "+err.Error())
+		}
+	}
+	for _, ns := range namespaces {
+		if systemNamespace(ns.Name) {
+			continue
+		}
+		entities, err := adapter.ListEntities(ctx, ns.Name)
+		if err != nil {
+			fail(ns.Name, err)
+			continue
+		}
+		for _, entity := range entities {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			samples, err := s.sample(ctx, adapter, ns.Name, entity.Name)
+			if err != nil {
+				fail(ns.Name+"."+entity.Name, err)
+				continue
+			}
+			scan.EntitiesScanned++
+			resource := EntityResource(conn.ID, ns.Name, entity.Name)
+			for _, finding := range Analyze(samples) {
+				if covered(rules, resource, finding.Field) {
+					continue
+				}
+				err := s.store.UpsertPIIProposal(ctx, store.PIIProposal{
+					ConnectionID: conn.ID,
+					ScanID:       &scan.ID,
+					Resource:     resource,
+					Field:        finding.Field,
+					Category:     finding.Category,
+					Confidence:   finding.Confidence,
+					Reasons:      finding.Reasons,
+					MaskType:     "mask",
+				})
+				if err != nil {
+					return err
+				}
+				scan.FieldsFlagged++
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) sample(ctx context.Context, adapter adapters.Adapter, ns string, name string) (map[string][]any, error) {
+	stream, err := adapter.Browse(ctx, ns, name, adapters.BrowseOptions{Page: 1, PageSize: s.sampleSize})
+	if err != nil {
+		return nil, err
+	}
+	samples := map[string][]any{}
+	for _, col := range stream.Columns {
+		samples[col.Name] = nil
+	}
+	for row := range stream.Rows {
+		for i, col := range stream.Columns {
+			if i < len(row) {
+				samples[col.Name] = append(samples[col.Name], row[i])
+			}
+		}
+	}
+	for doc := range stream.Docs {
+		flattenDoc("", doc, samples)
+	}
+	select {
+	case err := <-stream.Err:
+		if err != nil {
+			return nil, err
+		}
+	default:
+	}
+	return samples, nil
+}
+
+// flattenDoc collects values of nested documents under dot paths, using the
+// "[]" selector for arrays of documents so proposals map onto masking rules.
+func flattenDoc(prefix string, doc map[string]any, samples map[string][]any) {
+	for key, value := range doc {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			flattenDoc(path, v, samples)
+		case primitive.M:
+			flattenDoc(path, v, samples)
+		case primitive.D:
+			flattenDoc(path, v.Map(), samples)
+		case []any:
+			flattenArray(path, v, samples)
+		case primitive.A:
+			flattenArray(path, v, samples)
+		default:
+			samples[path] = append(samples[path], value)
+		}
+	}
+}
+
+func flattenArray(path string, items []any, samples map[string][]any) {
+	for _, item := range items {
+		switch v := item.(type) {
+		case map[string]any:
+			flattenDoc(path+"[]", v, samples)
+		case primitive.M:
+			flattenDoc(path+"[]", v, samples)
+		case primitive.D:
+			flattenDoc(path+"[]", v.Map(), samples)
+		default:
+			samples[path+"[]"] = append(samples[path+"[]"], item)
+		}
+	}
+}
+
+// Analyze classifies each sampled field by its name and values and returns the
+// fields that look like personal data.
+func Analyze(samples map[string][]any) []Finding {
+	var findings []Finding
+	for field, values := range samples {
+		nameCategory, byName := ClassifyName(field)
+		counts := map[string]int{}
+		nonEmpty := 0
+		for _, v := range values {
+			if v == nil {
+				continue
+			}
+			if str, ok := v.(string); ok && strings.TrimSpace(str) == "" {
+				continue
+			}
+			nonEmpty++
+			if category, ok := ClassifyValue(v); ok {
+				counts[category]++
+			}
+		}
+		valueCategory := ""
+		best := 0
+		for category, n := range counts {
+			if n > best || (n == best && category < valueCategory) {
+				valueCategory = category
+				best = n
+			}
+		}
+		ratio := 0.0
+		if nonEmpty > 0 {
+			ratio = float64(best) / float64(nonEmpty)
+		}
+		finding := Finding{Field: field}
+		switch {
+		case byName && valueCategory == nameCategory:
+			finding.Category = nameCategory
+			finding.Confidence = 0.6 + 0.4*ratio
+			finding.Reasons = []string{"name", "values"}
+		case valueCategory != "" && ratio >= 0.5:
+			finding.Category = valueCategory
+			finding.Confidence = 0.9 * ratio
+			finding.Reasons = []string{"values"}
+		case byName:
+			finding.Category = nameCategory
+			finding.Confidence = 0.5
+			finding.Reasons = []string{"name"}
+		default:
+			continue
+		}
+		findings = append(findings, finding)
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Field < findings[j].Field })
+	return findings
+}
+
+type EntityCoverage struct {
+	Resource  string   `json:"resource"`
+	Detected  int      `json:"detected"`
+	Covered   int      `json:"covered"`
+	Uncovered []string `json:"uncovered"`
+}
+
+type CoverageReport struct {
+	ConnectionID string           `json:"connectionId"`
+	LastScan     *store.PIIScan   `json:"lastScan,omitempty"`
+	Detected     int              `json:"detected"`
+	Covered      int              `json:"covered"`
+	Percent      float64          `json:"percent"`
+	Entities     []EntityCoverage `json:"entities"`
+}
+
+// BuildCoverage reports, per entity, how many detected PII fields are covered
+// by a masking rule. Rejected proposals are not counted as PII.
+func BuildCoverage(connectionID uuid.UUID, proposals []store.PIIProposal, rules []store.PIIRule) CoverageReport {
+	report := CoverageReport{ConnectionID: connectionID.String(), Entities: []EntityCoverage{}}
+	byResource := map[string]*EntityCoverage{}
+	var order []string
+	for _, p := range proposals {
+		if p.Status == "rejected" {
+			continue
+		}
+		entry, ok := byResource[p.Resource]
+		if !ok {
+			entry = &EntityCoverage{Resource: p.Resource, Uncovered: []string{}}
+			byResource[p.Resource] = entry
+			order = append(order, p.Resource)
+		}
+		entry.Detected++
+		if covered(rules, p.Resource, p.Field) {
+			entry.Covered++
+		} else {
+			entry.Uncovered = append(entry.Uncovered, p.Field)
+		}
+	}
+	sort.Strings(order)
+	for _, resource := range order {
+		entry := byResource[resource]
+		report.Detected += entry.Detected
+		report.Covered += entry.Covered
+		report.Entities = append(report.Entities, *entry)
+	}
+	if report.Detected > 0 {
+		report.Percent = 100 * float64(report.Covered) / float64(report.Detected)
+	}
+	return report
+}
+
+func EntityResource(connectionID uuid.UUID, ns string, name string) string {
+	return "connection/" + connectionID.String() + "/db/" + ns + "/entity/" + name
+}
+
+func covered(rules []store.PIIRule, resource string, field string) bool {
+	for _, rule := range query.RulesForResource(rules, resource) {
+		if strings.EqualFold(rule.Field, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func systemNamespace(name string) bool {
+	switch strings.ToLower(name) {
+	case "pg_catalog", "information_schema", "pg_toast", "admin", "local", "config":
+		return true
+	default:
+		return strings.HasPrefix(strings.ToLower(name), "pg_temp") || strings.HasPrefix(strings.ToLower(name), "pg_toast_temp")
+	}
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/policies"
+	"flowdb/backend/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const defaultAccessDuration = time.Hour
+
+type accessRequestRequest struct {
+	RoleID          string `json:"roleId"`
+	Role            string `json:"role"`
+	Resource        string `json:"resource"`
+	Justification   string `json:"justification"`
+	TicketID        string `json:"ticketId"`
+	DurationMinutes int    `json:"durationMinutes"`
+}
+
+type accessDecisionRequest struct {
+	Note string `json:"note"`
+}
+
+// CreateAccessRequest asks for a role on a resource for a limited time. Any
+// signed-in user may ask; someone with iam:approve on the resource decides.
+func (h *Handler) CreateAccessRequest(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req accessRequestRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Justification = strings.TrimSpace(req.Justification)
+	req.TicketID = strings.TrimSpace(req.TicketID)
+	if req.Justification == "" || req.TicketID == "" {
+		http.Error(w, "justification and ticketId required", http.StatusBadRequest)
+		return
+	}
+	var role store.Role
+	var err error
+	switch {
+	case req.RoleID != "":
+		id, parseErr := uuid.Parse(req.RoleID)
+		if parseErr != nil {
+			http.Error(w, "invalid roleId", http.StatusBadRequest)
+			return
+		}
+		role, err = h.Store.GetRole(r.Context(), id)
+	case req.Role != "":
+		role, err = h.Store.GetRoleByName(r.Context(), req.Role)
+	default:
+		http.Error(w, "roleId or role required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "role not found", http.StatusBadRequest)
+		return
+	}
+	resource := strings.TrimSpace(req.Resource)
+	if err := policies.ValidScope(resource); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	duration := time.Duration(req.DurationMinutes) * time.Minute
+	if duration <= 0 {
+		duration = defaultAccessDuration
+	}
+	if limit := h.Config.AccessMaxDuration; limit > 0 && duration > limit {
+		http.Error(w, "duration exceeds "+limit.String(), http.StatusBadRequest)
+		return
+	}
+	created, err := h.Store.CreateAccessRequest(r.Context(), store.AccessRequest{
+		UserID:        user.ID,
+		RoleID:        role.ID,
+		Resource:      resource,
+		Justification: req.Justification,
+		TicketID:      req.TicketID,
+		Duration:      duration,
+	})
+	if err != nil {
+		http.Error(w, "failed to create access request", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "access_request_create", &user.ID, map[string]any{
+		"requestId":     created.ID.String(),
+		"role":          role.Name,
+		"resource":      created.Resource,
+		"justification": created.Justification,
+		"ticketId":      created.TicketID,
+		"durationSec":   int(duration / time.Second),
+	}, "")
+	writeJSON(w, http.StatusCreated, accessRequestView(created))
+}
+
+// ListAccessRequests returns the caller's own requests together with those
+// the caller may decide. ?mine=true limits the list to the caller's own.
+func (h *Handler) ListAccessRequests(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	filter := store.AccessRequestFilter{
+		Status: r.URL.Query().Get("status"),
+		Limit:  parseInt(r.URL.Query().Get("limit"), 100),
+		Offset: parseInt(r.URL.Query().Get("offset"), 0),
+	}
+	if r.URL.Query().Get("mine") == "true" {
+		filter.UserID = &user.ID
+	}
+	list, err := h.Store.ListAccessRequests(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "failed to list access requests", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(list))
+	for _, a := range list {
+		if a.UserID == user.ID || h.allowed(r, "iam:approve", approvalResource(a), nil) {
+			views = append(views, accessRequestView(a))
+		}
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (h *Handler) GetAccessRequest(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.loadAccessRequest(w, r)
+	if !ok {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	if a.UserID != user.ID && !h.authorize(w, r, "iam:approve", approvalResource(a), nil) {
+		return
+	}
+	writeJSON(w, http.StatusOK, accessRequestView(a))
+}
+
+func (h *Handler) ApproveAccessRequest(w http.ResponseWriter, r *http.Request) {
+	h.decideAccessRequest(w, r, store.AccessApproved)
+}
+
+func (h *Handler) DenyAccessRequest(w http.ResponseWriter, r *http.Request) {
+	h.decideAccessRequest(w, r, store.AccessDenied)
+}
+
+func (h *Handler) decideAccessRequest(w http.ResponseWriter, r *http.Request, status string) {
+	a, ok := h.loadAccessRequest(w, r)
+	if !ok {
+		return
+	}
+	if !h.authorize(w, r, "iam:approve", approvalResource(a), nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	var req accessDecisionRequest
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	decided, err := h.Store.DecideAccessRequest(r.Context(), a.ID, status, user.ID, strings.TrimSpace(req.Note))
+	if err != nil {
+		if errors.Is(err, store.ErrSelfApproval) {
+			http.Error(w, "cannot decide own request", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "request is not pending", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to update access request", http.StatusInternalServerError)
+		return
+	}
+	details := map[string]any{
+		"requestId": decided.ID.String(),
+		"userId":    decided.UserID.String(),
+		"roleId":    decided.RoleID.String(),
+		"resource":  decided.Resource,
+		"ticketId":  decided.TicketID,
+		"note":      decided.DecisionNote,
+	}
+	event := "access_request_denied"
+	if status == store.AccessApproved {
+		event = "access_granted"
+		details["expiresAt"] = decided.ExpiresAt
+	}
+	_ = h.Audit.LogEvent(r.Context(), event, &user.ID, details, "")
+	writeJSON(w, http.StatusOK, accessRequestView(decided))
+}
+
+// RevokeAccessRequest lets the requester cancel a pending request or give up
+// an active grant early; approvers may revoke any grant they could approve.
+func (h *Handler) RevokeAccessRequest(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.loadAccessRequest(w, r)
+	if !ok {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	if a.UserID != user.ID && !h.authorize(w, r, "iam:approve", approvalResource(a), nil) {
+		return
+	}
+	closed, err := h.Store.CloseAccessRequest(r.Context(), a.ID, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "request is not active", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to update access request", http.StatusInternalServerError)
+		return
+	}
+	event := "access_grant_revoked"
+	if closed.Status == store.AccessCancelled {
+		event = "access_request_cancelled"
+	}
+	_ = h.Audit.LogEvent(r.Context(), event, &user.ID, map[string]any{
+		"requestId": closed.ID.String(),
+		"userId":    closed.UserID.String(),
+		"roleId":    closed.RoleID.String(),
+		"resource":  closed.Resource,
+	}, "")
+	writeJSON(w, http.StatusOK, accessRequestView(closed))
+}
+
+func (h *Handler) loadAccessRequest(w http.ResponseWriter, r *http.Request) (store.AccessRequest, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return store.AccessRequest{}, false
+	}
+	a, err := h.Store.GetAccessRequest(r.Context(), id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return store.AccessRequest{}, false
+	}
+	return a, true
+}
+
+// approvalResource is the resource iam:approve is checked on. Requests
+// without a scope need an approver for everything.
+func approvalResource(a store.AccessRequest) string {
+	if a.Resource == "" {
+		return "*"
+	}
+	return a.Resource
+}
+
+func accessRequestView(a store.AccessRequest) map[string]any {
+	status := a.Status
+	if status == store.AccessApproved && a.ExpiresAt != nil && !time.Now().Before(*a.ExpiresAt) {
+		status = store.AccessExpired
+	}
+	view := map[string]any{
+		"id":              a.ID.String(),
+		"userId":          a.UserID.String(),
+		"roleId":          a.RoleID.String(),
+		"resource":        a.Resource,
+		"justification":   a.Justification,
+		"ticketId":        a.TicketID,
+		"durationMinutes": int(a.Duration / time.Minute),
+		"status":          status,
+		"decisionNote":    a.DecisionNote,
+		"decidedAt":       a.DecidedAt,
+		"expiresAt":       a.ExpiresAt,
+		"revokedAt":       a.RevokedAt,
+		"lastUsedAt":      a.LastUsedAt,
+		"createdAt":       a.CreatedAt,
+	}
+	if a.DecidedBy != nil {
+		view["decidedBy"] = a.DecidedBy.String()
+	}
+	if a.RevokedBy != nil {
+		view["revokedBy"] = a.RevokedBy.String()
+	}
+	return view
+}
@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"flowdb/backend/auth"
+	"flowdb/backend/query"
+	"flowdb/backend/store"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -14,7 +20,7 @@ func (h *Handler) ListPendingApprovals(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	if !h.authorize(w, r, "query:admin", "approvals", "") {
+	if !h.authorize(w, r, "query:admin", "approvals", nil) {
 		return
 	}
 	list, err := h.Store.ListPendingApprovals(r.Context())
@@ -22,37 +28,299 @@ func (h *Handler) ListPendingApprovals(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to list", http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, list)
+	views := make([]approvalResponse, 0, len(list))
+	for _, approval := range list {
+		views = append(views, approvalView(approval))
+	}
+	writeJSON(w, http.StatusOK, views)
 }
 
-func (h *Handler) Approve(w http.ResponseWriter, r *http.Request) {
+type approvalCommentRequest struct {
+	Body string `json:"body"`
+}
+
+type approvalRevisionRequest struct {
+	Statement string `json:"statement"`
+	Reason    string `json:"reason"`
+	TicketURL string `json:"ticketUrl"`
+	Impact    string `json:"impact"`
+}
+
+// GetApproval returns an approval with its revisions, comments and votes.
+// The requester and approvers may read it.
+func (h *Handler) GetApproval(w http.ResponseWriter, r *http.Request) {
+	approval, ok := h.loadVisibleApproval(w, r)
+	if !ok {
+		return
+	}
+	revisions, err := h.Store.ListApprovalRevisions(r.Context(), approval.ID)
+	if err != nil {
+		http.Error(w, "failed to load approval", http.StatusInternalServerError)
+		return
+	}
+	comments, err := h.Store.ListApprovalComments(r.Context(), approval.ID)
+	if err != nil {
+		http.Error(w, "failed to load approval", http.StatusInternalServerError)
+		return
+	}
+	votes, err := h.Store.ListApprovalVotes(r.Context(), approval.ID)
+	if err != nil {
+		http.Error(w, "failed to load approval", http.StatusInternalServerError)
+		return
+	}
+	view := approvalDetailResponse{
+		approvalResponse: approvalView(approval),
+		Revisions:        make([]approvalRevisionResponse, 0, len(revisions)),
+		Comments:         make([]approvalCommentResponse, 0, len(comments)),
+		Votes:            make([]approvalVoteResponse, 0, len(votes)),
+	}
+	for _, rev := range revisions {
+		view.Revisions = append(view.Revisions, approvalRevisionView(rev))
+	}
+	for _, c := range comments {
+		view.Comments = append(view.Comments, approvalCommentView(c))
+	}
+	for _, v := range votes {
+		view.Votes = append(view.Votes, approvalVoteResponse{UserID: v.UserID, Decision: v.Decision, CreatedAt: v.CreatedAt})
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (h *Handler) AddApprovalComment(w http.ResponseWriter, r *http.Request) {
+	approval, ok := h.loadVisibleApproval(w, r)
+	if !ok {
+		return
+	}
+	var req approvalCommentRequest
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Body) == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	comment, err := h.Store.AddApprovalComment(r.Context(), approval.ID, user.ID, strings.TrimSpace(req.Body))
+	if err != nil {
+		http.Error(w, "failed to add comment", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "query_approval_comment", &user.ID, map[string]any{
+		"approvalId": approval.ID.String(),
+		"commentId":  comment.ID.String(),
+		"revision":   comment.Revision,
+	}, "")
+	writeJSON(w, http.StatusCreated, approvalCommentView(comment))
+}
+
+// RequestApprovalChanges sends the approval back to its author, who can
+// submit a new revision with ReviseApproval.
+func (h *Handler) RequestApprovalChanges(w http.ResponseWriter, r *http.Request) {
 	if !h.Settings.Get().FlagEnabled("enable_query_approval") {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	if !h.authorize(w, r, "query:admin", "approvals", "") {
+	if !h.authorize(w, r, "query:admin", "approvals", nil) {
 		return
 	}
-	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	approval, ok := h.loadApproval(w, r)
+	if !ok {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	if approval.UserID == user.ID {
+		http.Error(w, "cannot review own request", http.StatusForbidden)
+		return
+	}
+	var req approvalCommentRequest
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Body) == "" {
+		http.Error(w, "comment required", http.StatusBadRequest)
+		return
+	}
+	comment, err := h.Store.RequestApprovalChanges(r.Context(), approval.ID, user.ID, strings.TrimSpace(req.Body))
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "approval is not pending", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to request changes", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "query_approval_changes_requested", &user.ID, map[string]any{
+		"approvalId": approval.ID.String(),
+		"revision":   comment.Revision,
+	}, "")
+	writeJSON(w, http.StatusOK, approvalCommentView(comment))
+}
+
+// ReviseApproval lets the author submit a new revision of the statement.
+// The statement is authorized again and votes start over.
+func (h *Handler) ReviseApproval(w http.ResponseWriter, r *http.Request) {
+	if !h.Settings.Get().FlagEnabled("enable_query_approval") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	approval, ok := h.loadApproval(w, r)
+	if !ok {
 		return
 	}
 	user, _ := auth.UserFromContext(r.Context())
-	if err := h.Store.ApproveQuery(r.Context(), id, user.ID); err != nil {
-		http.Error(w, "failed to approve", http.StatusInternalServerError)
+	if approval.UserID != user.ID {
+		http.Error(w, "only the requester can revise", http.StatusForbidden)
+		return
+	}
+	var req approvalRevisionRequest
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Statement) == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	conn, err := h.Store.GetConnection(r.Context(), approval.ConnectionID)
+	if err != nil {
+		http.Error(w, "connection not found", http.StatusNotFound)
+		return
+	}
+	action := "query:read"
+	if (conn.Type == "postgres" && query.IsSQLWrite(req.Statement)) || (conn.Type == "mongodb" && query.IsMongoWrite(req.Statement)) {
+		action = "query:write"
+	}
+	entities, columns := statementResources(conn, req.Statement)
+	if _, ok := h.authorizeResources(w, r, action, append(entities, columns...), conn.Tags); !ok {
+		return
+	}
+	if action == "query:write" && isProd(getEnv(conn.Tags)) && !h.requireStepUp(w, r) {
+		return
+	}
+	history := store.QueryHistory{
+		StatementHash: query.StatementHash(req.Statement),
+		Action:        action,
+		Resource:      strings.Join(entities, ","),
+	}
+	history.StatementEnc, history.StatementRedacted = h.historyStatement(conn.Type, req.Statement)
+	history.Fingerprint, history.FingerprintText = h.historyFingerprint(conn.Type, req.Statement)
+	revised := approval
+	revised.Statement = req.Statement
+	revised.StatementHash = query.StatementHash(req.Statement)
+	revised.Reason = strings.TrimSpace(req.Reason)
+	revised.TicketURL = strings.TrimSpace(req.TicketURL)
+	revised.Impact = strings.TrimSpace(req.Impact)
+	revised.ExplainPlan = h.explainForApproval(r, conn, req.Statement)
+	revised, err = h.Store.ReviseQueryApproval(r.Context(), revised, history, h.Config.ApprovalTTL)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "approval can no longer be revised", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to revise approval", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "query_approval_revised", &user.ID, map[string]any{
+		"approvalId": revised.ID.String(),
+		"revision":   revised.Revision,
+	}, "")
+	writeJSON(w, http.StatusOK, approvalView(revised))
+}
+
+// ApprovalDiff compares the statements of two revisions, by default the
+// previous and the current one.
+func (h *Handler) ApprovalDiff(w http.ResponseWriter, r *http.Request) {
+	approval, ok := h.loadVisibleApproval(w, r)
+	if !ok {
+		return
+	}
+	revisions, err := h.Store.ListApprovalRevisions(r.Context(), approval.ID)
+	if err != nil {
+		http.Error(w, "failed to load revisions", http.StatusInternalServerError)
+		return
+	}
+	to := parseInt(r.URL.Query().Get("to"), approval.Revision)
+	from := parseInt(r.URL.Query().Get("from"), to-1)
+	var oldRev, newRev *store.ApprovalRevision
+	for i := range revisions {
+		switch revisions[i].Revision {
+		case from:
+			oldRev = &revisions[i]
+		case to:
+			newRev = &revisions[i]
+		}
+	}
+	if newRev == nil || (oldRev == nil && from > 0) {
+		http.Error(w, "revision not found", http.StatusNotFound)
 		return
 	}
-	_ = h.Audit.LogEvent(r.Context(), "query_approval_approved", &user.ID, map[string]any{"approvalId": id.String()}, "")
-	writeJSON(w, http.StatusOK, map[string]any{"status": "approved"})
+	oldStatement := ""
+	if oldRev != nil {
+		oldStatement = oldRev.Statement
+	}
+	writeJSON(w, http.StatusOK, approvalDiffResponse{
+		From:  from,
+		To:    to,
+		Lines: query.LineDiff(oldStatement, newRev.Statement),
+	})
+}
+
+func (h *Handler) loadApproval(w http.ResponseWriter, r *http.Request) (store.QueryApproval, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return store.QueryApproval{}, false
+	}
+	approval, err := h.Store.GetQueryApproval(r.Context(), id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return store.QueryApproval{}, false
+	}
+	return approval, true
+}
+
+// loadVisibleApproval is loadApproval for the requester or an approver.
+func (h *Handler) loadVisibleApproval(w http.ResponseWriter, r *http.Request) (store.QueryApproval, bool) {
+	if !h.Settings.Get().FlagEnabled("enable_query_approval") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return store.QueryApproval{}, false
+	}
+	approval, ok := h.loadApproval(w, r)
+	if !ok {
+		return store.QueryApproval{}, false
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	if approval.UserID != user.ID && !h.authorize(w, r, "query:admin", "approvals", nil) {
+		return store.QueryApproval{}, false
+	}
+	return approval, true
+}
+
+// explainForApproval attaches the plan of statement for approvers. Failures
+// are ignored; approvals do not depend on it.
+func (h *Handler) explainForApproval(r *http.Request, conn store.Connection, statement string) []byte {
+	adapter, err := h.Connections.GetAdapter(r.Context(), conn)
+	if err != nil {
+		return nil
+	}
+	defer adapter.Close()
+	plan, err := adapter.Explain(r.Context(), statement)
+	if err != nil {
+		return nil
+	}
+	raw, err := json.Marshal(plan)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+func (h *Handler) Approve(w http.ResponseWriter, r *http.Request) {
+	h.voteApproval(w, r, "approved")
 }
 
 func (h *Handler) Deny(w http.ResponseWriter, r *http.Request) {
+	h.voteApproval(w, r, "denied")
+}
+
+// voteApproval records the caller's decision. Requesters cannot vote on
+// their own approvals and each approver votes once.
+func (h *Handler) voteApproval(w http.ResponseWriter, r *http.Request, decision string) {
 	if !h.Settings.Get().FlagEnabled("enable_query_approval") {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	if !h.authorize(w, r, "query:admin", "approvals", "") {
+	if !h.authorize(w, r, "query:admin", "approvals", nil) {
 		return
 	}
 	id, err := uuid.Parse(chi.URLParam(r, "id"))
@@ -60,11 +328,194 @@ func (h *Handler) Deny(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid id", http.StatusBadRequest)
 		return
 	}
+	approval, err := h.Store.GetQueryApproval(r.Context(), id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
 	user, _ := auth.UserFromContext(r.Context())
-	if err := h.Store.DenyQuery(r.Context(), id, user.ID); err != nil {
-		http.Error(w, "failed to deny", http.StatusInternalServerError)
+	if approval.UserID == user.ID {
+		http.Error(w, "cannot approve own request", http.StatusForbidden)
+		return
+	}
+	if !h.requireStepUp(w, r) {
 		return
 	}
-	_ = h.Audit.LogEvent(r.Context(), "query_approval_denied", &user.ID, map[string]any{"approvalId": id.String()}, "")
-	writeJSON(w, http.StatusOK, map[string]any{"status": "denied"})
+	approval, err = h.Store.VoteQueryApproval(r.Context(), id, user.ID, decision, h.Config.ApprovalTTL)
+	switch {
+	case errors.Is(err, store.ErrSelfApproval):
+		http.Error(w, "cannot approve own request", http.StatusForbidden)
+		return
+	case errors.Is(err, store.ErrExpired):
+		http.Error(w, "approval expired", http.StatusConflict)
+		return
+	case errors.Is(err, store.ErrConflict):
+		http.Error(w, "already decided", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "failed to record vote", http.StatusInternalServerError)
+		return
+	}
+	details := map[string]any{
+		"approvalId": id.String(),
+		"decision":   decision,
+		"approvals":  approval.Approvals,
+		"required":   approval.RequiredApprovals,
+		"status":     approval.Status,
+	}
+	_ = h.Audit.LogEvent(r.Context(), "query_approval_vote", &user.ID, details, "")
+	if approval.Status != "pending" {
+		_ = h.Audit.LogEvent(r.Context(), "query_approval_"+approval.Status, &user.ID, map[string]any{
+			"approvalId":   id.String(),
+			"userId":       approval.UserID.String(),
+			"connectionId": approval.ConnectionID.String(),
+		}, "")
+	}
+	writeJSON(w, http.StatusOK, approvalVoteResult{
+		Status:    approval.Status,
+		Approvals: approval.Approvals,
+		Required:  approval.RequiredApprovals,
+		ExpiresAt: approval.ExpiresAt,
+	})
+}
+
+// requiredApprovals is the quorum for env from APPROVAL_QUORUM.
+func (h *Handler) requiredApprovals(env string) int {
+	if n, ok := h.Config.ApprovalQuorum[strings.ToLower(env)]; ok && n > 0 {
+		return n
+	}
+	if n, ok := h.Config.ApprovalQuorum["*"]; ok && n > 0 {
+		return n
+	}
+	return 1
+}
+
+type approvalResponse struct {
+	ID                uuid.UUID       `json:"id"`
+	ConnectionID      uuid.UUID       `json:"connectionId"`
+	UserID            uuid.UUID       `json:"userId"`
+	Statement         string          `json:"statement"`
+	StatementHash     string          `json:"statementHash"`
+	Status            string          `json:"status"`
+	Environment       string          `json:"environment"`
+	Reason            string          `json:"reason"`
+	TicketURL         string          `json:"ticketUrl"`
+	Impact            string          `json:"impact"`
+	Explain           json.RawMessage `json:"explain,omitempty"`
+	Revision          int             `json:"revision"`
+	RequiredApprovals int             `json:"requiredApprovals"`
+	Approvals         int             `json:"approvals"`
+	CreatedAt         time.Time       `json:"createdAt"`
+	ExpiresAt         *time.Time      `json:"expiresAt"`
+	ApprovedBy        *uuid.UUID      `json:"approvedBy,omitempty"`
+	ApprovedAt        *time.Time      `json:"approvedAt"`
+	DeniedBy          *uuid.UUID      `json:"deniedBy,omitempty"`
+	DeniedAt          *time.Time      `json:"deniedAt"`
+	ConsumedAt        *time.Time      `json:"consumedAt"`
+}
+
+type approvalDetailResponse struct {
+	approvalResponse
+	Revisions []approvalRevisionResponse `json:"revisions"`
+	Comments  []approvalCommentResponse  `json:"comments"`
+	Votes     []approvalVoteResponse     `json:"votes"`
+}
+
+type approvalRevisionResponse struct {
+	Revision      int             `json:"revision"`
+	Statement     string          `json:"statement"`
+	StatementHash string          `json:"statementHash"`
+	Reason        string          `json:"reason"`
+	TicketURL     string          `json:"ticketUrl"`
+	Impact        string          `json:"impact"`
+	Explain       json.RawMessage `json:"explain,omitempty"`
+	CreatedBy     *uuid.UUID      `json:"createdBy,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt"`
+}
+
+type approvalCommentResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	Revision  int        `json:"revision"`
+	Kind      string     `json:"kind"`
+	Body      string     `json:"body"`
+	UserID    *uuid.UUID `json:"userId,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type approvalVoteResponse struct {
+	UserID    uuid.UUID `json:"userId"`
+	Decision  string    `json:"decision"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type approvalVoteResult struct {
+	Status    string     `json:"status"`
+	Approvals int        `json:"approvals"`
+	Required  int        `json:"required"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type approvalDiffResponse struct {
+	From  int              `json:"from"`
+	To    int              `json:"to"`
+	Lines []query.DiffLine `json:"lines"`
+}
+
+func approvalView(a store.QueryApproval) approvalResponse {
+	return approvalResponse{
+		ID:                a.ID,
+		ConnectionID:      a.ConnectionID,
+		UserID:            a.UserID,
+		Statement:         a.Statement,
+		StatementHash:     a.StatementHash,
+		Status:            a.Status,
+		Environment:       a.Environment,
+		Reason:            a.Reason,
+		TicketURL:         a.TicketURL,
+		Impact:            a.Impact,
+		Explain:           rawJSON(a.ExplainPlan),
+		Revision:          a.Revision,
+		RequiredApprovals: a.RequiredApprovals,
+		Approvals:         a.Approvals,
+		CreatedAt:         a.CreatedAt,
+		ExpiresAt:         a.ExpiresAt,
+		ApprovedBy:        a.ApprovedBy,
+		ApprovedAt:        a.ApprovedAt,
+		DeniedBy:          a.DeniedBy,
+		DeniedAt:          a.DeniedAt,
+		ConsumedAt:        a.ConsumedAt,
+	}
+}
+
+func approvalRevisionView(v store.ApprovalRevision) approvalRevisionResponse {
+	return approvalRevisionResponse{
+		Revision:      v.Revision,
+		Statement:     v.Statement,
+		StatementHash: v.StatementHash,
+		Reason:        v.Reason,
+		TicketURL:     v.TicketURL,
+		Impact:        v.Impact,
+		Explain:       rawJSON(v.ExplainPlan),
+		CreatedBy:     v.CreatedBy,
+		CreatedAt:     v.CreatedAt,
+	}
+}
+
+func approvalCommentView(c store.ApprovalComment) approvalCommentResponse {
+	return approvalCommentResponse{
+		ID:        c.ID,
+		Revision:  c.Revision,
+		Kind:      c.Kind,
+		Body:      c.Body,
+		UserID:    c.UserID,
+		CreatedAt: c.CreatedAt,
+	}
+}
+
+// rawJSON passes stored JSON through, leaving it out when empty.
+func rawJSON(raw []byte) json.RawMessage {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.RawMessage(raw)
 }
@@ -2,13 +2,17 @@ package handlers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strings"
 	"time"
 
 	"flowdb/backend/auth"
+	"flowdb/backend/store"
 
+	"github.com/google/uuid"
 	"golang.org/x/oauth2"
 )
 
@@ -32,10 +36,12 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 	user, err := h.Store.GetUserByUsername(r.Context(), req.Username)
 	if err != nil {
+		h.loginFailed(r, nil, req.Username, "unknown_user")
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
 	if err := auth.CheckPassword(user.PasswordHash, req.Password); err != nil {
+		h.loginFailed(r, &user.ID, user.Username, "invalid_password")
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
@@ -48,19 +54,19 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		}
 		secret, err := h.decryptMFASecret(user.MFASecretEnc)
 		if err != nil || !auth.VerifyTOTP(secret, req.MFACode) {
+			h.loginFailed(r, &user.ID, user.Username, "invalid_mfa")
 			http.Error(w, "invalid mfa code", http.StatusUnauthorized)
 			return
 		}
 		now := time.Now().UTC()
 		mfaAt = &now
 	}
-	session, err := h.Sessions.Create(r.Context(), user.ID, mfaAt)
-	if err != nil {
-		http.Error(w, "failed to create session", http.StatusInternalServerError)
+	session, ok := h.startSession(w, r, user.ID, mfaAt)
+	if !ok {
 		return
 	}
-	h.Sessions.SetCookie(w, session.ID, !h.Config.AllowInsecureCookies)
 	_ = h.Audit.LogEvent(r.Context(), "login", &user.ID, map[string]any{"username": user.Username}, "")
+	h.recordDevice(w, r, user)
 	writeJSON(w, http.StatusOK, loginResponse{
 		ID:        user.ID.String(),
 		Username:  user.Username,
@@ -68,6 +74,43 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// recordDevice remembers the browser a user signed in from, keyed by the
+// device cookie and browser family, and audits login_new_device the first
+// time a known user shows up on another one.
+func (h *Handler) recordDevice(w http.ResponseWriter, r *http.Request, user store.User) {
+	userAgent := r.UserAgent()
+	deviceID, hadCookie := auth.DeviceID(r)
+	legacy := ""
+	if !hadCookie {
+		// Devices seen before the cookie existed were keyed by the hash of
+		// the full User-Agent.
+		sum := sha256.Sum256([]byte(userAgent))
+		legacy = hex.EncodeToString(sum[:])
+	}
+	auth.SetDeviceCookie(w, deviceID, !h.Config.AllowInsecureCookies)
+	ip := clientIP(r)
+	isNew, err := h.Store.TouchUserDevice(r.Context(), user.ID, auth.DeviceFingerprint(deviceID, auth.DeviceFamily(userAgent)), legacy, userAgent, ip)
+	if err != nil {
+		h.Logger.Error("device tracking failed", "user", user.ID, "error", err)
+		return
+	}
+	if isNew {
+		_ = h.Audit.LogEvent(r.Context(), "login_new_device", &user.ID, map[string]any{
+			"username":  user.Username,
+			"ip":        ip,
+			"userAgent": userAgent,
+			"device":    auth.DeviceFamily(userAgent),
+		}, "")
+	}
+}
+
+func (h *Handler) loginFailed(r *http.Request, userID *uuid.UUID, username string, reason string) {
+	_ = h.Audit.LogEvent(r.Context(), "login_failed", userID, map[string]any{
+		"username": username,
+		"reason":   reason,
+	}, "")
+}
+
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 	session, ok := auth.SessionFromContext(r.Context())
 	if ok {
@@ -84,13 +127,17 @@ func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
+	resp := map[string]any{
 		"id":         user.ID.String(),
 		"username":   user.Username,
 		"isAdmin":    user.IsAdmin,
 		"mfaEnabled": user.MFAEnabled,
 		"settings":   h.Settings.Get(),
-	})
+	}
+	if bg, ok := auth.BreakGlassFromContext(r.Context()); ok {
+		resp["breakGlass"] = breakGlassView(bg)
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 type mfaEnrollResponse struct {
@@ -160,6 +207,9 @@ func (h *Handler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
 		_ = h.Store.UpdateSessionMFA(r.Context(), session.ID, now)
 	}
 	_ = h.Audit.LogEvent(r.Context(), "mfa_verify", &user.ID, map[string]any{}, "")
+	if !user.MFAEnabled {
+		_ = h.Audit.LogEvent(r.Context(), "mfa_enabled", &user.ID, map[string]any{}, "")
+	}
 	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
 }
 
@@ -242,13 +292,12 @@ func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to map user", http.StatusInternalServerError)
 		return
 	}
-	session, err := h.Sessions.Create(r.Context(), user.ID, nil)
-	if err != nil {
-		http.Error(w, "failed to create session", http.StatusInternalServerError)
+	session, ok := h.startSession(w, r, user.ID, nil)
+	if !ok {
 		return
 	}
-	h.Sessions.SetCookie(w, session.ID, !h.Config.AllowInsecureCookies)
 	_ = h.Audit.LogEvent(r.Context(), "oidc_login", &user.ID, map[string]any{"subject": subject, "email": email}, "")
+	h.recordDevice(w, r, user)
 	writeJSON(w, http.StatusOK, loginResponse{
 		ID:        user.ID.String(),
 		Username:  user.Username,
@@ -280,10 +329,17 @@ func (h *Handler) saveOIDCState(ctx context.Context, state string, nonce string)
 
 func (h *Handler) consumeOIDCState(ctx context.Context, state string) (string, error) {
 	var nonce string
+	var createdAt time.Time
 	err := h.Store.DB().QueryRow(ctx, `
-		DELETE FROM oidc_states WHERE state=$1 RETURNING nonce
-	`, state).Scan(&nonce)
-	return nonce, err
+		DELETE FROM oidc_states WHERE state=$1 RETURNING nonce, created_at
+	`, state).Scan(&nonce, &createdAt)
+	if err != nil {
+		return "", err
+	}
+	if !auth.OIDCStateValid(createdAt, time.Now()) {
+		return "", errors.New("state expired")
+	}
+	return nonce, nil
 }
 
 func sanitizeUsername(value string) string {
@@ -2,30 +2,109 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"flowdb/backend/auth"
+	"flowdb/backend/middleware"
 	"flowdb/backend/policies"
+
+	"github.com/google/uuid"
 )
 
-func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, action string, resource string, env string) bool {
-	_, ok := h.authorizeWithConstraints(w, r, action, resource, env)
+// authorize checks action on resource. tags are the connection tags for
+// connection-scoped resources and nil otherwise.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, action string, resource string, tags map[string]any) bool {
+	_, ok := h.authorizeWithConstraints(w, r, action, resource, tags)
 	return ok
 }
 
-func (h *Handler) authorizeWithConstraints(w http.ResponseWriter, r *http.Request, action string, resource string, env string) (policies.Constraints, bool) {
+func (h *Handler) authorizeWithConstraints(w http.ResponseWriter, r *http.Request, action string, resource string, tags map[string]any) (policies.Constraints, bool) {
+	return h.authorizeResources(w, r, action, []string{resource}, tags)
+}
+
+// authorizeResources authorizes action on every resource and denies the
+// request if any of them is not allowed. Constraints are merged so the
+// tightest limits win. Use of a temporary access grant or of a break-glass
+// elevation is audited.
+func (h *Handler) authorizeResources(w http.ResponseWriter, r *http.Request, action string, resources []string, tags map[string]any) (policies.Constraints, bool) {
 	user, ok := auth.UserFromContext(r.Context())
 	if !ok {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return policies.Constraints{}, false
 	}
-	decision, err := h.Authorizer.Authorize(r.Context(), user, action, resource, env)
-	if err != nil {
-		http.Error(w, "authorization error", http.StatusInternalServerError)
-		return policies.Constraints{}, false
+	var constraints policies.Constraints
+	granted := map[uuid.UUID][]string{}
+	for _, resource := range resources {
+		req := h.policyRequest(r, action, resource, tags)
+		decision, err := h.Authorizer.Authorize(r.Context(), user, req)
+		if err != nil {
+			http.Error(w, "authorization error", http.StatusInternalServerError)
+			return policies.Constraints{}, false
+		}
+		if !decision.Allowed {
+			details := map[string]any{
+				"action":   action,
+				"resource": resource,
+				"reason":   decision.Reason,
+			}
+			if decision.Trace != nil {
+				details["trace"] = decision.Trace.Compact()
+			}
+			_ = h.Audit.LogEvent(r.Context(), "access_denied", &user.ID, details, "")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return policies.Constraints{}, false
+		}
+		constraints = constraints.Merge(decision.Constraints)
+		if decision.GrantID != nil {
+			granted[*decision.GrantID] = append(granted[*decision.GrantID], resource)
+		}
 	}
-	if !decision.Allowed {
-		http.Error(w, "forbidden", http.StatusForbidden)
-		return policies.Constraints{}, false
+	if bg, ok := auth.BreakGlassFromContext(r.Context()); ok {
+		_ = h.Audit.LogEvent(r.Context(), "break_glass_action", &user.ID, map[string]any{
+			"action":    action,
+			"resources": resources,
+			"method":    r.Method,
+			"path":      r.URL.Path,
+			"expiresAt": bg.ExpiresAt,
+		}, "")
 	}
-	return decision.Constraints, true
+	for grantID, used := range granted {
+		_ = h.Store.TouchAccessGrant(r.Context(), grantID)
+		_ = h.Audit.LogEvent(r.Context(), "access_grant_used", &user.ID, map[string]any{
+			"requestId": grantID.String(),
+			"action":    action,
+			"resources": used,
+		}, "")
+	}
+	return constraints, true
+}
+
+// allowed reports whether action on resource is allowed without writing a
+// response or audit event, for filtering lists.
+func (h *Handler) allowed(r *http.Request, action string, resource string, tags map[string]any) bool {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		return false
+	}
+	decision, err := h.Authorizer.Authorize(r.Context(), user, h.policyRequest(r, action, resource, tags))
+	return err == nil && decision.Allowed
+}
+
+func (h *Handler) policyRequest(r *http.Request, action string, resource string, tags map[string]any) policies.Request {
+	req := policies.Request{
+		Action:         action,
+		Resource:       resource,
+		Environment:    getEnv(tags),
+		Time:           time.Now().UTC(),
+		SourceIP:       clientIP(r),
+		ConnectionTags: tags,
+	}
+	if session, ok := auth.SessionFromContext(r.Context()); ok {
+		req.MFAAt = session.LastMFAAt
+	}
+	return req
+}
+
+func clientIP(r *http.Request) string {
+	return middleware.RequestIP(r)
 }
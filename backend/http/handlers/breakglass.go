@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type breakGlassRequest struct {
+	Reason          string `json:"reason"`
+	DurationMinutes int    `json:"durationMinutes"`
+}
+
+type breakGlassReviewRequest struct {
+	Note string `json:"note"`
+}
+
+// StartBreakGlass elevates the current session to admin for a short time.
+// It needs a fresh password and MFA check regardless of the step-up flag.
+func (h *Handler) StartBreakGlass(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:break_glass", "iam/break-glass", nil) {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	session, ok := auth.SessionFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if _, active := auth.BreakGlassFromContext(r.Context()); active {
+		http.Error(w, "break-glass already active", http.StatusConflict)
+		return
+	}
+	if !user.MFAEnabled {
+		http.Error(w, "mfa enrollment required", http.StatusForbidden)
+		return
+	}
+	if time.Since(session.LastAuthAt) > h.Config.StepUpMaxAge {
+		http.Error(w, "step up required", http.StatusUnauthorized)
+		return
+	}
+	if session.LastMFAAt == nil || time.Since(*session.LastMFAAt) > h.Config.StepUpMaxAge {
+		http.Error(w, "mfa step up required", http.StatusUnauthorized)
+		return
+	}
+	var req breakGlassRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "reason required", http.StatusBadRequest)
+		return
+	}
+	ttl := h.Config.BreakGlassTTL
+	if d := time.Duration(req.DurationMinutes) * time.Minute; d > 0 && d < ttl {
+		ttl = d
+	}
+	bg, err := h.Store.CreateBreakGlass(r.Context(), store.BreakGlass{
+		UserID:    user.ID,
+		SessionID: &session.ID,
+		Reason:    req.Reason,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		http.Error(w, "failed to start break-glass", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "break_glass_started", &user.ID, map[string]any{
+		"breakGlassId": bg.ID.String(),
+		"username":     user.Username,
+		"reason":       bg.Reason,
+		"expiresAt":    bg.ExpiresAt,
+	}, "")
+	writeJSON(w, http.StatusCreated, breakGlassView(bg))
+}
+
+// ListBreakGlass returns the caller's elevations, or all of them with
+// iam:read. ?unreviewed=true lists those awaiting review.
+func (h *Handler) ListBreakGlass(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var userID *uuid.UUID
+	if !h.allowed(r, "iam:read", "iam/break-glass", nil) {
+		userID = &user.ID
+	}
+	list, err := h.Store.ListBreakGlass(r.Context(), userID, r.URL.Query().Get("unreviewed") == "true",
+		parseInt(r.URL.Query().Get("limit"), 100), parseInt(r.URL.Query().Get("offset"), 0))
+	if err != nil {
+		http.Error(w, "failed to list break-glass", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(list))
+	for _, bg := range list {
+		views = append(views, breakGlassView(bg))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// EndBreakGlass drops the elevation before it expires.
+func (h *Handler) EndBreakGlass(w http.ResponseWriter, r *http.Request) {
+	bg, ok := h.loadBreakGlass(w, r)
+	if !ok {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	if bg.UserID != user.ID && !h.authorize(w, r, "iam:write", "iam/break-glass", nil) {
+		return
+	}
+	ended, err := h.Store.EndBreakGlass(r.Context(), bg.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "break-glass already ended", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to end break-glass", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "break_glass_ended", &user.ID, map[string]any{
+		"breakGlassId": ended.ID.String(),
+		"userId":       ended.UserID.String(),
+	}, "")
+	writeJSON(w, http.StatusOK, breakGlassView(ended))
+}
+
+// ReviewBreakGlass records the post-incident acknowledgement. The reviewer
+// must be an admin in their own right and not the person who broke glass.
+func (h *Handler) ReviewBreakGlass(w http.ResponseWriter, r *http.Request) {
+	bg, ok := h.loadBreakGlass(w, r)
+	if !ok {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	_, elevated := auth.BreakGlassFromContext(r.Context())
+	if status, msg := breakGlassReviewError(bg, user, elevated, time.Now()); status != 0 {
+		http.Error(w, msg, status)
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	var req breakGlassReviewRequest
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	reviewed, err := h.Store.ReviewBreakGlass(r.Context(), bg.ID, user.ID, strings.TrimSpace(req.Note))
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "break-glass already reviewed", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to review break-glass", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "break_glass_reviewed", &user.ID, map[string]any{
+		"breakGlassId": reviewed.ID.String(),
+		"userId":       reviewed.UserID.String(),
+		"note":         reviewed.ReviewNote,
+	}, "")
+	writeJSON(w, http.StatusOK, breakGlassView(reviewed))
+}
+
+// breakGlassReviewError returns the status and message refusing a review of
+// bg by reviewer at now, or 0 when the review may proceed. elevated is set
+// when the reviewer is themselves under break-glass.
+func breakGlassReviewError(bg store.BreakGlass, reviewer store.User, elevated bool, now time.Time) (int, string) {
+	switch {
+	case elevated || !reviewer.IsAdmin:
+		return http.StatusForbidden, "forbidden"
+	case bg.UserID == reviewer.ID:
+		return http.StatusForbidden, "cannot review own break-glass"
+	case bg.Active(now):
+		return http.StatusConflict, "break-glass still active"
+	}
+	return 0, ""
+}
+
+func (h *Handler) loadBreakGlass(w http.ResponseWriter, r *http.Request) (store.BreakGlass, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return store.BreakGlass{}, false
+	}
+	bg, err := h.Store.GetBreakGlass(r.Context(), id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return store.BreakGlass{}, false
+	}
+	return bg, true
+}
+
+func breakGlassView(bg store.BreakGlass) map[string]any {
+	view := map[string]any{
+		"id":         bg.ID.String(),
+		"userId":     bg.UserID.String(),
+		"reason":     bg.Reason,
+		"startedAt":  bg.StartedAt,
+		"expiresAt":  bg.ExpiresAt,
+		"endedAt":    bg.EndedAt,
+		"active":     bg.Active(time.Now()),
+		"reviewedAt": bg.ReviewedAt,
+		"reviewNote": bg.ReviewNote,
+	}
+	if bg.ReviewedBy != nil {
+		view["reviewedBy"] = bg.ReviewedBy.String()
+	}
+	return view
+}
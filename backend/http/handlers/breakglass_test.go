@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/config"
+	"flowdb/backend/iam"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+type auditEvent struct {
+	event   string
+	details map[string]any
+}
+
+type fakeAudit struct {
+	events []auditEvent
+}
+
+func (f *fakeAudit) LogEvent(ctx context.Context, eventType string, userID *uuid.UUID, details map[string]any, errorID string) error {
+	f.events = append(f.events, auditEvent{event: eventType, details: details})
+	return nil
+}
+
+// breakGlassHandler authorizes admins without a store, which is enough to
+// reach every check StartBreakGlass makes before creating the elevation.
+func breakGlassHandler() (*Handler, *fakeAudit) {
+	events := &fakeAudit{}
+	return &Handler{
+		Authorizer: iam.NewAuthorizer(nil, nil),
+		Audit:      events,
+		Config:     &config.Config{StepUpMaxAge: 5 * time.Minute, BreakGlassTTL: time.Hour},
+	}, events
+}
+
+func TestStartBreakGlassRefusals(t *testing.T) {
+	now := time.Now()
+	recent, stale := now.Add(-time.Minute), now.Add(-time.Hour)
+	user := store.User{ID: uuid.New(), IsAdmin: true, MFAEnabled: true}
+	session := store.Session{ID: uuid.New(), UserID: user.ID, LastAuthAt: recent, LastMFAAt: &recent}
+	cases := []struct {
+		name     string
+		user     func(store.User) store.User
+		session  func(store.Session) store.Session
+		elevated bool
+		body     string
+		status   int
+		message  string
+	}{
+		{"mfa not enrolled", func(u store.User) store.User { u.MFAEnabled = false; return u }, nil, false, `{"reason":"INC-1"}`, http.StatusForbidden, "mfa enrollment required"},
+		{"stale password check", nil, func(s store.Session) store.Session { s.LastAuthAt = stale; return s }, false, `{"reason":"INC-1"}`, http.StatusUnauthorized, "step up required"},
+		{"no mfa check", nil, func(s store.Session) store.Session { s.LastMFAAt = nil; return s }, false, `{"reason":"INC-1"}`, http.StatusUnauthorized, "mfa step up required"},
+		{"stale mfa check", nil, func(s store.Session) store.Session { s.LastMFAAt = &stale; return s }, false, `{"reason":"INC-1"}`, http.StatusUnauthorized, "mfa step up required"},
+		{"missing reason", nil, nil, false, `{"reason":"  "}`, http.StatusBadRequest, "reason required"},
+		{"already elevated", nil, nil, true, `{"reason":"INC-1"}`, http.StatusConflict, "break-glass already active"},
+	}
+	for _, tc := range cases {
+		h, events := breakGlassHandler()
+		u, s := user, session
+		if tc.user != nil {
+			u = tc.user(u)
+		}
+		if tc.session != nil {
+			s = tc.session(s)
+		}
+		ctx := auth.WithSession(auth.WithUser(context.Background(), u), s)
+		if tc.elevated {
+			ctx = auth.WithBreakGlass(ctx, store.BreakGlass{ID: uuid.New(), UserID: u.ID, SessionID: &s.ID, ExpiresAt: now.Add(time.Minute)})
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/iam/break-glass", strings.NewReader(tc.body)).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		h.StartBreakGlass(rec, req)
+		if rec.Code != tc.status || strings.TrimSpace(rec.Body.String()) != tc.message {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, rec.Code, rec.Body.String(), tc.status, tc.message)
+		}
+		for _, ev := range events.events {
+			if ev.event == "break_glass_started" {
+				t.Errorf("%s: break-glass started despite refusal", tc.name)
+			}
+		}
+	}
+}
+
+func TestBreakGlassReviewError(t *testing.T) {
+	now := time.Now()
+	oncall, reviewer := uuid.New(), uuid.New()
+	admin := store.User{ID: reviewer, IsAdmin: true}
+	ended := store.BreakGlass{UserID: oncall, StartedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)}
+	active := store.BreakGlass{UserID: oncall, StartedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Minute)}
+	cases := []struct {
+		name     string
+		bg       store.BreakGlass
+		reviewer store.User
+		elevated bool
+		status   int
+	}{
+		{"second admin after expiry", ended, admin, false, 0},
+		{"self review", ended, store.User{ID: oncall, IsAdmin: true}, false, http.StatusForbidden},
+		{"still active", active, admin, false, http.StatusConflict},
+		{"reviewer under break-glass", ended, admin, true, http.StatusForbidden},
+		{"reviewer not admin", ended, store.User{ID: reviewer}, false, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		if status, msg := breakGlassReviewError(tc.bg, tc.reviewer, tc.elevated, now); status != tc.status {
+			t.Errorf("%s: got %d %q, want %d", tc.name, status, msg, tc.status)
+		}
+	}
+}
+
+func TestAuthorizeAuditsBreakGlassActions(t *testing.T) {
+	h, events := breakGlassHandler()
+	user := store.User{ID: uuid.New(), IsAdmin: true}
+	bg := store.BreakGlass{ID: uuid.New(), UserID: user.ID, ExpiresAt: time.Now().Add(time.Minute)}
+	ctx := auth.WithBreakGlass(auth.WithUser(context.Background(), user), bg)
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/connections/c1", nil).WithContext(ctx)
+	if !h.authorize(httptest.NewRecorder(), req, "connection:write", "connection/c1", nil) {
+		t.Fatal("expected elevated admin to be allowed")
+	}
+	if len(events.events) != 1 || events.events[0].event != "break_glass_action" {
+		t.Fatalf("expected a break_glass_action event, got %+v", events.events)
+	}
+	details := events.events[0].details
+	if details["action"] != "connection:write" || details["method"] != http.MethodDelete || details["path"] != "/api/v1/connections/c1" {
+		t.Fatalf("unexpected details %v", details)
+	}
+
+	events.events = nil
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/connections/c1", nil).WithContext(auth.WithUser(context.Background(), user))
+	if !h.authorize(httptest.NewRecorder(), req, "connection:write", "connection/c1", nil) || len(events.events) != 0 {
+		t.Fatalf("expected no break-glass audit for a standing admin, got %+v", events.events)
+	}
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"flowdb/backend/adapters"
+	"flowdb/backend/audit"
 	"flowdb/backend/query"
 	"flowdb/backend/store"
 
@@ -17,8 +22,7 @@ func (h *Handler) ListNamespaces(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer adapter.Close()
-	env := getEnv(conn.Tags)
-	if !h.authorize(w, r, "connection:read", "connection/"+conn.ID.String(), env) {
+	if !h.authorize(w, r, "connection:read", "connection/"+conn.ID.String(), conn.Tags) {
 		return
 	}
 	list, err := adapter.ListNamespaces(r.Context())
@@ -26,6 +30,11 @@ func (h *Handler) ListNamespaces(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to list namespaces", http.StatusInternalServerError)
 		return
 	}
+	h.recordDataAccess(r.Context(), currentUserID(r), conn, audit.DataAccess{
+		Operation: audit.AccessListNamespaces,
+		Resource:  "connection/" + conn.ID.String(),
+		RowCount:  len(list),
+	})
 	writeJSON(w, http.StatusOK, list)
 }
 
@@ -41,8 +50,7 @@ func (h *Handler) ListEntities(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	resource := "connection/" + conn.ID.String() + "/db/" + ns + "/entity/*"
-	env := getEnv(conn.Tags)
-	if !h.authorize(w, r, "connection:read", resource, env) {
+	if !h.authorize(w, r, "connection:read", resource, conn.Tags) {
 		return
 	}
 	list, err := adapter.ListEntities(r.Context(), ns)
@@ -50,6 +58,11 @@ func (h *Handler) ListEntities(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to list entities", http.StatusInternalServerError)
 		return
 	}
+	h.recordDataAccess(r.Context(), currentUserID(r), conn, audit.DataAccess{
+		Operation: audit.AccessListEntities,
+		Resource:  resource,
+		RowCount:  len(list),
+	})
 	writeJSON(w, http.StatusOK, list)
 }
 
@@ -66,8 +79,7 @@ func (h *Handler) GetEntityInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	resource := "connection/" + conn.ID.String() + "/db/" + ns + "/entity/" + name
-	env := getEnv(conn.Tags)
-	if !h.authorize(w, r, "connection:read", resource, env) {
+	if !h.authorize(w, r, "connection:read", resource, conn.Tags) {
 		return
 	}
 	info, err := adapter.GetEntityInfo(r.Context(), ns, name)
@@ -75,6 +87,15 @@ func (h *Handler) GetEntityInfo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to get entity info", http.StatusInternalServerError)
 		return
 	}
+	columns := make([]string, 0, len(info.Columns))
+	for _, c := range info.Columns {
+		columns = append(columns, c.Name)
+	}
+	h.recordDataAccess(r.Context(), currentUserID(r), conn, audit.DataAccess{
+		Operation: audit.AccessEntityInfo,
+		Resource:  resource,
+		Columns:   columns,
+	})
 	writeJSON(w, http.StatusOK, info)
 }
 
@@ -91,8 +112,7 @@ func (h *Handler) BrowseEntity(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	resource := "connection/" + conn.ID.String() + "/db/" + ns + "/entity/" + name
-	env := getEnv(conn.Tags)
-	if !h.authorize(w, r, "query:read", resource, env) {
+	if !h.authorize(w, r, "query:read", resource, conn.Tags) {
 		return
 	}
 	opts := adapters.BrowseOptions{
@@ -112,29 +132,115 @@ func (h *Handler) BrowseEntity(w http.ResponseWriter, r *http.Request) {
 		"rows":    []any{},
 		"docs":    []any{},
 	}
-	if h.Settings.Get().FlagEnabled("enable_pii_masking") {
-		rules, _ := h.Store.ListPIIRules(r.Context(), conn.ID)
-		columns := make([]string, 0, len(stream.Columns))
+	justification := r.URL.Query().Get("justification")
+	rules, revealed := h.maskingRules(r, conn, []string{resource}, justification)
+	columns := make([]string, 0, len(stream.Columns))
+	for _, c := range stream.Columns {
+		columns = append(columns, c.Name)
+	}
+	for row := range stream.Rows {
+		masked := query.MaskRow(resource, columns, row, rules)
+		response["rows"] = append(response["rows"].([]any), masked)
+	}
+	fields := map[string]bool{}
+	for doc := range stream.Docs {
+		for k := range doc {
+			fields[k] = true
+		}
+		masked := query.MaskDoc(resource, doc, rules)
+		response["docs"] = append(response["docs"].([]any), masked)
+	}
+	rows := len(response["rows"].([]any)) + len(response["docs"].([]any))
+	h.recordDataAccess(r.Context(), currentUserID(r), conn, resultAccess(audit.AccessBrowse, resource, []string{resource}, columns, fields, rows, rules))
+	h.auditUnmask(r.Context(), currentUserID(r), conn, []string{resource}, justification, revealed, columns, fields, rows)
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) ExportEntity(w http.ResponseWriter, r *http.Request) {
+	conn, adapter, ok := h.getConnectionAdapter(w, r)
+	if !ok {
+		return
+	}
+	defer adapter.Close()
+	ns := r.URL.Query().Get("ns")
+	name := chi.URLParam(r, "name")
+	if ns == "" || name == "" {
+		http.Error(w, "ns and name required", http.StatusBadRequest)
+		return
+	}
+	resource := "connection/" + conn.ID.String() + "/db/" + ns + "/entity/" + name
+	if !h.authorize(w, r, "query:read", resource, conn.Tags) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+		return
+	}
+	justification := r.URL.Query().Get("justification")
+	rules, revealed := h.maskingRules(r, conn, []string{resource}, justification)
+	maxRows := h.Config.GlobalMaxRows
+	if limit := parseInt(r.URL.Query().Get("limit"), 0); limit > 0 && limit < maxRows {
+		maxRows = limit
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+"."+format+`"`)
+	writer := newExportWriter(w, format)
+	const pageSize = 500
+	written := 0
+	var columns []string
+	fields := map[string]bool{}
+	defer func() {
+		h.recordDataAccess(r.Context(), currentUserID(r), conn, resultAccess(audit.AccessExport, resource, []string{resource}, columns, fields, written, rules))
+		h.auditUnmask(r.Context(), currentUserID(r), conn, []string{resource}, justification, revealed, columns, fields, written)
+	}()
+	for page := 1; written < maxRows; page++ {
+		stream, err := adapter.Browse(r.Context(), ns, name, adapters.BrowseOptions{
+			Page:     page,
+			PageSize: pageSize,
+			Sort:     r.URL.Query().Get("sort"),
+			Filter:   r.URL.Query().Get("filter"),
+		})
+		if err != nil {
+			if written == 0 {
+				http.Error(w, "export failed", http.StatusInternalServerError)
+			}
+			return
+		}
+		columns = make([]string, 0, len(stream.Columns))
 		for _, c := range stream.Columns {
 			columns = append(columns, c.Name)
 		}
+		count := 0
 		for row := range stream.Rows {
-			masked := query.MaskRow(resource, columns, row, rules)
-			response["rows"] = append(response["rows"].([]any), masked)
+			count++
+			if written < maxRows {
+				writer.row(columns, query.MaskRow(resource, columns, row, rules))
+				written++
+			}
 		}
 		for doc := range stream.Docs {
-			masked := query.MaskDoc(resource, doc, rules)
-			response["docs"] = append(response["docs"].([]any), masked)
+			count++
+			if written < maxRows {
+				for k := range doc {
+					fields[k] = true
+				}
+				writer.doc(query.MaskDoc(resource, doc, rules))
+				written++
+			}
 		}
-	} else {
-		for row := range stream.Rows {
-			response["rows"] = append(response["rows"].([]any), row)
-		}
-		for doc := range stream.Docs {
-			response["docs"] = append(response["docs"].([]any), doc)
+		if count < pageSize {
+			break
 		}
 	}
-	writeJSON(w, http.StatusOK, response)
+	writer.flush()
 }
 
 func (h *Handler) getConnectionAdapter(w http.ResponseWriter, r *http.Request) (store.Connection, adapters.Adapter, bool) {
@@ -163,3 +269,59 @@ func drainStream(stream *adapters.ResultStream) {
 	for range stream.Docs {
 	}
 }
+
+type exportWriter struct {
+	format  string
+	buf     *bufio.Writer
+	csv     *csv.Writer
+	started bool
+}
+
+func newExportWriter(w http.ResponseWriter, format string) *exportWriter {
+	buf := bufio.NewWriter(w)
+	return &exportWriter{format: format, buf: buf, csv: csv.NewWriter(buf)}
+}
+
+func (e *exportWriter) row(columns []string, row []any) {
+	if e.format == "csv" {
+		if !e.started {
+			_ = e.csv.Write(columns)
+			e.started = true
+		}
+		record := make([]string, len(row))
+		for i, v := range row {
+			if v != nil {
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		_ = e.csv.Write(record)
+		return
+	}
+	obj := make(map[string]any, len(columns))
+	for i, col := range columns {
+		if i < len(row) {
+			obj[col] = row[i]
+		}
+	}
+	e.doc(obj)
+}
+
+func (e *exportWriter) doc(doc map[string]any) {
+	if e.format == "csv" {
+		line, _ := json.Marshal(doc)
+		if !e.started {
+			_ = e.csv.Write([]string{"document"})
+			e.started = true
+		}
+		_ = e.csv.Write([]string{string(line)})
+		return
+	}
+	line, _ := json.Marshal(doc)
+	_, _ = e.buf.Write(line)
+	_ = e.buf.WriteByte('\n')
+}
+
+func (e *exportWriter) flush() {
+	e.csv.Flush()
+	_ = e.buf.Flush()
+}
@@ -31,8 +31,7 @@ func (h *Handler) ListConnections(w http.ResponseWriter, r *http.Request) {
 	}
 	filtered := make([]store.Connection, 0, len(conns))
 	for _, conn := range conns {
-		env := getEnv(conn.Tags)
-		if h.authorize(w, r, "connection:read", "connection/"+conn.ID.String(), env) {
+		if h.allowed(r, "connection:read", "connection/"+conn.ID.String(), conn.Tags) {
 			filtered = append(filtered, conn)
 		}
 	}
@@ -40,7 +39,7 @@ func (h *Handler) ListConnections(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) CreateConnection(w http.ResponseWriter, r *http.Request) {
-	if !h.authorize(w, r, "connection:write", "connection/*", "") {
+	if !h.authorize(w, r, "connection:write", "connection/*", nil) {
 		return
 	}
 	var req connectionRequest
@@ -78,7 +77,7 @@ func (h *Handler) CreateConnection(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to create connection", http.StatusInternalServerError)
 		return
 	}
-	_ = h.Audit.LogEvent(r.Context(), "connection_create", nil, map[string]any{"id": conn.ID.String(), "name": conn.Name}, "")
+	_ = h.Audit.LogEvent(r.Context(), "connection_create", nil, map[string]any{"id": conn.ID.String(), "connectionId": conn.ID.String(), "name": conn.Name}, "")
 	writeJSON(w, http.StatusCreated, conn)
 }
 
@@ -94,8 +93,7 @@ func (h *Handler) GetConnection(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	env := getEnv(conn.Tags)
-	if !h.authorize(w, r, "connection:read", "connection/"+conn.ID.String(), env) {
+	if !h.authorize(w, r, "connection:read", "connection/"+conn.ID.String(), conn.Tags) {
 		return
 	}
 	writeJSON(w, http.StatusOK, conn)
@@ -113,8 +111,7 @@ func (h *Handler) UpdateConnection(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	env := getEnv(conn.Tags)
-	if !h.authorize(w, r, "connection:write", "connection/"+conn.ID.String(), env) {
+	if !h.authorize(w, r, "connection:write", "connection/"+conn.ID.String(), conn.Tags) {
 		return
 	}
 	if !h.requireStepUp(w, r) {
@@ -153,7 +150,7 @@ func (h *Handler) UpdateConnection(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	_ = h.Audit.LogEvent(r.Context(), "connection_update", nil, map[string]any{"id": conn.ID.String()}, "")
+	_ = h.Audit.LogEvent(r.Context(), "connection_update", nil, map[string]any{"id": conn.ID.String(), "connectionId": conn.ID.String()}, "")
 	writeJSON(w, http.StatusOK, conn)
 }
 
@@ -169,8 +166,7 @@ func (h *Handler) DeleteConnection(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	env := getEnv(conn.Tags)
-	if !h.authorize(w, r, "connection:write", "connection/"+conn.ID.String(), env) {
+	if !h.authorize(w, r, "connection:write", "connection/"+conn.ID.String(), conn.Tags) {
 		return
 	}
 	if !h.requireStepUp(w, r) {
@@ -180,7 +176,7 @@ func (h *Handler) DeleteConnection(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to delete", http.StatusInternalServerError)
 		return
 	}
-	_ = h.Audit.LogEvent(r.Context(), "connection_delete", nil, map[string]any{"id": conn.ID.String()}, "")
+	_ = h.Audit.LogEvent(r.Context(), "connection_delete", nil, map[string]any{"id": conn.ID.String(), "connectionId": conn.ID.String()}, "")
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -196,8 +192,7 @@ func (h *Handler) TestConnection(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	env := getEnv(conn.Tags)
-	if !h.authorize(w, r, "connection:read", "connection/"+conn.ID.String(), env) {
+	if !h.authorize(w, r, "connection:read", "connection/"+conn.ID.String(), conn.Tags) {
 		return
 	}
 	adapter, err := h.Connections.GetAdapter(r.Context(), conn)
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"flowdb/backend/audit"
+	"flowdb/backend/auth"
+	"flowdb/backend/query"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+// recordDataAccess logs data returned from conn to userID.
+func (h *Handler) recordDataAccess(ctx context.Context, userID uuid.UUID, conn store.Connection, access audit.DataAccess) {
+	if h.DataAccess == nil {
+		return
+	}
+	access.ConnectionID = conn.ID
+	access.Environment = getEnv(conn.Tags)
+	access.Prod = isProd(access.Environment)
+	h.DataAccess.Record(ctx, userID, access)
+}
+
+func currentUserID(r *http.Request) uuid.UUID {
+	user, _ := auth.UserFromContext(r.Context())
+	return user.ID
+}
+
+// resultAccess describes rows or documents read from resource, masked by the
+// rules for resources. Documents have no schema, so their columns are the
+// union of their top-level fields.
+func resultAccess(operation, resource string, resources []string, columns []string, fields map[string]bool, rows int, rules []store.PIIRule) audit.DataAccess {
+	masked := query.MaskedFields(resources, columns, rules)
+	if len(fields) > 0 {
+		columns = make([]string, 0, len(fields))
+		for k := range fields {
+			columns = append(columns, k)
+		}
+		sort.Strings(columns)
+		masked = query.MaskedFields(resources, nil, rules)
+	}
+	return audit.DataAccess{
+		Operation:     operation,
+		Resource:      resource,
+		Columns:       columns,
+		RowCount:      rows,
+		MaskedColumns: masked,
+	}
+}
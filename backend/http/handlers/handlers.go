@@ -8,13 +8,16 @@ import (
 	"flowdb/backend/config"
 	"flowdb/backend/connections"
 	"flowdb/backend/crypto"
+	"flowdb/backend/discovery"
 	"flowdb/backend/iam"
+	"flowdb/backend/middleware"
 	"flowdb/backend/policies"
 	"flowdb/backend/query"
 	"flowdb/backend/settings"
 	"flowdb/backend/store"
 	"flowdb/backend/stream"
 	"flowdb/backend/update"
+	"flowdb/backend/webhooks"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
@@ -28,12 +31,16 @@ type Handler struct {
 	Connections  *connections.Service
 	Policies     *policies.Store
 	Authorizer   *iam.Authorizer
-	Audit        *audit.Logger
+	Audit        middleware.EventLogger
+	Checkpoints  *audit.Checkpointer
+	DataAccess   *audit.DataAccessLog
 	Config       *config.Config
 	Logger       *slog.Logger
 	Stream       *stream.Manager
 	JobStore     *query.JobStore
 	Update       *update.Service
+	Discovery    *discovery.Scanner
+	Webhooks     *webhooks.Dispatcher
 	OIDC         *oidc.Provider
 	OIDCConfig   *oauth2.Config
 	OIDCVerifier *oidc.IDTokenVerifier
@@ -2,31 +2,31 @@ package handlers
 
 import (
 	"bufio"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"time"
+
+	"flowdb/backend/audit"
+	"flowdb/backend/auth"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
 )
 
-func (h *Handler) ListHistory(w http.ResponseWriter, r *http.Request) {
-	if !h.authorize(w, r, "query:read", "history", "") {
+func (h *Handler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "audit:read", "audit", nil) {
 		return
 	}
-	limit := parseInt(r.URL.Query().Get("limit"), 100)
-	offset := parseInt(r.URL.Query().Get("offset"), 0)
-	list, err := h.Store.ListHistory(r.Context(), limit, offset)
+	filter, err := auditFilter(r)
 	if err != nil {
-		http.Error(w, "failed to list history", http.StatusInternalServerError)
-		return
-	}
-	writeJSON(w, http.StatusOK, list)
-}
-
-func (h *Handler) ListAudit(w http.ResponseWriter, r *http.Request) {
-	if !h.authorize(w, r, "audit:read", "audit", "") {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	limit := parseInt(r.URL.Query().Get("limit"), 100)
-	offset := parseInt(r.URL.Query().Get("offset"), 0)
-	entries, err := h.Store.ListAudit(r.Context(), limit, offset)
+	entries, err := h.Store.ListAudit(r.Context(), filter)
 	if err != nil {
 		http.Error(w, "failed to list audit", http.StatusInternalServerError)
 		return
@@ -44,3 +44,172 @@ func (h *Handler) ListAudit(w http.ResponseWriter, r *http.Request) {
 	}
 	writeJSON(w, http.StatusOK, entries)
 }
+
+// auditFilter reads eventType (comma separated or repeated), actorId,
+// sessionId, requestId, clientIp (address or CIDR), from, to (RFC 3339),
+// connectionId, errorId and q (text in details).
+func auditFilter(r *http.Request) (store.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := store.AuditFilter{
+		ConnectionID: q.Get("connectionId"),
+		ErrorID:      q.Get("errorId"),
+		RequestID:    q.Get("requestId"),
+		Text:         q.Get("q"),
+		Limit:        parseInt(q.Get("limit"), 100),
+		Offset:       parseInt(q.Get("offset"), 0),
+	}
+	for _, value := range q["eventType"] {
+		for _, eventType := range strings.Split(value, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				filter.EventTypes = append(filter.EventTypes, eventType)
+			}
+		}
+	}
+	if raw := q.Get("actorId"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return filter, errors.New("invalid actorId")
+		}
+		filter.ActorID = &id
+	}
+	if raw := q.Get("sessionId"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return filter, errors.New("invalid sessionId")
+		}
+		filter.SessionID = &id
+	}
+	if raw := q.Get("clientIp"); raw != "" {
+		if ip := net.ParseIP(raw); ip != nil {
+			filter.ClientIP = ip.String()
+		} else if _, _, err := net.ParseCIDR(raw); err == nil {
+			filter.ClientIP = raw
+		} else {
+			return filter, errors.New("invalid clientIp")
+		}
+	}
+	var err error
+	if filter.From, err = timeParam(q.Get("from")); err != nil {
+		return filter, errors.New("invalid from")
+	}
+	if filter.To, err = timeParam(q.Get("to")); err != nil {
+		return filter, errors.New("invalid to")
+	}
+	return filter, nil
+}
+
+func timeParam(raw string) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// VerifyAudit walks the signed audit chain and reports the first entry that
+// does not link to the one before it. When checkpoint signing is configured
+// the stored checkpoints must match the chain too.
+func (h *Handler) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "audit:read", "audit", nil) {
+		return
+	}
+	var pub ed25519.PublicKey
+	if h.Checkpoints != nil {
+		pub = h.Checkpoints.PublicKey()
+	}
+	result, err := audit.VerifyStore(r.Context(), h.Store, pub)
+	if err != nil {
+		http.Error(w, "failed to verify audit", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "audit_verify", &user.ID, map[string]any{
+		"ok":          result.OK,
+		"signed":      result.Signed,
+		"lastSeq":     result.LastSeq,
+		"anchoredSeq": result.AnchoredSeq,
+	}, "")
+	writeJSON(w, http.StatusOK, result)
+}
+
+func auditCheckpointView(cp store.AuditCheckpoint) map[string]any {
+	return map[string]any{
+		"seq":       cp.Seq,
+		"count":     cp.SignedCount,
+		"headHash":  cp.HeadHash,
+		"keyId":     cp.KeyID,
+		"statement": json.RawMessage(cp.Statement),
+		"signature": cp.Signature,
+		"createdAt": cp.CreatedAt,
+	}
+}
+
+func (h *Handler) ListAuditCheckpoints(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "audit:read", "audit", nil) {
+		return
+	}
+	list, err := h.Store.ListAuditCheckpoints(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list checkpoints", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(list))
+	for _, cp := range list {
+		out = append(out, auditCheckpointView(cp))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// CreateAuditCheckpoint signs the chain head now instead of waiting for the
+// next interval.
+func (h *Handler) CreateAuditCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "settings:write", "audit", nil) {
+		return
+	}
+	if h.Checkpoints == nil {
+		http.Error(w, "audit checkpoints are not configured", http.StatusNotFound)
+		return
+	}
+	cp, err := h.Checkpoints.Checkpoint(r.Context())
+	if err != nil {
+		h.Logger.Error("audit checkpoint failed", "error", err)
+		http.Error(w, "failed to create checkpoint", http.StatusConflict)
+		return
+	}
+	if cp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusCreated, auditCheckpointView(*cp))
+}
+
+func (h *Handler) ListAuditArchives(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "audit:read", "audit", nil) {
+		return
+	}
+	list, err := h.Store.ListAuditArchives(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list archives", http.StatusInternalServerError)
+		return
+	}
+	out := make([]map[string]any, 0, len(list))
+	for _, a := range list {
+		out = append(out, map[string]any{
+			"id":        a.ID,
+			"firstSeq":  a.FirstSeq,
+			"lastSeq":   a.LastSeq,
+			"entries":   a.Entries,
+			"file":      a.FileName,
+			"sha256":    a.SHA256,
+			"headHash":  a.HeadHash,
+			"keyId":     a.KeyID,
+			"manifest":  json.RawMessage(a.Manifest),
+			"signature": a.Signature,
+			"createdAt": a.CreatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
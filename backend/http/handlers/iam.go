@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/policies"
+
+	"github.com/google/uuid"
+)
+
+type simulateRequest struct {
+	UserID         string         `json:"userId"`
+	Username       string         `json:"username"`
+	Action         string         `json:"action"`
+	Resource       string         `json:"resource"`
+	Environment    string         `json:"environment"`
+	ConnectionTags map[string]any `json:"connectionTags"`
+	SourceIP       string         `json:"sourceIp"`
+	Time           *time.Time     `json:"time"`
+	MFAAt          *time.Time     `json:"mfaAt"`
+}
+
+// Simulate explains the authorization decision for a user, action and
+// resource. Users may always simulate their own access but only see the
+// decision; the full explanation and simulating someone else require
+// iam:read.
+func (h *Handler) Simulate(w http.ResponseWriter, r *http.Request) {
+	caller, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req simulateRequest
+	if err := decodeJSON(r, &req); err != nil || req.Action == "" || req.Resource == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	target := caller
+	if req.UserID != "" || req.Username != "" {
+		var err error
+		if req.UserID != "" {
+			id, perr := uuid.Parse(req.UserID)
+			if perr != nil {
+				http.Error(w, "invalid user id", http.StatusBadRequest)
+				return
+			}
+			target, err = h.Store.GetUserByID(r.Context(), id)
+		} else {
+			target, err = h.Store.GetUserByUsername(r.Context(), req.Username)
+		}
+		if err != nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+	}
+	self := target.ID == caller.ID
+	if !self && !h.authorize(w, r, "iam:read", "iam", nil) {
+		return
+	}
+	tags := req.ConnectionTags
+	if tags == nil {
+		tags = h.resourceTags(r, req.Resource)
+	}
+	preq := policies.Request{
+		Action:         req.Action,
+		Resource:       req.Resource,
+		Environment:    getEnv(tags),
+		ConnectionTags: tags,
+		SourceIP:       req.SourceIP,
+		Time:           time.Now().UTC(),
+		MFAAt:          req.MFAAt,
+	}
+	if req.Environment != "" {
+		preq.Environment = req.Environment
+	}
+	if self {
+		current := h.policyRequest(r, req.Action, req.Resource, tags)
+		if preq.SourceIP == "" {
+			preq.SourceIP = current.SourceIP
+		}
+		if preq.MFAAt == nil {
+			preq.MFAAt = current.MFAAt
+		}
+	}
+	if req.Time != nil {
+		preq.Time = req.Time.UTC()
+	}
+	explanation, err := h.Authorizer.Explain(r.Context(), target, preq)
+	if err != nil {
+		http.Error(w, "authorization error", http.StatusInternalServerError)
+		return
+	}
+	decision := explanation.Decision
+	resp := map[string]any{
+		"user":    map[string]any{"id": target.ID.String(), "username": target.Username, "isAdmin": target.IsAdmin},
+		"allowed": decision.Allowed,
+		"reason":  decision.Reason,
+	}
+	// Without iam:read, self-simulation only reveals the decision; bindings
+	// and the rule trace expose policy contents.
+	if !self || h.allowed(r, "iam:read", "iam", nil) {
+		resp["constraints"] = decision.Constraints
+		resp["bindings"] = explanation.Bindings
+		resp["request"] = map[string]any{
+			"action":      preq.Action,
+			"resource":    preq.Resource,
+			"environment": preq.Environment,
+			"sourceIp":    preq.SourceIP,
+			"time":        explanation.Request.Time,
+			"mfaAt":       preq.MFAAt,
+			"groups":      explanation.Request.Groups,
+		}
+		resp["rules"] = []policies.RuleTrace{}
+		if decision.Trace != nil {
+			resp["rules"] = decision.Trace.Rules
+		}
+	}
+	_ = h.Audit.LogEvent(r.Context(), "iam_simulate", &caller.ID, map[string]any{
+		"userId":   target.ID.String(),
+		"action":   preq.Action,
+		"resource": preq.Resource,
+		"allowed":  decision.Allowed,
+	}, "")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// resourceTags returns the tags of the connection a resource belongs to, if
+// any.
+func (h *Handler) resourceTags(r *http.Request, resource string) map[string]any {
+	rest, ok := strings.CutPrefix(resource, "connection/")
+	if !ok {
+		return nil
+	}
+	idPart, _, _ := strings.Cut(rest, "/")
+	id, err := uuid.Parse(idPart)
+	if err != nil {
+		return nil
+	}
+	conn, err := h.Store.GetConnection(r.Context(), id)
+	if err != nil {
+		return nil
+	}
+	return conn.Tags
+}
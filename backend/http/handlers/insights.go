@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+// maxInsightBuckets bounds a timeline; wider ranges get wider buckets.
+const maxInsightBuckets = 1000
+
+// ListFingerprintInsights aggregates runs by statement fingerprint.
+func (h *Handler) ListFingerprintInsights(w http.ResponseWriter, r *http.Request) {
+	h.queryInsights(w, r, store.InsightByFingerprint)
+}
+
+// ListUserInsights aggregates runs by user.
+func (h *Handler) ListUserInsights(w http.ResponseWriter, r *http.Request) {
+	h.queryInsights(w, r, store.InsightByUser)
+}
+
+// ListConnectionInsights aggregates runs by connection.
+func (h *Handler) ListConnectionInsights(w http.ResponseWriter, r *http.Request) {
+	h.queryInsights(w, r, store.InsightByConnection)
+}
+
+// ListTimelineInsights aggregates runs into time buckets of width bucket
+// (default 1h).
+func (h *Handler) ListTimelineInsights(w http.ResponseWriter, r *http.Request) {
+	h.queryInsights(w, r, store.InsightByTime)
+}
+
+// queryInsights serves the /insights endpoints. Without history:read the
+// caller only sees their own runs, like ListHistory.
+func (h *Handler) queryInsights(w http.ResponseWriter, r *http.Request, groupBy string) {
+	if !h.authorize(w, r, "query:read", "history", nil) {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	filter, err := insightFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.allowed(r, "history:read", "history", nil) {
+		if filter.UserID != nil && *filter.UserID != user.ID {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		filter.UserID = &user.ID
+	}
+	if groupBy == store.InsightByTime {
+		filter.Limit = maxInsightBuckets + 1
+	}
+	list, err := h.Store.QueryInsights(r.Context(), groupBy, filter)
+	if err != nil {
+		http.Error(w, "failed to load insights", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(list))
+	for _, q := range list {
+		view := map[string]any{
+			"count":       q.Count,
+			"failed":      q.Failed,
+			"failureRate": float64(q.Failed) / float64(q.Count),
+			"p50Ms":       q.P50Ms,
+			"p95Ms":       q.P95Ms,
+			"rows":        q.Rows,
+			"avgRows":     float64(q.Rows) / float64(q.Count),
+			"lastRunAt":   q.LastRun,
+		}
+		switch groupBy {
+		case store.InsightByFingerprint:
+			view["fingerprint"] = q.Key
+			view["fingerprintText"] = q.FingerprintText
+		case store.InsightByUser:
+			view["userId"] = q.Key
+		case store.InsightByConnection:
+			view["connectionId"] = q.Key
+		case store.InsightByTime:
+			view["bucket"] = q.Key
+		}
+		views = append(views, view)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"from":  filter.From,
+		"to":    filter.To,
+		"items": views,
+	})
+}
+
+// insightFilter reads from and to (RFC 3339) or window (duration ending now,
+// default 24h), userId, connectionId, fingerprint, sort, bucket and limit
+// (default 50, at most 500).
+func insightFilter(r *http.Request) (store.InsightFilter, error) {
+	q := r.URL.Query()
+	filter := store.InsightFilter{
+		Fingerprint: q.Get("fingerprint"),
+		Sort:        q.Get("sort"),
+		Limit:       parseInt(q.Get("limit"), 50),
+		Bucket:      time.Hour,
+		To:          time.Now().UTC(),
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 500
+	}
+	switch filter.Sort {
+	case "", "count", "p95", "failures", "rows":
+	default:
+		return filter, errors.New("invalid sort")
+	}
+	if raw := q.Get("bucket"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < time.Minute {
+			return filter, errors.New("invalid bucket")
+		}
+		filter.Bucket = d
+	}
+	if raw := q.Get("userId"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return filter, errors.New("invalid userId")
+		}
+		filter.UserID = &id
+	}
+	if raw := q.Get("connectionId"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return filter, errors.New("invalid connectionId")
+		}
+		filter.ConnectionID = &id
+	}
+	to, err := timeParam(q.Get("to"))
+	if err != nil {
+		return filter, errors.New("invalid to")
+	}
+	if to != nil {
+		filter.To = *to
+	}
+	from, err := timeParam(q.Get("from"))
+	if err != nil {
+		return filter, errors.New("invalid from")
+	}
+	if from != nil {
+		filter.From = *from
+	} else {
+		window := 24 * time.Hour
+		if raw := q.Get("window"); raw != "" {
+			if window, err = time.ParseDuration(raw); err != nil || window <= 0 {
+				return filter, errors.New("invalid window")
+			}
+		}
+		filter.From = filter.To.Add(-window)
+	}
+	if !filter.From.Before(filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+	if span := filter.To.Sub(filter.From); span/filter.Bucket >= maxInsightBuckets {
+		filter.Bucket = span/maxInsightBuckets + time.Second
+	}
+	return filter, nil
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/notify"
+)
+
+type notificationPrefsRequest struct {
+	OptOut []string `json:"optOut"`
+}
+
+// GetNotificationPrefs returns the caller's email opt-outs and the events
+// that can be emailed.
+func (h *Handler) GetNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	optOut, err := h.Store.GetNotificationOptOut(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "failed to load preferences", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, notificationPrefsView(optOut))
+}
+
+// UpdateNotificationPrefs replaces the caller's opt-outs. "*" opts out of
+// every email.
+func (h *Handler) UpdateNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req notificationPrefsRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	events := notify.Events()
+	optOut := make([]string, 0, len(req.OptOut))
+	for _, event := range req.OptOut {
+		if event != "*" && !slices.Contains(events, event) {
+			http.Error(w, "unknown event "+event, http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(optOut, event) {
+			optOut = append(optOut, event)
+		}
+	}
+	if err := h.Store.SetNotificationOptOut(r.Context(), user.ID, optOut); err != nil {
+		http.Error(w, "failed to update preferences", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "notification_prefs_update", &user.ID, map[string]any{"optOut": optOut}, "")
+	writeJSON(w, http.StatusOK, notificationPrefsView(optOut))
+}
+
+func notificationPrefsView(optOut []string) map[string]any {
+	if optOut == nil {
+		optOut = []string{}
+	}
+	return map[string]any{
+		"optOut": optOut,
+		"events": notify.Events(),
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/policies"
+	"flowdb/backend/query"
+	"flowdb/backend/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type piiRuleRequest struct {
+	Resource             string   `json:"resource"`
+	Field                string   `json:"field"`
+	MaskType             string   `json:"maskType"`
+	ExemptRoles          []string `json:"exemptRoles"`
+	ExemptGroups         []string `json:"exemptGroups"`
+	RequireJustification bool     `json:"requireJustification"`
+}
+
+func (h *Handler) ListPIIRules(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.parseConnection(r)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !h.authorize(w, r, "pii:read", "connection/"+conn.ID.String()+"/pii", conn.Tags) {
+		return
+	}
+	rules, err := h.Store.ListPIIRules(r.Context(), conn.ID)
+	if err != nil {
+		http.Error(w, "failed to list rules", http.StatusInternalServerError)
+		return
+	}
+	if rules == nil {
+		rules = []store.PIIRule{}
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func (h *Handler) CreatePIIRule(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.parseConnection(r)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !h.authorize(w, r, "pii:write", "connection/"+conn.ID.String()+"/pii", conn.Tags) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	var req piiRuleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	rule, ok := req.toRule(conn)
+	if !ok {
+		http.Error(w, "invalid rule", http.StatusBadRequest)
+		return
+	}
+	rule, err = h.Store.CreatePIIRule(r.Context(), rule)
+	if err != nil {
+		http.Error(w, "failed to create rule", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "pii_rule_create", &user.ID, piiRuleDetails(rule), "")
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func (h *Handler) UpdatePIIRule(w http.ResponseWriter, r *http.Request) {
+	conn, rule, ok := h.loadPIIRule(w, r)
+	if !ok {
+		return
+	}
+	if !h.authorize(w, r, "pii:write", "connection/"+conn.ID.String()+"/pii", conn.Tags) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	var req piiRuleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	updated, ok := req.toRule(conn)
+	if !ok {
+		http.Error(w, "invalid rule", http.StatusBadRequest)
+		return
+	}
+	updated.ID = rule.ID
+	updated.CreatedAt = rule.CreatedAt
+	if err := h.Store.UpdatePIIRule(r.Context(), updated); err != nil {
+		http.Error(w, "failed to update rule", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	details := piiRuleDetails(updated)
+	details["previous"] = piiRuleDetails(rule)
+	_ = h.Audit.LogEvent(r.Context(), "pii_rule_update", &user.ID, details, "")
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (h *Handler) DeletePIIRule(w http.ResponseWriter, r *http.Request) {
+	conn, rule, ok := h.loadPIIRule(w, r)
+	if !ok {
+		return
+	}
+	if !h.authorize(w, r, "pii:write", "connection/"+conn.ID.String()+"/pii", conn.Tags) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	if err := h.Store.DeletePIIRule(r.Context(), rule.ID); err != nil {
+		http.Error(w, "failed to delete rule", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "pii_rule_delete", &user.ID, piiRuleDetails(rule), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) loadPIIRule(w http.ResponseWriter, r *http.Request) (store.Connection, store.PIIRule, bool) {
+	conn, err := h.parseConnection(r)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return store.Connection{}, store.PIIRule{}, false
+	}
+	ruleID, err := uuid.Parse(chi.URLParam(r, "ruleId"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return store.Connection{}, store.PIIRule{}, false
+	}
+	rule, err := h.Store.GetPIIRule(r.Context(), ruleID)
+	if err != nil || rule.ConnectionID != conn.ID {
+		http.Error(w, "not found", http.StatusNotFound)
+		return store.Connection{}, store.PIIRule{}, false
+	}
+	return conn, rule, true
+}
+
+// maskingRules returns the PII rules that must be enforced for the current
+// user on the given resources, and the justification-gated rules the user
+// sees unmasked, for auditUnmask.
+func (h *Handler) maskingRules(r *http.Request, conn store.Connection, resources []string, justification string) ([]store.PIIRule, []store.PIIRule) {
+	if !h.Settings.Get().FlagEnabled("enable_pii_masking") {
+		return nil, nil
+	}
+	rules, err := h.Store.ListPIIRules(r.Context(), conn.ID)
+	if err != nil || len(rules) == 0 {
+		return rules, nil
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	viewer := query.Viewer{Justification: justification}
+	bindings, _ := h.Store.ListUserRoleBindings(r.Context(), user.ID)
+	for _, b := range bindings {
+		for _, resource := range append([]string{"connection/" + conn.ID.String()}, resources...) {
+			if policies.MatchScope(b.Binding.Resource, resource) {
+				viewer.Roles = append(viewer.Roles, b.Role.Name)
+				break
+			}
+		}
+	}
+	groups, _ := h.Store.ListUserGroups(r.Context(), user.ID)
+	for _, group := range groups {
+		viewer.Groups = append(viewer.Groups, group.Name)
+	}
+	enforced, unmasked := query.ApplicableRules(rules, viewer)
+	var revealed []store.PIIRule
+	for _, rule := range unmasked {
+		if rule.RequireJustification {
+			revealed = append(revealed, rule)
+		}
+	}
+	return enforced, revealed
+}
+
+// auditUnmask records pii_unmask for the justification-gated fields that
+// were actually returned unmasked: rows came back and the field was among
+// the columns, or among the top-level fields of the documents.
+func (h *Handler) auditUnmask(ctx context.Context, userID uuid.UUID, conn store.Connection, resources []string, justification string, revealed []store.PIIRule, columns []string, fields map[string]bool, rows int) {
+	if len(revealed) == 0 || rows == 0 {
+		return
+	}
+	for _, resource := range resources {
+		var shown []string
+		if len(fields) > 0 {
+			for _, field := range query.MaskedFields([]string{resource}, nil, revealed) {
+				if fields[query.ParseFieldPath(field)[0]] {
+					shown = append(shown, field)
+				}
+			}
+		} else {
+			shown = query.MaskedFields([]string{resource}, columns, revealed)
+		}
+		if len(shown) == 0 {
+			continue
+		}
+		_ = h.Audit.LogEvent(ctx, "pii_unmask", &userID, map[string]any{
+			"connectionId":  conn.ID.String(),
+			"resource":      resource,
+			"fields":        shown,
+			"justification": justification,
+		}, "")
+	}
+}
+
+func (req piiRuleRequest) toRule(conn store.Connection) (store.PIIRule, bool) {
+	field := strings.TrimSpace(req.Field)
+	if field == "" {
+		return store.PIIRule{}, false
+	}
+	resource := strings.TrimSpace(req.Resource)
+	if resource == "" {
+		resource = "connection/" + conn.ID.String() + "/*"
+	}
+	maskType := strings.ToLower(strings.TrimSpace(req.MaskType))
+	if maskType == "" {
+		maskType = "mask"
+	}
+	if !query.ValidMaskType(maskType) {
+		return store.PIIRule{}, false
+	}
+	return store.PIIRule{
+		ConnectionID:         conn.ID,
+		Resource:             resource,
+		Field:                field,
+		MaskType:             maskType,
+		ExemptRoles:          req.ExemptRoles,
+		ExemptGroups:         req.ExemptGroups,
+		RequireJustification: req.RequireJustification,
+	}, true
+}
+
+func piiRuleDetails(rule store.PIIRule) map[string]any {
+	return map[string]any{
+		"id":                   rule.ID.String(),
+		"connectionId":         rule.ConnectionID.String(),
+		"resource":             rule.Resource,
+		"field":                rule.Field,
+		"maskType":             rule.MaskType,
+		"exemptRoles":          rule.ExemptRoles,
+		"exemptGroups":         rule.ExemptGroups,
+		"requireJustification": rule.RequireJustification,
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/discovery"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+type proposalDecisionRequest struct {
+	IDs      []string `json:"ids"`
+	MaskType string   `json:"maskType"`
+}
+
+func (h *Handler) StartPIIScan(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.parseConnection(r)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !h.authorize(w, r, "pii:write", "connection/"+conn.ID.String()+"/pii", conn.Tags) {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	scan, err := h.Discovery.StartScan(r.Context(), conn, &user.ID)
+	if errors.Is(err, discovery.ErrScanRunning) {
+		http.Error(w, "scan already running", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to start scan", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "pii_scan_start", &user.ID, map[string]any{"connectionId": conn.ID.String(), "scanId": scan.ID.String()}, "")
+	writeJSON(w, http.StatusAccepted, scan)
+}
+
+func (h *Handler) ListPIIScans(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.parseConnection(r)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !h.authorize(w, r, "pii:read", "connection/"+conn.ID.String()+"/pii", conn.Tags) {
+		return
+	}
+	scans, err := h.Store.ListPIIScans(r.Context(), conn.ID, parseInt(r.URL.Query().Get("limit"), 20))
+	if err != nil {
+		http.Error(w, "failed to list scans", http.StatusInternalServerError)
+		return
+	}
+	if scans == nil {
+		scans = []store.PIIScan{}
+	}
+	writeJSON(w, http.StatusOK, scans)
+}
+
+func (h *Handler) ListPIIProposals(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.parseConnection(r)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !h.authorize(w, r, "pii:read", "connection/"+conn.ID.String()+"/pii", conn.Tags) {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+	if status == "all" {
+		status = ""
+	}
+	proposals, err := h.Store.ListPIIProposals(r.Context(), conn.ID, status)
+	if err != nil {
+		http.Error(w, "failed to list proposals", http.StatusInternalServerError)
+		return
+	}
+	if proposals == nil {
+		proposals = []store.PIIProposal{}
+	}
+	writeJSON(w, http.StatusOK, proposals)
+}
+
+func (h *Handler) AcceptPIIProposals(w http.ResponseWriter, r *http.Request) {
+	h.decidePIIProposals(w, r, "accepted")
+}
+
+func (h *Handler) RejectPIIProposals(w http.ResponseWriter, r *http.Request) {
+	h.decidePIIProposals(w, r, "rejected")
+}
+
+func (h *Handler) decidePIIProposals(w http.ResponseWriter, r *http.Request, status string) {
+	conn, err := h.parseConnection(r)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !h.authorize(w, r, "pii:write", "connection/"+conn.ID.String()+"/pii", conn.Tags) {
+		return
+	}
+	var req proposalDecisionRequest
+	if err := decodeJSON(r, &req); err != nil || len(req.IDs) == 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	wanted := map[uuid.UUID]bool{}
+	for _, raw := range req.IDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		wanted[id] = true
+	}
+	pending, err := h.Store.ListPIIProposals(r.Context(), conn.ID, "pending")
+	if err != nil {
+		http.Error(w, "failed to load proposals", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	var ids []uuid.UUID
+	var rules []store.PIIRule
+	decided := []string{}
+	for _, p := range pending {
+		if !wanted[p.ID] {
+			continue
+		}
+		if status == "accepted" {
+			rule, ok := piiRuleRequest{Resource: p.Resource, Field: p.Field, MaskType: firstNonEmpty(req.MaskType, p.MaskType)}.toRule(conn)
+			if !ok {
+				http.Error(w, "invalid mask type", http.StatusBadRequest)
+				return
+			}
+			rules = append(rules, rule)
+		}
+		ids = append(ids, p.ID)
+		decided = append(decided, p.ID.String())
+	}
+	err = h.Store.DecidePIIProposals(r.Context(), ids, status, user.ID, rules)
+	if errors.Is(err, store.ErrConflict) {
+		http.Error(w, "proposal already decided", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to update proposals", http.StatusInternalServerError)
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "pii_proposals_"+status, &user.ID, map[string]any{"connectionId": conn.ID.String(), "proposalIds": decided}, "")
+	writeJSON(w, http.StatusOK, map[string]any{"status": status, "ids": decided})
+}
+
+func (h *Handler) PIICoverage(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.parseConnection(r)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !h.authorize(w, r, "pii:read", "connection/"+conn.ID.String()+"/pii", conn.Tags) {
+		return
+	}
+	proposals, err := h.Store.ListPIIProposals(r.Context(), conn.ID, "")
+	if err != nil {
+		http.Error(w, "failed to load proposals", http.StatusInternalServerError)
+		return
+	}
+	rules, err := h.Store.ListPIIRules(r.Context(), conn.ID)
+	if err != nil {
+		http.Error(w, "failed to load rules", http.StatusInternalServerError)
+		return
+	}
+	report := discovery.BuildCoverage(conn.ID, proposals, rules)
+	if scans, err := h.Store.ListPIIScans(r.Context(), conn.ID, 1); err == nil && len(scans) > 0 {
+		report.LastScan = &scans[0]
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
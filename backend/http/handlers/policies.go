@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/policies"
+	"flowdb/backend/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type policyRequest struct {
+	Name    string          `json:"name"`
+	Doc     json.RawMessage `json:"doc"`
+	Version int             `json:"version"`
+}
+
+type rollbackRequest struct {
+	Version int `json:"version"`
+}
+
+func (h *Handler) ListPolicies(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:read", "iam/policies", nil) {
+		return
+	}
+	list, err := h.Store.ListPolicies(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list policies", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(list))
+	for _, p := range list {
+		views = append(views, policyView(p))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"policies": views,
+		"status":   h.Policies.Status(),
+	})
+}
+
+func (h *Handler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:read", "iam/policies", nil) {
+		return
+	}
+	p, ok := h.loadPolicy(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, policyView(p))
+}
+
+// LintPolicy validates a document without saving it.
+func (h *Handler) LintPolicy(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:read", "iam/policies", nil) {
+		return
+	}
+	var req policyRequest
+	if err := decodeJSON(r, &req); err != nil || len(req.Doc) == 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	issues := policies.Lint(req.Doc)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"valid":  !policies.HasErrors(issues),
+		"issues": issues,
+	})
+}
+
+func (h *Handler) CreatePolicy(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "iam/policies", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	var req policyRequest
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Name) == "" || len(req.Doc) == 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	doc, ok := validPolicyDoc(w, req.Doc)
+	if !ok {
+		return
+	}
+	p := store.Policy{Name: strings.TrimSpace(req.Name), Doc: doc}
+	h.savePolicy(w, r, p, nil, "create", http.StatusCreated)
+}
+
+func (h *Handler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "iam/policies", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	current, ok := h.loadMutablePolicy(w, r)
+	if !ok {
+		return
+	}
+	var req policyRequest
+	if err := decodeJSON(r, &req); err != nil || len(req.Doc) == 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Version != 0 && req.Version != current.Version {
+		http.Error(w, "policy was modified", http.StatusConflict)
+		return
+	}
+	doc, ok := validPolicyDoc(w, req.Doc)
+	if !ok {
+		return
+	}
+	p := current
+	p.Doc = doc
+	if name := strings.TrimSpace(req.Name); name != "" {
+		p.Name = name
+	}
+	h.savePolicy(w, r, p, current.Doc, "update", http.StatusOK)
+}
+
+func (h *Handler) DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "iam/policies", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	p, ok := h.loadMutablePolicy(w, r)
+	if !ok {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	changes := policies.Diff(p.Doc, nil)
+	diff, _ := json.Marshal(changes)
+	if err := h.Store.DeletePolicy(r.Context(), p, diff, &user.ID); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "policy was modified", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to delete policy", http.StatusInternalServerError)
+		return
+	}
+	h.refreshPolicies(r)
+	_ = h.Audit.LogEvent(r.Context(), "policy_delete", &user.ID, map[string]any{
+		"policyId": p.ID.String(),
+		"name":     p.Name,
+		"version":  p.Version + 1,
+	}, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListPolicyVersions(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:read", "iam/policies", nil) {
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid policy id", http.StatusBadRequest)
+		return
+	}
+	versions, err := h.Store.ListPolicyVersions(r.Context(), id)
+	if err != nil {
+		http.Error(w, "failed to list versions", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(versions))
+	for _, v := range versions {
+		views = append(views, policyVersionView(v))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// RollbackPolicy restores the document of an earlier version as a new
+// version.
+func (h *Handler) RollbackPolicy(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "iam/policies", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	current, ok := h.loadMutablePolicy(w, r)
+	if !ok {
+		return
+	}
+	var req rollbackRequest
+	if err := decodeJSON(r, &req); err != nil || req.Version <= 0 {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	target, err := h.Store.GetPolicyVersion(r.Context(), current.ID, req.Version)
+	if err != nil || len(target.Doc) == 0 {
+		http.Error(w, "version not found", http.StatusNotFound)
+		return
+	}
+	doc, ok := validPolicyDoc(w, target.Doc)
+	if !ok {
+		return
+	}
+	p := current
+	p.Doc = doc
+	p.Name = target.PolicyName
+	h.savePolicy(w, r, p, current.Doc, "rollback", http.StatusOK)
+}
+
+func (h *Handler) savePolicy(w http.ResponseWriter, r *http.Request, p store.Policy, previous []byte, change string, status int) {
+	user, _ := auth.UserFromContext(r.Context())
+	changes := policies.Diff(previous, p.Doc)
+	diff, _ := json.Marshal(changes)
+	saved, err := h.Store.SavePolicy(r.Context(), p, change, diff, &user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "policy conflict", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to save policy", http.StatusInternalServerError)
+		return
+	}
+	h.refreshPolicies(r)
+	_ = h.Audit.LogEvent(r.Context(), "policy_"+change, &user.ID, map[string]any{
+		"policyId": saved.ID.String(),
+		"name":     saved.Name,
+		"version":  saved.Version,
+		"diff":     changes,
+	}, "")
+	writeJSON(w, status, policyView(saved))
+}
+
+// refreshPolicies reloads the engine right away so changes apply without
+// waiting for the refresh interval. Failures are reported via Status.
+func (h *Handler) refreshPolicies(r *http.Request) {
+	if err := h.Policies.Refresh(r.Context()); err != nil && h.Logger != nil {
+		h.Logger.Error("policy refresh failed", "error", err)
+	}
+}
+
+func (h *Handler) loadPolicy(w http.ResponseWriter, r *http.Request) (store.Policy, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid policy id", http.StatusBadRequest)
+		return store.Policy{}, false
+	}
+	p, err := h.Store.GetPolicy(r.Context(), id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return store.Policy{}, false
+	}
+	return p, true
+}
+
+// loadMutablePolicy is loadPolicy for changes; policies managed by an
+// external source such as POLICY_DIR are read-only.
+func (h *Handler) loadMutablePolicy(w http.ResponseWriter, r *http.Request) (store.Policy, bool) {
+	p, ok := h.loadPolicy(w, r)
+	if !ok {
+		return store.Policy{}, false
+	}
+	if p.ManagedBy != "" {
+		http.Error(w, "policy is managed by "+p.ManagedBy, http.StatusConflict)
+		return store.Policy{}, false
+	}
+	return p, true
+}
+
+// validPolicyDoc lints doc and writes the issues when it has errors. The
+// returned document is compacted for storage.
+func validPolicyDoc(w http.ResponseWriter, doc []byte) ([]byte, bool) {
+	issues := policies.Lint(doc)
+	if policies.HasErrors(issues) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error":  "invalid policy",
+			"issues": issues,
+		})
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, doc); err != nil {
+		http.Error(w, "invalid policy", http.StatusBadRequest)
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+func policyView(p store.Policy) map[string]any {
+	return map[string]any{
+		"id":        p.ID.String(),
+		"name":      p.Name,
+		"doc":       json.RawMessage(p.Doc),
+		"version":   p.Version,
+		"managedBy": p.ManagedBy,
+		"readOnly":  p.ManagedBy != "",
+		"createdAt": p.CreatedAt,
+		"updatedAt": p.UpdatedAt,
+	}
+}
+
+func policyVersionView(v store.PolicyVersion) map[string]any {
+	view := map[string]any{
+		"id":        v.ID.String(),
+		"policyId":  v.PolicyID.String(),
+		"name":      v.PolicyName,
+		"version":   v.Version,
+		"change":    v.Change,
+		"diff":      json.RawMessage(v.Diff),
+		"createdAt": v.CreatedAt,
+	}
+	if len(v.Doc) > 0 {
+		view["doc"] = json.RawMessage(v.Doc)
+	}
+	if v.AuthorID != nil {
+		view["authorId"] = v.AuthorID.String()
+	}
+	return view
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"strings"
 	"time"
 
 	"flowdb/backend/adapters"
+	"flowdb/backend/audit"
 	"flowdb/backend/auth"
 	"flowdb/backend/query"
 	"flowdb/backend/store"
@@ -17,10 +20,16 @@ import (
 )
 
 type queryRequest struct {
-	Statement  string `json:"statement"`
-	ApprovalID string `json:"approvalId"`
-	MaxRows    int    `json:"maxRows"`
-	TimeoutMs  int    `json:"timeoutMs"`
+	Statement     string `json:"statement"`
+	ApprovalID    string `json:"approvalId"`
+	MaxRows       int    `json:"maxRows"`
+	TimeoutMs     int    `json:"timeoutMs"`
+	Justification string `json:"justification"`
+	// Reason, TicketURL and Impact give approvers context when the statement
+	// needs approval.
+	Reason    string `json:"reason"`
+	TicketURL string `json:"ticketUrl"`
+	Impact    string `json:"impact"`
 }
 
 type queryResponse struct {
@@ -40,6 +49,12 @@ func (h *Handler) StartQuery(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
+	h.startQuery(w, r, conn, req)
+}
+
+// startQuery authorizes req on conn and queues it for streaming, or files it
+// for approval. It reports false when it wrote an error instead.
+func (h *Handler) startQuery(w http.ResponseWriter, r *http.Request, conn store.Connection, req queryRequest) bool {
 	env := getEnv(conn.Tags)
 	action := "query:read"
 	isWrite := false
@@ -51,71 +66,95 @@ func (h *Handler) StartQuery(w http.ResponseWriter, r *http.Request) {
 	if isWrite {
 		action = "query:write"
 	}
-	resource := "connection/" + conn.ID.String() + "/db/*"
-	constraints, ok := h.authorizeWithConstraints(w, r, action, resource, env)
+	entities, columns := statementResources(conn, req.Statement)
+	resource := strings.Join(entities, ",")
+	constraints, ok := h.authorizeResources(w, r, action, append(entities, columns...), conn.Tags)
 	if !ok {
-		return
+		return false
 	}
 	if constraints.ReadOnly && isWrite {
 		http.Error(w, "read only", http.StatusForbidden)
-		return
+		return false
 	}
 	if constraints.RequireWhere && isWrite && conn.Type == "postgres" && !query.HasWhere(req.Statement) {
 		http.Error(w, "where required", http.StatusForbidden)
-		return
+		return false
 	}
 	user, _ := auth.UserFromContext(r.Context())
 	if user.ID == uuid.Nil {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
+		return false
 	}
 	if !user.IsAdmin && conn.Type == "postgres" && query.IsDangerous(req.Statement) {
 		http.Error(w, "operation not allowed", http.StatusForbidden)
-		return
+		return false
 	}
 	if isWrite && isProd(env) && !h.requireStepUp(w, r) {
-		return
+		return false
 	}
+	var job query.Job
 	if h.Settings.Get().FlagEnabled("enable_query_approval") && isWrite && isProd(env) {
 		if req.ApprovalID == "" {
+			expiresAt := time.Now().UTC().Add(h.Config.ApprovalTTL)
 			approval, err := h.Store.CreateQueryApproval(r.Context(), store.QueryApproval{
-				ConnectionID: conn.ID,
-				UserID:       user.ID,
-				Statement:    req.Statement,
-				Status:       "pending",
-				Environment:  env,
+				ConnectionID:      conn.ID,
+				UserID:            user.ID,
+				Statement:         req.Statement,
+				StatementHash:     query.StatementHash(req.Statement),
+				Status:            "pending",
+				Environment:       env,
+				Reason:            strings.TrimSpace(req.Reason),
+				TicketURL:         strings.TrimSpace(req.TicketURL),
+				Impact:            strings.TrimSpace(req.Impact),
+				ExplainPlan:       h.explainForApproval(r, conn, req.Statement),
+				RequiredApprovals: h.requiredApprovals(env),
+				ExpiresAt:         &expiresAt,
 			})
 			if err != nil {
 				http.Error(w, "failed to create approval", http.StatusInternalServerError)
-				return
+				return false
 			}
+			statementEnc, statementRedacted := h.historyStatement(conn.Type, req.Statement)
+			fingerprint, fingerprintText := h.historyFingerprint(conn.Type, req.Statement)
 			_, _ = h.Store.CreateQueryHistory(r.Context(), store.QueryHistory{
-				UserID:        user.ID,
-				ConnectionID:  conn.ID,
-				StatementHash: query.StatementHash(req.Statement),
-				Status:        "pending_approval",
-				StartedAt:     time.Now().UTC(),
-				Action:        action,
-				Resource:      resource,
-				ApprovalID:    &approval.ID,
+				UserID:            user.ID,
+				ConnectionID:      conn.ID,
+				StatementHash:     query.StatementHash(req.Statement),
+				Status:            "pending_approval",
+				StartedAt:         time.Now().UTC(),
+				Action:            action,
+				Resource:          resource,
+				ApprovalID:        &approval.ID,
+				StatementEnc:      statementEnc,
+				StatementRedacted: statementRedacted,
+				Fingerprint:       fingerprint,
+				FingerprintText:   fingerprintText,
 			})
-			_ = h.Audit.LogEvent(r.Context(), "query_approval_requested", &user.ID, map[string]any{"approvalId": approval.ID.String()}, "")
+			_ = h.Audit.LogEvent(r.Context(), "query_approval_requested", &user.ID, map[string]any{
+				"approvalId":   approval.ID.String(),
+				"userId":       user.ID.String(),
+				"connectionId": conn.ID.String(),
+				"environment":  approval.Environment,
+				"reason":       approval.Reason,
+				"ticketUrl":    approval.TicketURL,
+			}, "")
 			writeJSON(w, http.StatusAccepted, queryResponse{
 				Status:     "pending_approval",
 				ApprovalID: approval.ID.String(),
 			})
-			return
+			return true
 		}
 		approvalID, err := uuid.Parse(req.ApprovalID)
 		if err != nil {
 			http.Error(w, "invalid approval id", http.StatusBadRequest)
-			return
+			return false
 		}
 		approval, err := h.Store.GetQueryApproval(r.Context(), approvalID)
-		if err != nil || approval.Status != "approved" {
+		if err != nil || store.CheckApprovalUsable(approval, user.ID, conn.ID, query.StatementHash(req.Statement), time.Now()) != nil {
 			http.Error(w, "approval required", http.StatusForbidden)
-			return
+			return false
 		}
+		job.ApprovalID = &approvalID
 	}
 	maxRows := h.Config.GlobalMaxRows
 	if constraints.MaxRows > 0 && constraints.MaxRows < maxRows {
@@ -131,24 +170,25 @@ func (h *Handler) StartQuery(w http.ResponseWriter, r *http.Request) {
 	if req.TimeoutMs > 0 && req.TimeoutMs < timeoutMs {
 		timeoutMs = req.TimeoutMs
 	}
-	jobID := h.JobStore.Create(query.Job{
-		ConnectionID: conn.ID,
-		Statement:    req.Statement,
-		Action:       action,
-		Resource:     resource,
-		UserID:       user.ID,
-		ApprovalID:   parseApprovalID(req.ApprovalID),
-		Options: query.Options{
-			MaxRows:      maxRows,
-			TimeoutMs:    timeoutMs,
-			ReadOnly:     constraints.ReadOnly,
-			RequireWhere: constraints.RequireWhere,
-		},
-	})
+	job.ConnectionID = conn.ID
+	job.Statement = req.Statement
+	job.Action = action
+	job.Resource = resource
+	job.Resources = entities
+	job.UserID = user.ID
+	job.Justification = req.Justification
+	job.Options = query.Options{
+		MaxRows:      maxRows,
+		TimeoutMs:    timeoutMs,
+		ReadOnly:     constraints.ReadOnly,
+		RequireWhere: constraints.RequireWhere,
+	}
+	jobID := h.JobStore.Create(job)
 	writeJSON(w, http.StatusOK, queryResponse{
 		QueryID: jobID,
 		Status:  "ready",
 	})
+	return true
 }
 
 func (h *Handler) StreamQuery(w http.ResponseWriter, r *http.Request) {
@@ -208,10 +248,23 @@ func (h *Handler) StreamQuery(w http.ResponseWriter, r *http.Request) {
 		Resource:      job.Resource,
 		ApprovalID:    job.ApprovalID,
 	}
+	history.StatementEnc, history.StatementRedacted = h.historyStatement(conn.Type, job.Statement)
+	history.Fingerprint, history.FingerprintText = h.historyFingerprint(conn.Type, job.Statement)
 	history, _ = h.Store.CreateQueryHistory(r.Context(), history)
-	_ = h.Audit.LogEvent(r.Context(), "query_start", &job.UserID, map[string]any{"queryId": queryID}, "")
+	_ = h.Audit.LogEvent(r.Context(), "query_start", &job.UserID, map[string]any{"queryId": queryID, "connectionId": conn.ID.String()}, "")
+	if job.ApprovalID != nil {
+		if _, err := h.Store.ConsumeQueryApproval(r.Context(), *job.ApprovalID, job.UserID, conn.ID, query.StatementHash(job.Statement)); err != nil {
+			_ = stream.SendError(ws, "approval required", "")
+			history.Status = "failed"
+			history.EndedAt = timePtr(time.Now().UTC())
+			_ = h.Store.UpdateQueryHistory(r.Context(), history)
+			return
+		}
+		_ = h.Audit.LogEvent(r.Context(), "query_approval_consumed", &job.UserID, map[string]any{"approvalId": job.ApprovalID.String()}, "")
+	}
 	result, err := adapter.Query(r.Context(), statement, opts)
 	if err != nil {
+		h.restoreApproval(r.Context(), job)
 		_ = stream.SendError(ws, "query failed", util.NewAppError("query failed", err).ID)
 		history.Status = "failed"
 		history.EndedAt = timePtr(time.Now().UTC())
@@ -228,49 +281,43 @@ func (h *Handler) StreamQuery(w http.ResponseWriter, r *http.Request) {
 		_ = stream.SendSchema(ws, colMeta)
 	}
 	rowCount := 0
-	if h.Settings.Get().FlagEnabled("enable_pii_masking") {
-		rules, _ := h.Store.ListPIIRules(r.Context(), conn.ID)
-		colNames := make([]string, 0, len(columns))
-		for _, c := range columns {
-			colNames = append(colNames, c.Name)
+	rules, revealed := h.maskingRules(r, conn, job.Resources, job.Justification)
+	if conn.Type == "mongodb" {
+		rules = query.ProjectedRules(job.Statement, rules)
+		revealed = query.ProjectedRules(job.Statement, revealed)
+	}
+	colNames := make([]string, 0, len(columns))
+	for _, c := range columns {
+		colNames = append(colNames, c.Name)
+	}
+	for row := range result.Rows {
+		rowCount++
+		masked := row
+		for _, resource := range job.Resources {
+			masked = query.MaskRow(resource, colNames, masked, rules)
 		}
-		for row := range result.Rows {
-			rowCount++
-			masked := query.MaskRow(job.Resource, colNames, row, rules)
-			_ = stream.SendRows(ws, []any{masked})
+		_ = stream.SendRows(ws, []any{masked})
+	}
+	firstDoc := true
+	fields := map[string]bool{}
+	for doc := range result.Docs {
+		rowCount++
+		for k := range doc {
+			fields[k] = true
 		}
-		firstDoc := true
-		for doc := range result.Docs {
-			rowCount++
-			if firstDoc {
-				fields := make([]string, 0, len(doc))
-				for k := range doc {
-					fields = append(fields, k)
-				}
-				_ = stream.SendFields(ws, fields)
-				firstDoc = false
+		if firstDoc {
+			names := make([]string, 0, len(doc))
+			for k := range doc {
+				names = append(names, k)
 			}
-			masked := query.MaskDoc(job.Resource, doc, rules)
-			_ = stream.SendRows(ws, []any{masked})
-		}
-	} else {
-		for row := range result.Rows {
-			rowCount++
-			_ = stream.SendRows(ws, []any{row})
+			_ = stream.SendFields(ws, names)
+			firstDoc = false
 		}
-		firstDoc := true
-		for doc := range result.Docs {
-			rowCount++
-			if firstDoc {
-				fields := make([]string, 0, len(doc))
-				for k := range doc {
-					fields = append(fields, k)
-				}
-				_ = stream.SendFields(ws, fields)
-				firstDoc = false
-			}
-			_ = stream.SendRows(ws, []any{doc})
+		masked := doc
+		for _, resource := range job.Resources {
+			masked = query.MaskDoc(resource, masked, rules)
 		}
+		_ = stream.SendRows(ws, []any{masked})
 	}
 	duration := time.Since(start).Milliseconds()
 	_ = stream.SendEnd(ws, rowCount, duration)
@@ -279,7 +326,9 @@ func (h *Handler) StreamQuery(w http.ResponseWriter, r *http.Request) {
 	history.DurationMs = duration
 	history.EndedAt = timePtr(time.Now().UTC())
 	_ = h.Store.UpdateQueryHistory(r.Context(), history)
-	_ = h.Audit.LogEvent(r.Context(), "query_end", &job.UserID, map[string]any{"queryId": queryID, "rows": rowCount}, "")
+	_ = h.Audit.LogEvent(r.Context(), "query_end", &job.UserID, map[string]any{"queryId": queryID, "connectionId": conn.ID.String(), "rows": rowCount}, "")
+	h.recordDataAccess(r.Context(), job.UserID, conn, resultAccess(audit.AccessQuery, job.Resource, job.Resources, colNames, fields, rowCount, rules))
+	h.auditUnmask(r.Context(), job.UserID, conn, job.Resources, job.Justification, revealed, colNames, fields, rowCount)
 	select {
 	case err := <-result.Err:
 		if err != nil {
@@ -304,9 +353,8 @@ func (h *Handler) ExplainQuery(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
-	env := getEnv(conn.Tags)
-	resource := "connection/" + conn.ID.String() + "/db/*"
-	if !h.authorize(w, r, "query:read", resource, env) {
+	entities, columns := statementResources(conn, req.Statement)
+	if _, ok := h.authorizeResources(w, r, "query:read", append(entities, columns...), conn.Tags); !ok {
 		return
 	}
 	result, err := adapter.Explain(r.Context(), req.Statement)
@@ -335,17 +383,35 @@ func isProd(env string) bool {
 	}
 }
 
-func parseApprovalID(value string) *uuid.UUID {
-	if value == "" {
-		return nil
+// restoreApproval gives back the approval consumed for a run that failed to
+// start, so the requester can try again without a new approval round.
+func (h *Handler) restoreApproval(ctx context.Context, job query.Job) {
+	if job.ApprovalID == nil {
+		return
 	}
-	id, err := uuid.Parse(value)
-	if err != nil {
-		return nil
+	if err := h.Store.RestoreQueryApproval(ctx, *job.ApprovalID); err != nil {
+		return
 	}
-	return &id
+	_ = h.Audit.LogEvent(ctx, "query_approval_restored", &job.UserID, map[string]any{"approvalId": job.ApprovalID.String()}, "")
 }
 
 func timePtr(t time.Time) *time.Time {
 	return &t
 }
+
+// statementResources returns the entity and column resources referenced by
+// statement. When nothing can be extracted the statement may touch anything,
+// so the whole database subtree, db/**, must be allowed and no deny may
+// match anything inside it.
+func statementResources(conn store.Connection, statement string) ([]string, []string) {
+	namespace := conn.Database
+	if conn.Type == "postgres" {
+		namespace = "public"
+	}
+	refs := query.StatementReferences(conn.Type, statement, namespace)
+	entities, columns := query.ReferenceResources(conn.ID.String(), refs)
+	if len(entities) == 0 {
+		return []string{"connection/" + conn.ID.String() + "/db/**"}, nil
+	}
+	return entities, columns
+}
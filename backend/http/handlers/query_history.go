@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/discovery"
+	"flowdb/backend/query"
+	"flowdb/backend/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// maxHistoryScan bounds the entries decrypted for one text search.
+const maxHistoryScan = 5000
+
+// ListHistory returns query history, newest first. Without history:read the
+// caller only sees their own entries. Filters: userId, connectionId,
+// fingerprint, status (comma separated or repeated), from, to (RFC 3339) and
+// q (text in the statement, case-insensitive).
+func (h *Handler) ListHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "query:read", "history", nil) {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	filter, err := historyFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.allowed(r, "history:read", "history", nil) {
+		if filter.UserID != nil && *filter.UserID != user.ID {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		filter.UserID = &user.ID
+	}
+	list, truncated, err := h.searchHistory(r.Context(), filter, strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		http.Error(w, "failed to list history", http.StatusInternalServerError)
+		return
+	}
+	if truncated {
+		w.Header().Set("X-History-Truncated", "true")
+	}
+	views := make([]map[string]any, 0, len(list))
+	for _, entry := range list {
+		views = append(views, h.historyView(entry))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// historyFilter reads userId, connectionId, fingerprint, status, from, to,
+// limit and offset.
+func historyFilter(r *http.Request) (store.HistoryFilter, error) {
+	q := r.URL.Query()
+	filter := store.HistoryFilter{
+		Fingerprint: q.Get("fingerprint"),
+		Limit:       parseInt(q.Get("limit"), 100),
+		Offset:      parseInt(q.Get("offset"), 0),
+	}
+	for _, value := range q["status"] {
+		for _, status := range strings.Split(value, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				filter.Status = append(filter.Status, status)
+			}
+		}
+	}
+	if raw := q.Get("userId"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return filter, errors.New("invalid userId")
+		}
+		filter.UserID = &id
+	}
+	if raw := q.Get("connectionId"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return filter, errors.New("invalid connectionId")
+		}
+		filter.ConnectionID = &id
+	}
+	var err error
+	if filter.From, err = timeParam(q.Get("from")); err != nil {
+		return filter, errors.New("invalid from")
+	}
+	if filter.To, err = timeParam(q.Get("to")); err != nil {
+		return filter, errors.New("invalid to")
+	}
+	return filter, nil
+}
+
+// searchHistory applies filter and, since statements are encrypted, matches
+// text after decrypting. At most maxHistoryScan entries are searched; the
+// result is then reported as truncated.
+func (h *Handler) searchHistory(ctx context.Context, filter store.HistoryFilter, text string) ([]store.QueryHistory, bool, error) {
+	if text == "" {
+		list, err := h.Store.ListHistory(ctx, filter)
+		return list, false, err
+	}
+	text = strings.ToLower(text)
+	limit, skip := filter.Limit, filter.Offset
+	filter.Limit, filter.Offset = 500, 0
+	var out []store.QueryHistory
+	for filter.Offset < maxHistoryScan {
+		page, err := h.Store.ListHistory(ctx, filter)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, entry := range page {
+			if !strings.Contains(strings.ToLower(h.decryptStatement(entry)), text) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			out = append(out, entry)
+			if len(out) == limit {
+				return out, false, nil
+			}
+		}
+		if len(page) < filter.Limit {
+			return out, false, nil
+		}
+		filter.Offset += len(page)
+	}
+	return out, true, nil
+}
+
+// historyStatement encrypts statement for query_history, redacted as
+// HISTORY_STATEMENTS says. It reports whether literals were redacted.
+func (h *Handler) historyStatement(connType, statement string) ([]byte, bool) {
+	redacted := false
+	switch h.Config.HistoryStatements {
+	case "off":
+		return nil, false
+	case "literals":
+		statement, redacted = query.RedactLiterals(connType, statement, nil)
+	case "pii":
+		statement, redacted = query.RedactLiterals(connType, statement, func(value string) bool {
+			_, ok := discovery.ClassifyValue(value)
+			return ok
+		})
+	}
+	enc, err := h.Cipher.Encrypt([]byte(statement))
+	if err != nil {
+		h.Logger.Error("history statement encrypt failed", "error", err)
+		return nil, false
+	}
+	return enc, redacted
+}
+
+// historyFingerprint fingerprints statement. The normalized text has no
+// literals but is still left out when statements are not stored.
+func (h *Handler) historyFingerprint(connType, statement string) (string, string) {
+	fingerprint, text := query.Fingerprint(connType, statement)
+	if h.Config.HistoryStatements == "off" {
+		text = ""
+	}
+	return fingerprint, text
+}
+
+func (h *Handler) decryptStatement(entry store.QueryHistory) string {
+	if entry.StatementEnc == nil {
+		return ""
+	}
+	plain, err := h.Cipher.Decrypt(entry.StatementEnc)
+	if err != nil {
+		return ""
+	}
+	return string(plain)
+}
+
+func (h *Handler) historyView(entry store.QueryHistory) map[string]any {
+	view := map[string]any{
+		"id":                entry.ID.String(),
+		"userId":            entry.UserID.String(),
+		"connectionId":      entry.ConnectionID.String(),
+		"statementHash":     entry.StatementHash,
+		"statement":         h.decryptStatement(entry),
+		"statementRedacted": entry.StatementRedacted,
+		"fingerprint":       entry.Fingerprint,
+		"fingerprintText":   entry.FingerprintText,
+		"status":            entry.Status,
+		"rowCount":          entry.RowCount,
+		"durationMs":        entry.DurationMs,
+		"startedAt":         entry.StartedAt,
+		"endedAt":           entry.EndedAt,
+		"action":            entry.Action,
+		"resource":          entry.Resource,
+	}
+	if entry.ApprovalID != nil {
+		view["approvalId"] = entry.ApprovalID.String()
+	}
+	return view
+}
+
+// RerunHistory runs the statement of a history entry again on its
+// connection as the caller, authorized and approved like a new query. The
+// body takes the StartQuery options other than the statement.
+func (h *Handler) RerunHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	entry, err := h.Store.GetQueryHistory(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "history not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to load history", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	if entry.UserID != user.ID && !h.authorize(w, r, "history:read", "history", nil) {
+		return
+	}
+	if entry.StatementEnc == nil {
+		http.Error(w, "statement not stored", http.StatusConflict)
+		return
+	}
+	if entry.StatementRedacted {
+		http.Error(w, "statement was redacted", http.StatusConflict)
+		return
+	}
+	statement, err := h.Cipher.Decrypt(entry.StatementEnc)
+	if err != nil {
+		http.Error(w, "failed to decrypt statement", http.StatusInternalServerError)
+		return
+	}
+	conn, err := h.Store.GetConnection(r.Context(), entry.ConnectionID)
+	if err != nil {
+		http.Error(w, "connection not found", http.StatusNotFound)
+		return
+	}
+	var req queryRequest
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Statement = string(statement)
+	if !h.startQuery(w, r, conn, req) {
+		return
+	}
+	_ = h.Audit.LogEvent(r.Context(), "query_rerun", &user.ID, map[string]any{
+		"historyId":    entry.ID.String(),
+		"connectionId": conn.ID.String(),
+	}, "")
+}
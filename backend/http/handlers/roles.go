@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/policies"
+	"flowdb/backend/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type roleRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type roleBindingRequest struct {
+	RoleID   string `json:"roleId"`
+	UserID   string `json:"userId"`
+	GroupID  string `json:"groupId"`
+	Group    string `json:"group"`
+	Resource string `json:"resource"`
+}
+
+func (h *Handler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:read", "iam/roles", nil) {
+		return
+	}
+	roles, err := h.Store.ListRoles(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list roles", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(roles))
+	for _, role := range roles {
+		views = append(views, roleView(role))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (h *Handler) GetRole(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:read", "iam/roles", nil) {
+		return
+	}
+	role, ok := h.loadRole(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, roleView(role))
+}
+
+func (h *Handler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "iam/roles", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	var req roleRequest
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !validPermissions(w, req.Permissions) {
+		return
+	}
+	role, err := h.Store.CreateRole(r.Context(), store.Role{Name: strings.TrimSpace(req.Name), Permissions: req.Permissions})
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "role already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to create role", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "role_create", &user.ID, map[string]any{
+		"roleId":      role.ID.String(),
+		"name":        role.Name,
+		"permissions": role.Permissions,
+	}, "")
+	writeJSON(w, http.StatusCreated, roleView(role))
+}
+
+func (h *Handler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "iam/roles", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	role, ok := h.loadMutableRole(w, r)
+	if !ok {
+		return
+	}
+	var req roleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !validPermissions(w, req.Permissions) {
+		return
+	}
+	previous := role.Permissions
+	if name := strings.TrimSpace(req.Name); name != "" {
+		role.Name = name
+	}
+	role.Permissions = req.Permissions
+	if err := h.Store.UpdateRole(r.Context(), role); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "role already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to update role", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "role_update", &user.ID, map[string]any{
+		"roleId":              role.ID.String(),
+		"name":                role.Name,
+		"permissions":         role.Permissions,
+		"previousPermissions": previous,
+	}, "")
+	writeJSON(w, http.StatusOK, roleView(role))
+}
+
+// DeleteRole removes a role together with its bindings.
+func (h *Handler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "iam/roles", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	role, ok := h.loadMutableRole(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Store.DeleteRole(r.Context(), role.ID); err != nil {
+		http.Error(w, "failed to delete role", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "role_delete", &user.ID, map[string]any{
+		"roleId": role.ID.String(),
+		"name":   role.Name,
+	}, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListRoleBindings lists bindings, optionally filtered by roleId, userId or
+// groupId query parameters.
+func (h *Handler) ListRoleBindings(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:read", "iam/bindings", nil) {
+		return
+	}
+	var filter store.RoleBindingFilter
+	for param, dst := range map[string]**uuid.UUID{
+		"roleId":  &filter.RoleID,
+		"userId":  &filter.UserID,
+		"groupId": &filter.GroupID,
+	} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		id, err := uuid.Parse(value)
+		if err != nil {
+			http.Error(w, "invalid "+param, http.StatusBadRequest)
+			return
+		}
+		*dst = &id
+	}
+	bindings, err := h.Store.ListRoleBindings(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "failed to list bindings", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(bindings))
+	for _, b := range bindings {
+		views = append(views, roleBindingView(b))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// CreateRoleBinding binds a role to exactly one user or group, optionally
+// scoped to a resource pattern such as "connection/<id>/*".
+func (h *Handler) CreateRoleBinding(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "iam/bindings", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	var req roleBindingRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	roleID, err := uuid.Parse(req.RoleID)
+	if err != nil {
+		http.Error(w, "invalid roleId", http.StatusBadRequest)
+		return
+	}
+	role, err := h.Store.GetRole(r.Context(), roleID)
+	if err != nil {
+		http.Error(w, "role not found", http.StatusBadRequest)
+		return
+	}
+	binding := store.RoleBinding{RoleID: role.ID, Resource: strings.TrimSpace(req.Resource)}
+	if err := policies.ValidScope(binding.Resource); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case req.UserID != "" && (req.GroupID != "" || req.Group != ""):
+		http.Error(w, "userId and group are exclusive", http.StatusBadRequest)
+		return
+	case req.UserID != "":
+		id, err := uuid.Parse(req.UserID)
+		if err != nil {
+			http.Error(w, "invalid userId", http.StatusBadRequest)
+			return
+		}
+		if _, err := h.Store.GetUserByID(r.Context(), id); err != nil {
+			http.Error(w, "user not found", http.StatusBadRequest)
+			return
+		}
+		binding.UserID = &id
+	case req.GroupID != "":
+		id, err := uuid.Parse(req.GroupID)
+		if err != nil {
+			http.Error(w, "invalid groupId", http.StatusBadRequest)
+			return
+		}
+		binding.GroupID = &id
+	case req.Group != "":
+		group, err := h.Store.UpsertGroup(r.Context(), strings.TrimSpace(req.Group))
+		if err != nil {
+			http.Error(w, "failed to resolve group", http.StatusInternalServerError)
+			return
+		}
+		binding.GroupID = &group.ID
+	default:
+		http.Error(w, "userId or group required", http.StatusBadRequest)
+		return
+	}
+	binding, err = h.Store.CreateRoleBinding(r.Context(), binding)
+	if err != nil {
+		http.Error(w, "failed to create binding", http.StatusBadRequest)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	details := roleBindingView(binding)
+	details["role"] = role.Name
+	_ = h.Audit.LogEvent(r.Context(), "role_binding_create", &user.ID, details, "")
+	writeJSON(w, http.StatusCreated, roleBindingView(binding))
+}
+
+func (h *Handler) DeleteRoleBinding(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "iam/bindings", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid binding id", http.StatusBadRequest)
+		return
+	}
+	binding, err := h.Store.GetRoleBinding(r.Context(), id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if binding.ManagedBy != "" {
+		http.Error(w, "binding is managed by "+binding.ManagedBy, http.StatusConflict)
+		return
+	}
+	if err := h.Store.DeleteRoleBinding(r.Context(), id); err != nil {
+		http.Error(w, "failed to delete binding", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "role_binding_delete", &user.ID, roleBindingView(binding), "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) loadRole(w http.ResponseWriter, r *http.Request) (store.Role, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid role id", http.StatusBadRequest)
+		return store.Role{}, false
+	}
+	role, err := h.Store.GetRole(r.Context(), id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return store.Role{}, false
+	}
+	return role, true
+}
+
+// loadMutableRole is loadRole for changes; roles managed by POLICY_DIR are
+// read-only.
+func (h *Handler) loadMutableRole(w http.ResponseWriter, r *http.Request) (store.Role, bool) {
+	role, ok := h.loadRole(w, r)
+	if !ok {
+		return store.Role{}, false
+	}
+	if role.ManagedBy != "" {
+		http.Error(w, "role is managed by "+role.ManagedBy, http.StatusConflict)
+		return store.Role{}, false
+	}
+	return role, true
+}
+
+func validPermissions(w http.ResponseWriter, perms []string) bool {
+	for _, perm := range perms {
+		if !policies.KnownAction(perm) {
+			http.Error(w, "unknown permission "+perm, http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+func roleView(role store.Role) map[string]any {
+	perms := role.Permissions
+	if perms == nil {
+		perms = []string{}
+	}
+	return map[string]any{
+		"id":          role.ID.String(),
+		"name":        role.Name,
+		"permissions": perms,
+		"managedBy":   role.ManagedBy,
+		"readOnly":    role.ManagedBy != "",
+		"createdAt":   role.CreatedAt,
+	}
+}
+
+func roleBindingView(b store.RoleBinding) map[string]any {
+	view := map[string]any{
+		"id":        b.ID.String(),
+		"roleId":    b.RoleID.String(),
+		"resource":  b.Resource,
+		"managedBy": b.ManagedBy,
+		"readOnly":  b.ManagedBy != "",
+		"createdAt": b.CreatedAt,
+	}
+	if b.UserID != nil {
+		view["userId"] = b.UserID.String()
+	}
+	if b.GroupID != nil {
+		view["groupId"] = b.GroupID.String()
+	}
+	return view
+}
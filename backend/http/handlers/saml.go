@@ -41,13 +41,12 @@ func (h *Handler) SAMLLogin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to map user", http.StatusInternalServerError)
 		return
 	}
-	session, err := h.Sessions.Create(r.Context(), user.ID, nil)
-	if err != nil {
-		http.Error(w, "failed to create session", http.StatusInternalServerError)
+	session, ok := h.startSession(w, r, user.ID, nil)
+	if !ok {
 		return
 	}
-	h.Sessions.SetCookie(w, session.ID, !h.Config.AllowInsecureCookies)
 	_ = h.Audit.LogEvent(r.Context(), "saml_login", &user.ID, map[string]any{"subject": subject, "email": email}, "")
+	h.recordDevice(w, r, user)
 	writeJSON(w, http.StatusOK, loginResponse{
 		ID:        user.ID.String(),
 		Username:  user.Username,
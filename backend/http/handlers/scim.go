@@ -9,7 +9,7 @@ func (h *Handler) ListSCIMUsers(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	if !h.authorize(w, r, "settings:write", "scim", "") {
+	if !h.authorize(w, r, "settings:write", "scim", nil) {
 		return
 	}
 	limit := parseInt(r.URL.Query().Get("count"), 100)
@@ -46,7 +46,7 @@ func (h *Handler) ListSCIMGroups(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	if !h.authorize(w, r, "settings:write", "scim", "") {
+	if !h.authorize(w, r, "settings:write", "scim", nil) {
 		return
 	}
 	limit := parseInt(r.URL.Query().Get("count"), 100)
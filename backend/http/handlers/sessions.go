@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// startSession creates a session for userID and sets its cookie. Sessions
+// evicted by SESSION_MAX_PER_USER are audited as session_evicted.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, userID uuid.UUID, mfaAt *time.Time) (store.Session, bool) {
+	session, evicted, err := h.Sessions.Create(r.Context(), userID, mfaAt, clientIP(r), r.UserAgent())
+	if err != nil {
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return store.Session{}, false
+	}
+	h.Sessions.SetCookie(w, session.ID, !h.Config.AllowInsecureCookies)
+	for _, id := range evicted {
+		_ = h.Audit.LogEvent(r.Context(), "session_evicted", &userID, map[string]any{
+			"sessionId": id.String(),
+			"reason":    "max_sessions",
+		}, "")
+	}
+	return session, true
+}
+
+// ListSessions returns the caller's sessions, most recently used first.
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	session, _ := auth.SessionFromContext(r.Context())
+	h.writeSessions(w, r, session.UserID, session.ID)
+}
+
+// RevokeSession ends one of the caller's sessions. Revoking the current
+// session also clears its cookie.
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	current, _ := auth.SessionFromContext(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid session id", http.StatusBadRequest)
+		return
+	}
+	if !h.revokeSession(w, r, current.UserID, id) {
+		return
+	}
+	if id == current.ID {
+		h.Sessions.ClearCookie(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListUserSessions returns the sessions of any user.
+func (h *Handler) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:read", "users", nil) {
+		return
+	}
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	current, _ := auth.SessionFromContext(r.Context())
+	h.writeSessions(w, r, userID, current.ID)
+}
+
+// RevokeUserSession ends one session of any user.
+func (h *Handler) RevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "users", nil) {
+		return
+	}
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "sessionId"))
+	if err != nil {
+		http.Error(w, "invalid session id", http.StatusBadRequest)
+		return
+	}
+	if !h.revokeSession(w, r, userID, id) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeUserSessions ends every session of a user, for example after a
+// credential compromise.
+func (h *Handler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "iam:write", "users", nil) {
+		return
+	}
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	ids, err := h.Store.DeleteUserSessions(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	actor, _ := auth.UserFromContext(r.Context())
+	revoked := make([]string, 0, len(ids))
+	for _, id := range ids {
+		revoked = append(revoked, id.String())
+	}
+	_ = h.Audit.LogEvent(r.Context(), "session_revoked", &actor.ID, map[string]any{
+		"userId":     userID.String(),
+		"sessionIds": revoked,
+	}, "")
+	writeJSON(w, http.StatusOK, map[string]any{"revoked": len(ids)})
+}
+
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request, userID uuid.UUID, id uuid.UUID) bool {
+	err := h.Store.DeleteUserSession(r.Context(), userID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+		return false
+	}
+	actor, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "session_revoked", &actor.ID, map[string]any{
+		"userId":     userID.String(),
+		"sessionIds": []string{id.String()},
+	}, "")
+	return true
+}
+
+func (h *Handler) writeSessions(w http.ResponseWriter, r *http.Request, userID uuid.UUID, currentID uuid.UUID) {
+	list, err := h.Store.ListUserSessions(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	views := make([]map[string]any, 0, len(list))
+	for _, sess := range list {
+		if h.Sessions.Expired(sess, now) {
+			continue
+		}
+		views = append(views, sessionView(sess, sess.ID == currentID))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// sessionView leaves out the CSRF token, which is a secret of the session.
+func sessionView(sess store.Session, current bool) map[string]any {
+	return map[string]any{
+		"id":         sess.ID.String(),
+		"userId":     sess.UserID.String(),
+		"createdAt":  sess.CreatedAt,
+		"expiresAt":  sess.ExpiresAt,
+		"lastAuthAt": sess.LastAuthAt,
+		"lastMfaAt":  sess.LastMFAAt,
+		"lastSeenAt": sess.LastSeenAt,
+		"clientIp":   sess.ClientIP,
+		"userAgent":  sess.UserAgent,
+		"current":    current,
+	}
+}
@@ -12,7 +12,7 @@ type settingsUpdateRequest struct {
 }
 
 func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
-	if !h.authorize(w, r, "settings:write", "settings", "") {
+	if !h.authorize(w, r, "settings:write", "settings", nil) {
 		return
 	}
 	if !h.requireStepUp(w, r) {
@@ -22,7 +22,7 @@ func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
-	if !h.authorize(w, r, "settings:write", "settings", "") {
+	if !h.authorize(w, r, "settings:write", "settings", nil) {
 		return
 	}
 	if !h.requireStepUp(w, r) {
@@ -61,7 +61,7 @@ type modeUpdateRequest struct {
 }
 
 func (h *Handler) UpdateSecurityMode(w http.ResponseWriter, r *http.Request) {
-	if !h.authorize(w, r, "settings:write", "settings", "") {
+	if !h.authorize(w, r, "settings:write", "settings", nil) {
 		return
 	}
 	if !h.requireStepUp(w, r) {
@@ -85,7 +85,7 @@ type flagsUpdateRequest struct {
 }
 
 func (h *Handler) UpdateFlags(w http.ResponseWriter, r *http.Request) {
-	if !h.authorize(w, r, "settings:write", "settings", "") {
+	if !h.authorize(w, r, "settings:write", "settings", nil) {
 		return
 	}
 	var req flagsUpdateRequest
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"flowdb/backend/siem"
+	"flowdb/backend/store"
+)
+
+const (
+	streamMaxLimit = 5000
+	streamMaxWait  = time.Minute
+)
+
+var consumerName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// StreamAudit returns audit entries after a cursor as NDJSON (or CEF lines
+// with format=cef), waiting up to wait for new entries when there are none.
+// X-Audit-Cursor carries the seq to pass as cursor on the next call. A named
+// consumer may omit cursor to resume from the last one it acknowledged;
+// passing cursor acknowledges everything up to it.
+func (h *Handler) StreamAudit(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "audit:read", "audit", nil) {
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = siem.FormatJSON
+	}
+	if format != siem.FormatJSON && format != siem.FormatCEF {
+		http.Error(w, "format must be json or cef", http.StatusBadRequest)
+		return
+	}
+	limit := parseInt(q.Get("limit"), 500)
+	if limit <= 0 || limit > streamMaxLimit {
+		limit = streamMaxLimit
+	}
+	wait := 25 * time.Second
+	if raw := q.Get("wait"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid wait", http.StatusBadRequest)
+			return
+		}
+		wait = min(parsed, streamMaxWait)
+	}
+	consumer := q.Get("consumer")
+	if consumer != "" && !consumerName.MatchString(consumer) {
+		http.Error(w, "invalid consumer", http.StatusBadRequest)
+		return
+	}
+	var cursor int64
+	if raw := q.Get("cursor"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = parsed
+		if consumer != "" && !h.ackAuditCursor(w, r, consumer, cursor) {
+			return
+		}
+	} else if consumer != "" {
+		stored, err := h.Store.GetAuditCursor(r.Context(), consumer)
+		switch {
+		case err == nil:
+			if stored.Kind != store.AuditCursorStream {
+				http.Error(w, "consumer name is in use", http.StatusConflict)
+				return
+			}
+			cursor = stored.LastSeq
+		case !errors.Is(err, store.ErrNotFound):
+			http.Error(w, "failed to load cursor", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 30*time.Second))
+	deadline := time.Now().Add(wait)
+	var entries []store.AuditEntry
+	for {
+		var err error
+		entries, err = h.Store.ListAuditSettled(r.Context(), cursor, time.Now().Add(-h.Config.SIEMSettleDelay), limit)
+		if err != nil {
+			http.Error(w, "failed to read audit", http.StatusInternalServerError)
+			return
+		}
+		if len(entries) > 0 || !time.Now().Before(deadline) {
+			break
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	next := cursor
+	if len(entries) > 0 {
+		next = entries[len(entries)-1].Seq
+	}
+	if format == siem.FormatCEF {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("X-Audit-Cursor", strconv.FormatInt(next, 10))
+	w.WriteHeader(http.StatusOK)
+	for _, entry := range entries {
+		line, err := siem.Format(format, entry)
+		if err != nil {
+			return
+		}
+		_, _ = w.Write(append(line, '\n'))
+	}
+}
+
+func (h *Handler) ackAuditCursor(w http.ResponseWriter, r *http.Request, consumer string, cursor int64) bool {
+	var previous int64
+	stored, err := h.Store.GetAuditCursor(r.Context(), consumer)
+	switch {
+	case err == nil:
+		if stored.Kind != store.AuditCursorStream {
+			http.Error(w, "consumer name is in use", http.StatusConflict)
+			return false
+		}
+		previous = stored.LastSeq
+	case !errors.Is(err, store.ErrNotFound):
+		http.Error(w, "failed to load cursor", http.StatusInternalServerError)
+		return false
+	}
+	acked := 0
+	if cursor > previous {
+		if acked, err = h.Store.CountAuditBetween(r.Context(), previous, cursor); err != nil {
+			http.Error(w, "failed to update cursor", http.StatusInternalServerError)
+			return false
+		}
+	}
+	if err := h.Store.AdvanceAuditCursor(r.Context(), consumer, store.AuditCursorStream, cursor, acked); err != nil {
+		http.Error(w, "failed to update cursor", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// ListAuditCursors reports the delivery status of the syslog forwarder and
+// named stream consumers.
+func (h *Handler) ListAuditCursors(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "audit:read", "audit", nil) {
+		return
+	}
+	cursors, err := h.Store.ListAuditCursors(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list cursors", http.StatusInternalServerError)
+		return
+	}
+	head, err := h.Store.MaxAuditSeq(r.Context())
+	if err != nil {
+		http.Error(w, "failed to list cursors", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(cursors))
+	for _, c := range cursors {
+		views = append(views, map[string]any{
+			"name":          c.Name,
+			"kind":          c.Kind,
+			"lastSeq":       c.LastSeq,
+			"lag":           max(head-c.LastSeq, 0),
+			"delivered":     c.Delivered,
+			"lastError":     c.LastError,
+			"lastAttemptAt": c.LastAttemptAt,
+			"lastSuccessAt": c.LastSuccessAt,
+			"createdAt":     c.CreatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"headSeq": head, "cursors": views})
+}
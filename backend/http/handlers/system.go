@@ -32,7 +32,7 @@ func (h *Handler) Version(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
-	if !h.authorize(w, r, "settings:write", "settings/*", "") {
+	if !h.authorize(w, r, "settings:write", "settings/*", nil) {
 		return
 	}
 	ctx := r.Context()
@@ -60,7 +60,7 @@ type updateApplyRequest struct {
 }
 
 func (h *Handler) UpdateApply(w http.ResponseWriter, r *http.Request) {
-	if !h.authorize(w, r, "settings:write", "settings/*", "") {
+	if !h.authorize(w, r, "settings:write", "settings/*", nil) {
 		return
 	}
 	var req updateApplyRequest
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type userAttributesRequest struct {
+	Attributes map[string]any `json:"attributes"`
+}
+
+// UpdateUserAttributes replaces the attributes that policies can match with
+// user_attributes conditions.
+func (h *Handler) UpdateUserAttributes(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "settings:write", "users", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	var req userAttributesRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := h.Store.UpdateUserAttributes(r.Context(), userID, req.Attributes); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to update user", http.StatusInternalServerError)
+		return
+	}
+	actor, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "user_attributes_update", &actor.ID, map[string]any{
+		"userId":     userID.String(),
+		"attributes": req.Attributes,
+	}, "")
+	writeJSON(w, http.StatusOK, map[string]any{"id": userID.String(), "attributes": req.Attributes})
+}
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/store"
+	"flowdb/backend/util"
+	"flowdb/backend/webhooks"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type webhookRequest struct {
+	Name         string   `json:"name"`
+	URL          string   `json:"url"`
+	Secret       string   `json:"secret"`
+	RotateSecret bool     `json:"rotateSecret"`
+	Events       []string `json:"events"`
+	Enabled      *bool    `json:"enabled"`
+}
+
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "settings:write", "webhooks", nil) {
+		return
+	}
+	hooks, err := h.Store.ListWebhooks(r.Context(), false)
+	if err != nil {
+		http.Error(w, "failed to list webhooks", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(hooks))
+	for _, hook := range hooks {
+		views = append(views, webhookView(hook))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (h *Handler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "settings:write", "webhooks", nil) {
+		return
+	}
+	hook, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, webhookView(hook))
+}
+
+// CreateWebhook subscribes a URL to events. The signing secret is generated
+// unless one is given, and is only returned in this response.
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "settings:write", "webhooks", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	var req webhookRequest
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !h.validWebhookURL(w, r, req.URL) {
+		return
+	}
+	secret, enc, ok := h.webhookSecret(w, req.Secret)
+	if !ok {
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	hook, err := h.Store.CreateWebhook(r.Context(), store.Webhook{
+		Name:      strings.TrimSpace(req.Name),
+		URL:       strings.TrimSpace(req.URL),
+		SecretEnc: enc,
+		Events:    cleanEvents(req.Events),
+		Enabled:   req.Enabled == nil || *req.Enabled,
+		CreatedBy: &user.ID,
+	})
+	if err != nil {
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	h.invalidateWebhooks()
+	_ = h.Audit.LogEvent(r.Context(), "webhook_create", &user.ID, map[string]any{
+		"webhookId": hook.ID.String(),
+		"name":      hook.Name,
+		"url":       hook.URL,
+		"events":    hook.Events,
+	}, "")
+	view := webhookView(hook)
+	view["secret"] = secret
+	writeJSON(w, http.StatusCreated, view)
+}
+
+// UpdateWebhook changes a subscription. rotateSecret or a new secret replaces
+// the signing secret, which is then returned once.
+func (h *Handler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "settings:write", "webhooks", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	hook, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	var req webhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		hook.Name = name
+	}
+	if req.URL != "" {
+		if !h.validWebhookURL(w, r, req.URL) {
+			return
+		}
+		hook.URL = strings.TrimSpace(req.URL)
+	}
+	if req.Events != nil {
+		hook.Events = cleanEvents(req.Events)
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	secret := ""
+	if req.RotateSecret || req.Secret != "" {
+		var enc []byte
+		secret, enc, ok = h.webhookSecret(w, req.Secret)
+		if !ok {
+			return
+		}
+		hook.SecretEnc = enc
+	}
+	hook, err := h.Store.UpdateWebhook(r.Context(), hook)
+	if err != nil {
+		http.Error(w, "failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+	h.invalidateWebhooks()
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "webhook_update", &user.ID, map[string]any{
+		"webhookId":     hook.ID.String(),
+		"url":           hook.URL,
+		"events":        hook.Events,
+		"enabled":       hook.Enabled,
+		"secretRotated": secret != "",
+	}, "")
+	view := webhookView(hook)
+	if secret != "" {
+		view["secret"] = secret
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "settings:write", "webhooks", nil) {
+		return
+	}
+	if !h.requireStepUp(w, r) {
+		return
+	}
+	hook, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Store.DeleteWebhook(r.Context(), hook.ID); err != nil {
+		http.Error(w, "failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	h.invalidateWebhooks()
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "webhook_delete", &user.ID, map[string]any{
+		"webhookId": hook.ID.String(),
+		"name":      hook.Name,
+	}, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries returns the delivery log of a webhook, newest first,
+// optionally filtered by status and event.
+func (h *Handler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "settings:write", "webhooks", nil) {
+		return
+	}
+	hook, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	list, err := h.Store.ListWebhookDeliveries(r.Context(), store.WebhookDeliveryFilter{
+		WebhookID: &hook.ID,
+		Status:    r.URL.Query().Get("status"),
+		EventType: r.URL.Query().Get("event"),
+		Limit:     parseInt(r.URL.Query().Get("limit"), 100),
+		Offset:    parseInt(r.URL.Query().Get("offset"), 0),
+	})
+	if err != nil {
+		http.Error(w, "failed to list deliveries", http.StatusInternalServerError)
+		return
+	}
+	views := make([]map[string]any, 0, len(list))
+	for _, d := range list {
+		views = append(views, webhookDeliveryView(d))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// RedeliverWebhook queues a delivered or failed delivery again.
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, "settings:write", "webhooks", nil) {
+		return
+	}
+	hook, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(chi.URLParam(r, "deliveryId"))
+	if err != nil {
+		http.Error(w, "invalid delivery id", http.StatusBadRequest)
+		return
+	}
+	delivery, err := h.Store.GetWebhookDelivery(r.Context(), id)
+	if err != nil || delivery.WebhookID != hook.ID {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	delivery, err = h.Store.RedeliverWebhookDelivery(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "delivery is still pending", http.StatusConflict)
+			return
+		}
+		http.Error(w, "failed to redeliver", http.StatusInternalServerError)
+		return
+	}
+	user, _ := auth.UserFromContext(r.Context())
+	_ = h.Audit.LogEvent(r.Context(), "webhook_redeliver", &user.ID, map[string]any{
+		"webhookId":  hook.ID.String(),
+		"deliveryId": delivery.ID.String(),
+		"event":      delivery.EventType,
+	}, "")
+	writeJSON(w, http.StatusOK, webhookDeliveryView(delivery))
+}
+
+func (h *Handler) loadWebhook(w http.ResponseWriter, r *http.Request) (store.Webhook, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return store.Webhook{}, false
+	}
+	hook, err := h.Store.GetWebhook(r.Context(), id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return store.Webhook{}, false
+	}
+	return hook, true
+}
+
+// webhookSecret returns the plain and encrypted signing secret, generating
+// one when given is empty.
+func (h *Handler) webhookSecret(w http.ResponseWriter, given string) (string, []byte, bool) {
+	secret := strings.TrimSpace(given)
+	if secret == "" {
+		token, err := util.RandomToken(32)
+		if err != nil {
+			http.Error(w, "failed to generate secret", http.StatusInternalServerError)
+			return "", nil, false
+		}
+		secret = token
+	} else if len(secret) < 16 {
+		http.Error(w, "secret must be at least 16 characters", http.StatusBadRequest)
+		return "", nil, false
+	}
+	enc, err := h.Cipher.Encrypt([]byte(secret))
+	if err != nil {
+		http.Error(w, "failed to store secret", http.StatusInternalServerError)
+		return "", nil, false
+	}
+	return secret, enc, true
+}
+
+func (h *Handler) validWebhookURL(w http.ResponseWriter, r *http.Request, raw string) bool {
+	err := webhooks.CheckURL(r.Context(), raw, h.Config.WebhookAllowPrivate)
+	if errors.Is(err, webhooks.ErrPrivateAddress) {
+		http.Error(w, "url must point to a public address", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// invalidateWebhooks makes the dispatcher reload subscriptions so the change
+// applies to the next event.
+func (h *Handler) invalidateWebhooks() {
+	if h.Webhooks != nil {
+		h.Webhooks.Invalidate()
+	}
+}
+
+func cleanEvents(events []string) []string {
+	cleaned := make([]string, 0, len(events))
+	for _, event := range events {
+		if event = strings.TrimSpace(event); event != "" {
+			cleaned = append(cleaned, event)
+		}
+	}
+	return cleaned
+}
+
+func webhookView(hook store.Webhook) map[string]any {
+	events := hook.Events
+	if events == nil {
+		events = []string{}
+	}
+	view := map[string]any{
+		"id":        hook.ID.String(),
+		"name":      hook.Name,
+		"url":       hook.URL,
+		"events":    events,
+		"enabled":   hook.Enabled,
+		"createdAt": hook.CreatedAt,
+		"updatedAt": hook.UpdatedAt,
+	}
+	if hook.CreatedBy != nil {
+		view["createdBy"] = hook.CreatedBy.String()
+	}
+	return view
+}
+
+func webhookDeliveryView(d store.WebhookDelivery) map[string]any {
+	return map[string]any{
+		"id":             d.ID.String(),
+		"webhookId":      d.WebhookID.String(),
+		"event":          d.EventType,
+		"status":         d.Status,
+		"attempts":       d.Attempts,
+		"nextAttemptAt":  d.NextAttemptAt,
+		"lastAttemptAt":  d.LastAttemptAt,
+		"responseStatus": d.ResponseStatus,
+		"lastError":      d.LastError,
+		"deliveredAt":    d.DeliveredAt,
+		"createdAt":      d.CreatedAt,
+	}
+}
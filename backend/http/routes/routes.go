@@ -15,6 +15,7 @@ func NewRouter(h *handlers.Handler, cfg *config.Config, settingsStore *settings.
 	r := chi.NewRouter()
 	limiter := middleware.NewRateLimiter(cfg.LoginRateLimitPerMin, cfg.LoginBurst)
 	r.Use(middleware.RequestID)
+	r.Use(middleware.ClientInfo(cfg.TrustedProxyCIDR))
 	r.Use(middleware.Recovery(h.Logger))
 	r.Use(middleware.Logging(h.Logger))
 	if len(cfg.CORSAllowOrigins) > 0 {
@@ -29,45 +30,116 @@ func NewRouter(h *handlers.Handler, cfg *config.Config, settingsStore *settings.
 			r.With(limiter.Middleware).Get("/oidc/callback", h.OIDCCallback)
 			r.Get("/oidc/login", h.OIDCLogin)
 			r.Get("/saml/login", h.SAMLLogin)
-			r.With(middleware.RequireAuth(h.Store, h.Sessions)).Post("/logout", h.Logout)
-			r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/me", h.Me)
-			r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Post("/mfa/enroll", h.EnrollMFA)
-			r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Post("/mfa/verify", h.VerifyMFA)
+			r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Post("/logout", h.Logout)
+			r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/me", h.Me)
+			r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/sessions", h.ListSessions)
+			r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/sessions/{id}", h.RevokeSession)
+			r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/me/notifications", h.GetNotificationPrefs)
+			r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/me/notifications", h.UpdateNotificationPrefs)
+			r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/mfa/enroll", h.EnrollMFA)
+			r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/mfa/verify", h.VerifyMFA)
 		})
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/settings", h.GetSettings)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Put("/settings", h.UpdateSettings)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Put("/settings/security-mode", h.UpdateSecurityMode)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Put("/settings/flags", h.UpdateFlags)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/settings", h.GetSettings)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/settings", h.UpdateSettings)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/settings/security-mode", h.UpdateSecurityMode)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/settings/flags", h.UpdateFlags)
 
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/connections", h.ListConnections)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections", h.CreateConnection)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/connections/{id}", h.GetConnection)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Put("/connections/{id}", h.UpdateConnection)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/connections/{id}", h.DeleteConnection)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Post("/connections/{id}/test", h.TestConnection)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections", h.ListConnections)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections", h.CreateConnection)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}", h.GetConnection)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/connections/{id}", h.UpdateConnection)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/connections/{id}", h.DeleteConnection)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Post("/connections/{id}/test", h.TestConnection)
 
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/connections/{id}/namespaces", h.ListNamespaces)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/connections/{id}/entities", h.ListEntities)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/connections/{id}/entities/{name}/info", h.GetEntityInfo)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/connections/{id}/entities/{name}/browse", h.BrowseEntity)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/namespaces", h.ListNamespaces)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/entities", h.ListEntities)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/entities/{name}/info", h.GetEntityInfo)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/entities/{name}/browse", h.BrowseEntity)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/entities/{name}/export", h.ExportEntity)
 
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections/{id}/query", h.StartQuery)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/connections/{id}/query/{queryId}/stream", h.StreamQuery)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections/{id}/explain", h.ExplainQuery)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/pii-rules", h.ListPIIRules)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections/{id}/pii-rules", h.CreatePIIRule)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/connections/{id}/pii-rules/{ruleId}", h.UpdatePIIRule)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/connections/{id}/pii-rules/{ruleId}", h.DeletePIIRule)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/pii-scans", h.ListPIIScans)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections/{id}/pii-scans", h.StartPIIScan)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/pii-proposals", h.ListPIIProposals)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections/{id}/pii-proposals/accept", h.AcceptPIIProposals)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections/{id}/pii-proposals/reject", h.RejectPIIProposals)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/pii-coverage", h.PIICoverage)
 
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/approvals/pending", h.ListPendingApprovals)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Post("/approvals/{id}/approve", h.Approve)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Post("/approvals/{id}/deny", h.Deny)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections/{id}/query", h.StartQuery)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/connections/{id}/query/{queryId}/stream", h.StreamQuery)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/connections/{id}/explain", h.ExplainQuery)
 
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/history", h.ListHistory)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/audit", h.ListAudit)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/approvals/pending", h.ListPendingApprovals)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/approvals/{id}/approve", h.Approve)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/approvals/{id}/deny", h.Deny)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/approvals/{id}", h.GetApproval)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/approvals/{id}", h.ReviseApproval)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/approvals/{id}/diff", h.ApprovalDiff)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/approvals/{id}/comments", h.AddApprovalComment)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/approvals/{id}/request-changes", h.RequestApprovalChanges)
 
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/system/version", h.Version)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/system/update", h.UpdateStatus)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions), middleware.CSRF(cfg.CSRFHeaderName)).Post("/system/update/apply", h.UpdateApply)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/history", h.ListHistory)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/history/{id}/rerun", h.RerunHistory)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/insights/fingerprints", h.ListFingerprintInsights)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/insights/users", h.ListUserInsights)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/insights/connections", h.ListConnectionInsights)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/insights/timeline", h.ListTimelineInsights)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/audit", h.ListAudit)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/audit/verify", h.VerifyAudit)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/audit/checkpoints", h.ListAuditCheckpoints)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/audit/stream", h.StreamAudit)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/audit/cursors", h.ListAuditCursors)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/audit/archives", h.ListAuditArchives)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/audit/checkpoints", h.CreateAuditCheckpoint)
 
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/scim/Users", h.ListSCIMUsers)
-		r.With(middleware.RequireAuth(h.Store, h.Sessions)).Get("/scim/Groups", h.ListSCIMGroups)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/system/version", h.Version)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/system/update", h.UpdateStatus)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/system/update/apply", h.UpdateApply)
+
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/users/{id}/attributes", h.UpdateUserAttributes)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/users/{id}/sessions", h.ListUserSessions)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/users/{id}/sessions", h.RevokeUserSessions)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/users/{id}/sessions/{sessionId}", h.RevokeUserSession)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/iam/simulate", h.Simulate)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/iam/policies", h.ListPolicies)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/iam/policies", h.CreatePolicy)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/iam/policies/lint", h.LintPolicy)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/iam/policies/{id}", h.GetPolicy)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/iam/policies/{id}", h.UpdatePolicy)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/iam/policies/{id}", h.DeletePolicy)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/iam/policies/{id}/versions", h.ListPolicyVersions)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/iam/policies/{id}/rollback", h.RollbackPolicy)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/iam/roles", h.ListRoles)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/iam/roles", h.CreateRole)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/iam/roles/{id}", h.GetRole)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/iam/roles/{id}", h.UpdateRole)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/iam/roles/{id}", h.DeleteRole)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/iam/bindings", h.ListRoleBindings)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/iam/bindings", h.CreateRoleBinding)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/iam/bindings/{id}", h.DeleteRoleBinding)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/iam/break-glass", h.ListBreakGlass)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/iam/break-glass", h.StartBreakGlass)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/iam/break-glass/{id}/end", h.EndBreakGlass)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/iam/break-glass/{id}/review", h.ReviewBreakGlass)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/webhooks", h.ListWebhooks)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/webhooks", h.CreateWebhook)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/webhooks/{id}", h.GetWebhook)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Put("/webhooks/{id}", h.UpdateWebhook)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Delete("/webhooks/{id}", h.DeleteWebhook)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/webhooks/{id}/deliveries", h.ListWebhookDeliveries)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/webhooks/{id}/deliveries/{deliveryId}/redeliver", h.RedeliverWebhook)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/access/requests", h.ListAccessRequests)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/access/requests", h.CreateAccessRequest)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/access/requests/{id}", h.GetAccessRequest)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/access/requests/{id}/approve", h.ApproveAccessRequest)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/access/requests/{id}/deny", h.DenyAccessRequest)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit), middleware.CSRF(cfg.CSRFHeaderName)).Post("/access/requests/{id}/revoke", h.RevokeAccessRequest)
+
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/scim/Users", h.ListSCIMUsers)
+		r.With(middleware.RequireAuth(h.Store, h.Sessions, h.Audit)).Get("/scim/Groups", h.ListSCIMGroups)
 	})
 	return r
 }
@@ -0,0 +1,91 @@
+package iam
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"flowdb/backend/audit"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+// GrantExpirer periodically marks access grants past their expiry as expired
+// and audits each one, and audits access_grant_expiring once for grants about
+// to expire within notice. Authorization never depends on it running:
+// expired grants are ignored as soon as their expiry passes.
+type GrantExpirer struct {
+	store    grantStore
+	audit    eventLogger
+	interval time.Duration
+	notice   time.Duration
+	logger   *slog.Logger
+}
+
+// grantStore is the part of the store the expirer uses.
+type grantStore interface {
+	MarkExpiringAccessGrants(ctx context.Context, within time.Duration) ([]store.AccessRequest, error)
+	ExpireAccessGrants(ctx context.Context) ([]store.AccessRequest, error)
+}
+
+type eventLogger interface {
+	LogEvent(ctx context.Context, eventType string, userID *uuid.UUID, details map[string]any, errorID string) error
+}
+
+func NewGrantExpirer(st *store.Store, auditLogger *audit.Logger, interval time.Duration, notice time.Duration, logger *slog.Logger) *GrantExpirer {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &GrantExpirer{store: st, audit: auditLogger, interval: interval, notice: notice, logger: logger}
+}
+
+func (e *GrantExpirer) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := e.Sweep(ctx); err != nil {
+					e.logger.Error("access grant sweep failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (e *GrantExpirer) Sweep(ctx context.Context) error {
+	if e.notice > 0 {
+		expiring, err := e.store.MarkExpiringAccessGrants(ctx, e.notice)
+		if err != nil {
+			return err
+		}
+		for _, a := range expiring {
+			_ = e.audit.LogEvent(ctx, "access_grant_expiring", &a.UserID, map[string]any{
+				"requestId": a.ID.String(),
+				"roleId":    a.RoleID.String(),
+				"resource":  a.Resource,
+				"expiresAt": a.ExpiresAt,
+			}, "")
+		}
+	}
+	expired, err := e.store.ExpireAccessGrants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, a := range expired {
+		_ = e.audit.LogEvent(ctx, "access_grant_expired", &a.UserID, map[string]any{
+			"requestId": a.ID.String(),
+			"roleId":    a.RoleID.String(),
+			"resource":  a.Resource,
+			"ticketId":  a.TicketID,
+		}, "")
+	}
+	return nil
+}
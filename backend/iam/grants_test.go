@@ -0,0 +1,89 @@
+package iam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+type fakeGrantStore struct {
+	expiring []store.AccessRequest
+	expired  []store.AccessRequest
+	within   time.Duration
+}
+
+func (f *fakeGrantStore) MarkExpiringAccessGrants(ctx context.Context, within time.Duration) ([]store.AccessRequest, error) {
+	f.within = within
+	list := f.expiring
+	f.expiring = nil
+	return list, nil
+}
+
+func (f *fakeGrantStore) ExpireAccessGrants(ctx context.Context) ([]store.AccessRequest, error) {
+	list := f.expired
+	f.expired = nil
+	return list, nil
+}
+
+type loggedEvent struct {
+	event   string
+	userID  uuid.UUID
+	details map[string]any
+}
+
+type fakeEventLogger struct {
+	events []loggedEvent
+}
+
+func (f *fakeEventLogger) LogEvent(ctx context.Context, eventType string, userID *uuid.UUID, details map[string]any, errorID string) error {
+	f.events = append(f.events, loggedEvent{event: eventType, userID: *userID, details: details})
+	return nil
+}
+
+func TestGrantExpirerSweep(t *testing.T) {
+	soon := time.Now().Add(5 * time.Minute)
+	expiring := store.AccessRequest{ID: uuid.New(), UserID: uuid.New(), RoleID: uuid.New(), Resource: "connection/c1", ExpiresAt: &soon}
+	expired := store.AccessRequest{ID: uuid.New(), UserID: uuid.New(), RoleID: uuid.New(), Resource: "connection/c2", TicketID: "OPS-1", Status: store.AccessExpired}
+	st := &fakeGrantStore{expiring: []store.AccessRequest{expiring}, expired: []store.AccessRequest{expired}}
+	logger := &fakeEventLogger{}
+	e := &GrantExpirer{store: st, audit: logger, notice: 10 * time.Minute}
+
+	if err := e.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if st.within != 10*time.Minute {
+		t.Fatalf("expected the notice window to be passed, got %v", st.within)
+	}
+	if len(logger.events) != 2 {
+		t.Fatalf("expected two events, got %+v", logger.events)
+	}
+	if ev := logger.events[0]; ev.event != "access_grant_expiring" || ev.userID != expiring.UserID || ev.details["requestId"] != expiring.ID.String() {
+		t.Fatalf("unexpected expiring event %+v", ev)
+	}
+	if ev := logger.events[1]; ev.event != "access_grant_expired" || ev.userID != expired.UserID || ev.details["requestId"] != expired.ID.String() || ev.details["ticketId"] != "OPS-1" {
+		t.Fatalf("unexpected expired event %+v", ev)
+	}
+
+	if err := e.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.events) != 2 {
+		t.Fatalf("expected closed grants to be audited once, got %+v", logger.events)
+	}
+}
+
+func TestGrantExpirerSweepWithoutNotice(t *testing.T) {
+	st := &fakeGrantStore{expiring: []store.AccessRequest{{ID: uuid.New()}}}
+	logger := &fakeEventLogger{}
+	e := &GrantExpirer{store: st, audit: logger}
+	if err := e.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.events) != 0 || st.within != 0 {
+		t.Fatalf("expected no expiry notices without a notice window, got %+v", logger.events)
+	}
+}
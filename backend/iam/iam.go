@@ -3,9 +3,12 @@ package iam
 import (
 	"context"
 	"strings"
+	"time"
 
 	"flowdb/backend/policies"
 	"flowdb/backend/store"
+
+	"github.com/google/uuid"
 )
 
 type Authorizer struct {
@@ -16,36 +19,185 @@ type Authorizer struct {
 type Decision struct {
 	Allowed     bool
 	Constraints policies.Constraints
+	// Reason is a short explanation: "admin", "no role grants action",
+	// "no policies" or "policy".
+	Reason string
+	Trace  *policies.Trace
+	// GrantID is set when the action is only granted by a temporary access
+	// grant rather than a standing role binding.
+	GrantID *uuid.UUID
+}
+
+// BindingTrace describes a role binding that applies to the user, whether its
+// scope covers the requested resource and whether it grants the action there.
+type BindingTrace struct {
+	BindingID   string   `json:"bindingId"`
+	Role        string   `json:"role"`
+	Group       string   `json:"group,omitempty"`
+	Resource    string   `json:"resource,omitempty"`
+	InScope     bool     `json:"inScope"`
+	Grants      bool     `json:"grants"`
+	Permissions []string `json:"permissions"`
+	// Temporary marks an approved access request; ExpiresAt ends it.
+	Temporary bool       `json:"temporary,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+type Explanation struct {
+	Decision Decision
+	Request  policies.Request
+	Bindings []BindingTrace
 }
 
 func NewAuthorizer(st *store.Store, pol *policies.Store) *Authorizer {
 	return &Authorizer{store: st, polices: pol}
 }
 
-func (a *Authorizer) Authorize(ctx context.Context, user store.User, action string, resource string, env string) (Decision, error) {
+// Authorize checks role permissions and policies for req. A role counts only
+// through bindings, direct or via a group, whose scope covers req.Resource,
+// or through an unexpired access grant. Group membership and user attributes
+// are filled in from the store.
+func (a *Authorizer) Authorize(ctx context.Context, user store.User, req policies.Request) (Decision, error) {
 	if user.IsAdmin {
-		return Decision{Allowed: true}, nil
+		return Decision{Allowed: true, Reason: "admin"}, nil
+	}
+	bindings, err := a.store.ListUserRoleBindings(ctx, user.ID)
+	if err != nil {
+		return Decision{}, err
 	}
-	roles, err := a.store.ListUserRoles(ctx, user.ID)
+	allowedByRole, grant, err := a.roleGrant(ctx, user, req, bindings)
 	if err != nil {
 		return Decision{}, err
 	}
-	allowedByRole := false
-	for _, role := range roles {
-		if roleAllows(role.Permissions, action) {
-			allowedByRole = true
-			break
+	decision, _, err := a.decide(ctx, user, req, allowedByRole)
+	if grant != nil {
+		decision.GrantID = &grant.Request.ID
+	}
+	return decision, err
+}
+
+// roleGrant reports whether a standing binding or, failing that, an active
+// access grant permits req. The grant is returned when it was needed.
+func (a *Authorizer) roleGrant(ctx context.Context, user store.User, req policies.Request, bindings []store.UserRoleBinding) (bool, *store.AccessGrant, error) {
+	for _, b := range bindings {
+		if bindingGrants(b, req) {
+			return true, nil, nil
 		}
 	}
-	if !allowedByRole {
-		return Decision{Allowed: false}, nil
+	grants, err := a.store.ListActiveAccessGrants(ctx, user.ID)
+	if err != nil {
+		return false, nil, err
+	}
+	if g := matchGrant(grants, req, time.Now()); g != nil {
+		return true, g, nil
+	}
+	return false, nil, nil
+}
+
+// matchGrant returns the first grant that permits req at now.
+func matchGrant(grants []store.AccessGrant, req policies.Request, now time.Time) *store.AccessGrant {
+	for i := range grants {
+		if grantAllows(grants[i], req, now) {
+			return &grants[i]
+		}
 	}
+	return nil
+}
+
+// Explain evaluates req like Authorize and also reports the role bindings
+// that apply to the user. The policy trace is included even for admins.
+func (a *Authorizer) Explain(ctx context.Context, user store.User, req policies.Request) (Explanation, error) {
+	bindings, err := a.store.ListUserRoleBindings(ctx, user.ID)
+	if err != nil {
+		return Explanation{}, err
+	}
+	out := Explanation{Bindings: []BindingTrace{}}
+	for _, b := range bindings {
+		inScope := policies.MatchScope(b.Binding.Resource, req.Resource)
+		out.Bindings = append(out.Bindings, BindingTrace{
+			BindingID:   b.Binding.ID.String(),
+			Role:        b.Role.Name,
+			Group:       b.GroupName,
+			Resource:    b.Binding.Resource,
+			InScope:     inScope,
+			Grants:      inScope && roleAllows(b.Role.Permissions, req.Action),
+			Permissions: b.Role.Permissions,
+		})
+	}
+	grants, err := a.store.ListActiveAccessGrants(ctx, user.ID)
+	if err != nil {
+		return Explanation{}, err
+	}
+	for _, g := range grants {
+		inScope := policies.MatchScope(g.Request.Resource, req.Resource)
+		out.Bindings = append(out.Bindings, BindingTrace{
+			BindingID:   g.Request.ID.String(),
+			Role:        g.Role.Name,
+			Resource:    g.Request.Resource,
+			InScope:     inScope,
+			Grants:      inScope && roleAllows(g.Role.Permissions, req.Action),
+			Permissions: g.Role.Permissions,
+			Temporary:   true,
+			ExpiresAt:   g.Request.ExpiresAt,
+		})
+	}
+	allowedByRole, grant, err := a.roleGrant(ctx, user, req, bindings)
+	if err != nil {
+		return Explanation{}, err
+	}
+	decision, filled, err := a.decide(ctx, user, req, allowedByRole)
+	if err != nil {
+		return Explanation{}, err
+	}
+	if grant != nil {
+		decision.GrantID = &grant.Request.ID
+	}
+	if user.IsAdmin {
+		decision.Allowed = true
+		decision.Reason = "admin"
+	}
+	out.Decision = decision
+	out.Request = filled
+	return out, nil
+}
+
+func (a *Authorizer) decide(ctx context.Context, user store.User, req policies.Request, allowedByRole bool) (Decision, policies.Request, error) {
 	engine := a.polices.Engine()
-	allowedByPolicy, constraints := engine.Evaluate(action, resource, env)
 	if !engine.HasRules() {
-		return Decision{Allowed: true}, nil
+		if !allowedByRole {
+			return Decision{Reason: "no role grants action"}, req, nil
+		}
+		return Decision{Allowed: true, Reason: "no policies"}, req, nil
 	}
-	return Decision{Allowed: allowedByPolicy, Constraints: constraints}, nil
+	if req.Groups == nil {
+		groups, err := a.store.ListUserGroups(ctx, user.ID)
+		if err != nil {
+			return Decision{}, req, err
+		}
+		for _, group := range groups {
+			req.Groups = append(req.Groups, group.Name)
+		}
+	}
+	if req.UserAttributes == nil {
+		req.UserAttributes = user.Attributes
+	}
+	if req.Time.IsZero() {
+		req.Time = time.Now()
+	}
+	trace := engine.Explain(req)
+	if !allowedByRole {
+		return Decision{Reason: "no role grants action", Trace: &trace}, req, nil
+	}
+	return Decision{Allowed: trace.Allowed, Constraints: trace.Constraints, Reason: "policy", Trace: &trace}, req, nil
+}
+
+func bindingGrants(b store.UserRoleBinding, req policies.Request) bool {
+	return policies.MatchScope(b.Binding.Resource, req.Resource) && roleAllows(b.Role.Permissions, req.Action)
+}
+
+func grantAllows(g store.AccessGrant, req policies.Request, now time.Time) bool {
+	return g.Request.Active(now) &&
+		policies.MatchScope(g.Request.Resource, req.Resource) && roleAllows(g.Role.Permissions, req.Action)
 }
 
 func roleAllows(perms []string, action string) bool {
@@ -0,0 +1,68 @@
+package iam
+
+import (
+	"testing"
+	"time"
+
+	"flowdb/backend/policies"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+func TestGrantAllows(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	grant := store.AccessGrant{
+		Request: store.AccessRequest{ID: uuid.New(), Status: store.AccessApproved, Resource: "connection/c1", ExpiresAt: &expires},
+		Role:    store.Role{Name: "writer", Permissions: []string{"query:write"}},
+	}
+	withStatus := func(status string) store.AccessGrant {
+		g := grant
+		g.Request.Status = status
+		return g
+	}
+	write := func(resource string) policies.Request {
+		return policies.Request{Action: "query:write", Resource: resource}
+	}
+	cases := []struct {
+		name  string
+		grant store.AccessGrant
+		req   policies.Request
+		at    time.Time
+		want  bool
+	}{
+		{"inside window", grant, write("connection/c1/db/public/entity/orders"), now, true},
+		{"at expiry", grant, write("connection/c1"), expires, false},
+		{"after expiry", grant, write("connection/c1"), expires.Add(time.Second), false},
+		{"other connection", grant, write("connection/c2/db/public/entity/orders"), now, false},
+		{"parent of granted resource", store.AccessGrant{Request: store.AccessRequest{Status: store.AccessApproved, Resource: "connection/c1/db/public/entity/orders", ExpiresAt: &expires}, Role: grant.Role}, write("connection/c1"), now, false},
+		{"sibling entity", store.AccessGrant{Request: store.AccessRequest{Status: store.AccessApproved, Resource: "connection/c1/db/public/entity/orders", ExpiresAt: &expires}, Role: grant.Role}, write("connection/c1/db/public/entity/users"), now, false},
+		{"action not in role", grant, policies.Request{Action: "iam:write", Resource: "connection/c1"}, now, false},
+		{"revoked", withStatus(store.AccessRevoked), write("connection/c1"), now, false},
+		{"expired by sweep", withStatus(store.AccessExpired), write("connection/c1"), now, false},
+		{"pending", withStatus(store.AccessPending), write("connection/c1"), now, false},
+	}
+	for _, tc := range cases {
+		if got := grantAllows(tc.grant, tc.req, tc.at); got != tc.want {
+			t.Errorf("%s: grantAllows = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMatchGrantSkipsExpiredGrants(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	role := store.Role{Permissions: []string{"query:*"}}
+	expired := store.AccessGrant{Request: store.AccessRequest{ID: uuid.New(), Status: store.AccessApproved, Resource: "connection/c1", ExpiresAt: &past}, Role: role}
+	active := store.AccessGrant{Request: store.AccessRequest{ID: uuid.New(), Status: store.AccessApproved, Resource: "connection/c1", ExpiresAt: &future}, Role: role}
+	req := policies.Request{Action: "query:write", Resource: "connection/c1/db/public/entity/orders"}
+
+	if g := matchGrant([]store.AccessGrant{expired}, req, now); g != nil {
+		t.Fatalf("expired grant matched: %+v", g)
+	}
+	g := matchGrant([]store.AccessGrant{expired, active}, req, now)
+	if g == nil || g.Request.ID != active.Request.ID {
+		t.Fatalf("expected the active grant, got %+v", g)
+	}
+}
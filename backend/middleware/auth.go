@@ -1,14 +1,24 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"flowdb/backend/auth"
 	"flowdb/backend/store"
+
+	"github.com/google/uuid"
 )
 
-func RequireAuth(st *store.Store, sessions *auth.SessionManager) func(http.Handler) http.Handler {
+// EventLogger records audit events; it is satisfied by *audit.Logger.
+type EventLogger interface {
+	LogEvent(ctx context.Context, eventType string, userID *uuid.UUID, details map[string]any, errorID string) error
+}
+
+// RequireAuth loads the session and user of the request. Sessions found past
+// their absolute or idle timeout are deleted and audited as session_expired.
+func RequireAuth(st *store.Store, sessions *auth.SessionManager, events EventLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			sessID, ok := sessions.Get(r)
@@ -21,8 +31,18 @@ func RequireAuth(st *store.Store, sessions *auth.SessionManager) func(http.Handl
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
-			if time.Now().UTC().After(sess.ExpiresAt) {
+			now := time.Now().UTC()
+			if sessions.Expired(sess, now) {
 				_ = st.DeleteSession(r.Context(), sessID)
+				reason := "idle"
+				if now.After(sess.ExpiresAt) {
+					reason = "ttl"
+				}
+				_ = events.LogEvent(r.Context(), "session_expired", &sess.UserID, map[string]any{
+					"sessionId":  sess.ID.String(),
+					"reason":     reason,
+					"lastSeenAt": sess.LastSeenAt,
+				}, "")
 				http.Error(w, "session expired", http.StatusUnauthorized)
 				return
 			}
@@ -31,9 +51,27 @@ func RequireAuth(st *store.Store, sessions *auth.SessionManager) func(http.Handl
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
-			ctx := auth.WithUser(r.Context(), user)
+			client, ok := ClientFromContext(r.Context())
+			if !ok {
+				client = Client{IP: peerIP(r), UserAgent: r.UserAgent()}
+			}
+			sessions.Touch(r.Context(), sess, now, client.IP, client.UserAgent)
+			ctx, user := elevate(r.Context(), user, sess, now)
+			ctx = auth.WithUser(ctx, user)
 			ctx = auth.WithSession(ctx, sess)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// elevate makes user an admin for the request when sess carries a
+// break-glass elevation started on that same session and still active at
+// now.
+func elevate(ctx context.Context, user store.User, sess store.Session, now time.Time) (context.Context, store.User) {
+	bg := sess.BreakGlass
+	if bg == nil || bg.SessionID == nil || *bg.SessionID != sess.ID || bg.UserID != user.ID || !bg.Active(now) {
+		return ctx, user
+	}
+	user.IsAdmin = true
+	return auth.WithBreakGlass(ctx, *bg), user
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"flowdb/backend/auth"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+func TestElevate(t *testing.T) {
+	now := time.Now()
+	user := store.User{ID: uuid.New(), Username: "oncall"}
+	sessionID := uuid.New()
+	otherSession := uuid.New()
+	ended := now.Add(-time.Minute)
+	breakGlass := func(mutate func(*store.BreakGlass)) *store.BreakGlass {
+		bg := &store.BreakGlass{ID: uuid.New(), UserID: user.ID, SessionID: &sessionID, StartedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Minute)}
+		if mutate != nil {
+			mutate(bg)
+		}
+		return bg
+	}
+	cases := []struct {
+		name string
+		bg   *store.BreakGlass
+		at   time.Time
+		want bool
+	}{
+		{"active on this session", breakGlass(nil), now, true},
+		{"no break-glass", nil, now, false},
+		{"started on another session", breakGlass(func(b *store.BreakGlass) { b.SessionID = &otherSession }), now, false},
+		{"without session", breakGlass(func(b *store.BreakGlass) { b.SessionID = nil }), now, false},
+		{"other user", breakGlass(func(b *store.BreakGlass) { b.UserID = uuid.New() }), now, false},
+		{"at expiry", breakGlass(nil), now.Add(time.Minute), false},
+		{"ended early", breakGlass(func(b *store.BreakGlass) { b.EndedAt = &ended }), now, false},
+	}
+	for _, tc := range cases {
+		sess := store.Session{ID: sessionID, UserID: user.ID, BreakGlass: tc.bg}
+		ctx, got := elevate(context.Background(), user, sess, tc.at)
+		_, tagged := auth.BreakGlassFromContext(ctx)
+		if got.IsAdmin != tc.want || tagged != tc.want {
+			t.Errorf("%s: admin = %v, tagged = %v, want %v", tc.name, got.IsAdmin, tagged, tc.want)
+		}
+	}
+}
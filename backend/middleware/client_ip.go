@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const ClientKey contextKey = "client"
+
+// Client describes who sent the request.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// ClientInfo resolves the client address. X-Forwarded-For is only honoured
+// when the peer is a trusted proxy; the client is then the right-most address
+// that is not itself a trusted proxy, so a client cannot spoof its address by
+// sending the header.
+func ClientInfo(trustedProxies []string) func(http.Handler) http.Handler {
+	trusted := NewIPAllowlist(trustedProxies)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := Client{IP: resolveClientIP(r, trusted), UserAgent: r.UserAgent()}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ClientKey, client)))
+		})
+	}
+}
+
+func resolveClientIP(r *http.Request, trusted *IPAllowlist) string {
+	ip := peerIP(r)
+	if !trusted.Contains(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !trusted.Contains(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func peerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(ClientKey).(Client)
+	return client, ok
+}
+
+// RequestIP is the client address resolved by ClientInfo, or the peer
+// address when the middleware did not run.
+func RequestIP(r *http.Request) string {
+	if client, ok := ClientFromContext(r.Context()); ok {
+		return client.IP
+	}
+	return peerIP(r)
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientInfo(t *testing.T) {
+	cases := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct", "198.51.100.1:5000", "", "198.51.100.1"},
+		{"untrusted peer ignores header", "198.51.100.1:5000", "203.0.113.9", "198.51.100.1"},
+		{"trusted proxy", "10.0.0.2:5000", "203.0.113.9", "203.0.113.9"},
+		{"spoofed left-most hop", "10.0.0.2:5000", "1.2.3.4, 203.0.113.9", "203.0.113.9"},
+		{"proxy chain", "10.0.0.2:5000", "203.0.113.9, 10.0.0.3", "203.0.113.9"},
+		{"only proxies", "10.0.0.2:5000", "10.0.0.3", "10.0.0.3"},
+		{"garbage hop", "10.0.0.2:5000", "not-an-ip", "10.0.0.2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Client
+			handler := ClientInfo([]string{"10.0.0.0/8"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = ClientFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remote
+			req.Header.Set("User-Agent", "test-agent")
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got.IP != tc.want || got.UserAgent != "test-agent" {
+				t.Fatalf("got %+v, want ip %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -37,10 +37,7 @@ func (a *IPAllowlist) Contains(ip string) bool {
 func IPAllowlistMiddleware(allowlist *IPAllowlist) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-			if ip == "" {
-				ip = r.RemoteAddr
-			}
+			ip := RequestIP(r)
 			if !allowlist.Contains(ip) {
 				http.Error(w, "forbidden", http.StatusForbidden)
 				return
@@ -1,7 +1,6 @@
 package middleware
 
 import (
-	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -37,10 +36,7 @@ func (l *RateLimiter) getLimiter(ip string) *rate.Limiter {
 
 func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-		if ip == "" {
-			ip = r.RemoteAddr
-		}
+		ip := RequestIP(r)
 		if !l.getLimiter(ip).Allow() {
 			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
 			return
@@ -1,7 +1,6 @@
 package middleware
 
 import (
-	"net"
 	"net/http"
 
 	"flowdb/backend/settings"
@@ -13,10 +12,7 @@ func SecurityControls(store *settings.Store, mtlsHeader string) func(http.Handle
 			current := store.Get()
 			if current.FlagEnabled("enable_ip_allowlist") {
 				allow := NewIPAllowlist(current.IPAllowlist)
-				ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-				if ip == "" {
-					ip = r.RemoteAddr
-				}
+				ip := RequestIP(r)
 				if !allow.Contains(ip) {
 					http.Error(w, "forbidden", http.StatusForbidden)
 					return
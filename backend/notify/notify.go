@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRecipients says who is emailed for each event unless
+// NOTIFY_RECIPIENTS overrides it. "subject" is the user the event is about:
+// details.userId when present, otherwise the actor.
+var DefaultRecipients = map[string][]string{
+	"query_approval_requested": {"role:admin"},
+	"query_approval_approved":  {"subject"},
+	"query_approval_denied":    {"subject"},
+	"access_grant_expiring":    {"subject"},
+	"login_new_device":         {"subject"},
+	"mfa_enroll":               {"subject"},
+	"mfa_enabled":              {"subject"},
+	"break_glass_started":      {"role:admin"},
+	"break_glass_ended":        {"role:admin"},
+}
+
+// Events lists the events that can be emailed, for opt-out preferences.
+func Events() []string {
+	events := make([]string, 0, len(templates))
+	for event := range templates {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	return events
+}
+
+// OptedOut reports whether the preference list excludes event.
+func OptedOut(optOut []string, event string) bool {
+	return slices.Contains(optOut, "*") || slices.Contains(optOut, event)
+}
+
+// Notifier emails audit events to the recipients configured for them. It
+// is registered as an audit sink; mail is sent in the background.
+type Notifier struct {
+	store      *store.Store
+	mailer     Mailer
+	recipients map[string][]string
+	appURL     string
+	logger     *slog.Logger
+}
+
+func NewNotifier(st *store.Store, mailer Mailer, recipients map[string][]string, appURL string, logger *slog.Logger) *Notifier {
+	merged := make(map[string][]string, len(DefaultRecipients))
+	for event, rules := range DefaultRecipients {
+		merged[event] = rules
+	}
+	for event, rules := range recipients {
+		merged[event] = rules
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Notifier{store: st, mailer: mailer, recipients: merged, appURL: strings.TrimRight(appURL, "/"), logger: logger}
+}
+
+func (n *Notifier) Publish(_ context.Context, eventType string, userID *uuid.UUID, details map[string]any) {
+	if _, ok := templates[eventType]; !ok || len(n.recipients[eventType]) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := n.notify(ctx, eventType, userID, details, time.Now().UTC()); err != nil {
+			n.logger.Error("notification failed", "event", eventType, "error", err)
+		}
+	}()
+}
+
+func (n *Notifier) notify(ctx context.Context, eventType string, actorID *uuid.UUID, details map[string]any, at time.Time) error {
+	var userIDs []uuid.UUID
+	var roles, groups, addresses []string
+	for _, rule := range n.recipients[eventType] {
+		kind, name, _ := strings.Cut(rule, ":")
+		switch {
+		case rule == "subject":
+			if id := subjectID(actorID, details); id != nil {
+				userIDs = append(userIDs, *id)
+			}
+		case kind == "role":
+			roles = append(roles, name)
+		case kind == "group":
+			groups = append(groups, name)
+		case strings.Contains(rule, "@"):
+			addresses = append(addresses, rule)
+		}
+	}
+	recipients, err := n.store.ListNotificationRecipients(ctx, userIDs, roles, groups)
+	if err != nil {
+		return err
+	}
+	actor := ""
+	if actorID != nil {
+		if user, err := n.store.GetUserByID(ctx, *actorID); err == nil {
+			actor = user.Username
+		}
+	}
+	tmpl := templates[eventType]
+	send := func(to string, name string) {
+		msg, err := tmpl.render(templateData{
+			Event:     eventType,
+			Recipient: name,
+			Actor:     actor,
+			Details:   details,
+			AppURL:    n.appURL,
+			Time:      at,
+		})
+		if err != nil {
+			n.logger.Error("notification render failed", "event", eventType, "error", err)
+			return
+		}
+		msg.To = to
+		if err := n.mailer.Send(ctx, msg); err != nil {
+			n.logger.Error("notification send failed", "event", eventType, "to", to, "error", err)
+		}
+	}
+	sent := map[string]bool{}
+	for _, r := range recipients {
+		if r.Email == "" || sent[r.Email] || OptedOut(r.OptOut, eventType) {
+			continue
+		}
+		sent[r.Email] = true
+		send(r.Email, r.Username)
+	}
+	for _, address := range addresses {
+		if !sent[address] {
+			sent[address] = true
+			send(address, address)
+		}
+	}
+	return nil
+}
+
+func subjectID(actorID *uuid.UUID, details map[string]any) *uuid.UUID {
+	if raw, ok := details["userId"].(string); ok {
+		if id, err := uuid.Parse(raw); err == nil {
+			return &id
+		}
+	}
+	return actorID
+}
@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a minimal SMTP stand-in that records one message.
+type fakeSMTP struct {
+	listener net.Listener
+	rcpt     chan string
+	data     chan string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSMTP{listener: ln, rcpt: make(chan string, 1), data: make(chan string, 1)}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeSMTP) serve() {
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM"):
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO"):
+			f.rcpt <- strings.TrimSpace(line[len("RCPT TO:"):])
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var body strings.Builder
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				body.WriteString(dataLine)
+			}
+			f.data <- body.String()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	mailer := &SMTPMailer{Host: host, Port: portNum, From: "flowdb@example.com"}
+	msg, err := templates["query_approval_denied"].render(templateData{
+		Actor:   "bob",
+		Details: map[string]any{"approvalId": "a-1"},
+		Time:    time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.To = "alice@example.com"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := mailer.Send(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if rcpt := <-server.rcpt; rcpt != "<alice@example.com>" {
+		t.Fatalf("unexpected recipient %q", rcpt)
+	}
+	data := <-server.data
+	for _, want := range []string{
+		"To: alice@example.com",
+		"Subject: [FlowDB] Your query was denied",
+		"multipart/alternative",
+		"text/plain; charset=utf-8",
+		"text/html; charset=utf-8",
+		"bob denied your query approval a-1.",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPMailerRequireTLS(t *testing.T) {
+	server := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	mailer := &SMTPMailer{Host: host, Port: portNum, From: "flowdb@example.com", RequireTLS: true}
+	if err := mailer.Send(context.Background(), Message{To: "alice@example.com"}); err == nil {
+		t.Fatal("expected error without STARTTLS")
+	}
+}
+
+func TestTemplatesRender(t *testing.T) {
+	for event, tmpl := range templates {
+		msg, err := tmpl.render(templateData{Event: event, Actor: "bob", Details: map[string]any{}, Time: time.Now()})
+		if err != nil {
+			t.Fatalf("%s: %v", event, err)
+		}
+		if msg.Subject == "" || msg.Text == "" || msg.HTML == "" {
+			t.Errorf("%s: empty part", event)
+		}
+		if strings.Contains(msg.Text+msg.HTML, "<no value>") {
+			t.Errorf("%s: missing details rendered as <no value>", event)
+		}
+	}
+}
+
+func TestOptedOut(t *testing.T) {
+	if !OptedOut([]string{"*"}, "mfa_enroll") || !OptedOut([]string{"mfa_enroll"}, "mfa_enroll") {
+		t.Fatal("expected opt-out")
+	}
+	if OptedOut([]string{"login_new_device"}, "mfa_enroll") || OptedOut(nil, "mfa_enroll") {
+		t.Fatal("unexpected opt-out")
+	}
+}
@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// templateData is what every email template is rendered with.
+type templateData struct {
+	Event     string
+	Recipient string
+	Actor     string
+	Details   map[string]any
+	AppURL    string
+	Time      time.Time
+}
+
+type emailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+func (t emailTemplate) render(data templateData) (Message, error) {
+	var subject, text, html strings.Builder
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+	return Message{Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
+
+const htmlLayout = `{{define "layout"}}<!DOCTYPE html>
+<html><body style="font-family:sans-serif;color:#1f2933">
+<h2 style="font-size:18px">{{template "title" .}}</h2>
+{{template "body" .}}
+{{if .AppURL}}<p><a href="{{.AppURL}}">Open FlowDB</a></p>{{end}}
+<p style="color:#7b8794;font-size:12px">{{.Time.Format "2006-01-02 15:04 MST"}} &middot; You can turn off these emails in your FlowDB notification settings.</p>
+</body></html>{{end}}`
+
+const textFooter = `{{if .AppURL}}
+Open FlowDB: {{.AppURL}}{{end}}
+
+{{.Time.Format "2006-01-02 15:04 MST"}}
+You can turn off these emails in your FlowDB notification settings.
+`
+
+var templateSources = map[string]struct {
+	subject string
+	title   string
+	text    string
+	html    string
+}{
+	"query_approval_requested": {
+		subject: "[FlowDB] Approval requested by {{.Actor}}",
+		title:   "Query approval requested",
+		text: `{{.Actor}} asked for approval to run a write query on {{detail .Details "environment"}}.
+Reason: {{detail .Details "reason"}}
+{{with detail .Details "ticketUrl"}}Ticket: {{.}}
+{{end}}Approval: {{detail .Details "approvalId"}}`,
+		html: `<p><b>{{.Actor}}</b> asked for approval to run a write query on <b>{{detail .Details "environment"}}</b>.</p>
+<p>Reason: {{detail .Details "reason"}}</p>
+{{with detail .Details "ticketUrl"}}<p>Ticket: {{.}}</p>{{end}}
+<p>Approval: <code>{{detail .Details "approvalId"}}</code></p>`,
+	},
+	"query_approval_approved": {
+		subject: "[FlowDB] Your query was approved",
+		title:   "Query approved",
+		text: `Your query approval {{detail .Details "approvalId"}} was approved; the last vote came from {{.Actor}}.
+Run the same statement with the approval id before it expires.`,
+		html: `<p>Your query approval <code>{{detail .Details "approvalId"}}</code> was approved; the last vote came from <b>{{.Actor}}</b>.</p>
+<p>Run the same statement with the approval id before it expires.</p>`,
+	},
+	"query_approval_denied": {
+		subject: "[FlowDB] Your query was denied",
+		title:   "Query denied",
+		text:    `{{.Actor}} denied your query approval {{detail .Details "approvalId"}}.`,
+		html:    `<p><b>{{.Actor}}</b> denied your query approval <code>{{detail .Details "approvalId"}}</code>.</p>`,
+	},
+	"access_grant_expiring": {
+		subject: "[FlowDB] Temporary access expires soon",
+		title:   "Temporary access expires soon",
+		text: `Your temporary access{{with detail .Details "resource"}} to {{.}}{{end}} expires at {{detail .Details "expiresAt"}}.
+Request access again if you still need it.`,
+		html: `<p>Your temporary access{{with detail .Details "resource"}} to <code>{{.}}</code>{{end}} expires at {{detail .Details "expiresAt"}}.</p>
+<p>Request access again if you still need it.</p>`,
+	},
+	"login_new_device": {
+		subject: "[FlowDB] New sign-in to your account",
+		title:   "New sign-in",
+		text: `Your account signed in from a device we have not seen before.
+IP: {{detail .Details "ip"}}
+Browser: {{detail .Details "userAgent"}}
+If this was not you, change your password and contact an administrator.`,
+		html: `<p>Your account signed in from a device we have not seen before.</p>
+<p>IP: {{detail .Details "ip"}}<br>Browser: {{detail .Details "userAgent"}}</p>
+<p>If this was not you, change your password and contact an administrator.</p>`,
+	},
+	"mfa_enroll": {
+		subject: "[FlowDB] A new MFA secret was created",
+		title:   "MFA secret created",
+		text:    `A new MFA secret was generated for your account. If this was not you, contact an administrator.`,
+		html:    `<p>A new MFA secret was generated for your account. If this was not you, contact an administrator.</p>`,
+	},
+	"mfa_enabled": {
+		subject: "[FlowDB] MFA is now enabled",
+		title:   "MFA enabled",
+		text:    `Multi-factor authentication was enabled on your account. If this was not you, contact an administrator.`,
+		html:    `<p>Multi-factor authentication was enabled on your account. If this was not you, contact an administrator.</p>`,
+	},
+	"break_glass_started": {
+		subject: "[FlowDB] Break-glass started by {{.Actor}}",
+		title:   "Break-glass started",
+		text: `{{.Actor}} elevated their session to admin until {{detail .Details "expiresAt"}}.
+Reason: {{detail .Details "reason"}}
+Review the elevation once it ends: {{detail .Details "breakGlassId"}}`,
+		html: `<p><b>{{.Actor}}</b> elevated their session to admin until {{detail .Details "expiresAt"}}.</p>
+<p>Reason: {{detail .Details "reason"}}</p>
+<p>Review the elevation once it ends: <code>{{detail .Details "breakGlassId"}}</code></p>`,
+	},
+	"break_glass_ended": {
+		subject: "[FlowDB] Break-glass ended",
+		title:   "Break-glass ended",
+		text:    `{{.Actor}} ended break-glass {{detail .Details "breakGlassId"}}. It is now awaiting review.`,
+		html:    `<p><b>{{.Actor}}</b> ended break-glass <code>{{detail .Details "breakGlassId"}}</code>. It is now awaiting review.</p>`,
+	},
+}
+
+var templates = parseTemplates()
+
+var templateFuncs = map[string]any{
+	// detail renders a details value, or nothing when it is missing.
+	"detail": func(details map[string]any, key string) string {
+		value, ok := details[key]
+		if !ok || value == nil {
+			return ""
+		}
+		return fmt.Sprint(value)
+	},
+}
+
+func parseTemplates() map[string]emailTemplate {
+	parsed := make(map[string]emailTemplate, len(templateSources))
+	for event, src := range templateSources {
+		html := htmltemplate.Must(htmltemplate.New(event).Funcs(templateFuncs).Parse(htmlLayout))
+		htmltemplate.Must(html.New("title").Parse(src.title))
+		htmltemplate.Must(html.New("body").Parse(src.html))
+		parsed[event] = emailTemplate{
+			subject: texttemplate.Must(texttemplate.New(event).Funcs(templateFuncs).Parse(src.subject)),
+			text:    texttemplate.Must(texttemplate.New(event).Funcs(templateFuncs).Parse(src.text + "\n" + textFooter)),
+			html:    html.Lookup("layout"),
+		}
+	}
+	return parsed
+}
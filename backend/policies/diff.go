@@ -0,0 +1,91 @@
+package policies
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Change is one difference between two policy documents. Path uses dots for
+// object keys and [i] for array elements.
+type Change struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// Diff compares two JSON documents structurally. A nil document is treated as
+// absent, so creating or deleting a policy yields a single change at the root.
+func Diff(oldRaw []byte, newRaw []byte) []Change {
+	var oldDoc, newDoc any
+	if len(oldRaw) > 0 {
+		_ = json.Unmarshal(oldRaw, &oldDoc)
+	}
+	if len(newRaw) > 0 {
+		_ = json.Unmarshal(newRaw, &newDoc)
+	}
+	changes := []Change{}
+	diffValue("", oldDoc, newDoc, &changes)
+	return changes
+}
+
+func diffValue(at string, oldVal any, newVal any, changes *[]Change) {
+	switch {
+	case oldVal == nil && newVal == nil:
+		return
+	case oldVal == nil:
+		*changes = append(*changes, Change{Op: "add", Path: at, New: newVal})
+		return
+	case newVal == nil:
+		*changes = append(*changes, Change{Op: "remove", Path: at, Old: oldVal})
+		return
+	}
+	oldMap, oldIsMap := oldVal.(map[string]any)
+	newMap, newIsMap := newVal.(map[string]any)
+	if oldIsMap && newIsMap {
+		keys := map[string]bool{}
+		for k := range oldMap {
+			keys[k] = true
+		}
+		for k := range newMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			child := k
+			if at != "" {
+				child = at + "." + k
+			}
+			diffValue(child, oldMap[k], newMap[k], changes)
+		}
+		return
+	}
+	oldList, oldIsList := oldVal.([]any)
+	newList, newIsList := newVal.([]any)
+	if oldIsList && newIsList {
+		n := len(oldList)
+		if len(newList) > n {
+			n = len(newList)
+		}
+		for i := 0; i < n; i++ {
+			var o, v any
+			if i < len(oldList) {
+				o = oldList[i]
+			}
+			if i < len(newList) {
+				v = newList[i]
+			}
+			diffValue(fmt.Sprintf("%s[%d]", at, i), o, v, changes)
+		}
+		return
+	}
+	if !reflect.DeepEqual(oldVal, newVal) {
+		*changes = append(*changes, Change{Op: "change", Path: at, Old: oldVal, New: newVal})
+	}
+}
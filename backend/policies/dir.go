@@ -0,0 +1,215 @@
+package policies
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ManagedByFile marks policies, roles and bindings loaded from POLICY_DIR.
+const ManagedByFile = "file"
+
+var builtinRoles = []string{"admin", "editor", "viewer"}
+
+// Bundle is the content of a policy directory.
+type Bundle struct {
+	Policies []NamedPolicy
+	Roles    []RoleSpec
+	Bindings []BindingSpec
+	Hash     string
+}
+
+type NamedPolicy struct {
+	Name string
+	Doc  []byte
+	File string
+}
+
+type RoleSpec struct {
+	Name        string   `yaml:"name" json:"name"`
+	Permissions []string `yaml:"permissions" json:"permissions"`
+}
+
+type BindingSpec struct {
+	Role     string `yaml:"role" json:"role"`
+	User     string `yaml:"user,omitempty" json:"user,omitempty"`
+	Group    string `yaml:"group,omitempty" json:"group,omitempty"`
+	Resource string `yaml:"resource,omitempty" json:"resource,omitempty"`
+}
+
+type fileSpec struct {
+	Policies []struct {
+		Name string `yaml:"name"`
+		Doc  any    `yaml:"doc"`
+	} `yaml:"policies"`
+	Roles    []RoleSpec    `yaml:"roles"`
+	Bindings []BindingSpec `yaml:"bindings"`
+}
+
+// FileIssue is a lint issue located in a file of the policy directory.
+type FileIssue struct {
+	File string `json:"file"`
+	Issue
+}
+
+func (i FileIssue) String() string {
+	where := i.File
+	if i.Path != "" {
+		where += ": " + i.Path
+	}
+	return fmt.Sprintf("%s: %s: %s", where, i.Severity, i.Message)
+}
+
+// LoadDir reads every .yaml, .yml and .json file under dir and validates the
+// combined bundle. The bundle should not be applied when any issue is an
+// error.
+func LoadDir(dir string) (Bundle, []FileIssue, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return Bundle{}, nil, err
+	}
+	sort.Strings(files)
+	var bundle Bundle
+	issues := []FileIssue{}
+	addIssue := func(file, severity, path, format string, args ...any) {
+		issues = append(issues, FileIssue{File: file, Issue: Issue{Severity: severity, Path: path, Message: fmt.Sprintf(format, args...)}})
+	}
+	hash := sha256.New()
+	policyFiles := map[string]string{}
+	roleFiles := map[string]string{}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return Bundle{}, nil, err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		hash.Write([]byte(rel))
+		hash.Write([]byte{0})
+		hash.Write(raw)
+		var spec fileSpec
+		dec := yaml.NewDecoder(bytes.NewReader(raw))
+		dec.KnownFields(true)
+		if err := dec.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+			addIssue(rel, SeverityError, "", "invalid file: %v", err)
+			continue
+		}
+		for i, p := range spec.Policies {
+			at := fmt.Sprintf("policies[%d]", i)
+			if strings.TrimSpace(p.Name) == "" {
+				addIssue(rel, SeverityError, at+".name", "name is required")
+				continue
+			}
+			if prev, ok := policyFiles[p.Name]; ok {
+				addIssue(rel, SeverityError, at+".name", "policy %q already defined in %s", p.Name, prev)
+				continue
+			}
+			policyFiles[p.Name] = rel
+			doc, err := json.Marshal(p.Doc)
+			if err != nil || p.Doc == nil {
+				addIssue(rel, SeverityError, at+".doc", "doc must be an object")
+				continue
+			}
+			for _, issue := range Lint(doc) {
+				issue.Path = strings.TrimSuffix(at+".doc."+issue.Path, ".")
+				issues = append(issues, FileIssue{File: rel, Issue: issue})
+			}
+			bundle.Policies = append(bundle.Policies, NamedPolicy{Name: p.Name, Doc: doc, File: rel})
+		}
+		for i, role := range spec.Roles {
+			at := fmt.Sprintf("roles[%d]", i)
+			if strings.TrimSpace(role.Name) == "" {
+				addIssue(rel, SeverityError, at+".name", "name is required")
+				continue
+			}
+			if isBuiltinRole(role.Name) {
+				addIssue(rel, SeverityError, at+".name", "role %q is built in and cannot be redefined", role.Name)
+				continue
+			}
+			if prev, ok := roleFiles[role.Name]; ok {
+				addIssue(rel, SeverityError, at+".name", "role %q already defined in %s", role.Name, prev)
+				continue
+			}
+			roleFiles[role.Name] = rel
+			for j, perm := range role.Permissions {
+				if !KnownAction(perm) {
+					addIssue(rel, SeverityError, fmt.Sprintf("%s.permissions[%d]", at, j), "unknown action %q", perm)
+				}
+			}
+			bundle.Roles = append(bundle.Roles, role)
+		}
+		for i, b := range spec.Bindings {
+			at := fmt.Sprintf("bindings[%d]", i)
+			if b.Role == "" {
+				addIssue(rel, SeverityError, at+".role", "role is required")
+				continue
+			}
+			if (b.User == "") == (b.Group == "") {
+				addIssue(rel, SeverityError, at, "exactly one of user or group is required")
+				continue
+			}
+			bundle.Bindings = append(bundle.Bindings, b)
+		}
+	}
+	for _, b := range bundle.Bindings {
+		if _, ok := roleFiles[b.Role]; !ok && !isBuiltinRole(b.Role) {
+			addIssue("", SeverityWarning, "bindings", "role %q is not defined in the policy directory", b.Role)
+		}
+	}
+	if !HasFileErrors(issues) {
+		sources := make([]Source, 0, len(bundle.Policies))
+		for _, p := range bundle.Policies {
+			sources = append(sources, Source{Name: p.Name, Doc: p.Doc})
+		}
+		if _, err := NewEngineFromSources(sources); err != nil {
+			addIssue("", SeverityError, "", "%v", err)
+		}
+	}
+	bundle.Hash = hex.EncodeToString(hash.Sum(nil))
+	return bundle, issues, nil
+}
+
+// HasFileErrors reports whether any issue is an error.
+func HasFileErrors(issues []FileIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func isBuiltinRole(name string) bool {
+	for _, role := range builtinRoles {
+		if role == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package policies
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "analysts.yaml", `
+policies:
+  - name: analysts
+    doc:
+      default_effect: deny
+      rules:
+        - effect: allow
+          actions: [query:read]
+          resources: ["connection/*/db/**"]
+          conditions:
+            max_rows: 1000
+roles:
+  - name: analyst
+    permissions: [query:read, connection:read]
+bindings:
+  - role: analyst
+    group: data-team
+`)
+	writeFile(t, dir, "billing.json", `{"policies":[{"name":"billing","doc":{"rules":[{"effect":"deny","actions":["query:*"],"resources":["connection/*/db/billing/**"]}]}}]}`)
+	writeFile(t, dir, "README.md", "ignored")
+	bundle, issues, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(issues) != 0 {
+		t.Fatalf("unexpected issues %v", issues)
+	}
+	if len(bundle.Policies) != 2 || len(bundle.Roles) != 1 || len(bundle.Bindings) != 1 || bundle.Hash == "" {
+		t.Fatalf("unexpected bundle %+v", bundle)
+	}
+	doc, err := ParseDocument(bundle.Policies[0].Doc)
+	if err != nil || doc.DefaultEffect != EffectDeny || *doc.Rules[0].Conditions.MaxRows != 1000 {
+		t.Fatalf("unexpected doc %+v, %v", doc, err)
+	}
+
+	writeFile(t, dir, "broken.yaml", `
+policies:
+  - name: analysts
+    doc: {rules: []}
+roles:
+  - name: ops
+    permissions: [query:run]
+  - name: admin
+    permissions: ["*"]
+bindings:
+  - role: ops
+    user: alice
+    group: ops
+`)
+	bundle2, issues, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !HasFileErrors(issues) || len(issues) != 4 {
+		t.Fatalf("expected duplicate policy, unknown permission, built-in role and binding errors, got %v", issues)
+	}
+	if bundle2.Hash == bundle.Hash {
+		t.Fatalf("expected hash to change")
+	}
+}
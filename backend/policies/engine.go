@@ -2,17 +2,30 @@ package policies
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 	"path"
+	"regexp"
 	"strings"
+	"sync"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
 )
 
 type Document struct {
 	Version string `json:"version"`
-	Rules   []Rule `json:"rules"`
+	// DefaultEffect applies when no rule in the document matches a request.
+	// It ranks below every explicit rule.
+	DefaultEffect string `json:"default_effect,omitempty"`
+	Rules         []Rule `json:"rules"`
 }
 
 type Rule struct {
 	Effect     string     `json:"effect"`
+	Priority   int        `json:"priority,omitempty"`
 	Actions    []string   `json:"actions"`
 	Resources  []string   `json:"resources"`
 	Conditions Conditions `json:"conditions"`
@@ -24,71 +37,208 @@ type Conditions struct {
 	TimeoutMs    *int     `json:"timeout_ms,omitempty"`
 	ReadOnly     *bool    `json:"read_only,omitempty"`
 	Environment  []string `json:"environment,omitempty"`
+
+	TimeWindows    []TimeWindow        `json:"time_windows,omitempty"`
+	SourceCIDRs    []string            `json:"source_cidrs,omitempty"`
+	MFAMaxAgeSec   *int                `json:"mfa_max_age_sec,omitempty"`
+	Groups         []string            `json:"groups,omitempty"`
+	UserAttributes map[string][]string `json:"user_attributes,omitempty"`
+	ConnectionTags map[string][]string `json:"connection_tags,omitempty"`
 }
 
 type Constraints struct {
-	RequireWhere bool
-	MaxRows      int
-	TimeoutMs    int
-	ReadOnly     bool
+	RequireWhere bool `json:"requireWhere"`
+	MaxRows      int  `json:"maxRows"`
+	TimeoutMs    int  `json:"timeoutMs"`
+	ReadOnly     bool `json:"readOnly"`
 }
 
 type Engine struct {
 	policies []Document
+	names    []string
+}
+
+// Source is a named raw policy document.
+type Source struct {
+	Name string
+	Doc  []byte
 }
 
 func NewEngine(rawPolicies [][]byte) (*Engine, error) {
-	var docs []Document
+	sources := make([]Source, 0, len(rawPolicies))
 	for _, raw := range rawPolicies {
-		var doc Document
-		if err := json.Unmarshal(raw, &doc); err != nil {
+		sources = append(sources, Source{Doc: raw})
+	}
+	return NewEngineFromSources(sources)
+}
+
+// NewEngineFromSources is like NewEngine but keeps policy names for traces.
+func NewEngineFromSources(sources []Source) (*Engine, error) {
+	engine := &Engine{}
+	for _, src := range sources {
+		doc, err := ParseDocument(src.Doc)
+		if err != nil {
+			if src.Name != "" {
+				return nil, fmt.Errorf("policy %s: %w", src.Name, err)
+			}
 			return nil, err
 		}
-		docs = append(docs, doc)
+		engine.policies = append(engine.policies, doc)
+		engine.names = append(engine.names, src.Name)
 	}
-	return &Engine{policies: docs}, nil
+	return engine, nil
 }
 
-func (e *Engine) Evaluate(action string, resource string, env string) (bool, Constraints) {
-	allowed := false
-	constraints := Constraints{
-		MaxRows:   0,
-		TimeoutMs: 0,
+// ParseDocument decodes and validates a policy document.
+func ParseDocument(raw []byte) (Document, error) {
+	var doc Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return Document{}, err
 	}
-	for _, doc := range e.policies {
-		for _, rule := range doc.Rules {
-			if strings.ToLower(rule.Effect) != "allow" {
-				continue
-			}
-			if !matchesAny(rule.Actions, action) {
-				continue
-			}
-			if !matchesAnyResource(rule.Resources, resource) {
-				continue
-			}
-			if len(rule.Conditions.Environment) > 0 && !matchesAny(rule.Conditions.Environment, env) {
-				continue
-			}
-			allowed = true
-			if rule.Conditions.RequireWhere != nil && *rule.Conditions.RequireWhere {
-				constraints.RequireWhere = true
-			}
-			if rule.Conditions.ReadOnly != nil && *rule.Conditions.ReadOnly {
-				constraints.ReadOnly = true
-			}
-			if rule.Conditions.MaxRows != nil {
-				if constraints.MaxRows == 0 || *rule.Conditions.MaxRows < constraints.MaxRows {
-					constraints.MaxRows = *rule.Conditions.MaxRows
-				}
+	if err := doc.normalize(); err != nil {
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+func (e *Engine) name(i int) string {
+	if i < len(e.names) && e.names[i] != "" {
+		return e.names[i]
+	}
+	return fmt.Sprintf("policy-%d", i)
+}
+
+func (d *Document) normalize() error {
+	d.DefaultEffect = strings.ToLower(strings.TrimSpace(d.DefaultEffect))
+	if d.DefaultEffect != "" && d.DefaultEffect != EffectAllow && d.DefaultEffect != EffectDeny {
+		return fmt.Errorf("invalid default_effect %q", d.DefaultEffect)
+	}
+	for i := range d.Rules {
+		effect := strings.ToLower(strings.TrimSpace(d.Rules[i].Effect))
+		if effect != EffectAllow && effect != EffectDeny {
+			return fmt.Errorf("rule %d: invalid effect %q", i, d.Rules[i].Effect)
+		}
+		d.Rules[i].Effect = effect
+		if err := d.Rules[i].Conditions.validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Evaluate decides whether action on resource is allowed. Among matching
+// rules only those with the highest priority are considered; within that tier
+// a deny overrides any allow. Constraints are combined from every allow rule
+// in the deciding tier, keeping the most restrictive values. A document whose
+// rules do not match contributes its default effect below all explicit rules.
+func (e *Engine) Evaluate(req Request) (bool, Constraints) {
+	trace := e.Explain(req)
+	return trace.Allowed, trace.Constraints
+}
+
+// Explain evaluates req like Evaluate and records, for every rule, whether it
+// matched and why not.
+func (e *Engine) Explain(req Request) Trace {
+	trace := Trace{Rules: []RuleTrace{}}
+	if e == nil {
+		return trace
+	}
+	var matched []int
+	var docOf []int
+	rank := func(rt RuleTrace) int {
+		if rt.Default {
+			return math.MinInt
+		}
+		return rt.Priority
+	}
+	for di, doc := range e.policies {
+		name := e.name(di)
+		docMatched := false
+		for i, rule := range doc.Rules {
+			rt := RuleTrace{Policy: name, Rule: i, Effect: rule.Effect, Priority: rule.Priority}
+			if reason := rule.mismatch(req); reason != "" {
+				rt.Reason = reason
+			} else {
+				rt.Matched = true
+				docMatched = true
+				matched = append(matched, len(trace.Rules))
 			}
-			if rule.Conditions.TimeoutMs != nil {
-				if constraints.TimeoutMs == 0 || *rule.Conditions.TimeoutMs < constraints.TimeoutMs {
-					constraints.TimeoutMs = *rule.Conditions.TimeoutMs
+			trace.Rules = append(trace.Rules, rt)
+			docOf = append(docOf, di)
+		}
+		if !docMatched && doc.DefaultEffect != "" {
+			matched = append(matched, len(trace.Rules))
+			trace.Rules = append(trace.Rules, RuleTrace{Policy: name, Rule: -1, Effect: doc.DefaultEffect, Default: true, Matched: true})
+			docOf = append(docOf, di)
+		}
+	}
+	if len(matched) == 0 {
+		return trace
+	}
+	top := math.MinInt
+	for _, idx := range matched {
+		if r := rank(trace.Rules[idx]); r > top {
+			top = r
+		}
+	}
+	denied := false
+	for _, idx := range matched {
+		rt := trace.Rules[idx]
+		if rank(rt) == top && rt.Effect == EffectDeny {
+			denied = true
+		}
+	}
+	for _, idx := range matched {
+		rt := &trace.Rules[idx]
+		switch {
+		case rank(*rt) != top:
+			rt.Reason = "outranked by higher priority rule"
+		case denied && rt.Effect == EffectAllow:
+			rt.Reason = "overridden by deny"
+		default:
+			rt.Deciding = true
+			if rt.Effect == EffectAllow {
+				trace.Allowed = true
+				if !rt.Default {
+					trace.Constraints = trace.Constraints.Merge(e.policies[docOf[idx]].Rules[rt.Rule].Conditions.constraints())
 				}
 			}
 		}
 	}
-	return allowed, constraints
+	if denied {
+		trace.Allowed = false
+		trace.Constraints = Constraints{}
+	}
+	return trace
+}
+
+// mismatch returns the first condition of the rule that req fails, or ""
+// when the rule matches.
+func (r Rule) mismatch(req Request) string {
+	if !matchesAction(r.Actions, req.Action) {
+		return "action"
+	}
+	if !r.matchesResource(req.Resource) {
+		return "resource"
+	}
+	return r.Conditions.mismatch(req)
+}
+
+func (c Conditions) constraints() Constraints {
+	var out Constraints
+	if c.RequireWhere != nil {
+		out.RequireWhere = *c.RequireWhere
+	}
+	if c.ReadOnly != nil {
+		out.ReadOnly = *c.ReadOnly
+	}
+	if c.MaxRows != nil {
+		out.MaxRows = *c.MaxRows
+	}
+	if c.TimeoutMs != nil {
+		out.TimeoutMs = *c.TimeoutMs
+	}
+	return out
 }
 
 func (e *Engine) HasRules() bool {
@@ -96,7 +246,7 @@ func (e *Engine) HasRules() bool {
 		return false
 	}
 	for _, doc := range e.policies {
-		if len(doc.Rules) > 0 {
+		if len(doc.Rules) > 0 || doc.DefaultEffect != "" {
 			return true
 		}
 	}
@@ -112,6 +262,112 @@ func matchesAny(list []string, value string) bool {
 	return false
 }
 
+// Merge combines constraints from several authorization decisions, keeping
+// the most restrictive value of each.
+func (c Constraints) Merge(other Constraints) Constraints {
+	c.RequireWhere = c.RequireWhere || other.RequireWhere
+	c.ReadOnly = c.ReadOnly || other.ReadOnly
+	if other.MaxRows > 0 && (c.MaxRows == 0 || other.MaxRows < c.MaxRows) {
+		c.MaxRows = other.MaxRows
+	}
+	if other.TimeoutMs > 0 && (c.TimeoutMs == 0 || other.TimeoutMs < c.TimeoutMs) {
+		c.TimeoutMs = other.TimeoutMs
+	}
+	return c
+}
+
+// matchesAction matches exact actions, "*" and "<prefix>:*".
+func matchesAction(patterns []string, action string) bool {
+	for _, pat := range patterns {
+		if pat == "*" || strings.EqualFold(pat, action) {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pat, "*"); ok && strings.HasSuffix(prefix, ":") && strings.HasPrefix(strings.ToLower(action), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesResource matches resource against the rule's patterns. A resource
+// ending in "/**" stands for its whole subtree and one ending in
+// "/column/*" for every column of an entity: an allow must cover all of
+// them, while a deny applies as soon as it matches any of them.
+func (r Rule) matchesResource(resource string) bool {
+	if !isWildcardResource(resource) {
+		return matchesAnyResource(r.Resources, resource)
+	}
+	if r.Effect == EffectDeny {
+		for _, pat := range r.Resources {
+			if pat == "*" || overlapsResource(pat, resource) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, concrete := range wildcardSamples(resource) {
+		if !matchesAnyResource(r.Resources, concrete) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWildcardResource(resource string) bool {
+	return strings.HasSuffix(resource, "/**") || strings.HasSuffix(resource, "/column/*")
+}
+
+// wildcardSample is a segment no pattern names literally, so a pattern
+// matching it matches any name in its place.
+const wildcardSample = "\x00"
+
+// wildcardSamples returns stand-ins for the resources a wildcard resource
+// covers. For "/**" both a child and a grandchild are checked so that only
+// patterns open to any depth cover it.
+func wildcardSamples(resource string) []string {
+	if prefix, ok := strings.CutSuffix(resource, "/**"); ok {
+		return []string{prefix + "/" + wildcardSample, prefix + "/" + wildcardSample + "/" + wildcardSample}
+	}
+	return []string{strings.TrimSuffix(resource, "*") + wildcardSample}
+}
+
+// overlapsResource reports whether pattern matches at least one of the
+// resources a wildcard resource stands for, directly or through the entity
+// of a column.
+func overlapsResource(pattern, resource string) bool {
+	if overlapSegments(strings.Split(pattern, "/"), strings.Split(resource, "/")) {
+		return true
+	}
+	if idx := strings.LastIndex(resource, "/column/"); idx > 0 {
+		return overlapSegments(strings.Split(pattern, "/"), strings.Split(resource[:idx], "/"))
+	}
+	return false
+}
+
+func overlapSegments(pattern, resource []string) bool {
+	for i, seg := range resource {
+		if seg == "**" {
+			return len(pattern) > i
+		}
+		if i >= len(pattern) {
+			return false
+		}
+		if strings.Contains(pattern[i], "**") {
+			return true
+		}
+		if seg == "*" {
+			continue
+		}
+		if ok, err := path.Match(pattern[i], seg); err != nil || !ok {
+			return false
+		}
+	}
+	return len(pattern) == len(resource)
+}
+
+// matchesAnyResource matches resource against the patterns. Column resources
+// (".../entity/<name>/column/<col>") are also covered by patterns matching
+// their entity.
 func matchesAnyResource(patterns []string, resource string) bool {
 	for _, pat := range patterns {
 		if pat == "*" {
@@ -120,15 +376,90 @@ func matchesAnyResource(patterns []string, resource string) bool {
 		if pathMatch(pat, resource) {
 			return true
 		}
+		if idx := strings.LastIndex(resource, "/column/"); idx > 0 && pathMatch(pat, resource[:idx]) {
+			return true
+		}
 	}
 	return false
 }
 
+// MatchScope reports whether a role binding scoped to pattern covers
+// resource. An empty or "*" scope covers everything; otherwise the pattern
+// must match the resource or one of its ancestors, so "connection/<id>"
+// also covers "connection/<id>/db/public/entity/users".
+func MatchScope(pattern, resource string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	for prefix := resource; prefix != ""; {
+		if pathMatch(pattern, prefix) {
+			return true
+		}
+		idx := strings.LastIndex(prefix, "/")
+		if idx < 0 {
+			break
+		}
+		prefix = prefix[:idx]
+	}
+	return false
+}
+
+// ValidScope checks the syntax of a binding scope pattern.
+func ValidScope(pattern string) error {
+	if strings.Contains(pattern, "**") {
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid resource pattern %q", pattern)
+	}
+	return nil
+}
+
+// pathMatch matches with path.Match semantics, where "*" stays within one
+// segment; "**" matches across segments.
 func pathMatch(pattern, resource string) bool {
-	pattern = strings.ReplaceAll(pattern, "**", "*")
+	if strings.Contains(pattern, "**") {
+		return globRegexp(pattern).MatchString(resource)
+	}
 	match, err := path.Match(pattern, resource)
 	if err != nil {
 		return false
 	}
 	return match
 }
+
+// globCache holds compiled "**" patterns; policies reuse a small set of them
+// on every evaluation.
+var globCache sync.Map
+
+func globRegexp(pattern string) *regexp.Regexp {
+	if re, ok := globCache.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			b.WriteString("[^/]*")
+		case pattern[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	re := regexp.MustCompile(b.String())
+	globCache.Store(pattern, re)
+	return re
+}
+
+// LegacyDBPattern reports whether pattern ends in "/db/*". Such patterns
+// once matched the connection-wide query resource but only reach a
+// namespace now that queries are authorized per entity and column.
+func LegacyDBPattern(pattern string) bool {
+	return strings.HasSuffix(pattern, "/db/*") || pattern == "db/*"
+}
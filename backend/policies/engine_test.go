@@ -0,0 +1,252 @@
+package policies
+
+import (
+	"testing"
+	"time"
+)
+
+func mustEngine(t *testing.T, docs ...string) *Engine {
+	t.Helper()
+	raw := make([][]byte, 0, len(docs))
+	for _, doc := range docs {
+		raw = append(raw, []byte(doc))
+	}
+	engine, err := NewEngine(raw)
+	if err != nil {
+		t.Fatalf("new engine: %v", err)
+	}
+	return engine
+}
+
+func TestEvaluateDenyOverridesAllow(t *testing.T) {
+	engine := mustEngine(t, `{"rules":[
+		{"effect":"allow","actions":["query:read"],"resources":["connection/c1/db/**"]},
+		{"effect":"deny","actions":["query:read"],"resources":["connection/c1/db/billing/**"]}
+	]}`)
+	if ok, _ := engine.Evaluate(Request{Action: "query:read", Resource: "connection/c1/db/public/entity/orders"}); !ok {
+		t.Fatalf("expected orders to be allowed")
+	}
+	if ok, _ := engine.Evaluate(Request{Action: "query:read", Resource: "connection/c1/db/billing/entity/invoices"}); ok {
+		t.Fatalf("expected billing to be denied")
+	}
+	if ok, _ := engine.Evaluate(Request{Action: "query:read", Resource: "connection/c1/db/billing/entity/invoices/column/total"}); ok {
+		t.Fatalf("expected billing column to be denied")
+	}
+}
+
+func TestEvaluateWildcardResources(t *testing.T) {
+	engine := mustEngine(t, `{"rules":[
+		{"effect":"allow","actions":["query:read"],"resources":["connection/c1/db/**"]},
+		{"effect":"allow","actions":["query:read"],"resources":["connection/c2/db/*"]},
+		{"effect":"deny","actions":["query:read"],"resources":["connection/c1/db/billing/**"]},
+		{"effect":"deny","actions":["query:read"],"resources":["connection/c3/db/public/entity/users/column/ssn"]},
+		{"effect":"allow","actions":["query:read"],"resources":["connection/c3/**"]}
+	]}`)
+	cases := []struct {
+		resource string
+		want     bool
+	}{
+		{"connection/c1/db/**", false},
+		{"connection/c2/db/**", false},
+		{"connection/c3/db/**", false},
+		{"connection/c3/db/public/entity/users/column/*", false},
+		{"connection/c3/db/public/entity/orders/column/*", true},
+		{"connection/c3/db/public/entity/orders/column/total", true},
+	}
+	for _, tc := range cases {
+		if ok, _ := engine.Evaluate(Request{Action: "query:read", Resource: tc.resource}); ok != tc.want {
+			t.Errorf("Evaluate(%q) = %v, want %v", tc.resource, ok, tc.want)
+		}
+	}
+	open := mustEngine(t, `{"rules":[{"effect":"allow","actions":["query:read"],"resources":["connection/*/db/**"]}]}`)
+	if ok, _ := open.Evaluate(Request{Action: "query:read", Resource: "connection/c1/db/**"}); !ok {
+		t.Fatalf("expected db/** allow to cover the subtree")
+	}
+}
+
+func TestEvaluatePriority(t *testing.T) {
+	engine := mustEngine(t, `{"rules":[
+		{"effect":"deny","actions":["query:read"],"resources":["connection/c1/db/billing/**"]},
+		{"effect":"allow","priority":10,"actions":["query:read"],"resources":["connection/c1/db/billing/entity/rates"]}
+	]}`)
+	if ok, _ := engine.Evaluate(Request{Action: "query:read", Resource: "connection/c1/db/billing/entity/rates"}); !ok {
+		t.Fatalf("expected higher priority allow to win")
+	}
+	if ok, _ := engine.Evaluate(Request{Action: "query:read", Resource: "connection/c1/db/billing/entity/invoices"}); ok {
+		t.Fatalf("expected deny for other billing tables")
+	}
+}
+
+func TestEvaluateDefaultEffect(t *testing.T) {
+	engine := mustEngine(t,
+		`{"default_effect":"deny","rules":[{"effect":"allow","actions":["query:read"],"resources":["connection/c1/**"]}]}`,
+		`{"default_effect":"allow","rules":[{"effect":"deny","actions":["query:write"],"resources":["*"]}]}`,
+	)
+	if ok, _ := engine.Evaluate(Request{Action: "query:read", Resource: "connection/c1/db/*"}); !ok {
+		t.Fatalf("expected explicit allow to outrank default deny")
+	}
+	if ok, _ := engine.Evaluate(Request{Action: "query:write", Resource: "connection/c1/db/*"}); ok {
+		t.Fatalf("expected explicit deny")
+	}
+	if ok, _ := engine.Evaluate(Request{Action: "history:read", Resource: "history"}); ok {
+		t.Fatalf("expected default deny to override default allow")
+	}
+	if !mustEngine(t, `{"default_effect":"allow","rules":[]}`).HasRules() {
+		t.Fatalf("expected default effect to count as a rule")
+	}
+}
+
+func TestEvaluateCombinesConstraints(t *testing.T) {
+	engine := mustEngine(t, `{"rules":[
+		{"effect":"allow","actions":["query:read"],"resources":["*"],"conditions":{"max_rows":500,"require_where":true}},
+		{"effect":"allow","actions":["query:read"],"resources":["*"],"conditions":{"max_rows":100,"timeout_ms":2000}},
+		{"effect":"allow","actions":["query:read"],"resources":["*"],"conditions":{"read_only":true,"environment":["prod"]}},
+		{"effect":"allow","priority":-1,"actions":["query:read"],"resources":["*"],"conditions":{"max_rows":1}}
+	]}`)
+	ok, c := engine.Evaluate(Request{Action: "query:read", Resource: "connection/c1/db/*", Environment: "dev"})
+	if !ok {
+		t.Fatalf("expected allow")
+	}
+	want := Constraints{RequireWhere: true, MaxRows: 100, TimeoutMs: 2000}
+	if c != want {
+		t.Fatalf("got %+v, want %+v", c, want)
+	}
+	_, c = engine.Evaluate(Request{Action: "query:read", Resource: "connection/c1/db/*", Environment: "prod"})
+	if !c.ReadOnly || c.MaxRows != 100 {
+		t.Fatalf("expected prod rule to add read_only, got %+v", c)
+	}
+}
+
+func TestNewEngineRejectsUnknownEffect(t *testing.T) {
+	if _, err := NewEngine([][]byte{[]byte(`{"rules":[{"effect":"permit","actions":["*"],"resources":["*"]}]}`)}); err == nil {
+		t.Fatalf("expected error for unknown effect")
+	}
+	if _, err := NewEngine([][]byte{[]byte(`{"default_effect":"maybe","rules":[]}`)}); err == nil {
+		t.Fatalf("expected error for unknown default effect")
+	}
+}
+
+func TestEvaluateAttributeConditions(t *testing.T) {
+	engine := mustEngine(t, `{"rules":[
+		{"effect":"allow","actions":["query:write"],"resources":["*"],"conditions":{
+			"source_cidrs":["10.0.0.0/8"],
+			"mfa_max_age_sec":900,
+			"groups":["dba"],
+			"user_attributes":{"department":["data","platform"]},
+			"connection_tags":{"tier":["gold"]}
+		}},
+		{"effect":"deny","priority":100,"actions":["query:write"],"resources":["*"],"conditions":{
+			"time_windows":[{"days":["fri"],"start":"18:00","end":"06:00","timezone":"Asia/Ho_Chi_Minh"}]
+		}}
+	]}`)
+	now := time.Date(2026, 10, 14, 3, 0, 0, 0, time.UTC) // Wednesday 10:00 in Ho Chi Minh City
+	mfa := now.Add(-5 * time.Minute)
+	req := Request{
+		Action:         "query:write",
+		Resource:       "connection/c1/db/*",
+		Time:           now,
+		SourceIP:       "10.1.2.3",
+		MFAAt:          &mfa,
+		Groups:         []string{"DBA"},
+		UserAttributes: map[string]any{"department": []any{"platform"}},
+		ConnectionTags: map[string]any{"tier": "gold"},
+	}
+	if ok, _ := engine.Evaluate(req); !ok {
+		t.Fatalf("expected allow")
+	}
+	cases := map[string]func(r *Request){
+		"source ip":      func(r *Request) { r.SourceIP = "192.168.1.1" },
+		"stale mfa":      func(r *Request) { old := now.Add(-time.Hour); r.MFAAt = &old },
+		"no mfa":         func(r *Request) { r.MFAAt = nil },
+		"group":          func(r *Request) { r.Groups = []string{"analyst"} },
+		"attribute":      func(r *Request) { r.UserAttributes = map[string]any{"department": "sales"} },
+		"tag":            func(r *Request) { r.ConnectionTags = nil },
+		"freeze":         func(r *Request) { r.Time = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) },
+		"after midnight": func(r *Request) { r.Time = time.Date(2026, 10, 16, 20, 0, 0, 0, time.UTC) },
+	}
+	for name, mutate := range cases {
+		r := req
+		mutate(&r)
+		if ok, _ := engine.Evaluate(r); ok {
+			t.Fatalf("%s: expected deny", name)
+		}
+	}
+	r := req
+	r.Time = time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC) // Saturday 07:00, after the freeze
+	r.MFAAt = &r.Time
+	if ok, _ := engine.Evaluate(r); !ok {
+		t.Fatalf("expected allow after freeze window")
+	}
+}
+
+func TestNewEngineValidatesConditions(t *testing.T) {
+	for _, doc := range []string{
+		`{"rules":[{"effect":"allow","actions":["*"],"resources":["*"],"conditions":{"source_cidrs":["10.0.0.0/33"]}}]}`,
+		`{"rules":[{"effect":"allow","actions":["*"],"resources":["*"],"conditions":{"time_windows":[{"timezone":"Mars/Base"}]}}]}`,
+		`{"rules":[{"effect":"allow","actions":["*"],"resources":["*"],"conditions":{"time_windows":[{"days":["someday"]}]}}]}`,
+		`{"rules":[{"effect":"allow","actions":["*"],"resources":["*"],"conditions":{"time_windows":[{"start":"25:00"}]}}]}`,
+	} {
+		if _, err := NewEngine([][]byte{[]byte(doc)}); err == nil {
+			t.Fatalf("expected validation error for %s", doc)
+		}
+	}
+}
+
+func TestExplainRecordsReasons(t *testing.T) {
+	engine, err := NewEngineFromSources([]Source{
+		{Name: "analysts", Doc: []byte(`{"rules":[
+			{"effect":"allow","actions":["query:read"],"resources":["connection/c1/db/**"]},
+			{"effect":"allow","actions":["query:write"],"resources":["*"]},
+			{"effect":"allow","actions":["query:read"],"resources":["*"],"conditions":{"environment":["prod"]}}
+		]}`)},
+		{Name: "billing", Doc: []byte(`{"default_effect":"allow","rules":[
+			{"effect":"deny","actions":["query:read"],"resources":["connection/*/db/billing/**"]}
+		]}`)},
+	})
+	if err != nil {
+		t.Fatalf("new engine: %v", err)
+	}
+	trace := engine.Explain(Request{Action: "query:read", Resource: "connection/c1/db/billing/entity/invoices", Environment: "dev"})
+	if trace.Allowed {
+		t.Fatalf("expected deny")
+	}
+	reasons := map[string]string{}
+	for _, rt := range trace.Rules {
+		reasons[rt.Policy+"/"+rt.Effect+"/"+string(rune('0'+rt.Rule))] = rt.Reason
+	}
+	want := map[string]string{
+		"analysts/allow/0": "overridden by deny",
+		"analysts/allow/1": "action",
+		"analysts/allow/2": "environment",
+		"billing/deny/0":   "",
+	}
+	for key, reason := range want {
+		if got, ok := reasons[key]; !ok || got != reason {
+			t.Fatalf("%s: got %q, want %q (%v)", key, got, reason, reasons)
+		}
+	}
+	if got := trace.String(); got != "analysts#0:allow,billing#0:deny*" {
+		t.Fatalf("unexpected compact trace %q", got)
+	}
+}
+
+func TestMatchScope(t *testing.T) {
+	cases := []struct {
+		pattern, resource string
+		want              bool
+	}{
+		{"", "connection/c1", true},
+		{"*", "iam/policies", true},
+		{"connection/c1", "connection/c1/db/public/entity/users", true},
+		{"connection/c1/*", "connection/c1/db/public/entity/users", true},
+		{"connection/c1/*", "connection/c1", false},
+		{"connection/c1", "connection/c2/db/public/entity/users", false},
+		{"connection/c1", "connection/*", false},
+		{"connection/*/db/public/**", "connection/c2/db/public/entity/users", true},
+	}
+	for _, tc := range cases {
+		if got := MatchScope(tc.pattern, tc.resource); got != tc.want {
+			t.Errorf("MatchScope(%q, %q) = %v, want %v", tc.pattern, tc.resource, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package policies
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// KnownActions lists every action the server authorizes.
+var KnownActions = []string{
+	"audit:read",
+	"connection:read",
+	"connection:write",
+	"history:read",
+	"iam:approve",
+	"iam:break_glass",
+	"iam:read",
+	"iam:write",
+	"pii:read",
+	"pii:write",
+	"query:admin",
+	"query:read",
+	"query:write",
+	"settings:write",
+}
+
+var resourceRoots = []string{"connection", "settings", "audit", "history", "approvals", "scim", "iam", "users"}
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+type Issue struct {
+	Severity string `json:"severity"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+// HasErrors reports whether any issue is an error rather than a warning.
+func HasErrors(issues []Issue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Lint validates a raw policy document against the schema and reports
+// unknown actions, malformed glob patterns and rules that cannot have the
+// intended effect. Documents with error issues are rejected by the API.
+func Lint(raw []byte) []Issue {
+	issues := []Issue{}
+	add := func(severity, path, format string, args ...any) {
+		issues = append(issues, Issue{Severity: severity, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var doc Document
+	if err := dec.Decode(&doc); err != nil {
+		add(SeverityError, "", "invalid document: %v", err)
+		return issues
+	}
+	switch strings.ToLower(strings.TrimSpace(doc.DefaultEffect)) {
+	case "", EffectAllow, EffectDeny:
+	default:
+		add(SeverityError, "default_effect", "must be allow or deny")
+	}
+	if len(doc.Rules) == 0 && doc.DefaultEffect == "" {
+		add(SeverityWarning, "rules", "document has no rules and no default effect")
+	}
+	seen := map[string]int{}
+	for i, rule := range doc.Rules {
+		at := fmt.Sprintf("rules[%d]", i)
+		effect := strings.ToLower(strings.TrimSpace(rule.Effect))
+		if effect != EffectAllow && effect != EffectDeny {
+			add(SeverityError, at+".effect", "must be allow or deny")
+		}
+		if len(rule.Actions) == 0 {
+			add(SeverityError, at+".actions", "at least one action is required")
+		}
+		for j, action := range rule.Actions {
+			if !KnownAction(action) {
+				add(SeverityError, fmt.Sprintf("%s.actions[%d]", at, j), "unknown action %q", action)
+			}
+		}
+		if len(rule.Resources) == 0 {
+			add(SeverityError, at+".resources", "at least one resource is required")
+		}
+		for j, resource := range rule.Resources {
+			rp := fmt.Sprintf("%s.resources[%d]", at, j)
+			if resource == "" {
+				add(SeverityError, rp, "resource pattern is empty")
+				continue
+			}
+			if _, err := path.Match(strings.ReplaceAll(resource, "**", "*"), ""); err != nil {
+				add(SeverityError, rp, "invalid glob pattern %q", resource)
+				continue
+			}
+			if !knownRoot(resource) {
+				add(SeverityWarning, rp, "pattern %q does not match any known resource type", resource)
+			}
+			if LegacyDBPattern(resource) {
+				add(SeverityWarning, rp, "pattern %q does not cover tables or columns; use %q", resource, resource+"*")
+			}
+		}
+		if err := rule.Conditions.validate(); err != nil {
+			add(SeverityError, at+".conditions", "%v", err)
+		}
+		c := rule.Conditions
+		if effect == EffectDeny && (c.RequireWhere != nil || c.ReadOnly != nil || c.MaxRows != nil || c.TimeoutMs != nil) {
+			add(SeverityWarning, at+".conditions", "constraints have no effect on deny rules")
+		}
+		if c.MaxRows != nil && *c.MaxRows <= 0 {
+			add(SeverityError, at+".conditions.max_rows", "must be positive")
+		}
+		if c.TimeoutMs != nil && *c.TimeoutMs <= 0 {
+			add(SeverityError, at+".conditions.timeout_ms", "must be positive")
+		}
+		key, _ := json.Marshal(rule)
+		if prev, ok := seen[string(key)]; ok {
+			add(SeverityWarning, at, "duplicates rules[%d]", prev)
+		} else {
+			seen[string(key)] = i
+		}
+	}
+	return issues
+}
+
+// KnownAction reports whether action, "*" or a "<prefix>:*" pattern names an
+// action the server authorizes.
+func KnownAction(action string) bool {
+	if action == "*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(action, ":*"); ok {
+		for _, known := range KnownActions {
+			if strings.HasPrefix(known, strings.ToLower(prefix)+":") {
+				return true
+			}
+		}
+		return false
+	}
+	for _, known := range KnownActions {
+		if strings.EqualFold(known, action) {
+			return true
+		}
+	}
+	return false
+}
+
+func knownRoot(pattern string) bool {
+	root, _, _ := strings.Cut(pattern, "/")
+	if strings.ContainsAny(root, "*?[") {
+		return true
+	}
+	for _, known := range resourceRoots {
+		if root == known {
+			return true
+		}
+	}
+	return false
+}
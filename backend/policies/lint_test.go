@@ -0,0 +1,49 @@
+package policies
+
+import "testing"
+
+func TestLint(t *testing.T) {
+	issues := Lint([]byte(`{"rules":[
+		{"effect":"allow","actions":["query:read","query:raed","pii:*"],"resources":["connection/[x/db/*","connection/*/db/**"]},
+		{"effect":"deny","actions":["*"],"resources":["tables/*"],"conditions":{"max_rows":10}},
+		{"effect":"allow","actions":["query:read"],"resources":["connection/*/db/*"]}
+	]}`))
+	want := map[string]string{
+		"rules[0].actions[1]":   SeverityError,
+		"rules[0].resources[0]": SeverityError,
+		"rules[1].resources[0]": SeverityWarning,
+		"rules[1].conditions":   SeverityWarning,
+		"rules[2].resources[0]": SeverityWarning,
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("unexpected issues %+v", issues)
+	}
+	for _, issue := range issues {
+		if want[issue.Path] != issue.Severity {
+			t.Fatalf("unexpected issue %+v", issue)
+		}
+	}
+	if issues := Lint([]byte(`{"rules":[],"defualt_effect":"deny"}`)); !HasErrors(issues) {
+		t.Fatalf("expected unknown field to be an error")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	changes := Diff(
+		[]byte(`{"rules":[{"effect":"allow","actions":["query:read"]}]}`),
+		[]byte(`{"default_effect":"deny","rules":[{"effect":"deny","actions":["query:read","query:write"]}]}`),
+	)
+	want := []Change{
+		{Op: "add", Path: "default_effect", New: "deny"},
+		{Op: "add", Path: "rules[0].actions[1]", New: "query:write"},
+		{Op: "change", Path: "rules[0].effect", Old: "allow", New: "deny"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("change %d: got %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+}
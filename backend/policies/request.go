@@ -0,0 +1,235 @@
+package policies
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Request is the context a policy is evaluated against.
+type Request struct {
+	Action         string
+	Resource       string
+	Environment    string
+	Time           time.Time
+	SourceIP       string
+	MFAAt          *time.Time
+	Groups         []string
+	UserAttributes map[string]any
+	ConnectionTags map[string]any
+}
+
+// TimeWindow matches requests made on one of Days (mon..sun, any day when
+// empty) between Start and End ("15:04", End may wrap past midnight) in
+// Timezone (UTC when empty).
+type TimeWindow struct {
+	Days     []string `json:"days,omitempty"`
+	Start    string   `json:"start,omitempty"`
+	End      string   `json:"end,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func (c Conditions) validate() error {
+	for _, w := range c.TimeWindows {
+		if _, err := w.location(); err != nil {
+			return err
+		}
+		for _, day := range w.Days {
+			if _, ok := weekdays[strings.ToLower(day)]; !ok {
+				return fmt.Errorf("invalid day %q", day)
+			}
+		}
+		for _, clock := range []string{w.Start, w.End} {
+			if _, err := parseClock(clock); clock != "" && err != nil {
+				return fmt.Errorf("invalid time %q", clock)
+			}
+		}
+	}
+	for _, cidr := range c.SourceCIDRs {
+		if _, err := parsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid cidr %q", cidr)
+		}
+	}
+	if c.MFAMaxAgeSec != nil && *c.MFAMaxAgeSec < 0 {
+		return fmt.Errorf("invalid mfa_max_age_sec %d", *c.MFAMaxAgeSec)
+	}
+	return nil
+}
+
+// mismatch returns the name of the first attribute condition that req fails,
+// or "" when every condition that is set holds.
+func (c Conditions) mismatch(req Request) string {
+	if len(c.Environment) > 0 && !matchesAny(c.Environment, req.Environment) {
+		return "environment"
+	}
+	if len(c.TimeWindows) > 0 && !inAnyWindow(c.TimeWindows, req.Time) {
+		return "time_windows"
+	}
+	if len(c.SourceCIDRs) > 0 && !inAnyCIDR(c.SourceCIDRs, req.SourceIP) {
+		return "source_cidrs"
+	}
+	if c.MFAMaxAgeSec != nil {
+		if req.MFAAt == nil || req.Time.Sub(*req.MFAAt) > time.Duration(*c.MFAMaxAgeSec)*time.Second {
+			return "mfa_max_age_sec"
+		}
+	}
+	if len(c.Groups) > 0 && !intersects(c.Groups, req.Groups) {
+		return "groups"
+	}
+	if !attributesMatch(c.UserAttributes, req.UserAttributes) {
+		return "user_attributes"
+	}
+	if !attributesMatch(c.ConnectionTags, req.ConnectionTags) {
+		return "connection_tags"
+	}
+	return ""
+}
+
+func (w TimeWindow) location() (*time.Location, error) {
+	if w.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(w.Timezone)
+}
+
+func (w TimeWindow) contains(t time.Time) bool {
+	loc, err := w.location()
+	if err != nil {
+		return false
+	}
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	start, _ := parseClock(w.Start)
+	end, err := parseClock(w.End)
+	if w.End == "" || err != nil {
+		end = 24 * 60
+	}
+	day := local.Weekday()
+	inTime := false
+	switch {
+	case start < end:
+		inTime = minute >= start && minute < end
+	case start > end:
+		// Wraps past midnight; the early-morning part belongs to the window
+		// that started the previous day.
+		if minute >= start {
+			inTime = true
+		} else if minute < end {
+			inTime = true
+			day = (day + 6) % 7
+		}
+	default:
+		inTime = true
+	}
+	if !inTime {
+		return false
+	}
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+func inAnyWindow(windows []TimeWindow, t time.Time) bool {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseClock(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func parsePrefix(cidr string) (netip.Prefix, error) {
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	return netip.ParsePrefix(cidr)
+}
+
+func inAnyCIDR(cidrs []string, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, cidr := range cidrs {
+		prefix, err := parsePrefix(cidr)
+		if err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func intersects(want []string, have []string) bool {
+	for _, w := range want {
+		if matchesAny(have, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// attributesMatch requires every key in want to be present in have with one
+// of the listed values. List-valued attributes match if any element does.
+func attributesMatch(want map[string][]string, have map[string]any) bool {
+	for key, values := range want {
+		if !intersects(values, attributeValues(have[key])) {
+			return false
+		}
+	}
+	return true
+}
+
+func attributeValues(value any) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			out = append(out, attributeValues(item)...)
+		}
+		return out
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
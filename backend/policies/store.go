@@ -2,6 +2,8 @@ package policies
 
 import (
 	"context"
+	"log/slog"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -11,11 +13,28 @@ import (
 type Store struct {
 	store   *store.Store
 	refresh time.Duration
+	logger  *slog.Logger
 	value   atomic.Value
+	mu      sync.Mutex
+	status  Status
+	// legacy holds the "/db/*" patterns already warned about.
+	legacy sync.Map
 }
 
-func NewStore(st *store.Store, refresh time.Duration) *Store {
-	return &Store{store: st, refresh: refresh}
+// Status reports the outcome of the most recent refresh. When Error is set
+// the engine loaded at LoadedAt is still being enforced.
+type Status struct {
+	LoadedAt    time.Time `json:"loadedAt"`
+	Policies    int       `json:"policies"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func NewStore(st *store.Store, refresh time.Duration, logger *slog.Logger) *Store {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Store{store: st, refresh: refresh, logger: logger}
 }
 
 func (s *Store) Start(ctx context.Context) error {
@@ -38,20 +57,48 @@ func (s *Store) Start(ctx context.Context) error {
 }
 
 func (s *Store) Refresh(ctx context.Context) error {
-	list, err := s.store.ListPolicies(ctx)
+	engine, count, err := s.load(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastAttempt = time.Now().UTC()
 	if err != nil {
+		if s.status.Error != err.Error() {
+			s.logger.Error("policy refresh failed, keeping previous policies", "error", err, "loaded_at", s.status.LoadedAt)
+		}
+		s.status.Error = err.Error()
 		return err
 	}
-	raw := make([][]byte, 0, len(list))
+	if s.status.Error != "" {
+		s.logger.Info("policy refresh recovered")
+	}
+	s.value.Store(engine)
+	s.status.LoadedAt = s.status.LastAttempt
+	s.status.Policies = count
+	s.status.Error = ""
+	return nil
+}
+
+func (s *Store) load(ctx context.Context) (*Engine, int, error) {
+	list, err := s.store.ListPolicies(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	sources := make([]Source, 0, len(list))
 	for _, p := range list {
-		raw = append(raw, p.Doc)
+		sources = append(sources, Source{Name: p.Name, Doc: p.Doc})
+		s.warnLegacy(p.Name, p.Doc)
 	}
-	engine, err := NewEngine(raw)
+	engine, err := NewEngineFromSources(sources)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	s.value.Store(engine)
-	return nil
+	return engine, len(list), nil
+}
+
+func (s *Store) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
 }
 
 func (s *Store) Engine() *Engine {
@@ -61,3 +108,23 @@ func (s *Store) Engine() *Engine {
 	}
 	return val.(*Engine)
 }
+
+// warnLegacy logs, once per policy and pattern, resource patterns written
+// for the connection-wide "db/*" query resource used before per-table
+// authorization.
+func (s *Store) warnLegacy(name string, raw []byte) {
+	doc, err := ParseDocument(raw)
+	if err != nil {
+		return
+	}
+	for _, rule := range doc.Rules {
+		for _, pattern := range rule.Resources {
+			if !LegacyDBPattern(pattern) {
+				continue
+			}
+			if _, seen := s.legacy.LoadOrStore(name+"\x00"+pattern, true); !seen {
+				s.logger.Warn("policy pattern does not cover tables or columns", "policy", name, "pattern", pattern, "suggested", pattern+"*")
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package policies
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"flowdb/backend/audit"
+	"flowdb/backend/store"
+)
+
+// DirSync keeps the policies, roles and bindings declared in a directory in
+// sync with the database, polling for changes. Invalid bundles are rejected
+// and the last good state stays in effect.
+type DirSync struct {
+	dir      string
+	interval time.Duration
+	store    *store.Store
+	policies *Store
+	audit    *audit.Logger
+	logger   *slog.Logger
+	lastHash string
+}
+
+func NewDirSync(dir string, interval time.Duration, st *store.Store, pol *Store, auditLogger *audit.Logger, logger *slog.Logger) *DirSync {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &DirSync{dir: dir, interval: interval, store: st, policies: pol, audit: auditLogger, logger: logger}
+}
+
+func (d *DirSync) Start(ctx context.Context) {
+	if err := d.Sync(ctx); err != nil {
+		d.logger.Error("policy dir sync failed", "dir", d.dir, "error", err)
+	}
+	ticker := time.NewTicker(d.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.Sync(ctx); err != nil {
+					d.logger.Error("policy dir sync failed", "dir", d.dir, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Sync applies the directory if its content changed since the last
+// successful sync.
+func (d *DirSync) Sync(ctx context.Context) error {
+	bundle, issues, err := LoadDir(d.dir)
+	if err != nil {
+		return err
+	}
+	if bundle.Hash == d.lastHash {
+		return nil
+	}
+	for _, issue := range issues {
+		d.logger.Warn("policy dir issue", "issue", issue.String())
+	}
+	if HasFileErrors(issues) {
+		d.lastHash = bundle.Hash
+		return errInvalidBundle
+	}
+	existing, err := d.store.ListPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	set := managedSet(bundle, existing)
+	result, err := d.store.SyncManaged(ctx, ManagedByFile, set)
+	if errors.Is(err, store.ErrNotManaged) {
+		d.lastHash = bundle.Hash
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	d.auditChanges(ctx, result.Changes)
+	for _, skipped := range result.Skipped {
+		d.logger.Warn("policy dir binding skipped", "binding", skipped)
+	}
+	d.lastHash = bundle.Hash
+	d.logger.Info("policy dir synced",
+		"dir", d.dir,
+		"changes", len(result.Changes),
+		"roles", result.Roles,
+		"bindings", result.Bindings,
+	)
+	return d.policies.Refresh(ctx)
+}
+
+// auditChanges logs one event per object the sync created, updated or
+// deleted, named like the events of the matching API handlers.
+func (d *DirSync) auditChanges(ctx context.Context, changes []store.SyncChange) {
+	if d.audit == nil {
+		return
+	}
+	for _, change := range changes {
+		details := map[string]any{"source": ManagedByFile}
+		switch change.Kind {
+		case "policy":
+			details["policyId"] = change.ID.String()
+			details["name"] = change.Name
+			details["version"] = change.Version
+		case "role":
+			details["roleId"] = change.ID.String()
+			details["name"] = change.Name
+			if change.Op != "delete" {
+				details["permissions"] = change.Permissions
+			}
+		case "role_binding":
+			details["binding"] = change.Name
+		}
+		if err := d.audit.LogEvent(ctx, change.Kind+"_"+change.Op, nil, details, ""); err != nil {
+			d.logger.Error("policy dir audit failed", "event", change.Kind+"_"+change.Op, "error", err)
+		}
+	}
+}
+
+var errInvalidBundle = errors.New("policy directory has errors, keeping current policies")
+
+func managedSet(bundle Bundle, existing []store.Policy) store.ManagedSet {
+	current := map[string]store.Policy{}
+	for _, p := range existing {
+		current[p.Name] = p
+	}
+	var set store.ManagedSet
+	declared := map[string]bool{}
+	for _, p := range bundle.Policies {
+		declared[p.Name] = true
+		prev, ok := current[p.Name]
+		mp := store.ManagedPolicy{Name: p.Name, Doc: p.Doc}
+		switch {
+		case !ok:
+			mp.Changed = true
+			mp.Diff, _ = json.Marshal(Diff(nil, p.Doc))
+		case prev.ManagedBy != ManagedByFile || !sameJSON(prev.Doc, p.Doc):
+			mp.Changed = true
+			mp.Diff, _ = json.Marshal(Diff(prev.Doc, p.Doc))
+		}
+		set.Policies = append(set.Policies, mp)
+	}
+	for _, p := range existing {
+		if p.ManagedBy == ManagedByFile && !declared[p.Name] {
+			diff, _ := json.Marshal(Diff(p.Doc, nil))
+			set.Removed = append(set.Removed, store.ManagedPolicy{Name: p.Name, Diff: diff})
+		}
+	}
+	for _, role := range bundle.Roles {
+		set.Roles = append(set.Roles, store.Role{Name: role.Name, Permissions: role.Permissions})
+	}
+	for _, b := range bundle.Bindings {
+		set.Bindings = append(set.Bindings, store.ManagedBinding{Role: b.Role, Username: b.User, Group: b.Group, Resource: b.Resource})
+	}
+	return set
+}
+
+func sameJSON(a []byte, b []byte) bool {
+	var av, bv any
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
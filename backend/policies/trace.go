@@ -0,0 +1,51 @@
+package policies
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleTrace records how a single rule (or a document default effect, with
+// Rule = -1) took part in a decision.
+type RuleTrace struct {
+	Policy   string `json:"policy"`
+	Rule     int    `json:"rule"`
+	Effect   string `json:"effect"`
+	Priority int    `json:"priority"`
+	Default  bool   `json:"default,omitempty"`
+	Matched  bool   `json:"matched"`
+	Deciding bool   `json:"deciding,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type Trace struct {
+	Allowed     bool        `json:"allowed"`
+	Constraints Constraints `json:"constraints"`
+	Rules       []RuleTrace `json:"rules"`
+}
+
+// Compact summarizes the matched rules as "policy#rule:effect" entries,
+// marking deciding rules with "*", for audit events.
+func (t Trace) Compact() []string {
+	out := []string{}
+	for _, rt := range t.Rules {
+		if !rt.Matched {
+			continue
+		}
+		ref := fmt.Sprintf("%s#%d", rt.Policy, rt.Rule)
+		if rt.Default {
+			ref = rt.Policy + "#default"
+		}
+		entry := ref + ":" + rt.Effect
+		if rt.Deciding {
+			entry += "*"
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// String is a one-line form of Compact.
+func (t Trace) String() string {
+	return strings.Join(t.Compact(), ",")
+}
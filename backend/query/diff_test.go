@@ -0,0 +1,23 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineDiff(t *testing.T) {
+	got := LineDiff("UPDATE users\nSET active = false\nWHERE id = 1", "UPDATE users\nSET active = false\nWHERE id = 2\nAND org = 3\n")
+	want := []DiffLine{
+		{Op: " ", Text: "UPDATE users"},
+		{Op: " ", Text: "SET active = false"},
+		{Op: "-", Text: "WHERE id = 1"},
+		{Op: "+", Text: "WHERE id = 2"},
+		{Op: "+", Text: "AND org = 3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected diff: %+v", got)
+	}
+	if got := LineDiff("", ""); len(got) != 0 {
+		t.Fatalf("expected empty diff, got %+v", got)
+	}
+}
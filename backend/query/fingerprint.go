@@ -0,0 +1,124 @@
+package query
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// Fingerprint normalizes a statement so that runs differing only in literals,
+// whitespace, comments, keyword case or the length of IN-lists share a
+// fingerprint. It returns a short hash and the normalized text.
+func Fingerprint(connType string, stmt string) (string, string) {
+	var text string
+	if connType == "mongodb" {
+		text = normalizeMongo(stmt)
+	} else {
+		text = normalizeSQL(stmt)
+	}
+	sum := sha256.Sum256([]byte(connType + "\x00" + text))
+	return hex.EncodeToString(sum[:8]), text
+}
+
+func normalizeSQL(stmt string) string {
+	var tokens []sqlToken
+	for _, tok := range tokenizeSQL(stmt) {
+		// A $1 placeholder reads as "$" and a literal.
+		if tok.text == "?" && len(tokens) > 0 && tokens[len(tokens)-1].text == "$" {
+			tokens = tokens[:len(tokens)-1]
+		}
+		tokens = append(tokens, tok)
+	}
+	var out []string
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		text := tok.text
+		if tok.quoted {
+			text = `"` + tok.text + `"`
+		} else if tok.ident {
+			text = strings.ToLower(tok.text)
+		}
+		if text == "(" && i > 0 && tokens[i-1].ident && !isKeyword(tokens[i-1]) {
+			// A function call keeps its parenthesis.
+			out[len(out)-1] += text
+		} else {
+			out = append(out, text)
+		}
+		// An IN-list of literals becomes in (...).
+		if keywordIs(tok, "in") && i+2 < len(tokens) && tokens[i+1].text == "(" && tokens[i+2].text == "?" {
+			end := i + 2
+			for end+2 < len(tokens) && tokens[end+1].text == "," && tokens[end+2].text == "?" {
+				end += 2
+			}
+			if end+1 < len(tokens) && tokens[end+1].text == ")" {
+				out = append(out, "(", "...", ")")
+				i = end + 1
+			}
+		}
+	}
+	for len(out) > 0 && out[len(out)-1] == ";" {
+		out = out[:len(out)-1]
+	}
+	var b strings.Builder
+	for i, text := range out {
+		if i > 0 && !noSpaceBefore(text) && !noSpaceAfter(out[i-1]) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+func noSpaceBefore(text string) bool {
+	return text == "," || text == ")" || text == "." || text == "::" || text == ";"
+}
+
+func noSpaceAfter(text string) bool {
+	return strings.HasSuffix(text, "(") || text == "." || text == "::"
+}
+
+// normalizeMongo replaces every value below the top level with "?" and
+// arrays of plain values with ["..."]. Statements that are not JSON are only
+// whitespace-normalized.
+func normalizeMongo(stmt string) string {
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(stmt), &doc); err != nil {
+		return strings.Join(strings.Fields(stmt), " ")
+	}
+	for k, v := range doc {
+		switch v.(type) {
+		case map[string]any, []any:
+			doc[k] = normalizeValue(v)
+		}
+	}
+	out, _ := json.Marshal(doc)
+	return string(out)
+}
+
+func normalizeValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = normalizeValue(item)
+		}
+		return v
+	case []any:
+		scalars := true
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]any, []any:
+				scalars = false
+			}
+		}
+		if scalars {
+			return []any{"..."}
+		}
+		for i, item := range v {
+			v[i] = normalizeValue(item)
+		}
+		return v
+	default:
+		return "?"
+	}
+}
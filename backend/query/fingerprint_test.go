@@ -0,0 +1,34 @@
+package query
+
+import "testing"
+
+func TestFingerprintSQL(t *testing.T) {
+	a, text := Fingerprint("postgres", "SELECT id, \"Name\"  FROM public.users\nWHERE id IN (1, 2, 3) AND email = 'a@b.c' -- note\n;")
+	want := `select id, "Name" from public.users where id in (...) and email = ?`
+	if text != want {
+		t.Fatalf("got %q", text)
+	}
+	b, _ := Fingerprint("postgres", "select id, \"Name\" from public.users where id in ($1) and email = 'x'")
+	if a != b {
+		t.Fatalf("expected equal fingerprints")
+	}
+	c, _ := Fingerprint("postgres", "select id from public.users where id in (1, 2)")
+	if a == c {
+		t.Fatalf("expected different fingerprints")
+	}
+	if _, text := Fingerprint("postgres", "select count(*)::int from t where x = -1.5"); text != "select count(*)::int from t where x = - ?" {
+		t.Fatalf("got %q", text)
+	}
+}
+
+func TestFingerprintMongo(t *testing.T) {
+	a, text := Fingerprint("mongodb", `{"action":"find","collection":"users","filter":{"age":{"$gt":30},"tag":{"$in":["a","b"]}}}`)
+	want := `{"action":"find","collection":"users","filter":{"age":{"$gt":"?"},"tag":{"$in":["..."]}}}`
+	if text != want {
+		t.Fatalf("got %q", text)
+	}
+	b, _ := Fingerprint("mongodb", `{"collection":"users", "action":"find", "filter":{"tag":{"$in":["c"]},"age":{"$gt":1}}}`)
+	if a != b {
+		t.Fatalf("expected equal fingerprints")
+	}
+}
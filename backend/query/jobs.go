@@ -8,15 +8,17 @@ import (
 )
 
 type Job struct {
-	ID           string
-	ConnectionID uuid.UUID
-	Statement    string
-	Action       string
-	Resource     string
-	UserID       uuid.UUID
-	CreatedAt    time.Time
-	Options      Options
-	ApprovalID   *uuid.UUID
+	ID            string
+	ConnectionID  uuid.UUID
+	Statement     string
+	Action        string
+	Resource      string
+	Resources     []string
+	UserID        uuid.UUID
+	CreatedAt     time.Time
+	Options       Options
+	ApprovalID    *uuid.UUID
+	Justification string
 }
 
 type Options struct {
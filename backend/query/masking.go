@@ -4,6 +4,8 @@ import (
 	"strings"
 
 	"flowdb/backend/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func MaskRow(resource string, columns []string, row []any, rules []store.PIIRule) []any {
@@ -35,13 +37,166 @@ func MaskDoc(resource string, doc map[string]any, rules []store.PIIRule) map[str
 		if !resourceMatch(rule.Resource, resource) {
 			continue
 		}
-		if _, ok := doc[rule.Field]; ok {
-			doc[rule.Field] = maskValue(doc[rule.Field], rule.MaskType)
-		}
+		maskPath(doc, ParseFieldPath(rule.Field), rule.MaskType)
 	}
 	return doc
 }
 
+// ParseFieldPath splits a field selector such as "profile.email" or
+// "contacts[].phone" into path segments. A "[]" suffix marks an array whose
+// elements are all selected; "*" matches any key.
+func ParseFieldPath(field string) []string {
+	return strings.Split(field, ".")
+}
+
+// maskPath masks the value at path inside value and returns the (possibly
+// replaced) value. Arrays met along the way are traversed element by element,
+// mirroring MongoDB dot-notation semantics.
+func maskPath(value any, path []string, maskType string) any {
+	if len(path) == 0 {
+		return maskValue(value, maskType)
+	}
+	if elems, ok := asArray(value); ok {
+		for i := range elems {
+			elems[i] = maskPath(elems[i], path, maskType)
+		}
+		return value
+	}
+	segment := path[0]
+	each := strings.HasSuffix(segment, "[]")
+	key := strings.TrimSuffix(segment, "[]")
+	apply := func(child any) any {
+		if each {
+			if elems, ok := asArray(child); ok {
+				for i := range elems {
+					elems[i] = maskPath(elems[i], path[1:], maskType)
+				}
+				return child
+			}
+		}
+		return maskPath(child, path[1:], maskType)
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		maskMap(v, key, apply)
+	case primitive.M:
+		maskMap(v, key, apply)
+	case primitive.D:
+		for i := range v {
+			if key == "*" || v[i].Key == key {
+				v[i].Value = apply(v[i].Value)
+			}
+		}
+	}
+	return value
+}
+
+func maskMap(m map[string]any, key string, apply func(any) any) {
+	if key == "*" {
+		for k, child := range m {
+			m[k] = apply(child)
+		}
+		return
+	}
+	if child, ok := m[key]; ok {
+		m[key] = apply(child)
+	}
+}
+
+func asArray(value any) ([]any, bool) {
+	switch v := value.(type) {
+	case []any:
+		return v, true
+	case primitive.A:
+		return v, true
+	default:
+		return nil, false
+	}
+}
+
+// Viewer describes who is reading masked data. Roles and groups are matched
+// against rule exemptions; Justification is required to unmask rules that ask
+// for one.
+type Viewer struct {
+	Roles         []string
+	Groups        []string
+	Justification string
+}
+
+// ApplicableRules splits rules into those that must still be enforced for the
+// viewer and those the viewer is exempt from.
+func ApplicableRules(rules []store.PIIRule, viewer Viewer) ([]store.PIIRule, []store.PIIRule) {
+	var enforced []store.PIIRule
+	var unmasked []store.PIIRule
+	for _, rule := range rules {
+		exempt := anyFold(rule.ExemptRoles, viewer.Roles) || anyFold(rule.ExemptGroups, viewer.Groups)
+		if exempt && rule.RequireJustification && strings.TrimSpace(viewer.Justification) == "" {
+			exempt = false
+		}
+		if exempt {
+			unmasked = append(unmasked, rule)
+		} else {
+			enforced = append(enforced, rule)
+		}
+	}
+	return enforced, unmasked
+}
+
+func RulesForResource(rules []store.PIIRule, resource string) []store.PIIRule {
+	var out []store.PIIRule
+	for _, rule := range rules {
+		if resourceMatch(rule.Resource, resource) {
+			out = append(out, rule)
+		}
+	}
+	return out
+}
+
+// MaskedFields returns the fields that rules mask in results for resources.
+// With columns, only rules on one of the columns count; documents have no
+// fixed columns, so every matching rule does.
+func MaskedFields(resources []string, columns []string, rules []store.PIIRule) []string {
+	present := map[string]bool{}
+	for _, col := range columns {
+		present[strings.ToLower(col)] = true
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, rule := range rules {
+		if seen[rule.Field] || (len(columns) > 0 && !present[strings.ToLower(rule.Field)]) {
+			continue
+		}
+		for _, resource := range resources {
+			if resourceMatch(rule.Resource, resource) {
+				seen[rule.Field] = true
+				out = append(out, rule.Field)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func ValidMaskType(maskType string) bool {
+	switch strings.ToLower(maskType) {
+	case "mask", "null":
+		return true
+	default:
+		return false
+	}
+}
+
+func anyFold(list []string, values []string) bool {
+	for _, item := range list {
+		for _, v := range values {
+			if strings.EqualFold(item, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func resourceMatch(ruleResource, resource string) bool {
 	if ruleResource == "*" || strings.EqualFold(ruleResource, resource) {
 		return true
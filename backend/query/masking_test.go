@@ -0,0 +1,97 @@
+package query
+
+import (
+	"testing"
+
+	"flowdb/backend/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMaskDocNestedPaths(t *testing.T) {
+	doc := map[string]any{
+		"name": "alice",
+		"profile": primitive.M{
+			"email": "alice@example.com",
+		},
+		"contacts": primitive.A{
+			primitive.D{{Key: "phone", Value: "+1 555 0100"}, {Key: "type", Value: "home"}},
+			map[string]any{"phone": "+1 555 0101"},
+		},
+	}
+	rules := []store.PIIRule{
+		{Resource: "*", Field: "profile.email", MaskType: "mask"},
+		{Resource: "*", Field: "contacts[].phone", MaskType: "null"},
+	}
+	MaskDoc("connection/x/db/app/entity/users", doc, rules)
+	if doc["profile"].(primitive.M)["email"] != "****" {
+		t.Fatalf("expected nested email to be masked, got %v", doc["profile"])
+	}
+	contacts := doc["contacts"].(primitive.A)
+	first := contacts[0].(primitive.D)
+	if first[0].Value != nil || first[1].Value != "home" {
+		t.Fatalf("unexpected first contact %v", first)
+	}
+	if contacts[1].(map[string]any)["phone"] != nil {
+		t.Fatalf("expected second phone to be masked")
+	}
+	if doc["name"] != "alice" {
+		t.Fatalf("expected unrelated field untouched")
+	}
+}
+
+func TestProjectedRulesFollowsRenames(t *testing.T) {
+	stmt := `{"action":"aggregate","collection":"users","pipeline":[
+		{"$project":{"mail":"$profile.email","contact":{"primary":"$contacts.phone"}}},
+		{"$group":{"_id":"$mail","count":{"$sum":1}}}
+	]}`
+	rules := []store.PIIRule{
+		{Resource: "*", Field: "profile.email", MaskType: "mask"},
+		{Resource: "*", Field: "contacts[].phone", MaskType: "mask"},
+	}
+	out := ProjectedRules(stmt, rules)
+	fields := map[string]bool{}
+	for _, r := range out {
+		fields[r.Field] = true
+	}
+	for _, want := range []string{"mail", "_id", "contact.primary"} {
+		if !fields[want] {
+			t.Fatalf("expected derived rule for %s, got %v", want, fields)
+		}
+	}
+	if fields["count"] {
+		t.Fatalf("did not expect rule for count")
+	}
+}
+
+func TestApplicableRulesExemptions(t *testing.T) {
+	rules := []store.PIIRule{
+		{Field: "email", ExemptRoles: []string{"support-lead"}},
+		{Field: "ssn", ExemptRoles: []string{"support-lead"}, RequireJustification: true},
+	}
+	enforced, unmasked := ApplicableRules(rules, Viewer{Roles: []string{"Support-Lead"}})
+	if len(enforced) != 1 || enforced[0].Field != "ssn" || len(unmasked) != 1 {
+		t.Fatalf("unexpected split: %v / %v", enforced, unmasked)
+	}
+	enforced, _ = ApplicableRules(rules, Viewer{Roles: []string{"support-lead"}, Justification: "ticket 42"})
+	if len(enforced) != 0 {
+		t.Fatalf("expected justification to unmask, got %v", enforced)
+	}
+}
+
+func TestMaskedFields(t *testing.T) {
+	rules := []store.PIIRule{
+		{Resource: "connection/x/db/app/entity/users", Field: "email", MaskType: "mask"},
+		{Resource: "connection/x/db/app/entity/users", Field: "ssn", MaskType: "null"},
+		{Resource: "connection/x/db/app/entity/orders", Field: "card", MaskType: "mask"},
+	}
+	resources := []string{"connection/x/db/app/entity/users"}
+	got := MaskedFields(resources, []string{"id", "EMAIL"}, rules)
+	if len(got) != 1 || got[0] != "email" {
+		t.Fatalf("expected only returned columns, got %v", got)
+	}
+	got = MaskedFields(resources, nil, rules)
+	if len(got) != 2 {
+		t.Fatalf("expected every rule on the resource for documents, got %v", got)
+	}
+}
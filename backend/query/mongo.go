@@ -6,9 +6,12 @@ import (
 )
 
 type MongoDSL struct {
-	Action string `json:"action"`
+	Action   string           `json:"action"`
+	Pipeline []map[string]any `json:"pipeline"`
 }
 
+// IsMongoWrite reports whether a Mongo DSL statement writes: insert, update
+// and delete, and aggregates whose pipeline ends in $out or $merge.
 func IsMongoWrite(statement string) bool {
 	var dsl MongoDSL
 	if err := json.Unmarshal([]byte(statement), &dsl); err != nil {
@@ -17,6 +20,16 @@ func IsMongoWrite(statement string) bool {
 	switch strings.ToLower(dsl.Action) {
 	case "insert", "update", "delete":
 		return true
+	case "aggregate":
+		for _, stage := range dsl.Pipeline {
+			if _, ok := stage["$out"]; ok {
+				return true
+			}
+			if _, ok := stage["$merge"]; ok {
+				return true
+			}
+		}
+		return false
 	default:
 		return false
 	}
@@ -0,0 +1,149 @@
+package query
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"flowdb/backend/store"
+)
+
+type mongoPipeline struct {
+	Action   string           `json:"action"`
+	Pipeline []map[string]any `json:"pipeline"`
+}
+
+// ProjectedRules extends rules with the output fields an aggregation pipeline
+// derives from masked fields, so that values renamed by $project, $addFields,
+// $set, $group or $replaceRoot stay masked. Rules are returned unchanged for
+// anything that is not an aggregate statement.
+func ProjectedRules(statement string, rules []store.PIIRule) []store.PIIRule {
+	if len(rules) == 0 {
+		return rules
+	}
+	var q mongoPipeline
+	if err := json.Unmarshal([]byte(statement), &q); err != nil || !strings.EqualFold(q.Action, "aggregate") {
+		return rules
+	}
+	out := append([]store.PIIRule{}, rules...)
+	for _, rule := range rules {
+		tracked := map[string]bool{normalizePath(rule.Field): true}
+		for _, stage := range q.Pipeline {
+			tracked = applyStage(stage, tracked)
+		}
+		delete(tracked, normalizePath(rule.Field))
+		fields := make([]string, 0, len(tracked))
+		for field := range tracked {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			derived := rule
+			derived.Field = field
+			out = append(out, derived)
+		}
+	}
+	return out
+}
+
+func applyStage(stage map[string]any, tracked map[string]bool) map[string]bool {
+	next := map[string]bool{}
+	for path := range tracked {
+		next[path] = true
+	}
+	for op, spec := range stage {
+		switch op {
+		case "$project", "$addFields", "$set", "$group":
+			if fields, ok := spec.(map[string]any); ok {
+				trackFields("", fields, tracked, next)
+			}
+		case "$replaceRoot", "$replaceWith":
+			root := spec
+			if m, ok := spec.(map[string]any); ok && op == "$replaceRoot" {
+				root = m["newRoot"]
+			}
+			if ref, ok := root.(string); ok && strings.HasPrefix(ref, "$") {
+				prefix := strings.TrimPrefix(ref, "$") + "."
+				for path := range tracked {
+					if strings.HasPrefix(path, prefix) {
+						next[strings.TrimPrefix(path, prefix)] = true
+					}
+				}
+			} else if m, ok := root.(map[string]any); ok {
+				trackFields("", m, tracked, next)
+			}
+		}
+	}
+	return next
+}
+
+func trackFields(prefix string, fields map[string]any, tracked map[string]bool, next map[string]bool) {
+	for key, value := range fields {
+		out := key
+		if prefix != "" {
+			out = prefix + "." + key
+		}
+		refs := fieldRefs(value)
+		if len(refs) == 0 {
+			if nested, ok := value.(map[string]any); ok && !isOperator(nested) {
+				trackFields(out, nested, tracked, next)
+			}
+			continue
+		}
+		for _, ref := range refs {
+			for path := range tracked {
+				switch {
+				case ref == path || strings.HasPrefix(ref, path+"."):
+					next[out] = true
+				case strings.HasPrefix(path, ref+"."):
+					next[out+strings.TrimPrefix(path, ref)] = true
+				}
+			}
+		}
+	}
+}
+
+// fieldRefs returns the field paths referenced by an expression such as
+// "$email", {"$first": "$email"} or {"$concat": ["$first", " ", "$last"]}.
+func fieldRefs(value any) []string {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, "$") && !strings.HasPrefix(v, "$$") {
+			return []string{strings.TrimPrefix(v, "$")}
+		}
+	case []any:
+		var refs []string
+		for _, item := range v {
+			refs = append(refs, fieldRefs(item)...)
+		}
+		return refs
+	case map[string]any:
+		if !isOperator(v) {
+			return nil
+		}
+		var refs []string
+		for _, arg := range v {
+			refs = append(refs, fieldRefs(arg)...)
+			if m, ok := arg.(map[string]any); ok && !isOperator(m) {
+				for _, inner := range m {
+					refs = append(refs, fieldRefs(inner)...)
+				}
+			}
+		}
+		return refs
+	}
+	return nil
+}
+
+func isOperator(m map[string]any) bool {
+	for key := range m {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return len(m) > 0
+}
+
+func normalizePath(field string) string {
+	return strings.ReplaceAll(field, "[]", "")
+}
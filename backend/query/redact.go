@@ -0,0 +1,200 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const redacted = "****"
+
+// RedactLiterals replaces the string and number literals of a SQL or Mongo
+// DSL statement with ****. With sensitive set, only literals it reports are
+// replaced. Comments and identifiers are kept. It reports whether anything
+// was replaced.
+func RedactLiterals(connType string, stmt string, sensitive func(string) bool) (string, bool) {
+	if sensitive == nil {
+		sensitive = func(string) bool { return true }
+	}
+	if connType == "mongodb" {
+		return redactJSON(stmt, sensitive)
+	}
+	return redactSQL(stmt, sensitive)
+}
+
+func redactSQL(stmt string, sensitive func(string) bool) (string, bool) {
+	runes := []rune(stmt)
+	var b strings.Builder
+	changed := false
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			end := i
+			for end < len(runes) && runes[end] != '\n' {
+				end++
+			}
+			b.WriteString(string(runes[i:end]))
+			i = end
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			end := i + 2
+			for end+1 < len(runes) && !(runes[end] == '*' && runes[end+1] == '/') {
+				end++
+			}
+			end = min(end+2, len(runes))
+			b.WriteString(string(runes[i:end]))
+			i = end
+		case c == '\'':
+			var value strings.Builder
+			end := i + 1
+			for end < len(runes) {
+				if runes[end] == '\'' {
+					if end+1 < len(runes) && runes[end+1] == '\'' {
+						value.WriteRune('\'')
+						end += 2
+						continue
+					}
+					break
+				}
+				value.WriteRune(runes[end])
+				end++
+			}
+			end = min(end+1, len(runes))
+			if sensitive(value.String()) {
+				b.WriteString("'" + redacted + "'")
+				changed = true
+			} else {
+				b.WriteString(string(runes[i:end]))
+			}
+			i = end
+		case c == '$' && i+1 < len(runes) && (runes[i+1] == '$' || isIdentStart(runes[i+1])):
+			tagEnd := i + 1
+			for tagEnd < len(runes) && runes[tagEnd] != '$' {
+				tagEnd++
+			}
+			if tagEnd >= len(runes) {
+				b.WriteString(string(runes[i:]))
+				i = len(runes)
+				continue
+			}
+			tag := string(runes[i : tagEnd+1])
+			rest := string(runes[tagEnd+1:])
+			idx := strings.Index(rest, tag)
+			if idx < 0 {
+				b.WriteString(string(runes[i:]))
+				i = len(runes)
+				continue
+			}
+			if sensitive(rest[:idx]) {
+				b.WriteString("'" + redacted + "'")
+				changed = true
+			} else {
+				b.WriteString(tag + rest[:idx] + tag)
+			}
+			i = tagEnd + 1 + len([]rune(rest[:idx])) + len([]rune(tag))
+		case c == '$' && i+1 < len(runes) && runes[i+1] >= '0' && runes[i+1] <= '9':
+			// Positional parameters such as $1 are placeholders, not values.
+			end := i + 1
+			for end < len(runes) && runes[end] >= '0' && runes[end] <= '9' {
+				end++
+			}
+			b.WriteString(string(runes[i:end]))
+			i = end
+		case c == '"':
+			end := i + 1
+			for end < len(runes) {
+				if runes[end] == '"' {
+					if end+1 < len(runes) && runes[end+1] == '"' {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			end = min(end+1, len(runes))
+			b.WriteString(string(runes[i:end]))
+			i = end
+		case isIdentStart(c):
+			end := i
+			for end < len(runes) && (isIdentStart(runes[end]) || (runes[end] >= '0' && runes[end] <= '9') || runes[end] == '$') {
+				end++
+			}
+			b.WriteString(string(runes[i:end]))
+			i = end
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(runes) && ((runes[end] >= '0' && runes[end] <= '9') || runes[end] == '.' || runes[end] == 'e' || runes[end] == 'E') {
+				end++
+			}
+			if sensitive(string(runes[i:end])) {
+				b.WriteString(redacted)
+				changed = true
+			} else {
+				b.WriteString(string(runes[i:end]))
+			}
+			i = end
+		default:
+			b.WriteRune(c)
+			i++
+		}
+	}
+	return b.String(), changed
+}
+
+// redactJSON redacts string values and numbers in a JSON document. Object
+// keys, which name fields and operators, and top-level values such as the
+// action and collection are kept.
+func redactJSON(stmt string, sensitive func(string) bool) (string, bool) {
+	var b strings.Builder
+	changed := false
+	depth := 0
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(stmt) && stmt[end] != '"' {
+				if stmt[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(stmt))
+			raw := stmt[i:end]
+			var value string
+			if json.Unmarshal([]byte(raw), &value) == nil && depth > 1 && !isJSONKey(stmt[end:]) && sensitive(value) {
+				b.WriteString(`"` + redacted + `"`)
+				changed = true
+			} else {
+				b.WriteString(raw)
+			}
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(stmt) && strings.IndexByte("0123456789.eE+-", stmt[end]) >= 0 {
+				end++
+			}
+			if depth > 1 && sensitive(stmt[i:end]) {
+				b.WriteString(`"` + redacted + `"`)
+				changed = true
+			} else {
+				b.WriteString(stmt[i:end])
+			}
+			i = end
+		default:
+			switch c {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), changed
+}
+
+func isJSONKey(rest string) bool {
+	return strings.HasPrefix(strings.TrimLeft(rest, " \t\r\n"), ":")
+}
@@ -0,0 +1,46 @@
+package query
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactLiteralsSQL(t *testing.T) {
+	stmt := `SELECT "name" FROM users WHERE email = 'a@example.com' AND id = 42 -- 'kept'`
+	got, changed := RedactLiterals("postgres", stmt, nil)
+	want := `SELECT "name" FROM users WHERE email = '****' AND id = **** -- 'kept'`
+	if !changed || got != want {
+		t.Fatalf("got %q", got)
+	}
+	onlyEmail := func(v string) bool { return strings.Contains(v, "@") }
+	got, changed = RedactLiterals("postgres", `SELECT * FROM t2 WHERE a = 'it''s' OR b = 'x@y.z' OR c = $$x@y$$`, onlyEmail)
+	if !changed || got != `SELECT * FROM t2 WHERE a = 'it''s' OR b = '****' OR c = '****'` {
+		t.Fatalf("got %q", got)
+	}
+	if _, changed := RedactLiterals("postgres", "SELECT 1", onlyEmail); changed {
+		t.Fatalf("expected nothing redacted")
+	}
+}
+
+func TestRedactLiteralsSQLKeepsParameters(t *testing.T) {
+	got, changed := RedactLiterals("postgres", `UPDATE t SET a = $1, b = 7 WHERE id = $12`, nil)
+	if !changed || got != `UPDATE t SET a = $1, b = **** WHERE id = $12` {
+		t.Fatalf("got %q", got)
+	}
+	if got, changed := RedactLiterals("postgres", `SELECT * FROM t WHERE id = $1`, nil); changed || got != `SELECT * FROM t WHERE id = $1` {
+		t.Fatalf("expected parameters kept, got %q", got)
+	}
+}
+
+func TestRedactLiteralsMongo(t *testing.T) {
+	stmt := `{"action":"find","collection":"users","filter":{"email":"a@example.com","age":{"$gt":30}}}`
+	got, changed := RedactLiterals("mongodb", stmt, func(v string) bool { return strings.Contains(v, "@") })
+	want := `{"action":"find","collection":"users","filter":{"email":"****","age":{"$gt":30}}}`
+	if !changed || got != want {
+		t.Fatalf("got %q", got)
+	}
+	got, _ = RedactLiterals("mongodb", stmt, nil)
+	if !strings.Contains(got, `"collection":"users"`) || strings.Contains(got, "30") || strings.Contains(got, "example") {
+		t.Fatalf("expected nested values redacted and top level kept, got %q", got)
+	}
+}
@@ -0,0 +1,574 @@
+package query
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Reference is a schema/collection-level object touched by a statement,
+// together with the columns or fields it reads or writes.
+type Reference struct {
+	Namespace string
+	Entity    string
+	Columns   []string
+}
+
+// StatementReferences extracts the entities and columns referenced by a
+// statement for the given connection type. The result is best effort; callers
+// should fall back to connection-wide resources when it is empty.
+func StatementReferences(connType string, statement string, defaultNamespace string) []Reference {
+	switch connType {
+	case "postgres":
+		if defaultNamespace == "" {
+			defaultNamespace = "public"
+		}
+		return SQLReferences(statement, defaultNamespace)
+	case "mongodb":
+		return MongoReferences(statement, defaultNamespace)
+	default:
+		return nil
+	}
+}
+
+// ReferenceResources maps references to policy resources of the form
+// connection/<id>/db/<ns>/entity/<name> and .../column/<col>. Entity
+// resources come first, in a stable order. The column "*" becomes
+// .../column/*, which policies treat as every column of the entity.
+func ReferenceResources(connectionID string, refs []Reference) ([]string, []string) {
+	var entities []string
+	var columns []string
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		entity := "connection/" + connectionID + "/db/" + ref.Namespace + "/entity/" + ref.Entity
+		if !seen[entity] {
+			seen[entity] = true
+			entities = append(entities, entity)
+		}
+		for _, col := range ref.Columns {
+			column := entity + "/column/" + col
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	return entities, columns
+}
+
+type sqlToken struct {
+	text   string
+	quoted bool
+	ident  bool
+}
+
+// SQLReferences finds tables after FROM/JOIN/UPDATE/INTO/TABLE/COPY and
+// USING (DELETE ... USING, MERGE ... USING, but not JOIN ... USING (col)) and the column
+// identifiers used elsewhere in the statement. Qualified columns are bound to
+// their table or alias; unqualified columns are attributed to every table in
+// the statement so that authorization errs on the side of denial. A star in
+// the select list or RETURNING is recorded as the column "*".
+func SQLReferences(stmt string, defaultSchema string) []Reference {
+	tokens := tokenizeSQL(stmt)
+	ctes := map[string]bool{}
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].ident && keywordIs(tokens[i+1], "as") && tokens[i+2].text == "(" {
+			if i > 0 && (keywordIs(tokens[i-1], "with") || keywordIs(tokens[i-1], "recursive") || tokens[i-1].text == ",") {
+				ctes[identName(tokens[i])] = true
+			}
+		}
+	}
+	type table struct {
+		ref     *Reference
+		aliases []string
+	}
+	var tables []*table
+	byName := map[string]*table{}
+	tablePositions := map[int]bool{}
+	addTable := func(parts []string, alias string) {
+		ns := defaultSchema
+		name := parts[len(parts)-1]
+		if len(parts) >= 2 {
+			ns = parts[len(parts)-2]
+		}
+		if len(parts) == 1 && ctes[name] {
+			return
+		}
+		key := ns + "." + name
+		t, ok := byName[key]
+		if !ok {
+			t = &table{ref: &Reference{Namespace: ns, Entity: name}}
+			byName[key] = t
+			tables = append(tables, t)
+		}
+		t.aliases = append(t.aliases, name, key)
+		if alias != "" {
+			t.aliases = append(t.aliases, alias)
+		}
+	}
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if !(keywordIs(tok, "from") || keywordIs(tok, "join") || keywordIs(tok, "update") || keywordIs(tok, "into") || keywordIs(tok, "table") || keywordIs(tok, "truncate") || keywordIs(tok, "copy") || keywordIs(tok, "using")) {
+			continue
+		}
+		if keywordIs(tok, "using") && i+1 < len(tokens) && tokens[i+1].text == "(" {
+			continue
+		}
+		j := i + 1
+		for {
+			for j < len(tokens) && (keywordIs(tokens[j], "only") || keywordIs(tokens[j], "lateral") || keywordIs(tokens[j], "if") || keywordIs(tokens[j], "not") || keywordIs(tokens[j], "exists")) {
+				j++
+			}
+			if j >= len(tokens) || !tokens[j].ident || (isKeyword(tokens[j]) && !tokens[j].quoted) {
+				break
+			}
+			start := j
+			parts := []string{identName(tokens[j])}
+			j++
+			for j+1 < len(tokens) && tokens[j].text == "." && tokens[j+1].ident {
+				parts = append(parts, identName(tokens[j+1]))
+				j += 2
+			}
+			if j < len(tokens) && tokens[j].text == "(" && !keywordIs(tok, "into") {
+				break
+			}
+			for k := start; k < j; k++ {
+				tablePositions[k] = true
+			}
+			alias := ""
+			if j < len(tokens) && keywordIs(tokens[j], "as") {
+				j++
+			}
+			if j < len(tokens) && tokens[j].ident && !isKeyword(tokens[j]) {
+				alias = identName(tokens[j])
+				tablePositions[j] = true
+				j++
+			}
+			addTable(parts, alias)
+			if j < len(tokens) && tokens[j].text == "," && (keywordIs(tok, "from") || keywordIs(tok, "truncate") || keywordIs(tok, "using")) {
+				j++
+				continue
+			}
+			break
+		}
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	lookup := func(name string) *table {
+		for _, t := range tables {
+			for _, a := range t.aliases {
+				if a == name {
+					return t
+				}
+			}
+		}
+		return nil
+	}
+	addColumn := func(t *table, col string) {
+		for _, c := range t.ref.Columns {
+			if c == col {
+				return
+			}
+		}
+		t.ref.Columns = append(t.ref.Columns, col)
+	}
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.text == "*" && !tok.ident && i > 0 && selectsAll(tokens[i-1]) {
+			for _, t := range tables {
+				addColumn(t, "*")
+			}
+			continue
+		}
+		if !tok.ident || tablePositions[i] || (isKeyword(tok) && !tok.quoted) {
+			continue
+		}
+		if i > 0 && (keywordIs(tokens[i-1], "as") || tokens[i-1].text == "::") {
+			continue
+		}
+		if ctes[identName(tok)] {
+			continue
+		}
+		parts := []string{identName(tok)}
+		j := i + 1
+		for j+1 < len(tokens) && tokens[j].text == "." && (tokens[j+1].ident || tokens[j+1].text == "*") {
+			parts = append(parts, identName(tokens[j+1]))
+			j += 2
+		}
+		if j < len(tokens) && tokens[j].text == "(" {
+			i = j - 1
+			continue
+		}
+		i = j - 1
+		col := parts[len(parts)-1]
+		if len(parts) == 1 {
+			for _, t := range tables {
+				addColumn(t, col)
+			}
+			continue
+		}
+		if t := lookup(strings.Join(parts[:len(parts)-1], ".")); t != nil {
+			addColumn(t, col)
+		} else if t := lookup(parts[len(parts)-2]); t != nil {
+			addColumn(t, col)
+		}
+	}
+	refs := make([]Reference, 0, len(tables))
+	for _, t := range tables {
+		sort.Strings(t.ref.Columns)
+		refs = append(refs, *t.ref)
+	}
+	return refs
+}
+
+// selectsAll reports whether a "*" after prev is a select-list or RETURNING
+// star rather than multiplication or count(*).
+func selectsAll(prev sqlToken) bool {
+	return prev.text == "," || keywordIs(prev, "select") || keywordIs(prev, "distinct") || keywordIs(prev, "all") || keywordIs(prev, "returning")
+}
+
+func tokenizeSQL(stmt string) []sqlToken {
+	var tokens []sqlToken
+	runes := []rune(stmt)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case c == '\'':
+			i++
+			for i < len(runes) {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			tokens = append(tokens, sqlToken{text: "?"})
+		case c == '$' && i+1 < len(runes) && (runes[i+1] == '$' || isIdentStart(runes[i+1])):
+			end := i + 1
+			for end < len(runes) && runes[end] != '$' {
+				end++
+			}
+			if end >= len(runes) {
+				i = len(runes)
+				continue
+			}
+			tag := string(runes[i : end+1])
+			body := strings.Index(string(runes[end+1:]), tag)
+			if body < 0 {
+				i = len(runes)
+			} else {
+				i = end + 1 + len([]rune(string(runes[end+1:])[:body])) + len([]rune(tag))
+			}
+			tokens = append(tokens, sqlToken{text: "?"})
+		case c == '"':
+			end := i + 1
+			var b strings.Builder
+			for end < len(runes) {
+				if runes[end] == '"' {
+					if end+1 < len(runes) && runes[end+1] == '"' {
+						b.WriteRune('"')
+						end += 2
+						continue
+					}
+					break
+				}
+				b.WriteRune(runes[end])
+				end++
+			}
+			tokens = append(tokens, sqlToken{text: b.String(), quoted: true, ident: true})
+			i = end + 1
+		case isIdentStart(c):
+			end := i
+			for end < len(runes) && (isIdentStart(runes[end]) || (runes[end] >= '0' && runes[end] <= '9') || runes[end] == '$') {
+				end++
+			}
+			tokens = append(tokens, sqlToken{text: string(runes[i:end]), ident: true})
+			i = end
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(runes) && ((runes[end] >= '0' && runes[end] <= '9') || runes[end] == '.' || runes[end] == 'e' || runes[end] == 'E') {
+				end++
+			}
+			tokens = append(tokens, sqlToken{text: "?"})
+			i = end
+		case c == ':' && i+1 < len(runes) && runes[i+1] == ':':
+			tokens = append(tokens, sqlToken{text: "::"})
+			i += 2
+		default:
+			tokens = append(tokens, sqlToken{text: string(c)})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r > 127
+}
+
+func identName(tok sqlToken) string {
+	if tok.quoted {
+		return tok.text
+	}
+	return strings.ToLower(tok.text)
+}
+
+func keywordIs(tok sqlToken, kw string) bool {
+	return tok.ident && !tok.quoted && strings.EqualFold(tok.text, kw)
+}
+
+func isKeyword(tok sqlToken) bool {
+	if !tok.ident || tok.quoted {
+		return false
+	}
+	return sqlKeywords[strings.ToLower(tok.text)]
+}
+
+var sqlKeywords = map[string]bool{}
+
+func init() {
+	for _, kw := range strings.Fields(`
+		select from where and or not in is null like ilike between exists as on join inner left right full outer cross natural using
+		group by order having limit offset fetch first next rows row only distinct all any some union intersect except
+		insert into values update set delete returning default create alter drop truncate table index view if cascade restrict
+		with recursive case when then else end asc desc nulls true false cast interval lateral over partition window filter
+		within conflict do nothing primary key references constraint unique check add column rename to schema sequence
+		begin commit rollback explain analyze verbose for share nowait skip locked similar escape collate current_date
+		current_time current_timestamp current_user session_user localtime localtimestamp array
+		copy stdin stdout merge matched
+	`) {
+		sqlKeywords[kw] = true
+	}
+}
+
+type mongoDSL struct {
+	Action     string           `json:"action"`
+	Collection string           `json:"collection"`
+	Filter     map[string]any   `json:"filter"`
+	Pipeline   []map[string]any `json:"pipeline"`
+	Document   any              `json:"document"`
+	Update     any              `json:"update"`
+	Options    map[string]any   `json:"options"`
+}
+
+// MongoReferences returns the collection named by a Mongo DSL statement, any
+// collections pulled in by $lookup/$unionWith/$out/$merge at any depth of the
+// pipeline, and the top-level fields used in filters, projections, sorts,
+// documents and updates.
+func MongoReferences(stmt string, database string) []Reference {
+	var q mongoDSL
+	if err := json.Unmarshal([]byte(stmt), &q); err != nil || q.Collection == "" {
+		return nil
+	}
+	main := Reference{Namespace: database, Entity: q.Collection}
+	fields := map[string]bool{}
+	collectFilterFields(q.Filter, fields)
+	if returnsAllFields(q) {
+		fields["*"] = true
+	}
+	if q.Options != nil {
+		for _, key := range []string{"projection", "sort"} {
+			if m, ok := q.Options[key].(map[string]any); ok {
+				for field := range m {
+					fields[field] = true
+				}
+			}
+		}
+	}
+	if doc, ok := q.Document.(map[string]any); ok {
+		for field := range doc {
+			fields[field] = true
+		}
+	}
+	if update, ok := q.Update.(map[string]any); ok {
+		for op, spec := range update {
+			if m, ok := spec.(map[string]any); ok && strings.HasPrefix(op, "$") {
+				for field := range m {
+					fields[field] = true
+				}
+			}
+		}
+	}
+	refs := []Reference{}
+	extra := map[mongoCollection]bool{}
+	collectStages(q.Pipeline, database, fields, extra)
+	if extra[mongoCollection{database, q.Collection}] {
+		fields["*"] = true
+	}
+	for field := range fields {
+		main.Columns = append(main.Columns, field)
+	}
+	sort.Strings(main.Columns)
+	refs = append(refs, main)
+	colls := make([]mongoCollection, 0, len(extra))
+	for coll := range extra {
+		if coll != (mongoCollection{database, q.Collection}) {
+			colls = append(colls, coll)
+		}
+	}
+	sort.Slice(colls, func(i, j int) bool {
+		if colls[i].name != colls[j].name {
+			return colls[i].name < colls[j].name
+		}
+		return colls[i].database < colls[j].database
+	})
+	for _, coll := range colls {
+		ref := Reference{Namespace: coll.database, Entity: coll.name}
+		if extra[coll] {
+			ref.Columns = []string{"*"}
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+type mongoCollection struct {
+	database string
+	name     string
+}
+
+// collectStages records the fields a pipeline reads from its input in fields
+// and the collections it pulls in or writes in extra, walking the
+// sub-pipelines of $facet, $lookup and $unionWith. extra is true for
+// collections whose documents are read whole. fields is nil for pipelines
+// that run on another collection. $out and $merge may name a target in
+// another database.
+func collectStages(stages []map[string]any, database string, fields map[string]bool, extra map[mongoCollection]bool) {
+	addCollection := func(name string, read bool) {
+		if name != "" {
+			coll := mongoCollection{database, name}
+			extra[coll] = extra[coll] || read
+		}
+	}
+	addTarget := func(target any) {
+		switch t := target.(type) {
+		case string:
+			addCollection(t, false)
+		case map[string]any:
+			db, _ := t["db"].(string)
+			name, _ := t["coll"].(string)
+			if name == "" {
+				return
+			}
+			if db == "" {
+				db = database
+			}
+			if _, ok := extra[mongoCollection{db, name}]; !ok {
+				extra[mongoCollection{db, name}] = false
+			}
+		}
+	}
+	for _, stage := range stages {
+		for op, spec := range stage {
+			m, _ := spec.(map[string]any)
+			switch op {
+			case "$match":
+				if m != nil && fields != nil {
+					collectFilterFields(m, fields)
+				}
+			case "$facet":
+				for _, sub := range m {
+					collectStages(pipelineStages(sub), database, fields, extra)
+				}
+			case "$lookup", "$graphLookup":
+				if m == nil {
+					continue
+				}
+				from, _ := m["from"].(string)
+				addCollection(from, true)
+				if local, ok := m["localField"].(string); ok && fields != nil {
+					fields[local] = true
+				}
+				collectStages(pipelineStages(m["pipeline"]), database, nil, extra)
+			case "$unionWith":
+				if coll, ok := spec.(string); ok {
+					addCollection(coll, true)
+				} else if m != nil {
+					coll, _ := m["coll"].(string)
+					addCollection(coll, true)
+					collectStages(pipelineStages(m["pipeline"]), database, nil, extra)
+				}
+			case "$out":
+				addTarget(spec)
+			case "$merge":
+				if m != nil {
+					addTarget(m["into"])
+				} else {
+					addTarget(spec)
+				}
+			}
+		}
+	}
+}
+
+func pipelineStages(value any) []map[string]any {
+	list, _ := value.([]any)
+	stages := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		if stage, ok := item.(map[string]any); ok {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// returnsAllFields reports whether a find or aggregate returns whole
+// documents: a find without an inclusion projection or an aggregate without
+// a $project stage.
+func returnsAllFields(q mongoDSL) bool {
+	switch strings.ToLower(q.Action) {
+	case "", "find":
+		projection, _ := q.Options["projection"].(map[string]any)
+		if len(projection) == 0 {
+			return true
+		}
+		for field, value := range projection {
+			if field != "_id" && (value == false || value == float64(0)) {
+				return true
+			}
+		}
+		return false
+	case "aggregate":
+		for _, stage := range q.Pipeline {
+			if _, ok := stage["$project"]; ok {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func collectFilterFields(filter map[string]any, fields map[string]bool) {
+	for key, value := range filter {
+		if strings.HasPrefix(key, "$") {
+			if list, ok := value.([]any); ok {
+				for _, item := range list {
+					if m, ok := item.(map[string]any); ok {
+						collectFilterFields(m, fields)
+					}
+				}
+			}
+			continue
+		}
+		fields[key] = true
+	}
+}
@@ -0,0 +1,174 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSQLReferences(t *testing.T) {
+	stmt := `WITH recent AS (SELECT id FROM orders WHERE created_at > now())
+		SELECT o.total, p.card_number, status
+		FROM sales.orders o
+		JOIN "Payments" AS p ON p.order_id = o.id
+		WHERE o.id IN (SELECT id FROM recent) AND note = 'from users'`
+	refs := SQLReferences(stmt, "public")
+	got := map[string][]string{}
+	for _, ref := range refs {
+		got[ref.Namespace+"."+ref.Entity] = ref.Columns
+	}
+	if _, ok := got["public.users"]; ok {
+		t.Fatalf("string literal treated as table: %v", got)
+	}
+	if _, ok := got["public.recent"]; ok {
+		t.Fatalf("cte treated as table: %v", got)
+	}
+	if cols := got["public.Payments"]; !reflect.DeepEqual(cols, []string{"card_number", "created_at", "id", "note", "order_id", "status"}) {
+		t.Fatalf("unexpected payments columns %v", cols)
+	}
+	if cols := got["sales.orders"]; !reflect.DeepEqual(cols, []string{"created_at", "id", "note", "status", "total"}) {
+		t.Fatalf("unexpected orders columns %v", cols)
+	}
+	if cols := got["public.orders"]; !reflect.DeepEqual(cols, []string{"created_at", "id", "note", "status"}) {
+		t.Fatalf("unexpected cte source columns %v", cols)
+	}
+}
+
+func TestSQLReferencesWrites(t *testing.T) {
+	refs := SQLReferences(`INSERT INTO audit.events (kind, payload) VALUES ($1, 'x')`, "public")
+	if len(refs) != 1 || refs[0].Namespace != "audit" || !reflect.DeepEqual(refs[0].Columns, []string{"kind", "payload"}) {
+		t.Fatalf("unexpected insert refs %+v", refs)
+	}
+	refs = SQLReferences(`UPDATE users SET email = lower(email) WHERE id = 1`, "public")
+	if len(refs) != 1 || refs[0].Entity != "users" || !reflect.DeepEqual(refs[0].Columns, []string{"email", "id"}) {
+		t.Fatalf("unexpected update refs %+v", refs)
+	}
+}
+
+func TestSQLReferencesUsing(t *testing.T) {
+	cases := []struct {
+		stmt string
+		want map[string][]string
+	}{
+		{`DELETE FROM orders USING payments p WHERE p.order_id = orders.id`, map[string][]string{
+			"public.orders":   {"id"},
+			"public.payments": {"order_id"},
+		}},
+		{`MERGE INTO stock t USING billing.deliveries d ON t.item_id = d.item_id WHEN MATCHED THEN UPDATE SET qty = t.qty + d.qty`, map[string][]string{
+			"public.stock":       {"item_id", "qty"},
+			"billing.deliveries": {"item_id", "qty"},
+		}},
+		{`SELECT name FROM users JOIN teams USING (team_id)`, map[string][]string{
+			"public.users": {"name", "team_id"},
+			"public.teams": {"name", "team_id"},
+		}},
+	}
+	for _, tc := range cases {
+		got := map[string][]string{}
+		for _, ref := range SQLReferences(tc.stmt, "public") {
+			got[ref.Namespace+"."+ref.Entity] = ref.Columns
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("SQLReferences(%s) = %v, want %v", tc.stmt, got, tc.want)
+		}
+	}
+}
+
+func TestSQLReferencesCopy(t *testing.T) {
+	refs := SQLReferences(`COPY billing.invoices TO STDOUT`, "public")
+	if len(refs) != 1 || refs[0].Namespace != "billing" || refs[0].Entity != "invoices" || len(refs[0].Columns) != 0 {
+		t.Fatalf("unexpected copy refs %+v", refs)
+	}
+}
+
+func TestSQLReferencesStar(t *testing.T) {
+	refs := SQLReferences(`SELECT o.*, count(*) * 2 FROM billing.orders o JOIN users u ON u.id = o.user_id`, "public")
+	got := map[string][]string{}
+	for _, ref := range refs {
+		got[ref.Namespace+"."+ref.Entity] = ref.Columns
+	}
+	if cols := got["billing.orders"]; !reflect.DeepEqual(cols, []string{"*", "user_id"}) {
+		t.Fatalf("unexpected orders columns %v", cols)
+	}
+	if cols := got["public.users"]; !reflect.DeepEqual(cols, []string{"id"}) {
+		t.Fatalf("unexpected users columns %v", cols)
+	}
+	refs = SQLReferences(`SELECT * FROM billing.invoices`, "public")
+	if _, columns := ReferenceResources("c1", refs); !reflect.DeepEqual(columns, []string{"connection/c1/db/billing/entity/invoices/column/*"}) {
+		t.Fatalf("unexpected star resources %v", columns)
+	}
+}
+
+func TestMongoReferences(t *testing.T) {
+	stmt := `{"action":"aggregate","collection":"orders","pipeline":[
+		{"$match":{"$or":[{"status":"paid"},{"total":{"$gt":10}}]}},
+		{"$lookup":{"from":"customers","localField":"customerId","foreignField":"_id","as":"customer"}}
+	]}`
+	refs := MongoReferences(stmt, "shop")
+	if len(refs) != 2 || refs[1].Entity != "customers" {
+		t.Fatalf("unexpected refs %+v", refs)
+	}
+	if !reflect.DeepEqual(refs[0].Columns, []string{"*", "customerId", "status", "total"}) {
+		t.Fatalf("unexpected fields %v", refs[0].Columns)
+	}
+	refs = MongoReferences(`{"action":"find","collection":"users","options":{"projection":{"email":1,"_id":0}}}`, "shop")
+	if !reflect.DeepEqual(refs[0].Columns, []string{"_id", "email"}) {
+		t.Fatalf("unexpected projected fields %v", refs[0].Columns)
+	}
+	refs = MongoReferences(stmt, "shop")
+	entities, columns := ReferenceResources("c1", refs)
+	if entities[0] != "connection/c1/db/shop/entity/orders" || columns[0] != "connection/c1/db/shop/entity/orders/column/*" {
+		t.Fatalf("unexpected resources %v %v", entities, columns)
+	}
+}
+
+func TestMongoReferencesNestedPipelines(t *testing.T) {
+	cases := []struct {
+		stmt string
+		want []string
+	}{
+		{`{"action":"aggregate","collection":"users","pipeline":[{"$facet":{"a":[{"$lookup":{"from":"secrets","localField":"id","foreignField":"uid","as":"s"}}]}}]}`, []string{"users", "secrets"}},
+		{`{"action":"aggregate","collection":"users","pipeline":[{"$lookup":{"from":"orders","pipeline":[{"$unionWith":"payroll"}],"as":"o"}}]}`, []string{"users", "orders", "payroll"}},
+		{`{"action":"aggregate","collection":"users","pipeline":[{"$unionWith":{"coll":"staff","pipeline":[{"$lookup":{"from":"salaries","as":"s"}}]}},{"$out":"report"}]}`, []string{"users", "report", "salaries", "staff"}},
+	}
+	for _, tc := range cases {
+		var got []string
+		for _, ref := range MongoReferences(tc.stmt, "shop") {
+			got = append(got, ref.Entity)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("MongoReferences(%s) = %v, want %v", tc.stmt, got, tc.want)
+		}
+	}
+	refs := MongoReferences(cases[1].stmt, "shop")
+	if !reflect.DeepEqual(refs[1].Columns, []string{"*"}) {
+		t.Fatalf("expected looked up collection to be read whole, got %v", refs[1].Columns)
+	}
+}
+
+func TestMongoReferencesWriteTargets(t *testing.T) {
+	cases := []struct {
+		stmt string
+		want []string
+	}{
+		{`{"action":"aggregate","collection":"users","pipeline":[{"$out":{"db":"other","coll":"x"}}]}`, []string{"shop.users", "other.x"}},
+		{`{"action":"aggregate","collection":"users","pipeline":[{"$out":{"coll":"x"}}]}`, []string{"shop.users", "shop.x"}},
+		{`{"action":"aggregate","collection":"users","pipeline":[{"$merge":{"into":{"db":"other","coll":"x"},"on":"_id"}}]}`, []string{"shop.users", "other.x"}},
+		{`{"action":"aggregate","collection":"users","pipeline":[{"$merge":{"into":"x"}}]}`, []string{"shop.users", "shop.x"}},
+		{`{"action":"aggregate","collection":"users","pipeline":[{"$merge":"x"}]}`, []string{"shop.users", "shop.x"}},
+	}
+	for _, tc := range cases {
+		var got []string
+		for _, ref := range MongoReferences(tc.stmt, "shop") {
+			got = append(got, ref.Namespace+"."+ref.Entity)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("MongoReferences(%s) = %v, want %v", tc.stmt, got, tc.want)
+		}
+		if !IsMongoWrite(tc.stmt) {
+			t.Errorf("IsMongoWrite(%s) = false, want true", tc.stmt)
+		}
+	}
+	if IsMongoWrite(`{"action":"aggregate","collection":"users","pipeline":[{"$match":{"a":1}}]}`) {
+		t.Fatal("read-only aggregate reported as write")
+	}
+}
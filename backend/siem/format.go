@@ -0,0 +1,137 @@
+package siem
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"flowdb/backend/store"
+	"flowdb/backend/util"
+)
+
+const (
+	FormatCEF  = "cef"
+	FormatJSON = "json"
+)
+
+// Record is the JSON form of an audit entry sent to collectors. Details are
+// embedded as JSON rather than the base64 of store.AuditEntry.
+type Record struct {
+	ID        string          `json:"id"`
+	Seq       int64           `json:"seq"`
+	EventType string          `json:"eventType"`
+	ActorID   string          `json:"actorId,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	RequestID string          `json:"requestId,omitempty"`
+	ClientIP  string          `json:"clientIp,omitempty"`
+	UserAgent string          `json:"userAgent,omitempty"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"createdAt"`
+	PrevHash  string          `json:"prevHash,omitempty"`
+	Hash      string          `json:"hash,omitempty"`
+	ErrorID   string          `json:"errorId,omitempty"`
+}
+
+func NewRecord(entry store.AuditEntry) Record {
+	rec := Record{
+		ID:        entry.ID.String(),
+		Seq:       entry.Seq,
+		EventType: entry.EventType,
+		RequestID: entry.RequestID,
+		ClientIP:  entry.ClientIP,
+		UserAgent: entry.UserAgent,
+		Details:   json.RawMessage(entry.Details),
+		CreatedAt: entry.CreatedAt.UTC(),
+		PrevHash:  entry.PrevHash,
+		Hash:      entry.Hash,
+		ErrorID:   entry.ErrorID,
+	}
+	if entry.ActorID != nil {
+		rec.ActorID = entry.ActorID.String()
+	}
+	if entry.SessionID != nil {
+		rec.SessionID = entry.SessionID.String()
+	}
+	if len(rec.Details) == 0 || !json.Valid(rec.Details) {
+		rec.Details = json.RawMessage(`{}`)
+	}
+	return rec
+}
+
+// Format renders an entry as a single line in the given format.
+func Format(format string, entry store.AuditEntry) ([]byte, error) {
+	if format == FormatCEF {
+		return []byte(CEF(entry)), nil
+	}
+	return json.Marshal(NewRecord(entry))
+}
+
+// Severity is the CEF severity (0-10) of an event: failures, denials and
+// break-glass access rank higher than routine events.
+func Severity(eventType string) int {
+	switch {
+	case strings.Contains(eventType, "break_glass"):
+		return 8
+	case strings.Contains(eventType, "failed"), strings.Contains(eventType, "denied"):
+		return 6
+	default:
+		return 3
+	}
+}
+
+// CEF renders an entry as an ArcSight Common Event Format line.
+func CEF(entry store.AuditEntry) string {
+	rec := NewRecord(entry)
+	ext := []string{
+		"rt=" + strconv.FormatInt(rec.CreatedAt.UnixMilli(), 10),
+		"externalId=" + cefValue(rec.ID),
+		"cn1=" + strconv.FormatInt(rec.Seq, 10),
+		"cn1Label=seq",
+	}
+	if rec.ActorID != "" {
+		ext = append(ext, "suid="+cefValue(rec.ActorID))
+	}
+	if rec.Hash != "" {
+		ext = append(ext, "cs1="+cefValue(rec.Hash), "cs1Label=hash")
+	}
+	if rec.ErrorID != "" {
+		ext = append(ext, "cs2="+cefValue(rec.ErrorID), "cs2Label=errorId")
+	}
+	if rec.RequestID != "" {
+		ext = append(ext, "cs3="+cefValue(rec.RequestID), "cs3Label=requestId")
+	}
+	if rec.SessionID != "" {
+		ext = append(ext, "cs4="+cefValue(rec.SessionID), "cs4Label=sessionId")
+	}
+	if rec.ClientIP != "" {
+		ext = append(ext, "src="+cefValue(rec.ClientIP))
+	}
+	if rec.UserAgent != "" {
+		ext = append(ext, "requestClientApplication="+cefValue(rec.UserAgent))
+	}
+	ext = append(ext, "msg="+cefValue(string(rec.Details)))
+	return strings.Join([]string{
+		"CEF:0",
+		"Vietrix",
+		"FlowDB",
+		cefHeader(util.Version),
+		cefHeader(rec.EventType),
+		cefHeader(rec.EventType),
+		strconv.Itoa(Severity(rec.EventType)),
+		strings.Join(ext, " "),
+	}, "|")
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`)
+)
+
+func cefHeader(value string) string {
+	return cefHeaderEscaper.Replace(value)
+}
+
+func cefValue(value string) string {
+	return cefValueEscaper.Replace(value)
+}
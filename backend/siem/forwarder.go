@@ -0,0 +1,116 @@
+package siem
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"flowdb/backend/store"
+)
+
+const (
+	// SyslogCursor is the cursor name of the syslog forwarder.
+	SyslogCursor = "syslog"
+
+	batchSize = 500
+)
+
+// Sender delivers a batch of rendered messages, all or nothing from the
+// caller's point of view: on error the batch is sent again later.
+type Sender interface {
+	Send(msgs [][]byte) error
+}
+
+// Forwarder sends audit entries to syslog in seq order. Its position is kept
+// in the audit_cursors table and only advances after a batch was written, so
+// a restart or an unreachable collector never skips entries; a batch that
+// failed part way is sent again in full.
+type Forwarder struct {
+	store    *store.Store
+	sender   Sender
+	format   string
+	facility int
+	hostname string
+	interval time.Duration
+	settle   time.Duration
+	logger   *slog.Logger
+}
+
+func NewForwarder(st *store.Store, sender Sender, format string, facility int, interval time.Duration, settle time.Duration, logger *slog.Logger) *Forwarder {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	hostname, _ := os.Hostname()
+	return &Forwarder{
+		store:    st,
+		sender:   sender,
+		format:   format,
+		facility: facility,
+		hostname: hostname,
+		interval: interval,
+		settle:   settle,
+		logger:   logger,
+	}
+}
+
+func (f *Forwarder) Start(ctx context.Context) {
+	ticker := time.NewTicker(f.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := f.Sweep(ctx); err != nil {
+					f.logger.Error("siem forward failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Sweep forwards every settled entry after the cursor.
+func (f *Forwarder) Sweep(ctx context.Context) error {
+	var after int64
+	cursor, err := f.store.GetAuditCursor(ctx, SyslogCursor)
+	switch {
+	case err == nil:
+		after = cursor.LastSeq
+	case !errors.Is(err, store.ErrNotFound):
+		return err
+	}
+	for {
+		entries, err := f.store.ListAuditSettled(ctx, after, time.Now().Add(-f.settle), batchSize)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		msgs := make([][]byte, 0, len(entries))
+		for _, entry := range entries {
+			body, err := Format(f.format, entry)
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, Message(f.facility, f.hostname, entry.CreatedAt, entry.EventType, body))
+		}
+		if err := f.sender.Send(msgs); err != nil {
+			_ = f.store.RecordAuditCursorError(ctx, SyslogCursor, store.AuditCursorSyslog, err.Error())
+			return err
+		}
+		after = entries[len(entries)-1].Seq
+		if err := f.store.AdvanceAuditCursor(ctx, SyslogCursor, store.AuditCursorSyslog, after, len(entries)); err != nil {
+			return err
+		}
+		if len(entries) < batchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package siem
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+func testEntry() store.AuditEntry {
+	actor := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	return store.AuditEntry{
+		ID:        uuid.MustParse("22222222-2222-2222-2222-222222222222"),
+		Seq:       42,
+		EventType: "login_failed",
+		ActorID:   &actor,
+		ClientIP:  "203.0.113.7",
+		UserAgent: "curl/8.0",
+		Details:   []byte(`{"reason":"a=b|c\\d","note":"x\ny"}`),
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Hash:      "abc",
+	}
+}
+
+func TestCEF(t *testing.T) {
+	line := CEF(testEntry())
+	if !strings.HasPrefix(line, "CEF:0|Vietrix|FlowDB|") {
+		t.Fatalf("unexpected prefix: %s", line)
+	}
+	if strings.Contains(line, "\n") {
+		t.Fatalf("line contains a newline: %q", line)
+	}
+	for _, want := range []string{
+		"|login_failed|login_failed|6|",
+		"rt=1704164645000",
+		"externalId=22222222-2222-2222-2222-222222222222",
+		"cn1=42 cn1Label=seq",
+		"suid=11111111-1111-1111-1111-111111111111",
+		"cs1=abc cs1Label=hash",
+		"src=203.0.113.7 requestClientApplication=curl/8.0",
+		`msg={"reason":"a\=b|c\\\\d","note":"x\\ny"}`,
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("missing %q in %s", want, line)
+		}
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	body, err := Format(FormatJSON, testEntry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal(body, &rec); err != nil {
+		t.Fatal(err)
+	}
+	details, ok := rec["details"].(map[string]any)
+	if !ok || details["note"] != "x\ny" || rec["seq"] != float64(42) {
+		t.Fatalf("unexpected record %s", body)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	msg := string(Message(FacilityLogAudit, "db host", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "login_failed", []byte("body")))
+	want := "<108>1 2024-01-02T03:04:05.000000Z dbhost flowdb - login_failed - body"
+	if msg != want {
+		t.Fatalf("got %q, want %q", msg, want)
+	}
+	if msg := string(Message(FacilityLogAudit, "", time.Unix(0, 0), "login", nil)); !strings.HasPrefix(msg, "<109>1 ") || !strings.Contains(msg, " - flowdb - login - ") {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var frames []string
+		for len(frames) < 2 {
+			size, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(size))
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			frames = append(frames, string(buf))
+		}
+		received <- frames
+	}()
+	sender := &Syslog{Network: "tcp", Addr: ln.Addr().String(), Timeout: 5 * time.Second}
+	defer sender.Close()
+	if err := sender.Send([][]byte{[]byte("first message"), []byte("second")}); err != nil {
+		t.Fatal(err)
+	}
+	frames := <-received
+	if frames[0] != "first message" || frames[1] != "second" {
+		t.Fatalf("unexpected frames %q", frames)
+	}
+}
@@ -0,0 +1,127 @@
+package siem
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Syslog facilities and severities used by the forwarder.
+const (
+	FacilityLogAudit = 13
+
+	severityWarning = 4
+	severityNotice  = 5
+)
+
+// Message renders an RFC 5424 syslog message.
+func Message(facility int, hostname string, at time.Time, msgID string, body []byte) []byte {
+	severity := severityNotice
+	if Severity(msgID) > 5 {
+		severity = severityWarning
+	}
+	header := fmt.Sprintf("<%d>1 %s %s flowdb - %s - ",
+		facility*8+severity,
+		at.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		headerField(hostname, 255),
+		headerField(msgID, 32),
+	)
+	return append([]byte(header), body...)
+}
+
+// headerField makes value a valid RFC 5424 header field: printable ASCII
+// without spaces, "-" when empty.
+func headerField(value string, max int) string {
+	out := make([]byte, 0, len(value))
+	for i := 0; i < len(value) && len(out) < max; i++ {
+		if c := value[i]; c > 32 && c < 127 {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		return "-"
+	}
+	return string(out)
+}
+
+// Syslog sends messages to a collector over "udp", "tcp" or "tls". Stream
+// transports use octet-counting framing (RFC 6587, RFC 5425). The
+// connection is reopened on the next Send after a failure.
+type Syslog struct {
+	Network   string
+	Addr      string
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+
+	conn net.Conn
+}
+
+func (s *Syslog) Send(msgs [][]byte) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	for _, msg := range msgs {
+		if s.Timeout > 0 {
+			_ = s.conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		}
+		frame := msg
+		if s.Network != "udp" {
+			frame = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+		if _, err := s.conn.Write(frame); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Syslog) Close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *Syslog) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.Timeout}
+	switch s.Network {
+	case "udp", "tcp":
+		return dialer.Dial(s.Network, s.Addr)
+	case "tls":
+		return tls.DialWithDialer(dialer, "tcp", s.Addr, s.TLSConfig)
+	default:
+		return nil, errors.New("unsupported syslog network " + strconv.Quote(s.Network))
+	}
+}
+
+// TLSConfig verifies the collector at addr, against the CA certificates in
+// caFile when set and the system roots otherwise.
+func TLSConfig(addr string, caFile string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(caFile + ": no certificates found")
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
@@ -14,6 +14,7 @@ type User struct {
 	MFAEnabled    bool
 	MFASecretEnc  []byte
 	MFAVerifiedAt *time.Time
+	Attributes    map[string]any
 	CreatedAt     time.Time
 }
 
@@ -25,6 +26,12 @@ type Session struct {
 	ExpiresAt  time.Time
 	LastAuthAt time.Time
 	LastMFAAt  *time.Time
+	// LastSeenAt, ClientIP and UserAgent are updated as the session is used.
+	LastSeenAt time.Time
+	ClientIP   string
+	UserAgent  string
+	// BreakGlass is the elevation in effect, filled in by GetSession only.
+	BreakGlass *BreakGlass
 }
 
 type ExternalIdentity struct {
@@ -47,6 +54,7 @@ type Role struct {
 	ID          uuid.UUID
 	Name        string
 	Permissions []string
+	ManagedBy   string
 	CreatedAt   time.Time
 }
 
@@ -56,14 +64,95 @@ type RoleBinding struct {
 	UserID    *uuid.UUID
 	GroupID   *uuid.UUID
 	Resource  string
+	ManagedBy string
 	CreatedAt time.Time
 }
 
+// UserRoleBinding is a binding that applies to a user, either directly or
+// through membership of GroupName.
+type UserRoleBinding struct {
+	Binding   RoleBinding
+	Role      Role
+	GroupName string
+}
+
 type Policy struct {
 	ID        uuid.UUID
 	Name      string
 	Doc       []byte
+	Version   int
+	ManagedBy string
 	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ManagedPolicy is a policy declared by an external source such as a policy
+// directory. Only policies with Changed set are written.
+type ManagedPolicy struct {
+	Name    string
+	Doc     []byte
+	Diff    []byte
+	Changed bool
+}
+
+// ManagedBinding is a role binding declared by names in an external source.
+type ManagedBinding struct {
+	Role     string
+	Username string
+	Group    string
+	Resource string
+}
+
+// ManagedSet is the full set of objects owned by one source.
+type ManagedSet struct {
+	Policies []ManagedPolicy
+	Removed  []ManagedPolicy
+	Roles    []Role
+	Bindings []ManagedBinding
+}
+
+// Key describes the binding as "role -> user name" or "role -> group
+// name", with " on resource" when scoped.
+func (b ManagedBinding) Key() string {
+	subject := "user " + b.Username
+	if b.Group != "" {
+		subject = "group " + b.Group
+	}
+	key := b.Role + " -> " + subject
+	if b.Resource != "" {
+		key += " on " + b.Resource
+	}
+	return key
+}
+
+type SyncResult struct {
+	Changes  []SyncChange
+	Roles    int
+	Bindings int
+	Skipped  []string
+}
+
+// SyncChange is one policy, role or role binding created, updated or
+// deleted by a managed sync. Bindings are identified by their Key.
+type SyncChange struct {
+	Kind        string
+	Op          string
+	Name        string
+	ID          uuid.UUID
+	Version     int
+	Permissions []string
+}
+
+type PolicyVersion struct {
+	ID         uuid.UUID
+	PolicyID   uuid.UUID
+	PolicyName string
+	Version    int
+	Change     string
+	Doc        []byte
+	Diff       []byte
+	AuthorID   *uuid.UUID
+	CreatedAt  time.Time
 }
 
 type Connection struct {
@@ -94,38 +183,284 @@ type QueryHistory struct {
 	Action        string
 	Resource      string
 	ApprovalID    *uuid.UUID
+	// StatementEnc is the statement encrypted with the master key, nil when
+	// statements are not stored. StatementRedacted marks a statement stored
+	// with literals redacted, which cannot be re-run.
+	StatementEnc      []byte
+	StatementRedacted bool
+	// Fingerprint groups runs of the same statement shape; FingerprintText
+	// is that shape with literals removed.
+	Fingerprint     string
+	FingerprintText string
 }
 
+// QueryApproval gates one execution of an exact statement by its requester.
+// It needs RequiredApprovals distinct approvers, expires at ExpiresAt and is
+// consumed by the first run.
 type QueryApproval struct {
-	ID           uuid.UUID
-	ConnectionID uuid.UUID
-	UserID       uuid.UUID
-	Statement    string
-	Status       string
-	Environment  string
-	CreatedAt    time.Time
-	ApprovedBy   *uuid.UUID
-	ApprovedAt   *time.Time
-	DeniedBy     *uuid.UUID
-	DeniedAt     *time.Time
+	ID                uuid.UUID
+	ConnectionID      uuid.UUID
+	UserID            uuid.UUID
+	Statement         string
+	StatementHash     string
+	Status            string
+	Environment       string
+	Reason            string
+	TicketURL         string
+	Impact            string
+	ExplainPlan       []byte
+	Revision          int
+	RequiredApprovals int
+	Approvals         int
+	CreatedAt         time.Time
+	ExpiresAt         *time.Time
+	ApprovedBy        *uuid.UUID
+	ApprovedAt        *time.Time
+	DeniedBy          *uuid.UUID
+	DeniedAt          *time.Time
+	ConsumedAt        *time.Time
+}
+
+// ApprovalRevision is one version of the statement and context submitted
+// for an approval.
+type ApprovalRevision struct {
+	ApprovalID    uuid.UUID
+	Revision      int
+	Statement     string
+	StatementHash string
+	Reason        string
+	TicketURL     string
+	Impact        string
+	ExplainPlan   []byte
+	CreatedBy     *uuid.UUID
+	CreatedAt     time.Time
+}
+
+// ApprovalComment is a message on an approval thread. Kind is "comment" or
+// "changes_requested".
+type ApprovalComment struct {
+	ID         uuid.UUID
+	ApprovalID uuid.UUID
+	UserID     *uuid.UUID
+	Revision   int
+	Kind       string
+	Body       string
+	CreatedAt  time.Time
+}
+
+type ApprovalVote struct {
+	ApprovalID uuid.UUID
+	UserID     uuid.UUID
+	Decision   string
+	CreatedAt  time.Time
+}
+
+const (
+	AccessPending   = "pending"
+	AccessApproved  = "approved"
+	AccessDenied    = "denied"
+	AccessCancelled = "cancelled"
+	AccessRevoked   = "revoked"
+	AccessExpired   = "expired"
+)
+
+// AccessRequest asks for a role binding limited in time. Once approved it is
+// an active grant until ExpiresAt.
+type AccessRequest struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	RoleID        uuid.UUID
+	Resource      string
+	Justification string
+	TicketID      string
+	Duration      time.Duration
+	Status        string
+	DecidedBy     *uuid.UUID
+	DecidedAt     *time.Time
+	DecisionNote  string
+	ExpiresAt     *time.Time
+	RevokedBy     *uuid.UUID
+	RevokedAt     *time.Time
+	LastUsedAt    *time.Time
+	CreatedAt     time.Time
+}
+
+// Active reports whether the request is an approved grant still in its
+// window at now.
+func (a AccessRequest) Active(now time.Time) bool {
+	return a.Status == AccessApproved && a.ExpiresAt != nil && now.Before(*a.ExpiresAt)
+}
+
+// AccessGrant is an approved, unexpired access request with its role.
+type AccessGrant struct {
+	Request AccessRequest
+	Role    Role
+}
+
+// BreakGlass is an emergency elevation of one session to admin. It ends at
+// ExpiresAt or EndedAt and stays open for review until a second admin
+// acknowledges it.
+type BreakGlass struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	SessionID  *uuid.UUID
+	Reason     string
+	StartedAt  time.Time
+	ExpiresAt  time.Time
+	EndedAt    *time.Time
+	ReviewedBy *uuid.UUID
+	ReviewedAt *time.Time
+	ReviewNote string
+}
+
+// Active reports whether the elevation is still in effect at now.
+func (b BreakGlass) Active(now time.Time) bool {
+	return b.EndedAt == nil && now.Before(b.ExpiresAt)
 }
 
 type PIIRule struct {
+	ID                   uuid.UUID
+	ConnectionID         uuid.UUID
+	Resource             string
+	Field                string
+	MaskType             string
+	ExemptRoles          []string
+	ExemptGroups         []string
+	RequireJustification bool
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+type PIIScan struct {
+	ID              uuid.UUID
+	ConnectionID    uuid.UUID
+	Status          string
+	StartedBy       *uuid.UUID
+	StartedAt       time.Time
+	FinishedAt      *time.Time
+	EntitiesScanned int
+	FieldsFlagged   int
+	Error           string
+	// Failures lists the namespaces and entities that could not be sampled.
+	Failures []string
+}
+
+type PIIProposal struct {
 	ID           uuid.UUID
 	ConnectionID uuid.UUID
+	ScanID       *uuid.UUID
 	Resource     string
 	Field        string
+	Category     string
+	Confidence   float64
+	Reasons      []string
 	MaskType     string
+	Status       string
 	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DecidedBy    *uuid.UUID
+	DecidedAt    *time.Time
 }
 
+// AuditEntry is one audit event. HashVersion 2 hashes the request context
+// and event type along with the details; version 1 entries hash the details
+// only.
 type AuditEntry struct {
+	ID          uuid.UUID
+	Seq         int64
+	EventType   string
+	ActorID     *uuid.UUID
+	SessionID   *uuid.UUID
+	RequestID   string
+	ClientIP    string
+	UserAgent   string
+	Details     []byte
+	CreatedAt   time.Time
+	PrevHash    string
+	Hash        string
+	HashVersion int
+	ErrorID     string
+}
+
+// AuditArchive records a range of audit entries moved to a file and deleted.
+// SignedCount, HeadSeq and HeadHash are the chain state after LastSeq, so
+// verification of the remaining entries can resume from it.
+type AuditArchive struct {
+	ID          uuid.UUID
+	FirstSeq    int64
+	LastSeq     int64
+	Entries     int64
+	SignedCount int64
+	HeadSeq     int64
+	HeadHash    string
+	FileName    string
+	SHA256      string
+	KeyID       string
+	Manifest    string
+	Signature   string
+	CreatedAt   time.Time
+}
+
+// AuditCursor tracks how far a SIEM consumer has read the audit log, by seq.
+type AuditCursor struct {
+	Name          string
+	Kind          string
+	LastSeq       int64
+	Delivered     int64
+	LastError     string
+	LastAttemptAt *time.Time
+	LastSuccessAt *time.Time
+	CreatedAt     time.Time
+}
+
+const (
+	AuditCursorSyslog = "syslog"
+	AuditCursorStream = "stream"
+)
+
+// Webhook subscribes a URL to audit events. An empty Events list matches
+// every event.
+type Webhook struct {
 	ID        uuid.UUID
-	EventType string
-	ActorID   *uuid.UUID
-	Details   []byte
+	Name      string
+	URL       string
+	SecretEnc []byte
+	Events    []string
+	Enabled   bool
+	CreatedBy *uuid.UUID
 	CreatedAt time.Time
-	PrevHash  string
-	Hash      string
-	ErrorID   string
+	UpdatedAt time.Time
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookDelivery struct {
+	ID             uuid.UUID
+	WebhookID      uuid.UUID
+	EventType      string
+	Payload        []byte
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastAttemptAt  *time.Time
+	ResponseStatus *int
+	LastError      string
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+}
+
+// AuditCheckpoint is a signed statement of the audit chain head. Statement
+// holds the exact signed bytes; Signature is base64 Ed25519.
+type AuditCheckpoint struct {
+	Seq         int64
+	SignedCount int64
+	HeadHash    string
+	KeyID       string
+	Statement   string
+	Signature   string
+	CreatedAt   time.Time
 }
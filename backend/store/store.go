@@ -4,10 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -45,25 +48,29 @@ func (s *Store) CreateUser(ctx context.Context, user User) (User, error) {
 
 func (s *Store) GetUserByUsername(ctx context.Context, username string) (User, error) {
 	var user User
+	var attributes []byte
 	err := s.db.QueryRow(ctx, `
-		SELECT id, username, password_hash, is_admin, mfa_enabled, mfa_secret_enc, mfa_verified_at, created_at
+		SELECT id, username, password_hash, is_admin, mfa_enabled, mfa_secret_enc, mfa_verified_at, attributes, created_at
 		FROM users WHERE username=$1
-	`, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.IsAdmin, &user.MFAEnabled, &user.MFASecretEnc, &user.MFAVerifiedAt, &user.CreatedAt)
+	`, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.IsAdmin, &user.MFAEnabled, &user.MFASecretEnc, &user.MFAVerifiedAt, &attributes, &user.CreatedAt)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return User{}, ErrNotFound
 	}
+	_ = json.Unmarshal(attributes, &user.Attributes)
 	return user, err
 }
 
 func (s *Store) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
 	var user User
+	var attributes []byte
 	err := s.db.QueryRow(ctx, `
-		SELECT id, username, password_hash, is_admin, mfa_enabled, mfa_secret_enc, mfa_verified_at, created_at
+		SELECT id, username, password_hash, is_admin, mfa_enabled, mfa_secret_enc, mfa_verified_at, attributes, created_at
 		FROM users WHERE id=$1
-	`, id).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.IsAdmin, &user.MFAEnabled, &user.MFASecretEnc, &user.MFAVerifiedAt, &user.CreatedAt)
+	`, id).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.IsAdmin, &user.MFAEnabled, &user.MFASecretEnc, &user.MFAVerifiedAt, &attributes, &user.CreatedAt)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return User{}, ErrNotFound
 	}
+	_ = json.Unmarshal(attributes, &user.Attributes)
 	return user, err
 }
 
@@ -74,33 +81,141 @@ func (s *Store) UpdateUserMFA(ctx context.Context, userID uuid.UUID, enabled boo
 	return err
 }
 
+func (s *Store) UpdateUserAttributes(ctx context.Context, userID uuid.UUID, attributes map[string]any) error {
+	if attributes == nil {
+		attributes = map[string]any{}
+	}
+	raw, err := json.Marshal(attributes)
+	if err != nil {
+		return err
+	}
+	tag, err := s.db.Exec(ctx, `UPDATE users SET attributes=$1 WHERE id=$2`, raw, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func (s *Store) UpdateUserAdmin(ctx context.Context, userID uuid.UUID, isAdmin bool) error {
 	_, err := s.db.Exec(ctx, `UPDATE users SET is_admin=$1 WHERE id=$2`, isAdmin, userID)
 	return err
 }
 
-func (s *Store) CreateSession(ctx context.Context, sess Session) (Session, error) {
+const sessionColumns = `id, user_id, csrf_token, created_at, expires_at, last_auth_at, last_mfa_at, last_seen_at, client_ip, user_agent`
+
+func scanSession(row pgx.Row) (Session, error) {
+	var sess Session
+	err := row.Scan(&sess.ID, &sess.UserID, &sess.CSRFToken, &sess.CreatedAt, &sess.ExpiresAt, &sess.LastAuthAt, &sess.LastMFAAt, &sess.LastSeenAt, &sess.ClientIP, &sess.UserAgent)
+	return sess, err
+}
+
+// CreateSession inserts sess and, when keep is positive, deletes all but the
+// keep most recently used sessions of the user in the same transaction,
+// returning their ids. The user row is locked so concurrent logins cannot
+// both stay within the limit.
+func (s *Store) CreateSession(ctx context.Context, sess Session, keep int) (Session, []uuid.UUID, error) {
 	if sess.ID == uuid.Nil {
 		sess.ID = uuid.New()
 	}
-	err := s.db.QueryRow(ctx, `
-		INSERT INTO sessions (id, user_id, csrf_token, created_at, expires_at, last_auth_at, last_mfa_at)
-		VALUES ($1,$2,$3,now(),$4,$5,$6)
-		RETURNING created_at
-	`, sess.ID, sess.UserID, sess.CSRFToken, sess.ExpiresAt, sess.LastAuthAt, sess.LastMFAAt).Scan(&sess.CreatedAt)
-	return sess, err
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return Session{}, nil, err
+	}
+	defer tx.Rollback(ctx)
+	if keep > 0 {
+		if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id=$1 FOR UPDATE`, sess.UserID); err != nil {
+			return Session{}, nil, err
+		}
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO sessions (id, user_id, csrf_token, created_at, expires_at, last_auth_at, last_mfa_at, last_seen_at, client_ip, user_agent)
+		VALUES ($1,$2,$3,now(),$4,$5,$6,now(),$7,$8)
+		RETURNING created_at, last_seen_at
+	`, sess.ID, sess.UserID, sess.CSRFToken, sess.ExpiresAt, sess.LastAuthAt, sess.LastMFAAt, sess.ClientIP, sess.UserAgent).Scan(&sess.CreatedAt, &sess.LastSeenAt)
+	if err != nil {
+		return Session{}, nil, err
+	}
+	var evicted []uuid.UUID
+	if keep > 0 {
+		rows, err := tx.Query(ctx, `
+			DELETE FROM sessions WHERE id IN (
+				SELECT id FROM sessions WHERE user_id=$1
+				ORDER BY last_seen_at DESC, created_at DESC OFFSET $2
+			) RETURNING id
+		`, sess.UserID, keep)
+		if err != nil {
+			return Session{}, nil, err
+		}
+		evicted, err = collectIDs(rows)
+		if err != nil {
+			return Session{}, nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Session{}, nil, err
+	}
+	return sess, evicted, nil
 }
 
+// GetSession returns a session together with the break-glass elevation in
+// effect for it, if any, so authenticating a request takes one query.
 func (s *Store) GetSession(ctx context.Context, id uuid.UUID) (Session, error) {
 	var sess Session
+	var bgID, bgUserID *uuid.UUID
+	var bgReason *string
+	var bgStartedAt, bgExpiresAt *time.Time
 	err := s.db.QueryRow(ctx, `
-		SELECT id, user_id, csrf_token, created_at, expires_at, last_auth_at, last_mfa_at
-		FROM sessions WHERE id=$1
-	`, id).Scan(&sess.ID, &sess.UserID, &sess.CSRFToken, &sess.CreatedAt, &sess.ExpiresAt, &sess.LastAuthAt, &sess.LastMFAAt)
+		SELECT s.id, s.user_id, s.csrf_token, s.created_at, s.expires_at, s.last_auth_at, s.last_mfa_at, s.last_seen_at, s.client_ip, s.user_agent,
+			b.id, b.user_id, b.reason, b.started_at, b.expires_at
+		FROM sessions s
+		LEFT JOIN LATERAL (
+			SELECT id, user_id, reason, started_at, expires_at FROM break_glass
+			WHERE session_id = s.id AND ended_at IS NULL AND expires_at > now()
+			ORDER BY started_at DESC LIMIT 1
+		) b ON true
+		WHERE s.id=$1
+	`, id).Scan(&sess.ID, &sess.UserID, &sess.CSRFToken, &sess.CreatedAt, &sess.ExpiresAt, &sess.LastAuthAt, &sess.LastMFAAt, &sess.LastSeenAt, &sess.ClientIP, &sess.UserAgent,
+		&bgID, &bgUserID, &bgReason, &bgStartedAt, &bgExpiresAt)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return Session{}, ErrNotFound
 	}
-	return sess, err
+	if err != nil {
+		return Session{}, err
+	}
+	if bgID != nil {
+		sess.BreakGlass = &BreakGlass{
+			ID:        *bgID,
+			UserID:    *bgUserID,
+			SessionID: &sess.ID,
+			Reason:    *bgReason,
+			StartedAt: *bgStartedAt,
+			ExpiresAt: *bgExpiresAt,
+		}
+	}
+	return sess, nil
+}
+
+// ListUserSessions returns the sessions of a user, most recently used first.
+func (s *Store) ListUserSessions(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+sessionColumns+` FROM sessions WHERE user_id=$1 ORDER BY last_seen_at DESC, created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, sess)
+	}
+	return list, rows.Err()
 }
 
 func (s *Store) DeleteSession(ctx context.Context, id uuid.UUID) error {
@@ -108,6 +223,73 @@ func (s *Store) DeleteSession(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+// DeleteUserSession deletes a session only if it belongs to userID.
+func (s *Store) DeleteUserSession(ctx context.Context, userID uuid.UUID, id uuid.UUID) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM sessions WHERE id=$1 AND user_id=$2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUserSessions deletes every session of userID and returns their ids.
+func (s *Store) DeleteUserSessions(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	return s.deleteSessionIDs(ctx, `DELETE FROM sessions WHERE user_id=$1 RETURNING id`, userID)
+}
+
+func (s *Store) deleteSessionIDs(ctx context.Context, sql string, args ...any) ([]uuid.UUID, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return collectIDs(rows)
+}
+
+func collectIDs(rows pgx.Rows) ([]uuid.UUID, error) {
+	defer rows.Close()
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// TouchSession records use of a session from clientIP with userAgent.
+func (s *Store) TouchSession(ctx context.Context, id uuid.UUID, at time.Time, clientIP string, userAgent string) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE sessions SET last_seen_at=$1, client_ip=$2, user_agent=$3 WHERE id=$4
+	`, at, clientIP, userAgent, id)
+	return err
+}
+
+// DeleteExpiredSessions deletes sessions past expires_at at now or, when
+// idleBefore is set, last used before it.
+func (s *Store) DeleteExpiredSessions(ctx context.Context, now time.Time, idleBefore *time.Time) (int64, error) {
+	tag, err := s.db.Exec(ctx, `
+		DELETE FROM sessions WHERE expires_at < $1 OR last_seen_at < $2
+	`, now, idleBefore)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteOIDCStatesBefore deletes login states created before the cutoff.
+func (s *Store) DeleteOIDCStatesBefore(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM oidc_states WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 func (s *Store) UpdateSessionMFA(ctx context.Context, id uuid.UUID, lastMFAAt time.Time) error {
 	_, err := s.db.Exec(ctx, `UPDATE sessions SET last_mfa_at=$1 WHERE id=$2`, lastMFAAt, id)
 	return err
@@ -175,6 +357,28 @@ func (s *Store) SetUserGroups(ctx context.Context, userID uuid.UUID, groupIDs []
 	return tx.Commit(ctx)
 }
 
+func (s *Store) ListUserGroups(ctx context.Context, userID uuid.UUID) ([]Group, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT g.id, g.name, g.created_at
+		FROM groups g
+		JOIN group_members gm ON gm.group_id = g.id
+		WHERE gm.user_id=$1 ORDER BY g.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []Group
+	for rows.Next() {
+		var g Group
+		if err := rows.Scan(&g.ID, &g.Name, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
 func (s *Store) ListUserRoles(ctx context.Context, userID uuid.UUID) ([]Role, error) {
 	rows, err := s.db.Query(ctx, `
 		SELECT r.id, r.name, r.permissions, r.created_at
@@ -200,330 +404,2518 @@ func (s *Store) ListUserRoles(ctx context.Context, userID uuid.UUID) ([]Role, er
 	return roles, rows.Err()
 }
 
+func (s *Store) ListUserRoleBindings(ctx context.Context, userID uuid.UUID) ([]UserRoleBinding, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT rb.id, rb.role_id, rb.user_id, rb.group_id, COALESCE(rb.resource, ''), rb.managed_by, rb.created_at,
+			r.name, r.permissions, r.managed_by, r.created_at, COALESCE(g.name, '')
+		FROM role_bindings rb
+		JOIN roles r ON r.id = rb.role_id
+		LEFT JOIN groups g ON g.id = rb.group_id
+		LEFT JOIN group_members gm ON gm.group_id = rb.group_id
+		WHERE rb.user_id=$1 OR gm.user_id=$1
+		ORDER BY r.name, rb.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []UserRoleBinding
+	for rows.Next() {
+		var b UserRoleBinding
+		var perms []byte
+		if err := rows.Scan(&b.Binding.ID, &b.Binding.RoleID, &b.Binding.UserID, &b.Binding.GroupID, &b.Binding.Resource, &b.Binding.ManagedBy, &b.Binding.CreatedAt,
+			&b.Role.Name, &perms, &b.Role.ManagedBy, &b.Role.CreatedAt, &b.GroupName); err != nil {
+			return nil, err
+		}
+		b.Role.ID = b.Binding.RoleID
+		_ = json.Unmarshal(perms, &b.Role.Permissions)
+		list = append(list, b)
+	}
+	return list, rows.Err()
+}
+
+// CreateRoleIfMissing returns the role with the given name, creating it with
+// permissions when it does not exist. Existing permissions are left alone.
 func (s *Store) CreateRoleIfMissing(ctx context.Context, name string, permissions []string) (Role, error) {
-	var role Role
-	perms, _ := json.Marshal(permissions)
-	err := s.db.QueryRow(ctx, `
+	perms, _ := json.Marshal(nonNilStrings(permissions))
+	_, err := s.db.Exec(ctx, `
 		INSERT INTO roles (id, name, permissions, created_at)
 		VALUES (gen_random_uuid(), $1, $2, now())
-		ON CONFLICT (name) DO UPDATE SET permissions=excluded.permissions
-		RETURNING id, name, permissions, created_at
-	`, name, perms).Scan(&role.ID, &role.Name, &perms, &role.CreatedAt)
+		ON CONFLICT (name) DO NOTHING
+	`, name, perms)
 	if err != nil {
 		return Role{}, err
 	}
-	_ = json.Unmarshal(perms, &role.Permissions)
-	return role, nil
+	return s.GetRoleByName(ctx, name)
 }
 
+// BindRoleToUser adds a direct binding unless an identical one exists.
 func (s *Store) BindRoleToUser(ctx context.Context, roleID uuid.UUID, userID uuid.UUID, resource string) error {
 	_, err := s.db.Exec(ctx, `
 		INSERT INTO role_bindings (id, role_id, user_id, resource, created_at)
-		VALUES (gen_random_uuid(), $1, $2, $3, now())
+		SELECT gen_random_uuid(), $1, $2, NULLIF($3, ''), now()
+		WHERE NOT EXISTS (
+			SELECT 1 FROM role_bindings
+			WHERE role_id=$1 AND user_id=$2 AND COALESCE(resource, '')=$3
+		)
 	`, roleID, userID, resource)
 	return err
 }
 
-func (s *Store) ListPolicies(ctx context.Context) ([]Policy, error) {
-	rows, err := s.db.Query(ctx, `SELECT id, name, doc, created_at FROM policies`)
+const roleColumns = `id, name, permissions, managed_by, created_at`
+
+func scanRole(row pgx.Row) (Role, error) {
+	var role Role
+	var perms []byte
+	if err := row.Scan(&role.ID, &role.Name, &perms, &role.ManagedBy, &role.CreatedAt); err != nil {
+		return Role{}, err
+	}
+	_ = json.Unmarshal(perms, &role.Permissions)
+	return role, nil
+}
+
+func (s *Store) ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+roleColumns+` FROM roles ORDER BY name`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var policies []Policy
+	var roles []Role
 	for rows.Next() {
-		var p Policy
-		if err := rows.Scan(&p.ID, &p.Name, &p.Doc, &p.CreatedAt); err != nil {
+		role, err := scanRole(rows)
+		if err != nil {
 			return nil, err
 		}
-		policies = append(policies, p)
+		roles = append(roles, role)
 	}
-	return policies, rows.Err()
+	return roles, rows.Err()
 }
 
-func (s *Store) UpsertPolicy(ctx context.Context, name string, doc []byte) (Policy, error) {
-	var p Policy
-	err := s.db.QueryRow(ctx, `
-		INSERT INTO policies (id, name, doc, created_at)
-		VALUES (gen_random_uuid(), $1, $2, now())
-		ON CONFLICT (name) DO UPDATE SET doc=excluded.doc
-		RETURNING id, name, doc, created_at
-	`, name, doc).Scan(&p.ID, &p.Name, &p.Doc, &p.CreatedAt)
-	return p, err
+func (s *Store) GetRole(ctx context.Context, id uuid.UUID) (Role, error) {
+	role, err := scanRole(s.db.QueryRow(ctx, `SELECT `+roleColumns+` FROM roles WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Role{}, ErrNotFound
+	}
+	return role, err
 }
 
-func (s *Store) CreateConnectionSecret(ctx context.Context, encrypted []byte) (uuid.UUID, error) {
-	var id uuid.UUID
-	err := s.db.QueryRow(ctx, `
-		INSERT INTO connection_secrets (id, encrypted, created_at, updated_at)
-		VALUES (gen_random_uuid(), $1, now(), now())
-		RETURNING id
-	`, encrypted).Scan(&id)
-	return id, err
+func (s *Store) GetRoleByName(ctx context.Context, name string) (Role, error) {
+	role, err := scanRole(s.db.QueryRow(ctx, `SELECT `+roleColumns+` FROM roles WHERE name=$1`, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Role{}, ErrNotFound
+	}
+	return role, err
 }
 
-func (s *Store) UpdateConnectionSecret(ctx context.Context, id uuid.UUID, encrypted []byte) error {
-	_, err := s.db.Exec(ctx, `
-		UPDATE connection_secrets SET encrypted=$1, updated_at=now() WHERE id=$2
-	`, encrypted, id)
-	return err
+func (s *Store) CreateRole(ctx context.Context, role Role) (Role, error) {
+	if role.ID == uuid.Nil {
+		role.ID = uuid.New()
+	}
+	perms, _ := json.Marshal(nonNilStrings(role.Permissions))
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO roles (id, name, permissions, created_at)
+		VALUES ($1,$2,$3,now())
+		RETURNING created_at
+	`, role.ID, role.Name, perms).Scan(&role.CreatedAt)
+	if err != nil {
+		return Role{}, uniqueConflict(err)
+	}
+	return role, nil
 }
 
-func (s *Store) GetConnectionSecret(ctx context.Context, id uuid.UUID) ([]byte, error) {
-	var encrypted []byte
-	err := s.db.QueryRow(ctx, `SELECT encrypted FROM connection_secrets WHERE id=$1`, id).Scan(&encrypted)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, ErrNotFound
+func (s *Store) UpdateRole(ctx context.Context, role Role) error {
+	perms, _ := json.Marshal(nonNilStrings(role.Permissions))
+	tag, err := s.db.Exec(ctx, `UPDATE roles SET name=$1, permissions=$2 WHERE id=$3`, role.Name, perms, role.ID)
+	if err != nil {
+		return uniqueConflict(err)
 	}
-	return encrypted, err
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-func (s *Store) CreateConnection(ctx context.Context, conn Connection) (Connection, error) {
-	if conn.ID == uuid.Nil {
-		conn.ID = uuid.New()
+func (s *Store) DeleteRole(ctx context.Context, id uuid.UUID) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM roles WHERE id=$1`, id)
+	if err != nil {
+		return err
 	}
-	tlsData, _ := json.Marshal(conn.TLS)
-	tagsData, _ := json.Marshal(conn.Tags)
-	err := s.db.QueryRow(ctx, `
-		INSERT INTO connections (id, name, type, host, port, database, username, secret_ref, tls, tags, created_at, updated_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,now(),now())
-		RETURNING created_at, updated_at
-	`, conn.ID, conn.Name, conn.Type, conn.Host, conn.Port, conn.Database, conn.Username, conn.SecretRef, tlsData, tagsData).Scan(&conn.CreatedAt, &conn.UpdatedAt)
-	return conn, err
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-func (s *Store) UpdateConnection(ctx context.Context, conn Connection) error {
-	tlsData, _ := json.Marshal(conn.TLS)
-	tagsData, _ := json.Marshal(conn.Tags)
-	_, err := s.db.Exec(ctx, `
-		UPDATE connections
-		SET name=$1, type=$2, host=$3, port=$4, database=$5, username=$6, tls=$7, tags=$8, updated_at=now()
-		WHERE id=$9
-	`, conn.Name, conn.Type, conn.Host, conn.Port, conn.Database, conn.Username, tlsData, tagsData, conn.ID)
-	return err
+// RoleBindingFilter narrows ListRoleBindings; nil fields match everything.
+type RoleBindingFilter struct {
+	RoleID  *uuid.UUID
+	UserID  *uuid.UUID
+	GroupID *uuid.UUID
 }
 
-func (s *Store) DeleteConnection(ctx context.Context, id uuid.UUID) error {
-	_, err := s.db.Exec(ctx, `DELETE FROM connections WHERE id=$1`, id)
-	return err
-}
+const roleBindingColumns = `id, role_id, user_id, group_id, COALESCE(resource, ''), managed_by, created_at`
 
-func (s *Store) GetConnection(ctx context.Context, id uuid.UUID) (Connection, error) {
-	var conn Connection
-	var tlsData []byte
-	var tagsData []byte
-	err := s.db.QueryRow(ctx, `
-		SELECT id, name, type, host, port, database, username, secret_ref, tls, tags, created_at, updated_at
-		FROM connections WHERE id=$1
-	`, id).Scan(&conn.ID, &conn.Name, &conn.Type, &conn.Host, &conn.Port, &conn.Database, &conn.Username, &conn.SecretRef, &tlsData, &tagsData, &conn.CreatedAt, &conn.UpdatedAt)
-	if errors.Is(err, pgx.ErrNoRows) {
-		return Connection{}, ErrNotFound
-	}
-	_ = json.Unmarshal(tlsData, &conn.TLS)
-	_ = json.Unmarshal(tagsData, &conn.Tags)
-	return conn, err
+func scanRoleBinding(row pgx.Row) (RoleBinding, error) {
+	var b RoleBinding
+	err := row.Scan(&b.ID, &b.RoleID, &b.UserID, &b.GroupID, &b.Resource, &b.ManagedBy, &b.CreatedAt)
+	return b, err
 }
 
-func (s *Store) ListConnections(ctx context.Context) ([]Connection, error) {
+func (s *Store) ListRoleBindings(ctx context.Context, filter RoleBindingFilter) ([]RoleBinding, error) {
 	rows, err := s.db.Query(ctx, `
-		SELECT id, name, type, host, port, database, username, secret_ref, tls, tags, created_at, updated_at
-		FROM connections ORDER BY name
-	`)
+		SELECT `+roleBindingColumns+` FROM role_bindings
+		WHERE ($1::uuid IS NULL OR role_id=$1)
+			AND ($2::uuid IS NULL OR user_id=$2)
+			AND ($3::uuid IS NULL OR group_id=$3)
+		ORDER BY created_at
+	`, filter.RoleID, filter.UserID, filter.GroupID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var conns []Connection
+	var list []RoleBinding
 	for rows.Next() {
-		var conn Connection
-		var tlsData []byte
-		var tagsData []byte
-		if err := rows.Scan(&conn.ID, &conn.Name, &conn.Type, &conn.Host, &conn.Port, &conn.Database, &conn.Username, &conn.SecretRef, &tlsData, &tagsData, &conn.CreatedAt, &conn.UpdatedAt); err != nil {
+		b, err := scanRoleBinding(rows)
+		if err != nil {
 			return nil, err
 		}
-		_ = json.Unmarshal(tlsData, &conn.TLS)
-		_ = json.Unmarshal(tagsData, &conn.Tags)
-		conns = append(conns, conn)
+		list = append(list, b)
 	}
-	return conns, rows.Err()
+	return list, rows.Err()
 }
 
-func (s *Store) CreateQueryHistory(ctx context.Context, history QueryHistory) (QueryHistory, error) {
-	if history.ID == uuid.Nil {
-		history.ID = uuid.New()
+func (s *Store) GetRoleBinding(ctx context.Context, id uuid.UUID) (RoleBinding, error) {
+	b, err := scanRoleBinding(s.db.QueryRow(ctx, `SELECT `+roleBindingColumns+` FROM role_bindings WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return RoleBinding{}, ErrNotFound
 	}
-	err := s.db.QueryRow(ctx, `
-		INSERT INTO query_history (id, user_id, connection_id, statement_hash, status, row_count, duration_ms, started_at, ended_at, action, resource, approval_id)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-		RETURNING started_at
-	`, history.ID, history.UserID, history.ConnectionID, history.StatementHash, history.Status, history.RowCount, history.DurationMs, history.StartedAt, history.EndedAt, history.Action, history.Resource, history.ApprovalID).Scan(&history.StartedAt)
-	return history, err
+	return b, err
 }
 
-func (s *Store) UpdateQueryHistory(ctx context.Context, history QueryHistory) error {
-	_, err := s.db.Exec(ctx, `
-		UPDATE query_history
-		SET status=$1, row_count=$2, duration_ms=$3, ended_at=$4
-		WHERE id=$5
-	`, history.Status, history.RowCount, history.DurationMs, history.EndedAt, history.ID)
-	return err
+func (s *Store) CreateRoleBinding(ctx context.Context, b RoleBinding) (RoleBinding, error) {
+	if b.ID == uuid.Nil {
+		b.ID = uuid.New()
+	}
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO role_bindings (id, role_id, user_id, group_id, resource, created_at)
+		VALUES ($1,$2,$3,$4,NULLIF($5, ''),now())
+		RETURNING created_at
+	`, b.ID, b.RoleID, b.UserID, b.GroupID, b.Resource).Scan(&b.CreatedAt)
+	return b, err
 }
 
-func (s *Store) ListHistory(ctx context.Context, limit int, offset int) ([]QueryHistory, error) {
-	rows, err := s.db.Query(ctx, `
-		SELECT id, user_id, connection_id, statement_hash, status, row_count, duration_ms, started_at, ended_at, action, resource, approval_id
-		FROM query_history ORDER BY started_at DESC LIMIT $1 OFFSET $2
-	`, limit, offset)
+func (s *Store) DeleteRoleBinding(ctx context.Context, id uuid.UUID) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM role_bindings WHERE id=$1`, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-	var list []QueryHistory
-	for rows.Next() {
-		var q QueryHistory
-		if err := rows.Scan(&q.ID, &q.UserID, &q.ConnectionID, &q.StatementHash, &q.Status, &q.RowCount, &q.DurationMs, &q.StartedAt, &q.EndedAt, &q.Action, &q.Resource, &q.ApprovalID); err != nil {
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) GetGroupByName(ctx context.Context, name string) (Group, error) {
+	var g Group
+	err := s.db.QueryRow(ctx, `SELECT id, name, created_at FROM groups WHERE name=$1`, name).Scan(&g.ID, &g.Name, &g.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Group{}, ErrNotFound
+	}
+	return g, err
+}
+
+func (s *Store) ListPolicies(ctx context.Context) ([]Policy, error) {
+	rows, err := s.db.Query(ctx, `SELECT id, name, doc, version, managed_by, created_at, updated_at FROM policies ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var policies []Policy
+	for rows.Next() {
+		var p Policy
+		if err := rows.Scan(&p.ID, &p.Name, &p.Doc, &p.Version, &p.ManagedBy, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+func (s *Store) GetPolicy(ctx context.Context, id uuid.UUID) (Policy, error) {
+	var p Policy
+	err := s.db.QueryRow(ctx, `
+		SELECT id, name, doc, version, managed_by, created_at, updated_at FROM policies WHERE id=$1
+	`, id).Scan(&p.ID, &p.Name, &p.Doc, &p.Version, &p.ManagedBy, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Policy{}, ErrNotFound
+	}
+	return p, err
+}
+
+// SavePolicy creates the policy when p.ID is nil and otherwise updates it,
+// bumping its version. Either way an immutable version row is recorded. An
+// update whose p.Version no longer matches the stored version returns
+// ErrConflict.
+func (s *Store) SavePolicy(ctx context.Context, p Policy, change string, diff []byte, authorID *uuid.UUID) (Policy, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return Policy{}, err
+	}
+	defer tx.Rollback(ctx)
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+		err = tx.QueryRow(ctx, `
+			INSERT INTO policies (id, name, doc, version, created_at, updated_at)
+			VALUES ($1,$2,$3,1,now(),now())
+			RETURNING version, created_at, updated_at
+		`, p.ID, p.Name, p.Doc).Scan(&p.Version, &p.CreatedAt, &p.UpdatedAt)
+	} else {
+		err = tx.QueryRow(ctx, `
+			UPDATE policies SET name=$1, doc=$2, version=version+1, updated_at=now()
+			WHERE id=$3 AND version=$4
+			RETURNING version, created_at, updated_at
+		`, p.Name, p.Doc, p.ID, p.Version).Scan(&p.Version, &p.CreatedAt, &p.UpdatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Policy{}, ErrConflict
+		}
+	}
+	if err != nil {
+		return Policy{}, uniqueConflict(err)
+	}
+	if err := insertPolicyVersion(ctx, tx, p, change, p.Doc, diff, authorID); err != nil {
+		return Policy{}, err
+	}
+	return p, tx.Commit(ctx)
+}
+
+// DeletePolicy removes the policy and records a final version without a
+// document.
+func (s *Store) DeletePolicy(ctx context.Context, p Policy, diff []byte, authorID *uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, `DELETE FROM policies WHERE id=$1 AND version=$2`, p.ID, p.Version)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConflict
+	}
+	p.Version++
+	if err := insertPolicyVersion(ctx, tx, p, "delete", nil, diff, authorID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SyncManaged replaces everything owned by source with set in one
+// transaction. Only rows already managed by source are updated or deleted; a
+// policy or role whose name is taken by one created elsewhere fails the whole
+// sync with ErrNotManaged. Bindings whose role, user or group does not exist
+// are skipped and reported.
+func (s *Store) SyncManaged(ctx context.Context, source string, set ManagedSet) (SyncResult, error) {
+	var result SyncResult
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback(ctx)
+	for _, mp := range set.Policies {
+		if !mp.Changed {
+			continue
+		}
+		p := Policy{Name: mp.Name, Doc: mp.Doc}
+		var created bool
+		err := tx.QueryRow(ctx, `
+			INSERT INTO policies (id, name, doc, version, managed_by, created_at, updated_at)
+			VALUES (gen_random_uuid(), $1, $2, 1, $3, now(), now())
+			ON CONFLICT (name) DO UPDATE SET doc=excluded.doc, version=policies.version+1, updated_at=now()
+				WHERE policies.managed_by = excluded.managed_by
+			RETURNING id, version, xmax = 0
+		`, p.Name, p.Doc, source).Scan(&p.ID, &p.Version, &created)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return SyncResult{}, notManaged("policy", p.Name)
+		}
+		if err != nil {
+			return SyncResult{}, err
+		}
+		change := "update"
+		if created {
+			change = "create"
+		}
+		if err := insertPolicyVersion(ctx, tx, p, "sync", p.Doc, mp.Diff, nil); err != nil {
+			return SyncResult{}, err
+		}
+		result.Changes = append(result.Changes, SyncChange{Kind: "policy", Op: change, Name: p.Name, ID: p.ID, Version: p.Version})
+	}
+	for _, mp := range set.Removed {
+		p := Policy{Name: mp.Name}
+		err := tx.QueryRow(ctx, `
+			DELETE FROM policies WHERE name=$1 AND managed_by=$2 RETURNING id, version
+		`, mp.Name, source).Scan(&p.ID, &p.Version)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return SyncResult{}, err
+		}
+		p.Version++
+		if err := insertPolicyVersion(ctx, tx, p, "delete", nil, mp.Diff, nil); err != nil {
+			return SyncResult{}, err
+		}
+		result.Changes = append(result.Changes, SyncChange{Kind: "policy", Op: "delete", Name: p.Name, ID: p.ID, Version: p.Version})
+	}
+	roleNames := make([]string, 0, len(set.Roles))
+	for _, role := range set.Roles {
+		roleNames = append(roleNames, role.Name)
+		var current Role
+		var permsData []byte
+		err := tx.QueryRow(ctx, `
+			SELECT id, permissions, managed_by FROM roles WHERE name=$1 FOR UPDATE
+		`, role.Name).Scan(&current.ID, &permsData, &current.ManagedBy)
+		perms, _ := json.Marshal(nonNilStrings(role.Permissions))
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			role.ID = uuid.New()
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO roles (id, name, permissions, managed_by, created_at) VALUES ($1, $2, $3, $4, now())
+			`, role.ID, role.Name, perms, source); err != nil {
+				return SyncResult{}, err
+			}
+			result.Changes = append(result.Changes, SyncChange{Kind: "role", Op: "create", Name: role.Name, ID: role.ID, Permissions: role.Permissions})
+		case err != nil:
+			return SyncResult{}, err
+		case current.ManagedBy != source:
+			return SyncResult{}, notManaged("role", role.Name)
+		default:
+			_ = json.Unmarshal(permsData, &current.Permissions)
+			if strings.Join(current.Permissions, ",") == strings.Join(role.Permissions, ",") {
+				continue
+			}
+			if _, err := tx.Exec(ctx, `UPDATE roles SET permissions=$1 WHERE id=$2`, perms, current.ID); err != nil {
+				return SyncResult{}, err
+			}
+			result.Changes = append(result.Changes, SyncChange{Kind: "role", Op: "update", Name: role.Name, ID: current.ID, Permissions: role.Permissions})
+		}
+	}
+	rows, err := tx.Query(ctx, `DELETE FROM roles WHERE managed_by=$1 AND NOT (name = ANY($2)) RETURNING id, name`, source, roleNames)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	for rows.Next() {
+		change := SyncChange{Kind: "role", Op: "delete"}
+		if err := rows.Scan(&change.ID, &change.Name); err != nil {
+			rows.Close()
+			return SyncResult{}, err
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return SyncResult{}, err
+	}
+	result.Roles = len(roleNames)
+	before, err := managedBindingKeys(ctx, tx, source)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM role_bindings WHERE managed_by=$1`, source); err != nil {
+		return SyncResult{}, err
+	}
+	for _, b := range set.Bindings {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO role_bindings (id, role_id, user_id, group_id, resource, managed_by, created_at)
+			SELECT gen_random_uuid(), r.id, u.id, g.id, NULLIF($4, ''), $5, now()
+			FROM roles r
+			LEFT JOIN users u ON u.username = $2
+			LEFT JOIN groups g ON g.name = $3
+			WHERE r.name = $1
+				AND (($2 <> '' AND u.id IS NOT NULL) OR ($3 <> '' AND g.id IS NOT NULL))
+		`, b.Role, b.Username, b.Group, b.Resource, source)
+		if err != nil {
+			return SyncResult{}, err
+		}
+		if tag.RowsAffected() == 0 {
+			result.Skipped = append(result.Skipped, b.Key())
+			continue
+		}
+		result.Bindings++
+	}
+	after, err := managedBindingKeys(ctx, tx, source)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	for key := range after {
+		if !before[key] {
+			result.Changes = append(result.Changes, SyncChange{Kind: "role_binding", Op: "create", Name: key})
+		}
+	}
+	for key := range before {
+		if !after[key] {
+			result.Changes = append(result.Changes, SyncChange{Kind: "role_binding", Op: "delete", Name: key})
+		}
+	}
+	return result, tx.Commit(ctx)
+}
+
+// ErrNotManaged reports a managed object whose name is already used by one
+// created through the API or by another source.
+var ErrNotManaged = errors.New("name is used by an object not managed by this source")
+
+func notManaged(kind, name string) error {
+	return &NotManagedError{Kind: kind, Name: name}
+}
+
+// NotManagedError names the object that blocked a managed sync.
+type NotManagedError struct {
+	Kind string
+	Name string
+}
+
+func (e *NotManagedError) Error() string {
+	return e.Kind + " " + strconv.Quote(e.Name) + ": " + ErrNotManaged.Error()
+}
+
+func (e *NotManagedError) Unwrap() error {
+	return ErrNotManaged
+}
+
+func managedBindingKeys(ctx context.Context, tx pgx.Tx, source string) (map[string]bool, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT r.name, COALESCE(u.username, ''), COALESCE(g.name, ''), COALESCE(b.resource, '')
+		FROM role_bindings b
+		JOIN roles r ON r.id = b.role_id
+		LEFT JOIN users u ON u.id = b.user_id
+		LEFT JOIN groups g ON g.id = b.group_id
+		WHERE b.managed_by=$1
+	`, source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := map[string]bool{}
+	for rows.Next() {
+		var b ManagedBinding
+		if err := rows.Scan(&b.Role, &b.Username, &b.Group, &b.Resource); err != nil {
+			return nil, err
+		}
+		keys[b.Key()] = true
+	}
+	return keys, rows.Err()
+}
+
+func insertPolicyVersion(ctx context.Context, tx pgx.Tx, p Policy, change string, doc []byte, diff []byte, authorID *uuid.UUID) error {
+	if diff == nil {
+		diff = []byte("[]")
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO policy_versions (id, policy_id, policy_name, version, change, doc, diff, author_id, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,now())
+	`, uuid.New(), p.ID, p.Name, p.Version, change, doc, diff, authorID)
+	return err
+}
+
+func (s *Store) ListPolicyVersions(ctx context.Context, policyID uuid.UUID) ([]PolicyVersion, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, policy_id, policy_name, version, change, doc, diff, author_id, created_at
+		FROM policy_versions WHERE policy_id=$1 ORDER BY version DESC
+	`, policyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []PolicyVersion
+	for rows.Next() {
+		v, err := scanPolicyVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+func (s *Store) GetPolicyVersion(ctx context.Context, policyID uuid.UUID, version int) (PolicyVersion, error) {
+	v, err := scanPolicyVersion(s.db.QueryRow(ctx, `
+		SELECT id, policy_id, policy_name, version, change, doc, diff, author_id, created_at
+		FROM policy_versions WHERE policy_id=$1 AND version=$2
+	`, policyID, version))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return PolicyVersion{}, ErrNotFound
+	}
+	return v, err
+}
+
+func scanPolicyVersion(row pgx.Row) (PolicyVersion, error) {
+	var v PolicyVersion
+	err := row.Scan(&v.ID, &v.PolicyID, &v.PolicyName, &v.Version, &v.Change, &v.Doc, &v.Diff, &v.AuthorID, &v.CreatedAt)
+	return v, err
+}
+
+func uniqueConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *Store) UpsertPolicy(ctx context.Context, name string, doc []byte) (Policy, error) {
+	var p Policy
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO policies (id, name, doc, created_at)
+		VALUES (gen_random_uuid(), $1, $2, now())
+		ON CONFLICT (name) DO UPDATE SET doc=excluded.doc
+		RETURNING id, name, doc, created_at
+	`, name, doc).Scan(&p.ID, &p.Name, &p.Doc, &p.CreatedAt)
+	return p, err
+}
+
+func (s *Store) CreateConnectionSecret(ctx context.Context, encrypted []byte) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO connection_secrets (id, encrypted, created_at, updated_at)
+		VALUES (gen_random_uuid(), $1, now(), now())
+		RETURNING id
+	`, encrypted).Scan(&id)
+	return id, err
+}
+
+func (s *Store) UpdateConnectionSecret(ctx context.Context, id uuid.UUID, encrypted []byte) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE connection_secrets SET encrypted=$1, updated_at=now() WHERE id=$2
+	`, encrypted, id)
+	return err
+}
+
+func (s *Store) GetConnectionSecret(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	var encrypted []byte
+	err := s.db.QueryRow(ctx, `SELECT encrypted FROM connection_secrets WHERE id=$1`, id).Scan(&encrypted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return encrypted, err
+}
+
+func (s *Store) CreateConnection(ctx context.Context, conn Connection) (Connection, error) {
+	if conn.ID == uuid.Nil {
+		conn.ID = uuid.New()
+	}
+	tlsData, _ := json.Marshal(conn.TLS)
+	tagsData, _ := json.Marshal(conn.Tags)
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO connections (id, name, type, host, port, database, username, secret_ref, tls, tags, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,now(),now())
+		RETURNING created_at, updated_at
+	`, conn.ID, conn.Name, conn.Type, conn.Host, conn.Port, conn.Database, conn.Username, conn.SecretRef, tlsData, tagsData).Scan(&conn.CreatedAt, &conn.UpdatedAt)
+	return conn, err
+}
+
+func (s *Store) UpdateConnection(ctx context.Context, conn Connection) error {
+	tlsData, _ := json.Marshal(conn.TLS)
+	tagsData, _ := json.Marshal(conn.Tags)
+	_, err := s.db.Exec(ctx, `
+		UPDATE connections
+		SET name=$1, type=$2, host=$3, port=$4, database=$5, username=$6, tls=$7, tags=$8, updated_at=now()
+		WHERE id=$9
+	`, conn.Name, conn.Type, conn.Host, conn.Port, conn.Database, conn.Username, tlsData, tagsData, conn.ID)
+	return err
+}
+
+func (s *Store) DeleteConnection(ctx context.Context, id uuid.UUID) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM connections WHERE id=$1`, id)
+	return err
+}
+
+func (s *Store) GetConnection(ctx context.Context, id uuid.UUID) (Connection, error) {
+	var conn Connection
+	var tlsData []byte
+	var tagsData []byte
+	err := s.db.QueryRow(ctx, `
+		SELECT id, name, type, host, port, database, username, secret_ref, tls, tags, created_at, updated_at
+		FROM connections WHERE id=$1
+	`, id).Scan(&conn.ID, &conn.Name, &conn.Type, &conn.Host, &conn.Port, &conn.Database, &conn.Username, &conn.SecretRef, &tlsData, &tagsData, &conn.CreatedAt, &conn.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Connection{}, ErrNotFound
+	}
+	_ = json.Unmarshal(tlsData, &conn.TLS)
+	_ = json.Unmarshal(tagsData, &conn.Tags)
+	return conn, err
+}
+
+func (s *Store) ListConnections(ctx context.Context) ([]Connection, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, name, type, host, port, database, username, secret_ref, tls, tags, created_at, updated_at
+		FROM connections ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var conns []Connection
+	for rows.Next() {
+		var conn Connection
+		var tlsData []byte
+		var tagsData []byte
+		if err := rows.Scan(&conn.ID, &conn.Name, &conn.Type, &conn.Host, &conn.Port, &conn.Database, &conn.Username, &conn.SecretRef, &tlsData, &tagsData, &conn.CreatedAt, &conn.UpdatedAt); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(tlsData, &conn.TLS)
+		_ = json.Unmarshal(tagsData, &conn.Tags)
+		conns = append(conns, conn)
+	}
+	return conns, rows.Err()
+}
+
+const historyColumns = `id, user_id, connection_id, statement_hash, status, row_count, duration_ms, started_at, ended_at, action, resource, approval_id, statement_enc, statement_redacted, fingerprint, fingerprint_text`
+
+func scanHistory(row pgx.Row) (QueryHistory, error) {
+	var q QueryHistory
+	err := row.Scan(&q.ID, &q.UserID, &q.ConnectionID, &q.StatementHash, &q.Status, &q.RowCount, &q.DurationMs, &q.StartedAt, &q.EndedAt, &q.Action, &q.Resource, &q.ApprovalID, &q.StatementEnc, &q.StatementRedacted, &q.Fingerprint, &q.FingerprintText)
+	return q, err
+}
+
+func (s *Store) CreateQueryHistory(ctx context.Context, history QueryHistory) (QueryHistory, error) {
+	if history.ID == uuid.Nil {
+		history.ID = uuid.New()
+	}
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO query_history (id, user_id, connection_id, statement_hash, status, row_count, duration_ms, started_at, ended_at, action, resource, approval_id, statement_enc, statement_redacted, fingerprint, fingerprint_text)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)
+		RETURNING started_at
+	`, history.ID, history.UserID, history.ConnectionID, history.StatementHash, history.Status, history.RowCount, history.DurationMs, history.StartedAt, history.EndedAt, history.Action, history.Resource, history.ApprovalID, history.StatementEnc, history.StatementRedacted, history.Fingerprint, history.FingerprintText).Scan(&history.StartedAt)
+	return history, err
+}
+
+func (s *Store) UpdateQueryHistory(ctx context.Context, history QueryHistory) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE query_history
+		SET status=$1, row_count=$2, duration_ms=$3, ended_at=$4
+		WHERE id=$5
+	`, history.Status, history.RowCount, history.DurationMs, history.EndedAt, history.ID)
+	return err
+}
+
+func (s *Store) GetQueryHistory(ctx context.Context, id uuid.UUID) (QueryHistory, error) {
+	q, err := scanHistory(s.db.QueryRow(ctx, `SELECT `+historyColumns+` FROM query_history WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return QueryHistory{}, ErrNotFound
+	}
+	return q, err
+}
+
+// HistoryFilter narrows ListHistory; zero fields match everything.
+type HistoryFilter struct {
+	UserID       *uuid.UUID
+	ConnectionID *uuid.UUID
+	Fingerprint  string
+	Status       []string
+	From         *time.Time
+	To           *time.Time
+	Limit        int
+	Offset       int
+}
+
+// ListHistory returns matching entries, newest first.
+func (s *Store) ListHistory(ctx context.Context, filter HistoryFilter) ([]QueryHistory, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if filter.UserID != nil {
+		add("user_id = ?", *filter.UserID)
+	}
+	if filter.ConnectionID != nil {
+		add("connection_id = ?", *filter.ConnectionID)
+	}
+	if filter.Fingerprint != "" {
+		add("fingerprint = ?", filter.Fingerprint)
+	}
+	if len(filter.Status) > 0 {
+		add("status = ANY(?)", filter.Status)
+	}
+	if filter.From != nil {
+		add("started_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		add("started_at < ?", *filter.To)
+	}
+	query := `SELECT ` + historyColumns + ` FROM query_history`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query += ` ORDER BY started_at DESC, id LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []QueryHistory
+	for rows.Next() {
+		q, err := scanHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
+
+// InsightFilter narrows QueryInsights to finished runs started in
+// [From, To).
+type InsightFilter struct {
+	From         time.Time
+	To           time.Time
+	UserID       *uuid.UUID
+	ConnectionID *uuid.UUID
+	Fingerprint  string
+	// Bucket is the bucket width when grouping by time.
+	Bucket time.Duration
+	// Sort is count, p95, failures or rows; results are ordered by it,
+	// largest first, or by time when grouping by time.
+	Sort  string
+	Limit int
+}
+
+// QueryInsight aggregates the runs in one group. Durations are percentiles
+// over completed runs.
+type QueryInsight struct {
+	Key             string
+	FingerprintText string
+	Count           int64
+	Failed          int64
+	P50Ms           float64
+	P95Ms           float64
+	Rows            int64
+	LastRun         time.Time
+}
+
+// Insight groupings.
+const (
+	InsightByFingerprint = "fingerprint"
+	InsightByUser        = "user"
+	InsightByConnection  = "connection"
+	InsightByTime        = "time"
+)
+
+var insightSorts = map[string]string{
+	"count":    "COUNT(*)",
+	"p95":      "5",
+	"failures": "COUNT(*) FILTER (WHERE status = 'failed')",
+	"rows":     "SUM(row_count)",
+}
+
+// QueryInsights aggregates completed and failed runs by groupBy.
+func (s *Store) QueryInsights(ctx context.Context, groupBy string, filter InsightFilter) ([]QueryInsight, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	where = append(where, "status IN ('completed', 'failed')")
+	add("started_at >= ?", filter.From)
+	add("started_at < ?", filter.To)
+	if filter.UserID != nil {
+		add("user_id = ?", *filter.UserID)
+	}
+	if filter.ConnectionID != nil {
+		add("connection_id = ?", *filter.ConnectionID)
+	}
+	if filter.Fingerprint != "" {
+		add("fingerprint = ?", filter.Fingerprint)
+	}
+	key, text := "", "''"
+	order := insightSorts[filter.Sort]
+	if order == "" {
+		order = insightSorts["count"]
+	}
+	order += " DESC, 1"
+	switch groupBy {
+	case InsightByFingerprint:
+		key, text = "fingerprint", "MAX(fingerprint_text)"
+		where = append(where, "fingerprint <> ''")
+	case InsightByUser:
+		key = "COALESCE(user_id::text, '')"
+	case InsightByConnection:
+		key = "COALESCE(connection_id::text, '')"
+	case InsightByTime:
+		if filter.Bucket < time.Second {
+			return nil, errors.New("bucket must be at least 1s")
+		}
+		args = append(args, int64(filter.Bucket/time.Second))
+		n := "$" + strconv.Itoa(len(args))
+		key = `to_char(to_timestamp(floor(extract(epoch FROM started_at) / ` + n + `) * ` + n + `) AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`
+		order = "1"
+	default:
+		return nil, errors.New("unknown grouping " + groupBy)
+	}
+	args = append(args, filter.Limit)
+	query := `
+		SELECT ` + key + `, ` + text + `, COUNT(*),
+			COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY duration_ms) FILTER (WHERE status = 'completed'), 0),
+			COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY duration_ms) FILTER (WHERE status = 'completed'), 0),
+			COUNT(*) FILTER (WHERE status = 'failed'), COALESCE(SUM(row_count), 0), MAX(started_at)
+		FROM query_history
+		WHERE ` + strings.Join(where, " AND ") + `
+		GROUP BY 1 ORDER BY ` + order + ` LIMIT $` + strconv.Itoa(len(args))
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []QueryInsight
+	for rows.Next() {
+		var q QueryInsight
+		if err := rows.Scan(&q.Key, &q.FingerprintText, &q.Count, &q.P50Ms, &q.P95Ms, &q.Failed, &q.Rows, &q.LastRun); err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
+
+func (s *Store) ListUsers(ctx context.Context, limit int, offset int) ([]User, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, username, password_hash, is_admin, mfa_enabled, mfa_secret_enc, mfa_verified_at, attributes, created_at
+		FROM users ORDER BY created_at DESC LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []User
+	for rows.Next() {
+		var u User
+		var attributes []byte
+		if err := rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.IsAdmin, &u.MFAEnabled, &u.MFASecretEnc, &u.MFAVerifiedAt, &attributes, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(attributes, &u.Attributes)
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *Store) ListGroups(ctx context.Context, limit int, offset int) ([]Group, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, name, created_at FROM groups ORDER BY created_at DESC LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []Group
+	for rows.Next() {
+		var g Group
+		if err := rows.Scan(&g.ID, &g.Name, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+const queryApprovalColumns = `id, connection_id, user_id, statement, statement_hash, status, environment,
+	reason, ticket_url, impact, explain_plan, revision, required_approvals,
+	(SELECT COUNT(1) FROM query_approval_votes v WHERE v.approval_id = query_approvals.id AND v.decision = 'approved'),
+	created_at, expires_at, approved_by, approved_at, denied_by, denied_at, consumed_at`
+
+func scanQueryApproval(row pgx.Row) (QueryApproval, error) {
+	var q QueryApproval
+	err := row.Scan(&q.ID, &q.ConnectionID, &q.UserID, &q.Statement, &q.StatementHash, &q.Status, &q.Environment,
+		&q.Reason, &q.TicketURL, &q.Impact, &q.ExplainPlan, &q.Revision, &q.RequiredApprovals,
+		&q.Approvals, &q.CreatedAt, &q.ExpiresAt, &q.ApprovedBy, &q.ApprovedAt, &q.DeniedBy, &q.DeniedAt, &q.ConsumedAt)
+	return q, err
+}
+
+// CreateQueryApproval stores a new approval together with its first
+// revision.
+func (s *Store) CreateQueryApproval(ctx context.Context, approval QueryApproval) (QueryApproval, error) {
+	if approval.ID == uuid.Nil {
+		approval.ID = uuid.New()
+	}
+	if approval.RequiredApprovals < 1 {
+		approval.RequiredApprovals = 1
+	}
+	approval.Revision = 1
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	defer tx.Rollback(ctx)
+	err = tx.QueryRow(ctx, `
+		INSERT INTO query_approvals (id, connection_id, user_id, statement, statement_hash, status, environment,
+			reason, ticket_url, impact, explain_plan, revision, required_approvals, expires_at, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,now())
+		RETURNING created_at
+	`, approval.ID, approval.ConnectionID, approval.UserID, approval.Statement, approval.StatementHash, approval.Status,
+		approval.Environment, approval.Reason, approval.TicketURL, approval.Impact, nullJSON(approval.ExplainPlan),
+		approval.Revision, approval.RequiredApprovals, approval.ExpiresAt).Scan(&approval.CreatedAt)
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	if err := insertApprovalRevision(ctx, tx, approval, approval.UserID); err != nil {
+		return QueryApproval{}, err
+	}
+	return approval, tx.Commit(ctx)
+}
+
+func insertApprovalRevision(ctx context.Context, tx pgx.Tx, q QueryApproval, author uuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO query_approval_revisions (approval_id, revision, statement, statement_hash, reason, ticket_url, impact, explain_plan, created_by, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,now())
+	`, q.ID, q.Revision, q.Statement, q.StatementHash, q.Reason, q.TicketURL, q.Impact, nullJSON(q.ExplainPlan), author)
+	return err
+}
+
+func nullJSON(raw []byte) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return raw
+}
+
+// ReviseQueryApproval replaces the statement and context of a pending or
+// changes_requested approval with a new revision. Earlier votes are
+// discarded and the expiry restarts. The pending_approval history entry is
+// rewritten from history so it shows the revised statement. ErrConflict is
+// returned for any other status.
+func (s *Store) ReviseQueryApproval(ctx context.Context, revised QueryApproval, history QueryHistory, ttl time.Duration) (QueryApproval, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	defer tx.Rollback(ctx)
+	current, err := scanQueryApproval(tx.QueryRow(ctx, `SELECT `+queryApprovalColumns+` FROM query_approvals WHERE id=$1 FOR UPDATE`, revised.ID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return QueryApproval{}, ErrNotFound
+	}
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	if current.Status != "pending" && current.Status != "changes_requested" {
+		return QueryApproval{}, ErrConflict
+	}
+	revised.Revision = current.Revision + 1
+	_, err = tx.Exec(ctx, `
+		UPDATE query_approvals SET statement=$2, statement_hash=$3, reason=$4, ticket_url=$5, impact=$6, explain_plan=$7,
+			revision=$8, status='pending', expires_at = now() + make_interval(secs => $9)
+		WHERE id=$1
+	`, revised.ID, revised.Statement, revised.StatementHash, revised.Reason, revised.TicketURL, revised.Impact,
+		nullJSON(revised.ExplainPlan), revised.Revision, ttl.Seconds())
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM query_approval_votes WHERE approval_id=$1`, revised.ID); err != nil {
+		return QueryApproval{}, err
+	}
+	if err := insertApprovalRevision(ctx, tx, revised, current.UserID); err != nil {
+		return QueryApproval{}, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE query_history SET statement_hash=$2, action=$3, resource=$4, statement_enc=$5, statement_redacted=$6,
+			fingerprint=$7, fingerprint_text=$8
+		WHERE approval_id=$1 AND status='pending_approval'
+	`, revised.ID, history.StatementHash, history.Action, history.Resource, history.StatementEnc, history.StatementRedacted,
+		history.Fingerprint, history.FingerprintText)
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	out, err := scanQueryApproval(tx.QueryRow(ctx, `SELECT `+queryApprovalColumns+` FROM query_approvals WHERE id=$1`, revised.ID))
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	return out, tx.Commit(ctx)
+}
+
+// RequestApprovalChanges sends a pending approval back to its author with a
+// comment. ErrConflict is returned when it is not pending.
+func (s *Store) RequestApprovalChanges(ctx context.Context, id uuid.UUID, reviewer uuid.UUID, body string) (ApprovalComment, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return ApprovalComment{}, err
+	}
+	defer tx.Rollback(ctx)
+	var revision int
+	err = tx.QueryRow(ctx, `
+		UPDATE query_approvals SET status='changes_requested'
+		WHERE id=$1 AND status='pending' AND (expires_at IS NULL OR expires_at > now())
+		RETURNING revision
+	`, id).Scan(&revision)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := s.GetQueryApproval(ctx, id); err != nil {
+			return ApprovalComment{}, err
+		}
+		return ApprovalComment{}, ErrConflict
+	}
+	if err != nil {
+		return ApprovalComment{}, err
+	}
+	comment, err := insertApprovalComment(ctx, tx, ApprovalComment{ApprovalID: id, UserID: &reviewer, Revision: revision, Kind: "changes_requested", Body: body})
+	if err != nil {
+		return ApprovalComment{}, err
+	}
+	return comment, tx.Commit(ctx)
+}
+
+// AddApprovalComment appends a comment on the approval's current revision.
+func (s *Store) AddApprovalComment(ctx context.Context, id uuid.UUID, userID uuid.UUID, body string) (ApprovalComment, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return ApprovalComment{}, err
+	}
+	defer tx.Rollback(ctx)
+	var revision int
+	err = tx.QueryRow(ctx, `SELECT revision FROM query_approvals WHERE id=$1`, id).Scan(&revision)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ApprovalComment{}, ErrNotFound
+	}
+	if err != nil {
+		return ApprovalComment{}, err
+	}
+	comment, err := insertApprovalComment(ctx, tx, ApprovalComment{ApprovalID: id, UserID: &userID, Revision: revision, Kind: "comment", Body: body})
+	if err != nil {
+		return ApprovalComment{}, err
+	}
+	return comment, tx.Commit(ctx)
+}
+
+func insertApprovalComment(ctx context.Context, tx pgx.Tx, c ApprovalComment) (ApprovalComment, error) {
+	c.ID = uuid.New()
+	err := tx.QueryRow(ctx, `
+		INSERT INTO query_approval_comments (id, approval_id, user_id, revision, kind, body, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,now())
+		RETURNING created_at
+	`, c.ID, c.ApprovalID, c.UserID, c.Revision, c.Kind, c.Body).Scan(&c.CreatedAt)
+	return c, err
+}
+
+func (s *Store) ListApprovalComments(ctx context.Context, approvalID uuid.UUID) ([]ApprovalComment, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, approval_id, user_id, revision, kind, body, created_at
+		FROM query_approval_comments WHERE approval_id=$1 ORDER BY created_at
+	`, approvalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []ApprovalComment
+	for rows.Next() {
+		var c ApprovalComment
+		if err := rows.Scan(&c.ID, &c.ApprovalID, &c.UserID, &c.Revision, &c.Kind, &c.Body, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+func (s *Store) ListApprovalRevisions(ctx context.Context, approvalID uuid.UUID) ([]ApprovalRevision, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT approval_id, revision, statement, statement_hash, reason, ticket_url, impact, explain_plan, created_by, created_at
+		FROM query_approval_revisions WHERE approval_id=$1 ORDER BY revision
+	`, approvalID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []ApprovalRevision
+	for rows.Next() {
+		var v ApprovalRevision
+		if err := rows.Scan(&v.ApprovalID, &v.Revision, &v.Statement, &v.StatementHash, &v.Reason, &v.TicketURL, &v.Impact, &v.ExplainPlan, &v.CreatedBy, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+func (s *Store) GetQueryApproval(ctx context.Context, id uuid.UUID) (QueryApproval, error) {
+	q, err := scanQueryApproval(s.db.QueryRow(ctx, `SELECT `+queryApprovalColumns+` FROM query_approvals WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return QueryApproval{}, ErrNotFound
+	}
+	return q, err
+}
+
+// ListPendingApprovals lists pending approvals that have not expired.
+func (s *Store) ListPendingApprovals(ctx context.Context) ([]QueryApproval, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+queryApprovalColumns+`
+		FROM query_approvals WHERE status='pending' AND (expires_at IS NULL OR expires_at > now())
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []QueryApproval
+	for rows.Next() {
+		q, err := scanQueryApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
+
+func (s *Store) ListApprovalVotes(ctx context.Context, approvalID uuid.UUID) ([]ApprovalVote, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT approval_id, user_id, decision, created_at FROM query_approval_votes
+		WHERE approval_id=$1 ORDER BY created_at
+	`, approvalID)
+	if err != nil {
+		return nil, err
+	}
+	return scanApprovalVotes(rows)
+}
+
+func scanApprovalVotes(rows pgx.Rows) ([]ApprovalVote, error) {
+	defer rows.Close()
+	var list []ApprovalVote
+	for rows.Next() {
+		var v ApprovalVote
+		if err := rows.Scan(&v.ApprovalID, &v.UserID, &v.Decision, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// ErrExpired is returned when acting on an approval past its expiry.
+var ErrExpired = errors.New("expired")
+
+// ErrSelfApproval is returned when a requester votes on their own approval.
+var ErrSelfApproval = errors.New("cannot approve own request")
+
+// DecideApprovalVote returns the status q moves to when voter casts decision
+// at now, given the votes already recorded. A single deny rejects the
+// request; it is approved once RequiredApprovals distinct approvers agreed.
+// ErrConflict is returned when q is no longer pending or voter already voted.
+func DecideApprovalVote(q QueryApproval, votes []ApprovalVote, voter uuid.UUID, decision string, now time.Time) (string, error) {
+	if voter == q.UserID {
+		return "", ErrSelfApproval
+	}
+	if q.Status != "pending" {
+		return "", ErrConflict
+	}
+	if q.ExpiresAt != nil && !now.Before(*q.ExpiresAt) {
+		return "", ErrExpired
+	}
+	approvals := 0
+	for _, v := range votes {
+		if v.UserID == voter {
+			return "", ErrConflict
+		}
+		if v.Decision == "approved" {
+			approvals++
+		}
+	}
+	switch decision {
+	case "denied":
+		return "denied", nil
+	case "approved":
+		if approvals+1 >= q.RequiredApprovals {
+			return "approved", nil
+		}
+		return "pending", nil
+	}
+	return "", errors.New("invalid decision")
+}
+
+// CheckApprovalUsable returns ErrNotFound unless q authorizes one run of the
+// statement with statementHash by userID on connectionID at now: it must be
+// approved, unexpired and not yet consumed.
+func CheckApprovalUsable(q QueryApproval, userID uuid.UUID, connectionID uuid.UUID, statementHash string, now time.Time) error {
+	if q.UserID != userID || q.ConnectionID != connectionID || q.StatementHash != statementHash {
+		return ErrNotFound
+	}
+	if q.Status != "approved" || q.ExpiresAt == nil || !now.Before(*q.ExpiresAt) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// VoteQueryApproval records one approver's decision as decided by
+// DecideApprovalVote. Once approved, the approval stays valid for ttl.
+func (s *Store) VoteQueryApproval(ctx context.Context, id uuid.UUID, voter uuid.UUID, decision string, ttl time.Duration) (QueryApproval, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	defer tx.Rollback(ctx)
+	q, err := scanQueryApproval(tx.QueryRow(ctx, `SELECT `+queryApprovalColumns+` FROM query_approvals WHERE id=$1 FOR UPDATE`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return QueryApproval{}, ErrNotFound
+	}
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT approval_id, user_id, decision, created_at FROM query_approval_votes WHERE approval_id=$1
+	`, id)
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	votes, err := scanApprovalVotes(rows)
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	status, err := DecideApprovalVote(q, votes, voter, decision, time.Now())
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO query_approval_votes (approval_id, user_id, decision, created_at) VALUES ($1,$2,$3,now())
+	`, id, voter, decision)
+	if err != nil {
+		return QueryApproval{}, uniqueConflict(err)
+	}
+	switch status {
+	case "denied":
+		_, err = tx.Exec(ctx, `
+			UPDATE query_approvals SET status='denied', denied_by=$1, denied_at=now() WHERE id=$2
+		`, voter, id)
+	case "approved":
+		_, err = tx.Exec(ctx, `
+			UPDATE query_approvals SET status='approved', approved_by=$1, approved_at=now(),
+				expires_at = now() + make_interval(secs => $3)
+			WHERE id=$2
+		`, voter, id, ttl.Seconds())
+	}
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	q, err = scanQueryApproval(tx.QueryRow(ctx, `SELECT `+queryApprovalColumns+` FROM query_approvals WHERE id=$1`, id))
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	return q, tx.Commit(ctx)
+}
+
+// ConsumeQueryApproval marks an approval as used for one run of the exact
+// statement by its requester on the connection. It fails with ErrNotFound
+// unless CheckApprovalUsable passes.
+func (s *Store) ConsumeQueryApproval(ctx context.Context, id uuid.UUID, userID uuid.UUID, connectionID uuid.UUID, statementHash string) (QueryApproval, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	defer tx.Rollback(ctx)
+	q, err := scanQueryApproval(tx.QueryRow(ctx, `SELECT `+queryApprovalColumns+` FROM query_approvals WHERE id=$1 FOR UPDATE`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return QueryApproval{}, ErrNotFound
+	}
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	if err := CheckApprovalUsable(q, userID, connectionID, statementHash, time.Now()); err != nil {
+		return QueryApproval{}, err
+	}
+	err = tx.QueryRow(ctx, `
+		UPDATE query_approvals SET status='consumed', consumed_at=now() WHERE id=$1 RETURNING status, consumed_at
+	`, id).Scan(&q.Status, &q.ConsumedAt)
+	if err != nil {
+		return QueryApproval{}, err
+	}
+	return q, tx.Commit(ctx)
+}
+
+// RestoreQueryApproval makes a consumed approval usable again after the run
+// it was consumed for failed to start. It keeps its original expiry.
+func (s *Store) RestoreQueryApproval(ctx context.Context, id uuid.UUID) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE query_approvals SET status='approved', consumed_at=NULL WHERE id=$1 AND status='consumed'
+	`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListPIIRules(ctx context.Context, connectionID uuid.UUID) ([]PIIRule, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, connection_id, resource, field, mask_type, exempt_roles, exempt_groups, require_justification, created_at, updated_at
+		FROM pii_rules WHERE connection_id=$1 ORDER BY created_at
+	`, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []PIIRule
+	for rows.Next() {
+		r, err := scanPIIRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *Store) GetPIIRule(ctx context.Context, id uuid.UUID) (PIIRule, error) {
+	r, err := scanPIIRule(s.db.QueryRow(ctx, `
+		SELECT id, connection_id, resource, field, mask_type, exempt_roles, exempt_groups, require_justification, created_at, updated_at
+		FROM pii_rules WHERE id=$1
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return PIIRule{}, ErrNotFound
+	}
+	return r, err
+}
+
+func (s *Store) CreatePIIRule(ctx context.Context, rule PIIRule) (PIIRule, error) {
+	if rule.ID == uuid.Nil {
+		rule.ID = uuid.New()
+	}
+	exemptRoles, _ := json.Marshal(nonNilStrings(rule.ExemptRoles))
+	exemptGroups, _ := json.Marshal(nonNilStrings(rule.ExemptGroups))
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO pii_rules (id, connection_id, resource, field, mask_type, exempt_roles, exempt_groups, require_justification, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,now(),now())
+		RETURNING created_at, updated_at
+	`, rule.ID, rule.ConnectionID, rule.Resource, rule.Field, rule.MaskType, exemptRoles, exemptGroups, rule.RequireJustification).Scan(&rule.CreatedAt, &rule.UpdatedAt)
+	return rule, err
+}
+
+func (s *Store) UpdatePIIRule(ctx context.Context, rule PIIRule) error {
+	exemptRoles, _ := json.Marshal(nonNilStrings(rule.ExemptRoles))
+	exemptGroups, _ := json.Marshal(nonNilStrings(rule.ExemptGroups))
+	_, err := s.db.Exec(ctx, `
+		UPDATE pii_rules
+		SET resource=$1, field=$2, mask_type=$3, exempt_roles=$4, exempt_groups=$5, require_justification=$6, updated_at=now()
+		WHERE id=$7
+	`, rule.Resource, rule.Field, rule.MaskType, exemptRoles, exemptGroups, rule.RequireJustification, rule.ID)
+	return err
+}
+
+func (s *Store) DeletePIIRule(ctx context.Context, id uuid.UUID) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM pii_rules WHERE id=$1`, id)
+	return err
+}
+
+func scanPIIRule(row pgx.Row) (PIIRule, error) {
+	var r PIIRule
+	var exemptRoles []byte
+	var exemptGroups []byte
+	if err := row.Scan(&r.ID, &r.ConnectionID, &r.Resource, &r.Field, &r.MaskType, &exemptRoles, &exemptGroups, &r.RequireJustification, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return PIIRule{}, err
+	}
+	_ = json.Unmarshal(exemptRoles, &r.ExemptRoles)
+	_ = json.Unmarshal(exemptGroups, &r.ExemptGroups)
+	return r, nil
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func (s *Store) CreatePIIScan(ctx context.Context, scan PIIScan) (PIIScan, error) {
+	if scan.ID == uuid.Nil {
+		scan.ID = uuid.New()
+	}
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO pii_scans (id, connection_id, status, started_by, started_at)
+		VALUES ($1,$2,$3,$4,now())
+		RETURNING started_at
+	`, scan.ID, scan.ConnectionID, scan.Status, scan.StartedBy).Scan(&scan.StartedAt)
+	return scan, err
+}
+
+func (s *Store) FinishPIIScan(ctx context.Context, scan PIIScan) error {
+	failures, _ := json.Marshal(nonNilStrings(scan.Failures))
+	_, err := s.db.Exec(ctx, `
+		UPDATE pii_scans
+		SET status=$1, finished_at=now(), entities_scanned=$2, fields_flagged=$3, error=$4, failures=$5
+		WHERE id=$6
+	`, scan.Status, scan.EntitiesScanned, scan.FieldsFlagged, scan.Error, failures, scan.ID)
+	return err
+}
+
+// FailRunningPIIScans marks scans still running, left behind by a server that
+// stopped mid-scan, as failed with reason.
+func (s *Store) FailRunningPIIScans(ctx context.Context, reason string) (int64, error) {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE pii_scans SET status='failed', finished_at=now(), error=$1 WHERE status='running'
+	`, reason)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// LastPIIScanTimes returns, per connection, when its latest scan started.
+func (s *Store) LastPIIScanTimes(ctx context.Context) (map[uuid.UUID]time.Time, error) {
+	rows, err := s.db.Query(ctx, `SELECT connection_id, max(started_at) FROM pii_scans GROUP BY connection_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[uuid.UUID]time.Time{}
+	for rows.Next() {
+		var id uuid.UUID
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		out[id] = at
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) ListPIIScans(ctx context.Context, connectionID uuid.UUID, limit int) ([]PIIScan, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, connection_id, status, started_by, started_at, finished_at, entities_scanned, fields_flagged, COALESCE(error, ''), failures
+		FROM pii_scans WHERE connection_id=$1 ORDER BY started_at DESC LIMIT $2
+	`, connectionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []PIIScan
+	for rows.Next() {
+		var scan PIIScan
+		var failures []byte
+		if err := rows.Scan(&scan.ID, &scan.ConnectionID, &scan.Status, &scan.StartedBy, &scan.StartedAt, &scan.FinishedAt, &scan.EntitiesScanned, &scan.FieldsFlagged, &scan.Error, &failures); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(failures, &scan.Failures)
+		list = append(list, scan)
+	}
+	return list, rows.Err()
+}
+
+// UpsertPIIProposal records a discovery result. Proposals that were already
+// accepted or rejected keep their status so rescans do not resurface them.
+func (s *Store) UpsertPIIProposal(ctx context.Context, p PIIProposal) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	reasons, _ := json.Marshal(nonNilStrings(p.Reasons))
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO pii_proposals (id, connection_id, scan_id, resource, field, category, confidence, reasons, mask_type, status, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,'pending',now(),now())
+		ON CONFLICT (connection_id, resource, field) DO UPDATE
+		SET scan_id=excluded.scan_id, category=excluded.category, confidence=excluded.confidence, reasons=excluded.reasons, updated_at=now()
+	`, p.ID, p.ConnectionID, p.ScanID, p.Resource, p.Field, p.Category, p.Confidence, reasons, p.MaskType)
+	return err
+}
+
+func (s *Store) ListPIIProposals(ctx context.Context, connectionID uuid.UUID, status string) ([]PIIProposal, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, connection_id, scan_id, resource, field, category, confidence, reasons, mask_type, status, created_at, updated_at, decided_by, decided_at
+		FROM pii_proposals WHERE connection_id=$1 AND ($2='' OR status=$2)
+		ORDER BY resource, field
+	`, connectionID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []PIIProposal
+	for rows.Next() {
+		var p PIIProposal
+		var reasons []byte
+		if err := rows.Scan(&p.ID, &p.ConnectionID, &p.ScanID, &p.Resource, &p.Field, &p.Category, &p.Confidence, &reasons, &p.MaskType, &p.Status, &p.CreatedAt, &p.UpdatedAt, &p.DecidedBy, &p.DecidedAt); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(reasons, &p.Reasons)
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// DecidePIIProposals sets the status of pending proposals and creates the
+// rules accepting them in one transaction. A proposal that is no longer
+// pending returns ErrConflict and nothing is changed.
+func (s *Store) DecidePIIProposals(ctx context.Context, ids []uuid.UUID, status string, decidedBy uuid.UUID, rules []PIIRule) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for _, id := range ids {
+		tag, err := tx.Exec(ctx, `
+			UPDATE pii_proposals SET status=$1, decided_by=$2, decided_at=now(), updated_at=now()
+			WHERE id=$3 AND status='pending'
+		`, status, decidedBy, id)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrConflict
+		}
+	}
+	for _, rule := range rules {
+		if rule.ID == uuid.Nil {
+			rule.ID = uuid.New()
+		}
+		exemptRoles, _ := json.Marshal(nonNilStrings(rule.ExemptRoles))
+		exemptGroups, _ := json.Marshal(nonNilStrings(rule.ExemptGroups))
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO pii_rules (id, connection_id, resource, field, mask_type, exempt_roles, exempt_groups, require_justification, created_at, updated_at)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,now(),now())
+		`, rule.ID, rule.ConnectionID, rule.Resource, rule.Field, rule.MaskType, exemptRoles, exemptGroups, rule.RequireJustification); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+const auditColumns = `id, seq, event_type, actor_user_id, session_id, request_id, client_ip, user_agent, details, created_at, COALESCE(prev_hash, ''), COALESCE(hash, ''), hash_version, COALESCE(error_id, '')`
+
+func collectAudit(rows pgx.Rows) ([]AuditEntry, error) {
+	defer rows.Close()
+	var list []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Seq, &entry.EventType, &entry.ActorID, &entry.SessionID, &entry.RequestID, &entry.ClientIP, &entry.UserAgent, &entry.Details, &entry.CreatedAt, &entry.PrevHash, &entry.Hash, &entry.HashVersion, &entry.ErrorID); err != nil {
+			return nil, err
+		}
+		list = append(list, entry)
+	}
+	return list, rows.Err()
+}
+
+// AuditFilter narrows ListAudit. Zero fields do not filter; Text matches
+// anywhere in the details JSON, case-insensitively.
+type AuditFilter struct {
+	EventTypes   []string
+	ActorID      *uuid.UUID
+	From         *time.Time
+	To           *time.Time
+	ConnectionID string
+	ErrorID      string
+	SessionID    *uuid.UUID
+	RequestID    string
+	// ClientIP is an address or a CIDR range.
+	ClientIP string
+	Text     string
+	Limit    int
+	Offset   int
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListAudit returns matching entries, newest first. Only the conditions in
+// use are added to the query so each can use its own index.
+func (s *Store) ListAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if len(filter.EventTypes) > 0 {
+		add("event_type = ANY(?)", filter.EventTypes)
+	}
+	if filter.ActorID != nil {
+		add("actor_user_id = ?", *filter.ActorID)
+	}
+	if filter.From != nil {
+		add("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		add("created_at < ?", *filter.To)
+	}
+	if filter.ConnectionID != "" {
+		add("details->>'connectionId' = ?", filter.ConnectionID)
+	}
+	if filter.ErrorID != "" {
+		add("error_id = ?", filter.ErrorID)
+	}
+	if filter.SessionID != nil {
+		add("session_id = ?", *filter.SessionID)
+	}
+	if filter.RequestID != "" {
+		add("request_id = ?", filter.RequestID)
+	}
+	if strings.Contains(filter.ClientIP, "/") {
+		add("NULLIF(client_ip, '')::inet <<= ?::inet", filter.ClientIP)
+	} else if filter.ClientIP != "" {
+		add("client_ip = ?", filter.ClientIP)
+	}
+	if filter.Text != "" {
+		add("details::text ILIKE ?", "%"+likeEscaper.Replace(filter.Text)+"%")
+	}
+	query := `SELECT ` + auditColumns + ` FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query += ` ORDER BY seq DESC LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return collectAudit(rows)
+}
+
+// ListAuditAfter returns entries in chain order starting after seq.
+func (s *Store) ListAuditAfter(ctx context.Context, seq int64, limit int) ([]AuditEntry, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+auditColumns+`
+		FROM audit_log WHERE seq > $1 ORDER BY seq LIMIT $2
+	`, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectAudit(rows)
+}
+
+// ListAuditSettled is ListAuditAfter limited to entries created before
+// settledBefore. Seq is assigned at insert but rows become visible at
+// commit, so the newest entries are held back until any concurrent insert
+// with a lower seq has committed; a consumer that advances its cursor past
+// them never skips one. created_at is taken before the chain lock is
+// acquired, so it is not ordered by seq: the result stops at the first
+// unsettled entry instead of skipping it.
+func (s *Store) ListAuditSettled(ctx context.Context, seq int64, settledBefore time.Time, limit int) ([]AuditEntry, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+auditColumns+`
+		FROM audit_log WHERE seq > $1 ORDER BY seq LIMIT $2
+	`, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := collectAudit(rows)
+	if err != nil {
+		return nil, err
+	}
+	return settledPrefix(entries, settledBefore), nil
+}
+
+// settledPrefix returns the entries, in seq order, up to the first one
+// created at or after settledBefore.
+func settledPrefix(entries []AuditEntry, settledBefore time.Time) []AuditEntry {
+	for i, e := range entries {
+		if !e.CreatedAt.Before(settledBefore) {
+			return entries[:i]
+		}
+	}
+	return entries
+}
+
+func (s *Store) MaxAuditSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	err := s.db.QueryRow(ctx, `SELECT COALESCE(max(seq), 0) FROM audit_log`).Scan(&seq)
+	return seq, err
+}
+
+// CountAuditBetween counts entries with after < seq <= upTo.
+func (s *Store) CountAuditBetween(ctx context.Context, after int64, upTo int64) (int, error) {
+	var n int
+	err := s.db.QueryRow(ctx, `SELECT count(*) FROM audit_log WHERE seq > $1 AND seq <= $2`, after, upTo).Scan(&n)
+	return n, err
+}
+
+const auditArchiveColumns = `id, first_seq, last_seq, entries, signed_count, head_seq, head_hash, file_name, sha256, key_id, manifest, signature, created_at`
+
+func scanAuditArchive(row pgx.Row) (AuditArchive, error) {
+	var a AuditArchive
+	err := row.Scan(&a.ID, &a.FirstSeq, &a.LastSeq, &a.Entries, &a.SignedCount, &a.HeadSeq, &a.HeadHash, &a.FileName, &a.SHA256, &a.KeyID, &a.Manifest, &a.Signature, &a.CreatedAt)
+	return a, err
+}
+
+// ArchiveAudit records the archive and deletes the entries it holds in one
+// transaction.
+func (s *Store) ArchiveAudit(ctx context.Context, a AuditArchive) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO audit_archives (id, first_seq, last_seq, entries, signed_count, head_seq, head_hash, file_name, sha256, key_id, manifest, signature, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+	`, a.ID, a.FirstSeq, a.LastSeq, a.Entries, a.SignedCount, a.HeadSeq, a.HeadHash, a.FileName, a.SHA256, a.KeyID, a.Manifest, a.Signature, a.CreatedAt); err != nil {
+		return uniqueConflict(err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM audit_log WHERE seq <= $1`, a.LastSeq); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *Store) LatestAuditArchive(ctx context.Context) (AuditArchive, error) {
+	a, err := scanAuditArchive(s.db.QueryRow(ctx, `SELECT `+auditArchiveColumns+` FROM audit_archives ORDER BY last_seq DESC LIMIT 1`))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return AuditArchive{}, ErrNotFound
+	}
+	return a, err
+}
+
+func (s *Store) ListAuditArchives(ctx context.Context) ([]AuditArchive, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+auditArchiveColumns+` FROM audit_archives ORDER BY last_seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []AuditArchive
+	for rows.Next() {
+		a, err := scanAuditArchive(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+const auditCursorColumns = `name, kind, last_seq, delivered, last_error, last_attempt_at, last_success_at, created_at`
+
+func scanAuditCursor(row pgx.Row) (AuditCursor, error) {
+	var c AuditCursor
+	err := row.Scan(&c.Name, &c.Kind, &c.LastSeq, &c.Delivered, &c.LastError, &c.LastAttemptAt, &c.LastSuccessAt, &c.CreatedAt)
+	return c, err
+}
+
+func (s *Store) GetAuditCursor(ctx context.Context, name string) (AuditCursor, error) {
+	c, err := scanAuditCursor(s.db.QueryRow(ctx, `SELECT `+auditCursorColumns+` FROM audit_cursors WHERE name=$1`, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return AuditCursor{}, ErrNotFound
+	}
+	return c, err
+}
+
+func (s *Store) ListAuditCursors(ctx context.Context) ([]AuditCursor, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+auditCursorColumns+` FROM audit_cursors ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []AuditCursor
+	for rows.Next() {
+		c, err := scanAuditCursor(rows)
+		if err != nil {
 			return nil, err
 		}
-		list = append(list, q)
+		list = append(list, c)
 	}
 	return list, rows.Err()
 }
 
-func (s *Store) ListUsers(ctx context.Context, limit int, offset int) ([]User, error) {
+// AdvanceAuditCursor records that entries up to seq were delivered. The
+// cursor never moves backwards.
+func (s *Store) AdvanceAuditCursor(ctx context.Context, name string, kind string, seq int64, delivered int) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO audit_cursors (name, kind, last_seq, delivered, last_attempt_at, last_success_at)
+		VALUES ($1,$2,$3,$4,now(),now())
+		ON CONFLICT (name) DO UPDATE SET
+			last_seq = GREATEST(audit_cursors.last_seq, EXCLUDED.last_seq),
+			delivered = audit_cursors.delivered + EXCLUDED.delivered,
+			last_error = '',
+			last_attempt_at = now(),
+			last_success_at = now()
+	`, name, kind, seq, delivered)
+	return err
+}
+
+func (s *Store) RecordAuditCursorError(ctx context.Context, name string, kind string, message string) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO audit_cursors (name, kind, last_error, last_attempt_at)
+		VALUES ($1,$2,$3,now())
+		ON CONFLICT (name) DO UPDATE SET last_error = EXCLUDED.last_error, last_attempt_at = now()
+	`, name, kind, message)
+	return err
+}
+
+const auditCheckpointColumns = `seq, signed_count, head_hash, key_id, statement, signature, created_at`
+
+func scanAuditCheckpoint(row pgx.Row) (AuditCheckpoint, error) {
+	var c AuditCheckpoint
+	err := row.Scan(&c.Seq, &c.SignedCount, &c.HeadHash, &c.KeyID, &c.Statement, &c.Signature, &c.CreatedAt)
+	return c, err
+}
+
+func (s *Store) CreateAuditCheckpoint(ctx context.Context, c AuditCheckpoint) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO audit_checkpoints (seq, signed_count, head_hash, key_id, statement, signature, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+	`, c.Seq, c.SignedCount, c.HeadHash, c.KeyID, c.Statement, c.Signature, c.CreatedAt)
+	return uniqueConflict(err)
+}
+
+// LatestAuditCheckpoint returns the checkpoint with the highest seq, or
+// ErrNotFound.
+func (s *Store) LatestAuditCheckpoint(ctx context.Context) (AuditCheckpoint, error) {
+	c, err := scanAuditCheckpoint(s.db.QueryRow(ctx, `
+		SELECT `+auditCheckpointColumns+` FROM audit_checkpoints ORDER BY seq DESC LIMIT 1
+	`))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return AuditCheckpoint{}, ErrNotFound
+	}
+	return c, err
+}
+
+// ListAuditCheckpoints returns checkpoints in seq order.
+func (s *Store) ListAuditCheckpoints(ctx context.Context) ([]AuditCheckpoint, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+auditCheckpointColumns+` FROM audit_checkpoints ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []AuditCheckpoint
+	for rows.Next() {
+		c, err := scanAuditCheckpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+const accessRequestColumns = `id, user_id, role_id, resource, justification, ticket_id, duration_sec, status,
+	decided_by, decided_at, decision_note, expires_at, revoked_by, revoked_at, last_used_at, created_at`
+
+func scanAccessRequest(row pgx.Row, extra ...any) (AccessRequest, error) {
+	var a AccessRequest
+	var durationSec int
+	dest := []any{&a.ID, &a.UserID, &a.RoleID, &a.Resource, &a.Justification, &a.TicketID, &durationSec, &a.Status,
+		&a.DecidedBy, &a.DecidedAt, &a.DecisionNote, &a.ExpiresAt, &a.RevokedBy, &a.RevokedAt, &a.LastUsedAt, &a.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return AccessRequest{}, err
+	}
+	a.Duration = time.Duration(durationSec) * time.Second
+	return a, nil
+}
+
+func (s *Store) CreateAccessRequest(ctx context.Context, a AccessRequest) (AccessRequest, error) {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return scanAccessRequest(s.db.QueryRow(ctx, `
+		INSERT INTO access_requests (id, user_id, role_id, resource, justification, ticket_id, duration_sec, status, created_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,now())
+		RETURNING `+accessRequestColumns,
+		a.ID, a.UserID, a.RoleID, a.Resource, a.Justification, a.TicketID, int(a.Duration/time.Second), AccessPending))
+}
+
+func (s *Store) GetAccessRequest(ctx context.Context, id uuid.UUID) (AccessRequest, error) {
+	a, err := scanAccessRequest(s.db.QueryRow(ctx, `SELECT `+accessRequestColumns+` FROM access_requests WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return AccessRequest{}, ErrNotFound
+	}
+	return a, err
+}
+
+// AccessRequestFilter narrows ListAccessRequests; zero fields match everything.
+type AccessRequestFilter struct {
+	UserID *uuid.UUID
+	Status string
+	Limit  int
+	Offset int
+}
+
+func (s *Store) ListAccessRequests(ctx context.Context, filter AccessRequestFilter) ([]AccessRequest, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
 	rows, err := s.db.Query(ctx, `
-		SELECT id, username, password_hash, is_admin, mfa_enabled, mfa_secret_enc, mfa_verified_at, created_at
-		FROM users ORDER BY created_at DESC LIMIT $1 OFFSET $2
-	`, limit, offset)
+		SELECT `+accessRequestColumns+` FROM access_requests
+		WHERE ($1::uuid IS NULL OR user_id=$1) AND ($2 = '' OR status=$2)
+		ORDER BY created_at DESC LIMIT $3 OFFSET $4
+	`, filter.UserID, filter.Status, filter.Limit, filter.Offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var users []User
+	var list []AccessRequest
 	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.IsAdmin, &u.MFAEnabled, &u.MFASecretEnc, &u.MFAVerifiedAt, &u.CreatedAt); err != nil {
+		a, err := scanAccessRequest(rows)
+		if err != nil {
 			return nil, err
 		}
-		users = append(users, u)
+		list = append(list, a)
 	}
-	return users, rows.Err()
+	return list, rows.Err()
 }
 
-func (s *Store) ListGroups(ctx context.Context, limit int, offset int) ([]Group, error) {
+// DecideAccessRequest approves or denies a pending request. Approval starts
+// the grant window. ErrConflict is returned when the request is no longer
+// pending and ErrSelfApproval when decidedBy made the request.
+func (s *Store) DecideAccessRequest(ctx context.Context, id uuid.UUID, status string, decidedBy uuid.UUID, note string) (AccessRequest, error) {
+	return s.updateAccessRequest(ctx, id, func(a AccessRequest, now time.Time) (AccessRequest, error) {
+		return DecideAccess(a, status, decidedBy, note, now)
+	})
+}
+
+// CloseAccessRequest cancels a pending request or revokes an active grant.
+func (s *Store) CloseAccessRequest(ctx context.Context, id uuid.UUID, by uuid.UUID) (AccessRequest, error) {
+	return s.updateAccessRequest(ctx, id, func(a AccessRequest, now time.Time) (AccessRequest, error) {
+		return CloseAccess(a, by, now)
+	})
+}
+
+// DecideAccess returns a decided with status by decidedBy at now. Approval
+// opens the grant window for the requested duration.
+func DecideAccess(a AccessRequest, status string, decidedBy uuid.UUID, note string, now time.Time) (AccessRequest, error) {
+	if a.UserID == decidedBy {
+		return AccessRequest{}, ErrSelfApproval
+	}
+	if a.Status != AccessPending {
+		return AccessRequest{}, ErrConflict
+	}
+	switch status {
+	case AccessApproved:
+		expires := now.Add(a.Duration)
+		a.ExpiresAt = &expires
+	case AccessDenied:
+		a.ExpiresAt = nil
+	default:
+		return AccessRequest{}, errors.New("invalid decision")
+	}
+	a.Status = status
+	a.DecidedBy = &decidedBy
+	a.DecidedAt = &now
+	a.DecisionNote = note
+	return a, nil
+}
+
+// CloseAccess returns a cancelled when it is pending or revoked, ending its
+// window at now, when it is an active grant. ErrConflict is returned
+// otherwise.
+func CloseAccess(a AccessRequest, by uuid.UUID, now time.Time) (AccessRequest, error) {
+	switch {
+	case a.Status == AccessPending:
+		a.Status = AccessCancelled
+	case a.Active(now):
+		a.Status = AccessRevoked
+		a.ExpiresAt = &now
+	default:
+		return AccessRequest{}, ErrConflict
+	}
+	a.RevokedBy = &by
+	a.RevokedAt = &now
+	return a, nil
+}
+
+// updateAccessRequest applies change to the locked request and stores the
+// result.
+func (s *Store) updateAccessRequest(ctx context.Context, id uuid.UUID, change func(AccessRequest, time.Time) (AccessRequest, error)) (AccessRequest, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	defer tx.Rollback(ctx)
+	a, err := scanAccessRequest(tx.QueryRow(ctx, `SELECT `+accessRequestColumns+` FROM access_requests WHERE id=$1 FOR UPDATE`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return AccessRequest{}, ErrNotFound
+	}
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	a, err = change(a, time.Now().UTC())
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE access_requests SET status=$2, decided_by=$3, decided_at=$4, decision_note=$5,
+			expires_at=$6, revoked_by=$7, revoked_at=$8
+		WHERE id=$1
+	`, a.ID, a.Status, a.DecidedBy, a.DecidedAt, a.DecisionNote, a.ExpiresAt, a.RevokedBy, a.RevokedAt); err != nil {
+		return AccessRequest{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return AccessRequest{}, err
+	}
+	return a, nil
+}
+
+// ListActiveAccessGrants returns the user's approved requests that have not
+// expired yet. Expiry is checked here rather than by the sweep, so a grant
+// stops counting as soon as its window ends.
+func (s *Store) ListActiveAccessGrants(ctx context.Context, userID uuid.UUID) ([]AccessGrant, error) {
 	rows, err := s.db.Query(ctx, `
-		SELECT id, name, created_at FROM groups ORDER BY created_at DESC LIMIT $1 OFFSET $2
-	`, limit, offset)
+		SELECT a.id, a.user_id, a.role_id, a.resource, a.justification, a.ticket_id, a.duration_sec, a.status,
+			a.decided_by, a.decided_at, a.decision_note, a.expires_at, a.revoked_by, a.revoked_at, a.last_used_at, a.created_at,
+			r.name, r.permissions, r.managed_by, r.created_at
+		FROM access_requests a
+		JOIN roles r ON r.id = a.role_id
+		WHERE a.user_id=$1 AND a.status='approved'
+		ORDER BY a.expires_at
+	`, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var groups []Group
+	var list []AccessGrant
 	for rows.Next() {
-		var g Group
-		if err := rows.Scan(&g.ID, &g.Name, &g.CreatedAt); err != nil {
+		var g AccessGrant
+		var perms []byte
+		g.Request, err = scanAccessRequest(rows, &g.Role.Name, &perms, &g.Role.ManagedBy, &g.Role.CreatedAt)
+		if err != nil {
 			return nil, err
 		}
-		groups = append(groups, g)
+		g.Role.ID = g.Request.RoleID
+		_ = json.Unmarshal(perms, &g.Role.Permissions)
+		list = append(list, g)
 	}
-	return groups, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ActiveAccessGrants(list, time.Now()), nil
 }
 
-func (s *Store) CreateQueryApproval(ctx context.Context, approval QueryApproval) (QueryApproval, error) {
-	if approval.ID == uuid.Nil {
-		approval.ID = uuid.New()
+// ActiveAccessGrants returns the grants still in their window at now.
+func ActiveAccessGrants(grants []AccessGrant, now time.Time) []AccessGrant {
+	var active []AccessGrant
+	for _, g := range grants {
+		if g.Request.Active(now) {
+			active = append(active, g)
+		}
 	}
-	err := s.db.QueryRow(ctx, `
-		INSERT INTO query_approvals (id, connection_id, user_id, statement, status, environment, created_at)
-		VALUES ($1,$2,$3,$4,$5,$6,now())
-		RETURNING created_at
-	`, approval.ID, approval.ConnectionID, approval.UserID, approval.Statement, approval.Status, approval.Environment).Scan(&approval.CreatedAt)
-	return approval, err
+	return active
 }
 
-func (s *Store) GetQueryApproval(ctx context.Context, id uuid.UUID) (QueryApproval, error) {
-	var q QueryApproval
-	err := s.db.QueryRow(ctx, `
-		SELECT id, connection_id, user_id, statement, status, environment, created_at, approved_by, approved_at, denied_by, denied_at
-		FROM query_approvals WHERE id=$1
-	`, id).Scan(&q.ID, &q.ConnectionID, &q.UserID, &q.Statement, &q.Status, &q.Environment, &q.CreatedAt, &q.ApprovedBy, &q.ApprovedAt, &q.DeniedBy, &q.DeniedAt)
+func (s *Store) TouchAccessGrant(ctx context.Context, id uuid.UUID) error {
+	_, err := s.db.Exec(ctx, `UPDATE access_requests SET last_used_at=now() WHERE id=$1`, id)
+	return err
+}
+
+// ExpireAccessGrants marks approved requests past their expiry as expired
+// and returns them.
+func (s *Store) ExpireAccessGrants(ctx context.Context) ([]AccessRequest, error) {
+	rows, err := s.db.Query(ctx, `
+		UPDATE access_requests SET status='expired'
+		WHERE status='approved' AND expires_at <= now()
+		RETURNING `+accessRequestColumns)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []AccessRequest
+	for rows.Next() {
+		a, err := scanAccessRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// MarkExpiringAccessGrants flags active grants that expire within the given
+// window and have not been flagged yet, and returns them.
+func (s *Store) MarkExpiringAccessGrants(ctx context.Context, within time.Duration) ([]AccessRequest, error) {
+	rows, err := s.db.Query(ctx, `
+		UPDATE access_requests SET expiry_notified_at=now()
+		WHERE status='approved' AND expiry_notified_at IS NULL
+			AND expires_at > now() AND expires_at <= now() + $1 * interval '1 second'
+		RETURNING `+accessRequestColumns, within.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []AccessRequest
+	for rows.Next() {
+		a, err := scanAccessRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+const breakGlassColumns = `id, user_id, session_id, reason, started_at, expires_at, ended_at, reviewed_by, reviewed_at, review_note`
+
+func scanBreakGlass(row pgx.Row) (BreakGlass, error) {
+	var b BreakGlass
+	err := row.Scan(&b.ID, &b.UserID, &b.SessionID, &b.Reason, &b.StartedAt, &b.ExpiresAt, &b.EndedAt, &b.ReviewedBy, &b.ReviewedAt, &b.ReviewNote)
+	return b, err
+}
+
+func (s *Store) CreateBreakGlass(ctx context.Context, b BreakGlass) (BreakGlass, error) {
+	if b.ID == uuid.Nil {
+		b.ID = uuid.New()
+	}
+	return scanBreakGlass(s.db.QueryRow(ctx, `
+		INSERT INTO break_glass (id, user_id, session_id, reason, started_at, expires_at)
+		VALUES ($1,$2,$3,$4,now(),$5)
+		RETURNING `+breakGlassColumns, b.ID, b.UserID, b.SessionID, b.Reason, b.ExpiresAt))
+}
+
+func (s *Store) GetBreakGlass(ctx context.Context, id uuid.UUID) (BreakGlass, error) {
+	b, err := scanBreakGlass(s.db.QueryRow(ctx, `SELECT `+breakGlassColumns+` FROM break_glass WHERE id=$1`, id))
 	if errors.Is(err, pgx.ErrNoRows) {
-		return QueryApproval{}, ErrNotFound
+		return BreakGlass{}, ErrNotFound
 	}
-	return q, err
+	return b, err
 }
 
-func (s *Store) ListPendingApprovals(ctx context.Context) ([]QueryApproval, error) {
+// ListBreakGlass lists elevations, newest first. unreviewed limits the list
+// to those still awaiting review.
+func (s *Store) ListBreakGlass(ctx context.Context, userID *uuid.UUID, unreviewed bool, limit int, offset int) ([]BreakGlass, error) {
 	rows, err := s.db.Query(ctx, `
-		SELECT id, connection_id, user_id, statement, status, environment, created_at, approved_by, approved_at, denied_by, denied_at
-		FROM query_approvals WHERE status='pending' ORDER BY created_at DESC
-	`)
+		SELECT `+breakGlassColumns+` FROM break_glass
+		WHERE ($1::uuid IS NULL OR user_id=$1) AND (NOT $2 OR reviewed_at IS NULL)
+		ORDER BY started_at DESC LIMIT $3 OFFSET $4
+	`, userID, unreviewed, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var list []QueryApproval
+	var list []BreakGlass
 	for rows.Next() {
-		var q QueryApproval
-		if err := rows.Scan(&q.ID, &q.ConnectionID, &q.UserID, &q.Statement, &q.Status, &q.Environment, &q.CreatedAt, &q.ApprovedBy, &q.ApprovedAt, &q.DeniedBy, &q.DeniedAt); err != nil {
+		b, err := scanBreakGlass(rows)
+		if err != nil {
 			return nil, err
 		}
-		list = append(list, q)
+		list = append(list, b)
 	}
 	return list, rows.Err()
 }
 
-func (s *Store) ApproveQuery(ctx context.Context, id uuid.UUID, approver uuid.UUID) error {
-	_, err := s.db.Exec(ctx, `
-		UPDATE query_approvals SET status='approved', approved_by=$1, approved_at=now() WHERE id=$2
-	`, approver, id)
-	return err
+// EndBreakGlass ends an active elevation early. ErrConflict is returned when
+// it already ended.
+func (s *Store) EndBreakGlass(ctx context.Context, id uuid.UUID) (BreakGlass, error) {
+	b, err := scanBreakGlass(s.db.QueryRow(ctx, `
+		UPDATE break_glass SET ended_at=now()
+		WHERE id=$1 AND ended_at IS NULL AND expires_at > now()
+		RETURNING `+breakGlassColumns, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := s.GetBreakGlass(ctx, id); err != nil {
+			return BreakGlass{}, err
+		}
+		return BreakGlass{}, ErrConflict
+	}
+	return b, err
 }
 
-func (s *Store) DenyQuery(ctx context.Context, id uuid.UUID, approver uuid.UUID) error {
-	_, err := s.db.Exec(ctx, `
-		UPDATE query_approvals SET status='denied', denied_by=$1, denied_at=now() WHERE id=$2
-	`, approver, id)
-	return err
+// ReviewBreakGlass records the post-incident acknowledgement. ErrConflict is
+// returned when it was already reviewed.
+func (s *Store) ReviewBreakGlass(ctx context.Context, id uuid.UUID, reviewer uuid.UUID, note string) (BreakGlass, error) {
+	b, err := scanBreakGlass(s.db.QueryRow(ctx, `
+		UPDATE break_glass SET reviewed_by=$2, reviewed_at=now(), review_note=$3
+		WHERE id=$1 AND reviewed_at IS NULL
+		RETURNING `+breakGlassColumns, id, reviewer, note))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := s.GetBreakGlass(ctx, id); err != nil {
+			return BreakGlass{}, err
+		}
+		return BreakGlass{}, ErrConflict
+	}
+	return b, err
 }
 
-func (s *Store) ListPIIRules(ctx context.Context, connectionID uuid.UUID) ([]PIIRule, error) {
+const webhookColumns = `id, name, url, secret_enc, events, enabled, created_by, created_at, updated_at`
+
+func scanWebhook(row pgx.Row) (Webhook, error) {
+	var h Webhook
+	err := row.Scan(&h.ID, &h.Name, &h.URL, &h.SecretEnc, &h.Events, &h.Enabled, &h.CreatedBy, &h.CreatedAt, &h.UpdatedAt)
+	return h, err
+}
+
+// ListWebhooks returns all webhooks, or only enabled ones.
+func (s *Store) ListWebhooks(ctx context.Context, enabledOnly bool) ([]Webhook, error) {
 	rows, err := s.db.Query(ctx, `
-		SELECT id, connection_id, resource, field, mask_type, created_at
-		FROM pii_rules WHERE connection_id=$1
-	`, connectionID)
+		SELECT `+webhookColumns+` FROM webhooks
+		WHERE NOT $1 OR enabled
+		ORDER BY name
+	`, enabledOnly)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var rules []PIIRule
+	var list []Webhook
 	for rows.Next() {
-		var r PIIRule
-		if err := rows.Scan(&r.ID, &r.ConnectionID, &r.Resource, &r.Field, &r.MaskType, &r.CreatedAt); err != nil {
+		h, err := scanWebhook(rows)
+		if err != nil {
 			return nil, err
 		}
-		rules = append(rules, r)
+		list = append(list, h)
 	}
-	return rules, rows.Err()
+	return list, rows.Err()
+}
+
+func (s *Store) GetWebhook(ctx context.Context, id uuid.UUID) (Webhook, error) {
+	h, err := scanWebhook(s.db.QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Webhook{}, ErrNotFound
+	}
+	return h, err
+}
+
+func (s *Store) CreateWebhook(ctx context.Context, h Webhook) (Webhook, error) {
+	if h.ID == uuid.Nil {
+		h.ID = uuid.New()
+	}
+	if h.Events == nil {
+		h.Events = []string{}
+	}
+	return scanWebhook(s.db.QueryRow(ctx, `
+		INSERT INTO webhooks (id, name, url, secret_enc, events, enabled, created_by, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,now(),now())
+		RETURNING `+webhookColumns, h.ID, h.Name, h.URL, h.SecretEnc, h.Events, h.Enabled, h.CreatedBy))
+}
+
+func (s *Store) UpdateWebhook(ctx context.Context, h Webhook) (Webhook, error) {
+	if h.Events == nil {
+		h.Events = []string{}
+	}
+	updated, err := scanWebhook(s.db.QueryRow(ctx, `
+		UPDATE webhooks SET name=$2, url=$3, secret_enc=$4, events=$5, enabled=$6, updated_at=now()
+		WHERE id=$1
+		RETURNING `+webhookColumns, h.ID, h.Name, h.URL, h.SecretEnc, h.Events, h.Enabled))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Webhook{}, ErrNotFound
+	}
+	return updated, err
+}
+
+// DeleteWebhook removes a webhook together with its delivery log.
+func (s *Store) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM webhooks WHERE id=$1`, id)
+	return err
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event_type, payload, status, attempts, next_attempt_at, last_attempt_at, response_status, last_error, delivered_at, created_at`
+
+func scanWebhookDelivery(row pgx.Row) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastAttemptAt, &d.ResponseStatus, &d.LastError, &d.DeliveredAt, &d.CreatedAt)
+	return d, err
+}
+
+func collectWebhookDeliveries(rows pgx.Rows) ([]WebhookDelivery, error) {
+	defer rows.Close()
+	var list []WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// EnqueueWebhookDeliveries adds deliveries to the outbox in one transaction.
+func (s *Store) EnqueueWebhookDeliveries(ctx context.Context, deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for _, d := range deliveries {
+		if d.ID == uuid.Nil {
+			d.ID = uuid.New()
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO webhook_deliveries (id, webhook_id, event_type, payload, status, next_attempt_at, created_at)
+			VALUES ($1,$2,$3,$4,'pending',now(),now())
+		`, d.ID, d.WebhookID, d.EventType, d.Payload); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
 }
 
-func (s *Store) ListAudit(ctx context.Context, limit int, offset int) ([]AuditEntry, error) {
+// ClaimWebhookDeliveries returns up to limit due deliveries and pushes their
+// next attempt out by lease so concurrent workers skip them meanwhile.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
 	rows, err := s.db.Query(ctx, `
-		SELECT id, event_type, actor_user_id, details, created_at, prev_hash, hash, error_id
-		FROM audit_log ORDER BY created_at DESC LIMIT $1 OFFSET $2
-	`, limit, offset)
+		UPDATE webhook_deliveries SET next_attempt_at = now() + $2 * interval '1 second'
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status='pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+webhookDeliveryColumns, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return collectWebhookDeliveries(rows)
+}
+
+// RecordWebhookAttempt stores the outcome of one attempt. A nil retryAt with
+// delivered false marks the delivery as failed for good.
+func (s *Store) RecordWebhookAttempt(ctx context.Context, id uuid.UUID, responseStatus *int, lastError string, delivered bool, retryAt *time.Time) error {
+	status := DeliveryFailed
+	switch {
+	case delivered:
+		status = DeliveryDelivered
+	case retryAt != nil:
+		status = DeliveryPending
+	}
+	_, err := s.db.Exec(ctx, `
+		UPDATE webhook_deliveries SET
+			status=$2, attempts=attempts+1, last_attempt_at=now(), response_status=$3, last_error=$4,
+			next_attempt_at=COALESCE($5, next_attempt_at),
+			delivered_at=CASE WHEN $6 THEN now() ELSE delivered_at END
+		WHERE id=$1
+	`, id, status, responseStatus, lastError, retryAt, delivered)
+	return err
+}
+
+type WebhookDeliveryFilter struct {
+	WebhookID *uuid.UUID
+	Status    string
+	EventType string
+	Limit     int
+	Offset    int
+}
+
+func (s *Store) ListWebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]WebhookDelivery, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE ($1::uuid IS NULL OR webhook_id=$1) AND ($2='' OR status=$2) AND ($3='' OR event_type=$3)
+		ORDER BY created_at DESC LIMIT $4 OFFSET $5
+	`, filter.WebhookID, filter.Status, filter.EventType, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return collectWebhookDeliveries(rows)
+}
+
+func (s *Store) GetWebhookDelivery(ctx context.Context, id uuid.UUID) (WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(s.db.QueryRow(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id=$1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return WebhookDelivery{}, ErrNotFound
+	}
+	return d, err
+}
+
+// RedeliverWebhookDelivery puts a delivery back in the outbox with a fresh
+// retry budget. ErrConflict is returned while it is still pending.
+func (s *Store) RedeliverWebhookDelivery(ctx context.Context, id uuid.UUID) (WebhookDelivery, error) {
+	d, err := scanWebhookDelivery(s.db.QueryRow(ctx, `
+		UPDATE webhook_deliveries SET status='pending', attempts=0, next_attempt_at=now(), delivered_at=NULL
+		WHERE id=$1 AND status <> 'pending'
+		RETURNING `+webhookDeliveryColumns, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := s.GetWebhookDelivery(ctx, id); err != nil {
+			return WebhookDelivery{}, err
+		}
+		return WebhookDelivery{}, ErrConflict
+	}
+	return d, err
+}
+
+// NotificationRecipient is a user resolved for an email notification. Email
+// is the "email" user attribute, falling back to the latest SSO identity.
+type NotificationRecipient struct {
+	UserID   uuid.UUID
+	Username string
+	Email    string
+	OptOut   []string
+}
+
+// ListNotificationRecipients returns the users in userIDs together with the
+// members of the named roles and groups.
+func (s *Store) ListNotificationRecipients(ctx context.Context, userIDs []uuid.UUID, roles []string, groups []string) ([]NotificationRecipient, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT u.id, u.username,
+			COALESCE(NULLIF(u.attributes->>'email', ''), (
+				SELECT ei.email FROM external_identities ei
+				WHERE ei.user_id=u.id AND COALESCE(ei.email, '') <> ''
+				ORDER BY ei.created_at DESC LIMIT 1
+			), ''),
+			u.notification_opt_out
+		FROM users u
+		WHERE u.id = ANY($1)
+			OR EXISTS (
+				SELECT 1 FROM role_bindings rb
+				JOIN roles r ON r.id = rb.role_id
+				LEFT JOIN group_members gm ON gm.group_id = rb.group_id
+				WHERE r.name = ANY($2) AND (rb.user_id=u.id OR gm.user_id=u.id)
+			)
+			OR EXISTS (
+				SELECT 1 FROM group_members gm
+				JOIN groups g ON g.id = gm.group_id
+				WHERE gm.user_id=u.id AND g.name = ANY($3)
+			)
+		ORDER BY u.username
+	`, userIDs, roles, groups)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var list []AuditEntry
+	var list []NotificationRecipient
 	for rows.Next() {
-		var entry AuditEntry
-		if err := rows.Scan(&entry.ID, &entry.EventType, &entry.ActorID, &entry.Details, &entry.CreatedAt, &entry.PrevHash, &entry.Hash, &entry.ErrorID); err != nil {
+		var n NotificationRecipient
+		if err := rows.Scan(&n.UserID, &n.Username, &n.Email, &n.OptOut); err != nil {
 			return nil, err
 		}
-		list = append(list, entry)
+		list = append(list, n)
 	}
 	return list, rows.Err()
 }
 
+func (s *Store) GetNotificationOptOut(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	var optOut []string
+	err := s.db.QueryRow(ctx, `SELECT notification_opt_out FROM users WHERE id=$1`, userID).Scan(&optOut)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return optOut, err
+}
+
+func (s *Store) SetNotificationOptOut(ctx context.Context, userID uuid.UUID, optOut []string) error {
+	if optOut == nil {
+		optOut = []string{}
+	}
+	tag, err := s.db.Exec(ctx, `UPDATE users SET notification_opt_out=$2 WHERE id=$1`, userID, optOut)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TouchUserDevice records a login from a device. It reports true when the
+// device is new for a user who had signed in from another device before.
+// legacyFingerprint, when set, names a row recorded before device cookies;
+// it is replaced by fingerprint and does not count as a new device.
+func (s *Store) TouchUserDevice(ctx context.Context, userID uuid.UUID, fingerprint string, legacyFingerprint string, userAgent string, ip string) (bool, error) {
+	var inserted, legacy, known bool
+	err := s.db.QueryRow(ctx, `
+		WITH legacy AS (
+			DELETE FROM user_devices WHERE user_id=$1 AND fingerprint=$5 AND $5 <> ''
+			RETURNING 1
+		), inserted AS (
+			INSERT INTO user_devices (user_id, fingerprint, user_agent, ip, first_seen_at, last_seen_at)
+			VALUES ($1,$2,$3,$4,now(),now())
+			ON CONFLICT (user_id, fingerprint) DO NOTHING
+			RETURNING 1
+		)
+		SELECT EXISTS (SELECT 1 FROM inserted), EXISTS (SELECT 1 FROM legacy),
+			EXISTS (SELECT 1 FROM user_devices WHERE user_id=$1)
+	`, userID, fingerprint, userAgent, ip, legacyFingerprint).Scan(&inserted, &legacy, &known)
+	if err != nil {
+		return false, err
+	}
+	if !inserted {
+		if _, err := s.db.Exec(ctx, `
+			UPDATE user_devices SET user_agent=$3, ip=$4, last_seen_at=now() WHERE user_id=$1 AND fingerprint=$2
+		`, userID, fingerprint, userAgent, ip); err != nil {
+			return false, err
+		}
+	}
+	return inserted && !legacy && known, nil
+}
+
 var ErrNotFound = errors.New("not found")
+
+var ErrConflict = errors.New("conflict")
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDecideApprovalVote(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Minute)
+	requester, alice, bob := uuid.New(), uuid.New(), uuid.New()
+	pending := QueryApproval{UserID: requester, Status: "pending", RequiredApprovals: 2, ExpiresAt: &later}
+	approvedBy := func(users ...uuid.UUID) []ApprovalVote {
+		var votes []ApprovalVote
+		for _, u := range users {
+			votes = append(votes, ApprovalVote{UserID: u, Decision: "approved"})
+		}
+		return votes
+	}
+	withStatus := func(status string) QueryApproval {
+		q := pending
+		q.Status = status
+		return q
+	}
+	expired := pending
+	expired.ExpiresAt = &earlier
+	single := pending
+	single.RequiredApprovals = 1
+
+	cases := []struct {
+		name     string
+		approval QueryApproval
+		votes    []ApprovalVote
+		voter    uuid.UUID
+		decision string
+		want     string
+		err      error
+	}{
+		{"first of two approvals", pending, nil, alice, "approved", "pending", nil},
+		{"quorum reached", pending, approvedBy(alice), bob, "approved", "approved", nil},
+		{"single approver", single, nil, alice, "approved", "approved", nil},
+		{"deny wins", pending, approvedBy(alice), bob, "denied", "denied", nil},
+		{"self approval", pending, nil, requester, "approved", "", ErrSelfApproval},
+		{"duplicate vote", pending, approvedBy(alice), alice, "approved", "", ErrConflict},
+		{"duplicate deny", pending, approvedBy(alice), alice, "denied", "", ErrConflict},
+		{"expired", expired, nil, alice, "approved", "", ErrExpired},
+		{"already approved", withStatus("approved"), nil, alice, "approved", "", ErrConflict},
+		{"already consumed", withStatus("consumed"), nil, alice, "denied", "", ErrConflict},
+	}
+	for _, tc := range cases {
+		got, err := DecideApprovalVote(tc.approval, tc.votes, tc.voter, tc.decision, now)
+		if !errors.Is(err, tc.err) || got != tc.want {
+			t.Errorf("%s: got %q, %v; want %q, %v", tc.name, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestCheckApprovalUsable(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Minute)
+	user, conn := uuid.New(), uuid.New()
+	approved := QueryApproval{UserID: user, ConnectionID: conn, StatementHash: "h", Status: "approved", ExpiresAt: &later}
+	if err := CheckApprovalUsable(approved, user, conn, "h", now); err != nil {
+		t.Fatalf("expected approval to be usable, got %v", err)
+	}
+	consumed := approved
+	consumed.Status = "consumed"
+	if err := CheckApprovalUsable(consumed, user, conn, "h", now); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a consumed approval to be refused, got %v", err)
+	}
+	expired := approved
+	expired.ExpiresAt = &earlier
+	cases := map[string]error{
+		"other user":      CheckApprovalUsable(approved, uuid.New(), conn, "h", now),
+		"other conn":      CheckApprovalUsable(approved, user, uuid.New(), "h", now),
+		"other statement": CheckApprovalUsable(approved, user, conn, "x", now),
+		"expired":         CheckApprovalUsable(expired, user, conn, "h", now),
+		"pending":         CheckApprovalUsable(QueryApproval{UserID: user, ConnectionID: conn, StatementHash: "h", Status: "pending", ExpiresAt: &later}, user, conn, "h", now),
+	}
+	for name, err := range cases {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", name, err)
+		}
+	}
+}
+
+func TestSettledPrefixStopsAtFirstUnsettledEntry(t *testing.T) {
+	cutoff := time.Now()
+	entries := []AuditEntry{
+		{Seq: 1, CreatedAt: cutoff.Add(-3 * time.Second)},
+		{Seq: 2, CreatedAt: cutoff.Add(time.Millisecond)},
+		{Seq: 3, CreatedAt: cutoff.Add(-2 * time.Second)},
+	}
+	got := settledPrefix(entries, cutoff)
+	if len(got) != 1 || got[0].Seq != 1 {
+		t.Fatalf("expected only seq 1 before the unsettled seq 2, got %+v", got)
+	}
+	entries[1].CreatedAt = cutoff.Add(-time.Second)
+	if got := settledPrefix(entries, cutoff); len(got) != 3 {
+		t.Fatalf("expected all entries once settled, got %+v", got)
+	}
+}
+
+func TestActiveAccessGrants(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Second), now.Add(time.Hour)
+	grant := func(status string, expires *time.Time) AccessGrant {
+		return AccessGrant{Request: AccessRequest{ID: uuid.New(), Status: status, ExpiresAt: expires}}
+	}
+	active := grant(AccessApproved, &future)
+	grants := []AccessGrant{
+		grant(AccessApproved, &past),
+		active,
+		grant(AccessApproved, nil),
+		grant(AccessRevoked, &future),
+		grant(AccessExpired, &future),
+	}
+	got := ActiveAccessGrants(grants, now)
+	if len(got) != 1 || got[0].Request.ID != active.Request.ID {
+		t.Fatalf("expected only the unexpired approved grant, got %+v", got)
+	}
+	if got := ActiveAccessGrants([]AccessGrant{active}, future); len(got) != 0 {
+		t.Fatalf("expected the grant to end at its expiry, got %+v", got)
+	}
+}
+
+func TestDecideAccess(t *testing.T) {
+	now := time.Now()
+	requester, approver := uuid.New(), uuid.New()
+	pending := AccessRequest{ID: uuid.New(), UserID: requester, Status: AccessPending, Duration: 30 * time.Minute}
+	withStatus := func(status string) AccessRequest {
+		a := pending
+		a.Status = status
+		return a
+	}
+	cases := []struct {
+		name     string
+		request  AccessRequest
+		status   string
+		decider  uuid.UUID
+		wantErr  error
+		expires  bool
+		decision string
+	}{
+		{"approve", pending, AccessApproved, approver, nil, true, AccessApproved},
+		{"deny", pending, AccessDenied, approver, nil, false, AccessDenied},
+		{"requester approves own request", pending, AccessApproved, requester, ErrSelfApproval, false, ""},
+		{"requester denies own request", pending, AccessDenied, requester, ErrSelfApproval, false, ""},
+		{"already approved", withStatus(AccessApproved), AccessApproved, approver, ErrConflict, false, ""},
+		{"cancelled", withStatus(AccessCancelled), AccessApproved, approver, ErrConflict, false, ""},
+	}
+	for _, tc := range cases {
+		got, err := DecideAccess(tc.request, tc.status, tc.decider, "ok", now)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr != nil {
+			continue
+		}
+		if got.Status != tc.decision || got.DecidedBy == nil || *got.DecidedBy != tc.decider || got.DecisionNote != "ok" {
+			t.Errorf("%s: unexpected decision %+v", tc.name, got)
+		}
+		if tc.expires && (got.ExpiresAt == nil || !got.ExpiresAt.Equal(now.Add(30*time.Minute))) {
+			t.Errorf("%s: expected the window to start at the decision, got %v", tc.name, got.ExpiresAt)
+		}
+		if !tc.expires && got.ExpiresAt != nil {
+			t.Errorf("%s: unexpected expiry %v", tc.name, got.ExpiresAt)
+		}
+	}
+	if _, err := DecideAccess(pending, AccessRevoked, approver, "", now); err == nil {
+		t.Fatal("expected an invalid decision to be rejected")
+	}
+}
+
+func TestCloseAccess(t *testing.T) {
+	now := time.Now()
+	by := uuid.New()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	cases := []struct {
+		name    string
+		request AccessRequest
+		want    string
+		wantErr error
+	}{
+		{"cancel pending", AccessRequest{Status: AccessPending}, AccessCancelled, nil},
+		{"revoke active grant", AccessRequest{Status: AccessApproved, ExpiresAt: &future}, AccessRevoked, nil},
+		{"grant past expiry", AccessRequest{Status: AccessApproved, ExpiresAt: &past}, "", ErrConflict},
+		{"denied", AccessRequest{Status: AccessDenied}, "", ErrConflict},
+		{"already revoked", AccessRequest{Status: AccessRevoked, ExpiresAt: &now}, "", ErrConflict},
+	}
+	for _, tc := range cases {
+		got, err := CloseAccess(tc.request, by, now)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr != nil {
+			continue
+		}
+		if got.Status != tc.want || got.RevokedBy == nil || *got.RevokedBy != by || got.RevokedAt == nil {
+			t.Errorf("%s: unexpected result %+v", tc.name, got)
+		}
+		if tc.want == AccessRevoked && (got.ExpiresAt == nil || !got.ExpiresAt.Equal(now) || got.Active(now)) {
+			t.Errorf("%s: expected the window to end now, got %v", tc.name, got.ExpiresAt)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"flowdb/backend/crypto"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SignatureHeader = "X-FlowDB-Signature"
+	TimestampHeader = "X-FlowDB-Timestamp"
+	EventHeader     = "X-FlowDB-Event"
+	DeliveryHeader  = "X-FlowDB-Delivery"
+
+	batchSize  = 50
+	maxBackoff = time.Hour
+)
+
+// Dispatcher turns audit events into webhook deliveries and sends them from
+// the outbox. Publish only writes to the outbox, so a slow or unreachable
+// receiver never delays the request that produced the event.
+type Dispatcher struct {
+	store       *store.Store
+	cipher      *crypto.AESCipher
+	client      *http.Client
+	interval    time.Duration
+	maxAttempts int
+	logger      *slog.Logger
+
+	mu       sync.Mutex
+	hooks    []store.Webhook
+	loadedAt time.Time
+}
+
+func NewDispatcher(st *store.Store, cipher *crypto.AESCipher, interval time.Duration, timeout time.Duration, maxAttempts int, allowPrivate bool, logger *slog.Logger) *Dispatcher {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 8
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Dispatcher{
+		store:       st,
+		cipher:      cipher,
+		client:      NewClient(timeout, allowPrivate),
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		logger:      logger,
+	}
+}
+
+// Publish queues the event for every enabled webhook subscribed to it.
+func (d *Dispatcher) Publish(ctx context.Context, eventType string, userID *uuid.UUID, details map[string]any) {
+	hooks, err := d.subscriptions(ctx)
+	if err != nil {
+		d.logger.Error("webhook lookup failed", "event", eventType, "error", err)
+		return
+	}
+	var deliveries []store.WebhookDelivery
+	now := time.Now().UTC()
+	for _, hook := range hooks {
+		if !Matches(hook.Events, eventType) {
+			continue
+		}
+		id := uuid.New()
+		payload, err := json.Marshal(map[string]any{
+			"id":          id.String(),
+			"event":       eventType,
+			"actorUserId": userID,
+			"details":     details,
+			"time":        now,
+		})
+		if err != nil {
+			d.logger.Error("webhook payload failed", "event", eventType, "error", err)
+			return
+		}
+		deliveries = append(deliveries, store.WebhookDelivery{ID: id, WebhookID: hook.ID, EventType: eventType, Payload: payload})
+	}
+	if err := d.store.EnqueueWebhookDeliveries(ctx, deliveries); err != nil {
+		d.logger.Error("webhook enqueue failed", "event", eventType, "error", err)
+	}
+}
+
+// subscriptions returns the enabled webhooks, reloading them when
+// Invalidate was called or after one poll interval, so changes made on other
+// instances are picked up too.
+func (d *Dispatcher) subscriptions(ctx context.Context) ([]store.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.loadedAt.IsZero() && time.Since(d.loadedAt) < d.interval {
+		return d.hooks, nil
+	}
+	hooks, err := d.store.ListWebhooks(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	d.hooks = hooks
+	d.loadedAt = time.Now()
+	return hooks, nil
+}
+
+// Invalidate drops the cached subscriptions after a webhook was created,
+// changed or deleted.
+func (d *Dispatcher) Invalidate() {
+	d.mu.Lock()
+	d.loadedAt = time.Time{}
+	d.mu.Unlock()
+}
+
+func (d *Dispatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.Sweep(ctx); err != nil {
+					d.logger.Error("webhook sweep failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Sweep sends the deliveries that are due.
+func (d *Dispatcher) Sweep(ctx context.Context) error {
+	due, err := d.store.ClaimWebhookDeliveries(ctx, batchSize, d.client.Timeout+d.interval)
+	if err != nil {
+		return err
+	}
+	hooks := map[uuid.UUID]store.Webhook{}
+	for _, delivery := range due {
+		hook, ok := hooks[delivery.WebhookID]
+		if !ok {
+			hook, err = d.store.GetWebhook(ctx, delivery.WebhookID)
+			if err != nil {
+				d.record(ctx, delivery, nil, err)
+				continue
+			}
+			hooks[hook.ID] = hook
+		}
+		status, err := d.send(ctx, hook, delivery)
+		d.record(ctx, delivery, status, err)
+	}
+	return nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, hook store.Webhook, delivery store.WebhookDelivery) (*int, error) {
+	secret, err := d.cipher.Decrypt(hook.SecretEnc)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secret: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	status := resp.StatusCode
+	if status < 200 || status >= 300 {
+		return &status, fmt.Errorf("unexpected status %d", status)
+	}
+	return &status, nil
+}
+
+func (d *Dispatcher) record(ctx context.Context, delivery store.WebhookDelivery, status *int, sendErr error) {
+	lastError := ""
+	if sendErr != nil {
+		lastError = sendErr.Error()
+	}
+	retryAt := d.retryAt(delivery, sendErr, time.Now().UTC())
+	if err := d.store.RecordWebhookAttempt(ctx, delivery.ID, status, lastError, sendErr == nil, retryAt); err != nil {
+		d.logger.Error("webhook attempt not recorded", "delivery", delivery.ID, "error", err)
+	}
+}
+
+// retryAt returns when a failed delivery is tried again, or nil when it
+// succeeded or has used up its attempts.
+func (d *Dispatcher) retryAt(delivery store.WebhookDelivery, sendErr error, now time.Time) *time.Time {
+	attempt := delivery.Attempts + 1
+	if sendErr == nil || attempt >= d.maxAttempts {
+		return nil
+	}
+	next := now.Add(Backoff(attempt))
+	return &next
+}
+
+// Sign returns the signature header value: "sha256=" followed by the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook secret.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the wait before retrying after the given failed attempt:
+// 30s doubling each time, capped at an hour.
+func Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	wait := 30 * time.Second
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return wait
+}
+
+// Matches reports whether a subscription covers eventType. No patterns or
+// "*" match everything; a trailing "*" matches by prefix, e.g. "query_approval_*".
+func Matches(patterns []string, eventType string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
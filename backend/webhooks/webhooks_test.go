@@ -0,0 +1,176 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"flowdb/backend/crypto"
+	"flowdb/backend/store"
+
+	"github.com/google/uuid"
+)
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		event    string
+		want     bool
+	}{
+		{nil, "login_failed", true},
+		{[]string{"*"}, "login_failed", true},
+		{[]string{"login_failed"}, "login_failed", true},
+		{[]string{"login_failed"}, "login_success", false},
+		{[]string{"query_approval_*"}, "query_approval_requested", true},
+		{[]string{"query_approval_*"}, "query_run", false},
+		{[]string{"connection_create", "break_glass_*"}, "break_glass_started", true},
+	}
+	for _, tc := range cases {
+		if got := Matches(tc.patterns, tc.event); got != tc.want {
+			t.Errorf("Matches(%v, %q) = %v, want %v", tc.patterns, tc.event, got, tc.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		0:  30 * time.Second,
+		1:  30 * time.Second,
+		2:  time.Minute,
+		4:  4 * time.Minute,
+		20: time.Hour,
+	}
+	for attempt, want := range cases {
+		if got := Backoff(attempt); got != want {
+			t.Errorf("Backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	got := Sign([]byte("secret"), "1700000000", []byte(`{"event":"login_failed"}`))
+	if got != Sign([]byte("secret"), "1700000000", []byte(`{"event":"login_failed"}`)) {
+		t.Fatal("signature is not deterministic")
+	}
+	if got == Sign([]byte("other"), "1700000000", []byte(`{"event":"login_failed"}`)) {
+		t.Fatal("signature does not depend on the secret")
+	}
+	if got == Sign([]byte("secret"), "1700000001", []byte(`{"event":"login_failed"}`)) {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+	if len(got) != len("sha256=")+64 {
+		t.Fatalf("unexpected signature %q", got)
+	}
+}
+
+func TestDispatcherSend(t *testing.T) {
+	cipher, err := crypto.NewAESCipher(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("0123456789abcdef")
+	enc, err := cipher.Encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		want := Sign(secret, r.Header.Get(TimestampHeader), body)
+		if r.Header.Get(SignatureHeader) != want || r.Header.Get(EventHeader) != "login_failed" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if n == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(nil, cipher, time.Second, time.Second, 3, true, nil)
+	hook := store.Webhook{ID: uuid.New(), URL: srv.URL, SecretEnc: enc}
+	delivery := store.WebhookDelivery{ID: uuid.New(), WebhookID: hook.ID, EventType: "login_failed", Payload: []byte(`{"event":"login_failed"}`)}
+	now := time.Now()
+
+	status, err := d.send(context.Background(), hook, delivery)
+	if err == nil || status == nil || *status != http.StatusBadGateway {
+		t.Fatalf("expected a failed first attempt, got %v, %v", status, err)
+	}
+	if next := d.retryAt(delivery, err, now); next == nil || !next.Equal(now.Add(Backoff(1))) {
+		t.Fatalf("expected a retry after %s, got %v", Backoff(1), next)
+	}
+
+	delivery.Attempts = 1
+	status, err = d.send(context.Background(), hook, delivery)
+	if err != nil || *status != http.StatusNoContent {
+		t.Fatalf("expected delivery, got %v, %v", status, err)
+	}
+	if next := d.retryAt(delivery, err, now); next != nil {
+		t.Fatalf("expected no retry after delivery, got %v", next)
+	}
+
+	delivery.Attempts = 2
+	if next := d.retryAt(delivery, errors.New("down"), now); next != nil {
+		t.Fatalf("expected no retry after the last attempt, got %v", next)
+	}
+}
+
+func TestDispatcherRefusesPrivateAndRedirects(t *testing.T) {
+	cipher, err := crypto.NewAESCipher(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, _ := cipher.Encrypt([]byte("0123456789abcdef"))
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusFound))
+	defer redirect.Close()
+	delivery := store.WebhookDelivery{ID: uuid.New(), EventType: "login_failed", Payload: []byte(`{}`)}
+
+	strict := NewDispatcher(nil, cipher, time.Second, time.Second, 3, false, nil)
+	_, err = strict.send(context.Background(), store.Webhook{URL: target.URL, SecretEnc: enc}, delivery)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("expected loopback to be refused, got %v", err)
+	}
+
+	open := NewDispatcher(nil, cipher, time.Second, time.Second, 3, true, nil)
+	status, err := open.send(context.Background(), store.Webhook{URL: redirect.URL, SecretEnc: enc}, delivery)
+	if err == nil || status == nil || *status != http.StatusFound {
+		t.Fatalf("expected the redirect not to be followed, got %v, %v", status, err)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	cases := map[string]bool{
+		"https://hooks.example.com/x":   true,
+		"https://8.8.8.8/x":             true,
+		"ftp://hooks.example.com":       false,
+		"https://user:pw@example.com/x": false,
+		"http://127.0.0.1:8080/":        false,
+		"http://localhost/":             false,
+		"http://169.254.169.254/latest": false,
+		"http://10.1.2.3/":              false,
+		"http://[::1]/":                 false,
+		"http://[::ffff:192.168.1.1]/":  false,
+		"http://100.64.0.1/":            false,
+	}
+	for raw, ok := range cases {
+		if err := CheckURL(context.Background(), raw, false); (err == nil) != ok {
+			t.Errorf("CheckURL(%q) = %v, want ok=%v", raw, err, ok)
+		}
+	}
+	if err := CheckURL(context.Background(), "http://127.0.0.1/", true); err != nil {
+		t.Errorf("expected private address to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"os"
+
+	"flowdb/backend/audit"
+	"flowdb/backend/policies"
+)
+
+const usage = `usage:
+  flowdb                      start the server
+  flowdb policy lint [dir]    validate a policy directory (default $POLICY_DIR)
+  flowdb audit verify [-public-key file] [-checkpoints dir] <file>...
+                              verify the hash chain across audit exports and archives (NDJSON or
+                              CSV, gzipped or not, - for stdin); with -public-key archive manifests
+                              are checked, and with -checkpoints the signed checkpoints in dir
+`
+
+// runCommand handles command line subcommands and returns the exit code.
+func runCommand(args []string) int {
+	switch {
+	case len(args) >= 2 && args[0] == "policy" && args[1] == "lint":
+		dir := os.Getenv("POLICY_DIR")
+		if len(args) > 2 {
+			dir = args[2]
+		}
+		return policyLint(dir)
+	case len(args) >= 3 && args[0] == "audit" && args[1] == "verify":
+		return auditVerify(args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+}
+
+func policyLint(dir string) int {
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "policy directory is required")
+		return 2
+	}
+	bundle, issues, err := policies.LoadDir(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, issue := range issues {
+		fmt.Println(issue.String())
+	}
+	if policies.HasFileErrors(issues) {
+		return 1
+	}
+	fmt.Printf("ok: %d policies, %d roles, %d bindings\n", len(bundle.Policies), len(bundle.Roles), len(bundle.Bindings))
+	return 0
+}
+
+func auditVerify(args []string) int {
+	fs := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	publicKey := fs.String("public-key", "", "Ed25519 public key (PEM) checkpoints and archives are signed with")
+	checkpointDir := fs.String("checkpoints", "", "directory of checkpoint-*.json and .sig files")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	if *checkpointDir != "" && *publicKey == "" {
+		fmt.Fprintln(os.Stderr, "-checkpoints requires -public-key")
+		return 2
+	}
+	var pub ed25519.PublicKey
+	var checkpoints []audit.Checkpoint
+	if *publicKey != "" {
+		var err error
+		if pub, err = audit.LoadPublicKey(*publicKey); err == nil && *checkpointDir != "" {
+			checkpoints, err = audit.ReadCheckpointDir(*checkpointDir, pub)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	result, err := audit.VerifyFiles(fs.Args(), pub, checkpoints)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !result.OK {
+		b := result.Broken
+		fmt.Printf("broken at seq %d (id %s, %s): %s\n", b.Seq, b.ID, b.EventType, b.Reason)
+		return 1
+	}
+	fmt.Printf("ok: %d entries, %d signed, last hash %s\n", result.Entries, result.Signed, result.LastHash)
+	if len(result.LegacyRestarts) > 0 {
+		fmt.Printf("legacy: chain restarted at seq %v before strict verification from seq %d\n", result.LegacyRestarts, result.StrictFromSeq)
+	}
+	if len(checkpoints) > 0 {
+		fmt.Printf("anchored: %d checkpoints, up to seq %d\n", result.Checkpoints, result.AnchoredSeq)
+	}
+	return 0
+}
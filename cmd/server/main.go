@@ -17,16 +17,20 @@ import (
 	"flowdb/backend/config"
 	"flowdb/backend/connections"
 	"flowdb/backend/crypto"
+	"flowdb/backend/discovery"
 	"flowdb/backend/http/handlers"
 	"flowdb/backend/http/routes"
 	"flowdb/backend/iam"
+	"flowdb/backend/notify"
 	"flowdb/backend/policies"
 	"flowdb/backend/query"
 	"flowdb/backend/settings"
+	"flowdb/backend/siem"
 	"flowdb/backend/store"
 	"flowdb/backend/stream"
 	"flowdb/backend/update"
 	"flowdb/backend/util"
+	"flowdb/backend/webhooks"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -36,6 +40,9 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	cfg, err := config.Load()
 	if err != nil {
@@ -81,7 +88,7 @@ func main() {
 		logger.Error("settings error", "error", err)
 		os.Exit(1)
 	}
-	policyStore := policies.NewStore(st, cfg.SettingsRefresh)
+	policyStore := policies.NewStore(st, cfg.SettingsRefresh, logger)
 	if err := policyStore.Start(ctx); err != nil {
 		logger.Error("policy error", "error", err)
 		os.Exit(1)
@@ -95,13 +102,62 @@ func main() {
 		os.Exit(1)
 	}
 
-	sessions := auth.NewSessionManager(st, cfg.SessionCookieName, cfg.SessionTTL)
+	sessions := auth.NewSessionManager(st, cfg.SessionCookieName, cfg.SessionTTL, cfg.SessionIdleTimeout, cfg.SessionMaxPerUser, cfg.SessionCleanupEvery, logger)
+	sessions.Start(ctx)
 	connService := connections.NewService(st, cipher)
 	authorizer := iam.NewAuthorizer(st, policyStore)
 	auditLogger := audit.NewLogger(st, settingsStore)
 	streamManager := stream.NewManager()
 	jobStore := query.NewJobStore(10 * time.Minute)
 	updateService := update.NewService(cfg.UpdateRepo, util.Version, cfg.UpdateCheckInterval, cfg.UpdateToken)
+	piiScanner := discovery.NewScanner(st, connService, auditLogger, cfg.PIIScanSampleSize, cfg.PIIScanInterval, logger)
+	piiScanner.Start(ctx)
+	iam.NewGrantExpirer(st, auditLogger, cfg.AccessSweepInterval, cfg.AccessExpiryNotice, logger).Start(ctx)
+	webhookDispatcher := webhooks.NewDispatcher(st, cipher, cfg.WebhookPollInterval, cfg.WebhookTimeout, cfg.WebhookMaxAttempts, cfg.WebhookAllowPrivate, logger)
+	auditLogger.AddSink(webhookDispatcher)
+	webhookDispatcher.Start(ctx)
+	if cfg.SMTPHost != "" {
+		mailer := &notify.SMTPMailer{
+			Host:       cfg.SMTPHost,
+			Port:       cfg.SMTPPort,
+			Username:   cfg.SMTPUsername,
+			Password:   cfg.SMTPPassword,
+			From:       cfg.SMTPFrom,
+			RequireTLS: cfg.SMTPRequireTLS,
+		}
+		auditLogger.AddSink(notify.NewNotifier(st, mailer, cfg.NotifyRecipients, cfg.NotifyAppURL, logger))
+	}
+	var checkpointer *audit.Checkpointer
+	if cfg.AuditSigningKeyFile != "" {
+		key, err := audit.LoadSigningKey(cfg.AuditSigningKeyFile)
+		if err != nil {
+			logger.Error("audit signing key error", "error", err)
+			os.Exit(1)
+		}
+		checkpointer = audit.NewCheckpointer(st, key, cfg.AuditCheckpointDir, cfg.AuditCheckpointURL, cfg.AuditCheckpointEvery, logger)
+		checkpointer.Start(ctx)
+		if cfg.AuditRetention > 0 {
+			if !settingsStore.Get().FlagEnabled("enable_signed_audit_log") {
+				logger.Warn("AUDIT_RETENTION is set but enable_signed_audit_log is off; entries are archived only once covered by a signed checkpoint")
+			}
+			audit.NewArchiver(st, key, cfg.AuditArchiveDir, cfg.AuditRetention, cfg.AuditArchiveEvery, logger).Start(ctx)
+		}
+	}
+	if cfg.SIEMSyslogAddr != "" {
+		sender := &siem.Syslog{Network: cfg.SIEMSyslogNetwork, Addr: cfg.SIEMSyslogAddr, Timeout: 10 * time.Second}
+		if cfg.SIEMSyslogNetwork == "tls" {
+			if sender.TLSConfig, err = siem.TLSConfig(cfg.SIEMSyslogAddr, cfg.SIEMSyslogCAFile); err != nil {
+				logger.Error("siem tls config error", "error", err)
+				os.Exit(1)
+			}
+		}
+		siem.NewForwarder(st, sender, cfg.SIEMSyslogFormat, cfg.SIEMSyslogFacility, cfg.SIEMForwardInterval, cfg.SIEMSettleDelay, logger).Start(ctx)
+	}
+	if cfg.PolicyDir != "" {
+		policies.NewDirSync(cfg.PolicyDir, cfg.PolicyDirPoll, st, policyStore, auditLogger, logger).Start(ctx)
+	}
+	dataAccess := audit.NewDataAccessLog(auditLogger, cfg.DataAccessSample, cfg.DataAccessWindow, logger)
+	dataAccess.Start(ctx)
 
 	var oidcProvider *oidc.Provider
 	var oidcConfig *oauth2.Config
@@ -131,11 +187,15 @@ func main() {
 		Policies:     policyStore,
 		Authorizer:   authorizer,
 		Audit:        auditLogger,
+		Checkpoints:  checkpointer,
+		DataAccess:   dataAccess,
 		Config:       cfg,
 		Logger:       logger,
 		Stream:       streamManager,
 		JobStore:     jobStore,
 		Update:       updateService,
+		Discovery:    piiScanner,
+		Webhooks:     webhookDispatcher,
 		OIDC:         oidcProvider,
 		OIDCConfig:   oidcConfig,
 		OIDCVerifier: oidcVerifier,
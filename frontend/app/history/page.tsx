@@ -38,7 +38,7 @@ export default function HistoryPage() {
                 <th>Duration</th>
                 <th>Action</th>
                 <th>Resource</th>
-                <th>Statement Hash</th>
+                <th>Statement</th>
               </tr>
             </thead>
             <tbody>
@@ -50,7 +50,9 @@ export default function HistoryPage() {
                   <td>{entry.durationMs}ms</td>
                   <td>{entry.action}</td>
                   <td>{entry.resource}</td>
-                  <td className="font-mono">{entry.statementHash}</td>
+                  <td className="font-mono" title={entry.statementHash}>
+                    {entry.statement || entry.statementHash}
+                  </td>
                 </tr>
               ))}
               {entries.length === 0 && (
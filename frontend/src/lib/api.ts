@@ -434,6 +434,8 @@ function normalizeHistory(raw: unknown): QueryHistoryEntry {
     userId: asString(record.userId ?? record.UserID) || null,
     connectionId: asString(record.connectionId ?? record.ConnectionID) || null,
     statementHash: asString(record.statementHash ?? record.StatementHash),
+    statement: asString(record.statement),
+    statementRedacted: record.statementRedacted === true,
     status: asString(record.status ?? record.Status),
     rowCount: asNumber(record.rowCount ?? record.RowCount),
     durationMs: asNumber(record.durationMs ?? record.DurationMs),
@@ -72,6 +72,8 @@ export interface QueryHistoryEntry {
   userId: string | null;
   connectionId: string | null;
   statementHash: string;
+  statement?: string;
+  statementRedacted?: boolean;
   status: string;
   rowCount: number;
   durationMs: number;
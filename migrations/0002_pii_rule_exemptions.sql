@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE pii_rules ADD COLUMN IF NOT EXISTS exempt_roles JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE pii_rules ADD COLUMN IF NOT EXISTS exempt_groups JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE pii_rules ADD COLUMN IF NOT EXISTS require_justification BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE pii_rules ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS pii_rules_connection_idx ON pii_rules(connection_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS pii_rules_connection_idx;
+ALTER TABLE pii_rules DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE pii_rules DROP COLUMN IF EXISTS require_justification;
+ALTER TABLE pii_rules DROP COLUMN IF EXISTS exempt_groups;
+ALTER TABLE pii_rules DROP COLUMN IF EXISTS exempt_roles;
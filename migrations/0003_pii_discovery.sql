@@ -0,0 +1,36 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS pii_scans (
+	id UUID PRIMARY KEY,
+	connection_id UUID NOT NULL REFERENCES connections(id) ON DELETE CASCADE,
+	status TEXT NOT NULL,
+	started_by UUID REFERENCES users(id) ON DELETE SET NULL,
+	started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	finished_at TIMESTAMPTZ,
+	entities_scanned INT NOT NULL DEFAULT 0,
+	fields_flagged INT NOT NULL DEFAULT 0,
+	error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS pii_scans_connection_idx ON pii_scans(connection_id, started_at DESC);
+
+CREATE TABLE IF NOT EXISTS pii_proposals (
+	id UUID PRIMARY KEY,
+	connection_id UUID NOT NULL REFERENCES connections(id) ON DELETE CASCADE,
+	scan_id UUID REFERENCES pii_scans(id) ON DELETE SET NULL,
+	resource TEXT NOT NULL,
+	field TEXT NOT NULL,
+	category TEXT NOT NULL,
+	confidence REAL NOT NULL DEFAULT 0,
+	reasons JSONB NOT NULL DEFAULT '[]',
+	mask_type TEXT NOT NULL DEFAULT 'mask',
+	status TEXT NOT NULL DEFAULT 'pending',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	decided_by UUID REFERENCES users(id) ON DELETE SET NULL,
+	decided_at TIMESTAMPTZ,
+	UNIQUE(connection_id, resource, field)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS pii_proposals;
+DROP TABLE IF EXISTS pii_scans;
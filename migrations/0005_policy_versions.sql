@@ -0,0 +1,28 @@
+-- +goose Up
+ALTER TABLE policies ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE policies ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE TABLE IF NOT EXISTS policy_versions (
+	id UUID PRIMARY KEY,
+	policy_id UUID NOT NULL,
+	policy_name TEXT NOT NULL,
+	version INT NOT NULL,
+	change TEXT NOT NULL,
+	doc JSONB,
+	diff JSONB NOT NULL DEFAULT '[]',
+	author_id UUID REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE(policy_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS policy_versions_policy_idx ON policy_versions(policy_id, version DESC);
+
+INSERT INTO policy_versions (id, policy_id, policy_name, version, change, doc, created_at)
+SELECT gen_random_uuid(), p.id, p.name, 1, 'create', p.doc, p.created_at
+FROM policies p
+WHERE NOT EXISTS (SELECT 1 FROM policy_versions v WHERE v.policy_id = p.id);
+
+-- +goose Down
+DROP TABLE IF EXISTS policy_versions;
+ALTER TABLE policies DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE policies DROP COLUMN IF EXISTS version;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE policies ADD COLUMN IF NOT EXISTS managed_by TEXT NOT NULL DEFAULT '';
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS managed_by TEXT NOT NULL DEFAULT '';
+ALTER TABLE role_bindings ADD COLUMN IF NOT EXISTS managed_by TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE role_bindings DROP COLUMN IF EXISTS managed_by;
+ALTER TABLE roles DROP COLUMN IF EXISTS managed_by;
+ALTER TABLE policies DROP COLUMN IF EXISTS managed_by;
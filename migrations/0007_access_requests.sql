@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS access_requests (
+	id UUID PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	resource TEXT NOT NULL DEFAULT '',
+	justification TEXT NOT NULL,
+	ticket_id TEXT NOT NULL DEFAULT '',
+	duration_sec INT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	decided_by UUID REFERENCES users(id) ON DELETE SET NULL,
+	decided_at TIMESTAMPTZ,
+	decision_note TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMPTZ,
+	revoked_by UUID REFERENCES users(id) ON DELETE SET NULL,
+	revoked_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS access_requests_active_idx ON access_requests (user_id, expires_at) WHERE status = 'approved';
+CREATE INDEX IF NOT EXISTS access_requests_status_idx ON access_requests (status, created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS access_requests;
@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS break_glass (
+	id UUID PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	session_id UUID REFERENCES sessions(id) ON DELETE SET NULL,
+	reason TEXT NOT NULL,
+	started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	ended_at TIMESTAMPTZ,
+	reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+	reviewed_at TIMESTAMPTZ,
+	review_note TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS break_glass_session_idx ON break_glass (session_id, expires_at) WHERE ended_at IS NULL;
+
+-- +goose Down
+DROP TABLE IF EXISTS break_glass;
@@ -0,0 +1,30 @@
+-- +goose Up
+ALTER TABLE query_approvals ADD COLUMN IF NOT EXISTS statement_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE query_approvals ADD COLUMN IF NOT EXISTS required_approvals INT NOT NULL DEFAULT 1;
+ALTER TABLE query_approvals ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE query_approvals ADD COLUMN IF NOT EXISTS consumed_at TIMESTAMPTZ;
+
+-- Approvals created before statements were bound by hash: hash the stored
+-- statement the way query.StatementHash does so they still match, and give
+-- those still pending or unused one default APPROVAL_TTL from the upgrade
+-- instead of leaving them without an expiry, which would make approved ones
+-- unusable.
+UPDATE query_approvals SET statement_hash = encode(sha256(convert_to(statement, 'UTF8')), 'hex')
+WHERE statement_hash = '';
+UPDATE query_approvals SET expires_at = now() + interval '1 hour'
+WHERE expires_at IS NULL AND status IN ('pending', 'approved');
+
+CREATE TABLE IF NOT EXISTS query_approval_votes (
+	approval_id UUID NOT NULL REFERENCES query_approvals(id) ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	decision TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY(approval_id, user_id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS query_approval_votes;
+ALTER TABLE query_approvals DROP COLUMN IF EXISTS consumed_at;
+ALTER TABLE query_approvals DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE query_approvals DROP COLUMN IF EXISTS required_approvals;
+ALTER TABLE query_approvals DROP COLUMN IF EXISTS statement_hash;
@@ -0,0 +1,44 @@
+-- +goose Up
+ALTER TABLE query_approvals ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE query_approvals ADD COLUMN IF NOT EXISTS ticket_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE query_approvals ADD COLUMN IF NOT EXISTS impact TEXT NOT NULL DEFAULT '';
+ALTER TABLE query_approvals ADD COLUMN IF NOT EXISTS explain_plan JSONB;
+ALTER TABLE query_approvals ADD COLUMN IF NOT EXISTS revision INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS query_approval_revisions (
+	approval_id UUID NOT NULL REFERENCES query_approvals(id) ON DELETE CASCADE,
+	revision INT NOT NULL,
+	statement TEXT NOT NULL,
+	statement_hash TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	ticket_url TEXT NOT NULL DEFAULT '',
+	impact TEXT NOT NULL DEFAULT '',
+	explain_plan JSONB,
+	created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY(approval_id, revision)
+);
+
+INSERT INTO query_approval_revisions (approval_id, revision, statement, statement_hash, created_by, created_at)
+SELECT id, 1, statement, statement_hash, user_id, created_at FROM query_approvals
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS query_approval_comments (
+	id UUID PRIMARY KEY,
+	approval_id UUID NOT NULL REFERENCES query_approvals(id) ON DELETE CASCADE,
+	user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+	revision INT NOT NULL,
+	kind TEXT NOT NULL DEFAULT 'comment',
+	body TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS query_approval_comments_approval_idx ON query_approval_comments (approval_id, created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS query_approval_comments;
+DROP TABLE IF EXISTS query_approval_revisions;
+ALTER TABLE query_approvals DROP COLUMN IF EXISTS revision;
+ALTER TABLE query_approvals DROP COLUMN IF EXISTS explain_plan;
+ALTER TABLE query_approvals DROP COLUMN IF EXISTS impact;
+ALTER TABLE query_approvals DROP COLUMN IF EXISTS ticket_url;
+ALTER TABLE query_approvals DROP COLUMN IF EXISTS reason;
@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notification_opt_out TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE access_requests ADD COLUMN IF NOT EXISTS expiry_notified_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS user_devices (
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	fingerprint TEXT NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	first_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (user_id, fingerprint)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_devices;
+ALTER TABLE access_requests DROP COLUMN IF EXISTS expiry_notified_at;
+ALTER TABLE users DROP COLUMN IF EXISTS notification_opt_out;
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS seq BIGINT;
+CREATE SEQUENCE IF NOT EXISTS audit_log_seq OWNED BY audit_log.seq;
+UPDATE audit_log SET seq = ordered.n
+FROM (SELECT id, row_number() OVER (ORDER BY created_at, id) AS n FROM audit_log) ordered
+WHERE audit_log.id = ordered.id AND audit_log.seq IS NULL;
+SELECT setval('audit_log_seq', COALESCE((SELECT max(seq) FROM audit_log), 0) + 1, false);
+ALTER TABLE audit_log ALTER COLUMN seq SET DEFAULT nextval('audit_log_seq');
+ALTER TABLE audit_log ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS audit_log_seq_idx ON audit_log (seq);
+
+-- +goose Down
+DROP INDEX IF EXISTS audit_log_seq_idx;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS seq;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+	seq BIGINT PRIMARY KEY,
+	signed_count BIGINT NOT NULL,
+	head_hash TEXT NOT NULL,
+	key_id TEXT NOT NULL,
+	statement TEXT NOT NULL,
+	signature TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS audit_checkpoints;
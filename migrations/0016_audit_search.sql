@@ -0,0 +1,33 @@
+-- +goose Up
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS audit_log_event_type_idx ON audit_log (event_type, seq);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_user_id, seq);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_error_id_idx ON audit_log (error_id) WHERE error_id IS NOT NULL AND error_id <> '';
+CREATE INDEX IF NOT EXISTS audit_log_connection_idx ON audit_log ((details->>'connectionId'), seq);
+CREATE INDEX IF NOT EXISTS audit_log_details_trgm_idx ON audit_log USING gin ((details::text) gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS audit_archives (
+    id UUID PRIMARY KEY,
+    first_seq BIGINT NOT NULL,
+    last_seq BIGINT NOT NULL UNIQUE,
+    entries BIGINT NOT NULL,
+    signed_count BIGINT NOT NULL,
+    head_seq BIGINT NOT NULL,
+    head_hash TEXT NOT NULL,
+    file_name TEXT NOT NULL,
+    sha256 TEXT NOT NULL,
+    key_id TEXT NOT NULL,
+    manifest TEXT NOT NULL,
+    signature TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS audit_archives;
+DROP INDEX IF EXISTS audit_log_details_trgm_idx;
+DROP INDEX IF EXISTS audit_log_connection_idx;
+DROP INDEX IF EXISTS audit_log_error_id_idx;
+DROP INDEX IF EXISTS audit_log_created_at_idx;
+DROP INDEX IF EXISTS audit_log_actor_idx;
+DROP INDEX IF EXISTS audit_log_event_type_idx;
@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS session_id UUID;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS request_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS client_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS hash_version SMALLINT NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS audit_log_session_idx ON audit_log (session_id, seq) WHERE session_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS audit_log_request_idx ON audit_log (request_id) WHERE request_id <> '';
+CREATE INDEX IF NOT EXISTS audit_log_client_ip_idx ON audit_log (client_ip) WHERE client_ip <> '';
+
+-- +goose Down
+DROP INDEX IF EXISTS audit_log_client_ip_idx;
+DROP INDEX IF EXISTS audit_log_request_idx;
+DROP INDEX IF EXISTS audit_log_session_idx;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS hash_version;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS client_ip;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS request_id;
+ALTER TABLE audit_log DROP COLUMN IF EXISTS session_id;
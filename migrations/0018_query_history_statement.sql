@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS statement_enc BYTEA;
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS statement_redacted BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS query_history_started_idx ON query_history (started_at DESC);
+CREATE INDEX IF NOT EXISTS query_history_user_idx ON query_history (user_id, started_at DESC);
+CREATE INDEX IF NOT EXISTS query_history_connection_idx ON query_history (connection_id, started_at DESC);
+CREATE INDEX IF NOT EXISTS query_history_status_idx ON query_history (status, started_at DESC);
+
+-- +goose Down
+DROP INDEX IF EXISTS query_history_status_idx;
+DROP INDEX IF EXISTS query_history_connection_idx;
+DROP INDEX IF EXISTS query_history_user_idx;
+DROP INDEX IF EXISTS query_history_started_idx;
+ALTER TABLE query_history DROP COLUMN IF EXISTS statement_redacted;
+ALTER TABLE query_history DROP COLUMN IF EXISTS statement_enc;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS fingerprint TEXT NOT NULL DEFAULT '';
+ALTER TABLE query_history ADD COLUMN IF NOT EXISTS fingerprint_text TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS query_history_fingerprint_idx ON query_history (fingerprint, started_at DESC) WHERE fingerprint <> '';
+
+-- +goose Down
+DROP INDEX IF EXISTS query_history_fingerprint_idx;
+ALTER TABLE query_history DROP COLUMN IF EXISTS fingerprint_text;
+ALTER TABLE query_history DROP COLUMN IF EXISTS fingerprint;
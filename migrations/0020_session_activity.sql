@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS client_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS sessions_user_idx ON sessions (user_id, last_seen_at DESC);
+CREATE INDEX IF NOT EXISTS sessions_expires_idx ON sessions (expires_at);
+CREATE INDEX IF NOT EXISTS sessions_last_seen_idx ON sessions (last_seen_at);
+CREATE INDEX IF NOT EXISTS oidc_states_created_idx ON oidc_states (created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS oidc_states_created_idx;
+DROP INDEX IF EXISTS sessions_last_seen_idx;
+DROP INDEX IF EXISTS sessions_expires_idx;
+DROP INDEX IF EXISTS sessions_user_idx;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE sessions DROP COLUMN IF EXISTS client_ip;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_seen_at;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE pii_scans ADD COLUMN IF NOT EXISTS failures JSONB NOT NULL DEFAULT '[]';
+CREATE INDEX IF NOT EXISTS pii_scans_status_idx ON pii_scans(status);
+
+-- +goose Down
+DROP INDEX IF EXISTS pii_scans_status_idx;
+ALTER TABLE pii_scans DROP COLUMN IF EXISTS failures;